@@ -30,6 +30,12 @@ import {
 import { TabsTrigger } from "@/app/_components/tabs";
 import { ChatBubbleLeftEllipsisIcon } from "@heroicons/react/24/solid";
 
+// The worker encrypts secrets in stored configs (see worker/secrets.go). Encrypted values are kept as they are unless
+// a new value is entered, so they're not shown in inputs.
+function isEncryptedSecret(value: string | undefined) {
+  return value?.startsWith("enc:v1:") ?? false;
+}
+
 export const supportedVectorStores: Record<
   VectorStoreType,
   {
@@ -62,9 +68,17 @@ export const supportedVectorStores: Record<
               <KeyIcon className={"h-4 w-4"} />
             </TextField.Slot>
             <TextField.Input
-              placeholder={"API Key"}
+              placeholder={
+                isEncryptedSecret(vectorStoreConfig?.api_key)
+                  ? "API Key (stored encrypted)"
+                  : "API Key"
+              }
               type={"password"}
-              value={vectorStoreConfig?.api_key || ""}
+              value={
+                isEncryptedSecret(vectorStoreConfig?.api_key)
+                  ? ""
+                  : vectorStoreConfig?.api_key || ""
+              }
               onChange={(e) => {
                 upsertVectorStoreDataSink(true, {
                   store_type: VectorStoreType.Pinecone,
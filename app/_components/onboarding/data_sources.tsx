@@ -359,7 +359,7 @@ function RenderDataSourceSettings({
 
   const connectSource = useConnect();
 
-  if (connection && connection.connected_at) {
+  if (connection && connection.connected_at && !connection.needs_reauth) {
     return (
       <Tabs.Content key={i} value={i}>
         <div
@@ -406,7 +406,9 @@ function RenderDataSourceSettings({
       <Button key={i} variant={"soft"} onClick={connectSource}>
         <Icon />
 
-        <span>Connect {label}</span>
+        <span>
+          {connection?.needs_reauth ? "Reconnect" : "Connect"} {label}
+        </span>
       </Button>
     </Tabs.Content>
   );
@@ -128,6 +128,9 @@ export interface Account {
 
 export enum LinearDocumentType {
   Issue = "issue",
+  Project = "project",
+  Document = "document",
+  Cycle = "cycle",
 }
 
 export async function findAccountByEmail(sql: SqlFunc, email: string) {
@@ -313,6 +316,9 @@ export interface IntegrationConnectionBase {
 
   // workspace or organization ID contained in receiving webhooks
   workspace_id: string;
+
+  // set by the worker when the provider rejected the credentials, cleared when connecting again
+  needs_reauth: boolean;
 }
 
 export interface NotionIntegrationConnection extends IntegrationConnectionBase {
@@ -323,6 +329,8 @@ export interface NotionIntegrationConnection extends IntegrationConnectionBase {
     workspace_id: string;
     workspace_name: string;
     workspace_icon: string;
+    refresh_token?: string;
+    expires_at?: string;
   };
 }
 
@@ -336,6 +344,8 @@ export interface LinearIntegrationConnection extends IntegrationConnectionBase {
     organization_id: string;
     organization_name: string;
     organization_logo?: string;
+    refresh_token?: string;
+    expires_at?: string;
   };
 }
 
@@ -580,7 +590,8 @@ export async function updateIntegrationConnection(
                 update "langsync"."integration_connection"
                 set "config" = ${JSON.stringify(config)},
                     "connected_at" = ${connectedAt},
-                    "workspace_id" = ${workspaceId}
+                    "workspace_id" = ${workspaceId},
+                    "needs_reauth" = false
                 where "account" = ${account} and "integration_name" = ${integrationName}
                 returning *
             `;
@@ -79,6 +79,33 @@ export async function POST(req: NextRequest) {
     case "Issue":
       documentType = LinearDocumentType.Issue;
       break;
+    case "Comment":
+      // Comments are part of the issue content
+      if (!linearData.issueId) {
+        return NextResponse.json({ ok: true }, { status: 200 });
+      }
+      documentAction = ChangeAction.Update;
+      documentId = linearData.issueId;
+      documentType = LinearDocumentType.Issue;
+      break;
+    case "Project":
+      documentType = LinearDocumentType.Project;
+      break;
+    case "ProjectUpdate":
+      // Project updates are part of the project content
+      if (!linearData.projectId) {
+        return NextResponse.json({ ok: true }, { status: 200 });
+      }
+      documentAction = ChangeAction.Update;
+      documentId = linearData.projectId;
+      documentType = LinearDocumentType.Project;
+      break;
+    case "Document":
+      documentType = LinearDocumentType.Document;
+      break;
+    case "Cycle":
+      documentType = LinearDocumentType.Cycle;
+      break;
     default:
       // Don't handle other types for now
       return NextResponse.json({ ok: true }, { status: 200 });
@@ -60,7 +60,8 @@ export async function POST(req: NextRequest) {
     );
   }
 
-  const { access_token, token_type, expires_in, scope } = await resp.json();
+  const { access_token, token_type, expires_in, scope, refresh_token } =
+    await resp.json();
 
   let org;
   {
@@ -108,6 +109,11 @@ export async function POST(req: NextRequest) {
       access_token,
       token_type,
       expires_in,
+      // the worker refreshes the access token before it expires
+      expires_at: expires_in
+        ? new Date(Date.now() + expires_in * 1000).toISOString()
+        : undefined,
+      refresh_token,
       scope,
       organization_id: org.id,
       organization_name: org.name,
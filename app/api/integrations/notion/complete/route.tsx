@@ -55,8 +55,15 @@ export async function POST(req: NextRequest) {
     );
   }
 
-  const { access_token, bot_id, workspace_id, workspace_name, workspace_icon } =
-    await resp.json();
+  const {
+    access_token,
+    refresh_token,
+    expires_in,
+    bot_id,
+    workspace_id,
+    workspace_name,
+    workspace_icon,
+  } = await resp.json();
 
   // This process is idempotent, we can easily refresh connections
   await updateIntegrationConnection(
@@ -65,6 +72,11 @@ export async function POST(req: NextRequest) {
     Integration.Notion,
     {
       access_token,
+      // the worker refreshes the access token before it expires
+      refresh_token,
+      expires_at: expires_in
+        ? new Date(Date.now() + expires_in * 1000).toISOString()
+        : undefined,
       bot_id,
       workspace_id,
       workspace_name,
@@ -1,5 +1,26 @@
 CREATE SCHEMA langsync;
 
+-- quotas of accounts, limits apply per calendar month (UTC) and are unlimited if null
+CREATE TABLE "langsync"."plan" (
+    "id" varchar(64) NOT NULL,
+    "name" varchar(256) NOT NULL,
+
+    "max_documents" integer,
+    "max_tokens" bigint,
+    -- in USD
+    "max_embedding_spend" double precision,
+
+    -- documents with more tokens aren't embedded
+    "max_document_tokens" integer NOT NULL,
+
+    CONSTRAINT "plan_pkey" PRIMARY KEY ("id")
+);
+
+-- accounts without a plan are on the free or subscriber plan
+INSERT INTO "langsync"."plan" ("id", "name", "max_documents", "max_tokens", "max_embedding_spend", "max_document_tokens")
+VALUES ('free', 'Free', 100, 100000, NULL, 1000),
+       ('subscriber', 'Subscriber', 1000, 100000000, 10, 100000);
+
 CREATE TABLE "langsync"."account"(
     "id" varchar(64) NOT NULL,
 
@@ -17,11 +38,15 @@ CREATE TABLE "langsync"."account"(
     "is_subscriber" boolean NOT NULL,
     "is_unlimited" boolean NOT NULL DEFAULT false,
 
+    "plan" varchar(64),
+
+    -- lifetime totals, quotas are checked against account_usage
     "total_indexed_document_count" integer NOT NULL DEFAULT 0,
     "total_indexed_document_tokens" integer NOT NULL DEFAULT 0,
 
     CONSTRAINT "account_pkey" PRIMARY KEY ("id"),
-    CONSTRAINT "account_email_key" UNIQUE ("email")
+    CONSTRAINT "account_email_key" UNIQUE ("email"),
+    CONSTRAINT "account_plan_fkey" FOREIGN KEY ("plan") REFERENCES "langsync"."plan" ("id")
 );
 
 CREATE TABLE "langsync"."auth_attempt" (
@@ -50,6 +75,14 @@ CREATE TABLE "langsync"."integration_connection" (
     -- not necessarily unique, related workspace/organization/team entity for incoming change webhooks
     "workspace_id" varchar(64),
 
+    -- set when the provider rejected the credentials, cleared when the integration is connected again
+    "needs_reauth" boolean NOT NULL DEFAULT false,
+
+    -- change polling for integrations without webhooks, polled_at is used to claim the connection
+    "polled_at" timestamp with time zone,
+    "poll_cursor" timestamp with time zone,
+    "reconciled_at" timestamp with time zone,
+
     CONSTRAINT "integration_connection_pkey" PRIMARY KEY ("account", "integration_name"),
     CONSTRAINT "integration_connection_account_fkey" FOREIGN KEY ("account") REFERENCES "langsync"."account" ("id") ON DELETE CASCADE
 );
@@ -96,18 +129,33 @@ CREATE TABLE "langsync"."pipeline_run_step" (
     "started_at" timestamp with time zone,
     "completed_at" timestamp with time zone,
     "error" jsonb,
+    -- documents left out because of quotas
+    "excluded_documents" jsonb,
 
     CONSTRAINT "pipeline_run_step_pkey" PRIMARY KEY ("pipeline_run", "data_source"),
     CONSTRAINT "pipeline_run_step_pipeline_run_fkey" FOREIGN KEY ("pipeline_run") REFERENCES "langsync"."pipeline_run" ("id") ON DELETE CASCADE
 );
 
+-- cursors of data sources which list changes since their last run instead of all documents, e.g. the Zendesk
+-- incremental ticket export
+CREATE TABLE "langsync"."pipeline_data_source_cursor" (
+    "pipeline" varchar(64) NOT NULL,
+    "data_source" varchar(64) NOT NULL,
+    "cursor" text NOT NULL,
+    "updated_at" timestamp with time zone NOT NULL,
+
+    CONSTRAINT "pipeline_data_source_cursor_pkey" PRIMARY KEY ("pipeline", "data_source"),
+    CONSTRAINT "pipeline_data_source_cursor_pipeline_fkey" FOREIGN KEY ("pipeline") REFERENCES "langsync"."pipeline" ("id") ON DELETE CASCADE
+);
+
 CREATE TABLE "langsync"."document" (
     "account" varchar(64) NOT NULL,
     "pipeline" varchar(64) NOT NULL,
     "integration_name" varchar(64) NOT NULL,
 
     "document_type" varchar(64) NOT NULL,
-    "id" varchar(64) NOT NULL,
+    -- long enough for file paths and object keys
+    "id" varchar(1024) NOT NULL,
     "created_at" timestamp with time zone NOT NULL,
     "updated_at" timestamp with time zone,
 
@@ -122,6 +170,12 @@ CREATE TABLE "langsync"."document" (
     "token_count" integer NOT NULL DEFAULT 0,
     "exceeds_token_limit" boolean NOT NULL DEFAULT false,
 
+    -- set if the document couldn't be indexed, e.g. unsupported_format
+    "skip_reason" varchar(64),
+
+    -- set for attachments, which are indexed as child documents of the document they're attached to
+    "parent_id" varchar(1024),
+
     CONSTRAINT "document_pkey" PRIMARY KEY ("account", "pipeline", "integration_name", "document_type", "id"),
     CONSTRAINT "document_account_fkey" FOREIGN KEY ("account") REFERENCES "langsync"."account" ("id") ON DELETE CASCADE,
     CONSTRAINT "document_pipeline_fkey" FOREIGN KEY ("pipeline") REFERENCES "langsync"."pipeline" ("id") ON DELETE CASCADE,
@@ -133,3 +187,76 @@ CREATE INDEX "document_account_pipeline_integration_idx" ON "langsync"."document
 
 -- index documents by account, pipeline
 CREATE INDEX "document_account_pipeline_idx" ON "langsync"."document" ("account", "pipeline");
+
+-- index attachments by their parent
+CREATE INDEX "document_parent_idx" ON "langsync"."document" ("account", "pipeline", "integration_name", "parent_id");
+
+-- token buckets of provider rate limits, shared by all worker replicas
+CREATE TABLE "langsync"."rate_limit_bucket" (
+    -- provider and workspace or token hash, e.g. notion:<workspace id>
+    "key" varchar(256) NOT NULL,
+
+    "tokens" double precision NOT NULL,
+    -- when tokens were last refilled, in the future while requests are blocked
+    "updated_at" timestamp with time zone NOT NULL,
+    "blocked_until" timestamp with time zone,
+
+    -- rate adapted to the provider's rate limit headers until its window resets
+    "adapted_rate" double precision,
+    "adapted_until" timestamp with time zone,
+
+    CONSTRAINT "rate_limit_bucket_pkey" PRIMARY KEY ("key")
+);
+
+-- idle buckets are deleted by the workers
+CREATE INDEX "rate_limit_bucket_updated_at_idx" ON "langsync"."rate_limit_bucket" ("updated_at");
+
+-- runs currently processed by workers, limits how many runs of an account are processed at the same time
+CREATE TABLE "langsync"."account_run_lease" (
+    -- id of the SQS message of the run
+    "message_id" varchar(128) NOT NULL,
+    "account" varchar(64) NOT NULL,
+
+    -- extended while the run is processed, so leases of crashed workers expire
+    "expires_at" timestamp with time zone NOT NULL,
+
+    CONSTRAINT "account_run_lease_pkey" PRIMARY KEY ("message_id"),
+    CONSTRAINT "account_run_lease_account_fkey" FOREIGN KEY ("account") REFERENCES "langsync"."account" ("id") ON DELETE CASCADE
+);
+
+CREATE INDEX "account_run_lease_account_idx" ON "langsync"."account_run_lease" ("account");
+
+-- usage of an account counted towards the quotas of its plan, one row per month
+CREATE TABLE "langsync"."account_usage" (
+    "account" varchar(64) NOT NULL,
+    -- first day of the month in UTC
+    "period_start" timestamp with time zone NOT NULL,
+
+    "indexed_documents" integer NOT NULL DEFAULT 0,
+    "indexed_tokens" bigint NOT NULL DEFAULT 0,
+    -- in USD
+    "embedding_spend" double precision NOT NULL DEFAULT 0,
+
+    CONSTRAINT "account_usage_pkey" PRIMARY KEY ("account", "period_start"),
+    CONSTRAINT "account_usage_account_fkey" FOREIGN KEY ("account") REFERENCES "langsync"."account" ("id") ON DELETE CASCADE
+);
+
+-- quota reserved by runs in progress, counted towards the quota of the account until it's committed to account_usage
+-- or released
+CREATE TABLE "langsync"."quota_reservation" (
+    "id" varchar(64) NOT NULL,
+    "account" varchar(64) NOT NULL,
+    "period_start" timestamp with time zone NOT NULL,
+
+    "documents" integer NOT NULL DEFAULT 0,
+    "tokens" bigint NOT NULL DEFAULT 0,
+    "embedding_spend" double precision NOT NULL DEFAULT 0,
+
+    -- extended whenever the reservation changes, so reservations of crashed workers expire
+    "expires_at" timestamp with time zone NOT NULL,
+
+    CONSTRAINT "quota_reservation_pkey" PRIMARY KEY ("id"),
+    CONSTRAINT "quota_reservation_account_fkey" FOREIGN KEY ("account") REFERENCES "langsync"."account" ("id") ON DELETE CASCADE
+);
+
+CREATE INDEX "quota_reservation_account_idx" ON "langsync"."quota_reservation" ("account", "period_start");
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// Documents carry the principals which may access them in the source as a flat list of strings, so it can be stored
+// as vector store metadata and matched with an "in" filter against the principals of the querying user:
+//
+//	public                        anyone
+//	workspace:<integration>:<id>  any member of a workspace of the integration
+//	group:<integration>:<id>      any member of a group, e.g. a private Linear team
+//	user:<email>                  a single user, emails are lower case so users can be matched across integrations
+//
+// Connectors which can't tell who may access a document leave the list empty, which data sinks treat as unrestricted,
+// so connectors fall back to the most restrictive principal they know of instead where possible. The list is only sent
+// to the data sinks when a document is ingested, so it is part of the freshness indicator stored for the document, see
+// aclFreshness.
+const ACLPublic = "public"
+
+func aclWorkspace(integration Integration, id string) string {
+	return "workspace:" + string(integration) + ":" + id
+}
+
+func aclGroup(integration Integration, id string) string {
+	return "group:" + string(integration) + ":" + id
+}
+
+func aclUser(email string) string {
+	return "user:" + strings.ToLower(email)
+}
+
+// normalizeACL sorts and deduplicates principals
+func normalizeACL(principals []string) []string {
+	if len(principals) == 0 {
+		return nil
+	}
+
+	sorted := make([]string, len(principals))
+	copy(sorted, principals)
+	sort.Strings(sorted)
+
+	acl := sorted[:1]
+	for _, principal := range sorted[1:] {
+		if principal != acl[len(acl)-1] {
+			acl = append(acl, principal)
+		}
+	}
+	return acl
+}
+
+// aclFreshness appends a hash of the access list to a freshness indicator, so that documents whose access changed
+// aren't considered fresh and are ingested again with the new list
+func aclFreshness(freshnessIndicator string, acl []string) string {
+	if len(acl) == 0 {
+		return freshnessIndicator
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(acl, "\n")))
+	return freshnessIndicator + "#acl:" + hex.EncodeToString(hash[:8])
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+)
+
+const (
+	attachmentMaxSize = 25 * 1024 * 1024
+	// Attachments beyond this are ignored, so a single document can't cause an unbounded number of downloads
+	attachmentMaxCount = 20
+)
+
+// AttachmentLister is implemented by data sources whose documents can have attachments. Attachments are indexed as
+// child documents whenever their parent changes, so the parent's freshness indicator must cover attachment changes.
+// Child documents are removed together with their parent.
+type AttachmentLister interface {
+	// GetDocumentContentAndAttachments is GetDocumentContent for documents which can have attachments, the attachments
+	// are found while retrieving the content, so they're returned with it rather than retrieved again
+	GetDocumentContentAndAttachments(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, []IndexedDocument, error)
+	// GetAttachmentContent is GetDocumentContent for attachments, which are passed as they were returned, so their
+	// parent doesn't have to be retrieved again
+	GetAttachmentContent(ctx context.Context, dataSource *PipelineDataSource, attachment IndexedDocument, integration IntegrationConnection) (string, map[string]any, error)
+}
+
+// newAttachmentTransport creates a transport for downloading attachments, which are only ever fetched from public
+// addresses
+func newAttachmentTransport(provider string, config HTTPClientConfig) *ProviderTransport {
+	httpClient := &http.Client{
+		Timeout:   config.Timeout,
+		Transport: newPublicOnlyTransport(false),
+	}
+	return newProviderTransport(provider, httpClient, nil, config.MaxAttempts, nil)
+}
+
+// downloadAttachment downloads an attachment of at most attachmentMaxSize bytes, returning the content and its type
+func downloadAttachment(ctx context.Context, transport *ProviderTransport, url string, header http.Header) ([]byte, string, error) {
+	res, err := transport.Do(ctx, providerRequest{
+		Method: http.MethodGet,
+		URL:    url,
+		Header: header,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.ContentLength > attachmentMaxSize {
+		return nil, "", &SkippedDocumentError{Reason: SkipReasonTooLarge}
+	}
+
+	content, err := io.ReadAll(io.LimitReader(res.Body, attachmentMaxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) > attachmentMaxSize {
+		return nil, "", &SkippedDocumentError{Reason: SkipReasonTooLarge}
+	}
+
+	return content, res.Header.Get("Content-Type"), nil
+}
+
+// extractAttachmentText downloads an attachment and converts it to text, formats which can't be extracted are
+// skipped before downloading if the filename tells
+func extractAttachmentText(ctx context.Context, transport *ProviderTransport, url string, header http.Header, filename string) (string, error) {
+	if _, ok := detectDocumentFormat(filename, ""); !ok && path.Ext(filename) != "" {
+		return "", &SkippedDocumentError{Reason: SkipReasonUnsupportedFormat}
+	}
+
+	content, contentType, err := downloadAttachment(ctx, transport, url, header)
+	if err != nil {
+		return "", err
+	}
+
+	return extractDocumentText(content, filename, contentType)
+}
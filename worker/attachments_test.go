@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// testAttachmentClient records which content method was called for which document
+type testAttachmentClient struct {
+	calls []string
+}
+
+func (client *testAttachmentClient) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	return nil, nil
+}
+
+func (client *testAttachmentClient) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+	return IndexedDocument{}, nil
+}
+
+func (client *testAttachmentClient) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
+	client.calls = append(client.calls, "content:"+id)
+	return "content", nil, nil
+}
+
+func (client *testAttachmentClient) GetDocumentContentAndAttachments(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, []IndexedDocument, error) {
+	client.calls = append(client.calls, "document:"+id)
+	return "content", nil, []IndexedDocument{{Id: id + "/file", ParentId: id}}, nil
+}
+
+func (client *testAttachmentClient) GetAttachmentContent(ctx context.Context, dataSource *PipelineDataSource, attachment IndexedDocument, integration IntegrationConnection) (string, map[string]any, error) {
+	client.calls = append(client.calls, "attachment:"+attachment.Id)
+	return "attachment", nil, nil
+}
+
+func TestGetDocumentTextContentAttachments(t *testing.T) {
+	client := &testAttachmentClient{}
+	clients := map[Integration]DataSourceApiClient{IntegrationLinear: client}
+	integration := IntegrationConnection{}
+	integration.Integration = IntegrationLinear
+	ctx := context.Background()
+
+	_, _, attachments, err := getDocumentTextContent(ctx, nil, IndexedDocument{Id: "issue"}, integration, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(attachments))
+	}
+
+	// Attachments are retrieved as they were returned, without retrieving their parent again
+	content, _, children, err := getDocumentTextContent(ctx, nil, attachments[0], integration, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "attachment" || children != nil {
+		t.Errorf("got %q with attachments %v", content, children)
+	}
+
+	if want := []string{"document:issue", "attachment:issue/file"}; !reflect.DeepEqual(client.calls, want) {
+		t.Errorf("calls = %v, want %v", client.calls, want)
+	}
+}
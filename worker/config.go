@@ -0,0 +1,495 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the runtime configuration of the worker. It's read from the YAML file at WORKER_CONFIG_FILE, if set, and
+// every setting can be overridden by the environment variable named by its env tag, prefixed with the env tags of
+// the sections it's nested in. Fields tagged secret are redacted when the configuration is logged.
+//
+//	workers: 4
+//	queue:
+//	  url: https://sqs.eu-central-1.amazonaws.com/123456789012/index
+//	  visibility_timeout: 20s
+//	notion:
+//	  timeout: 5m
+//	  concurrency: 3
+//	quotas:
+//	  embedding_price_per_million_tokens: 0.1
+//
+// sets the same values as NUM_WORKERS=4, INDEX_QUEUE_URL=..., INDEX_QUEUE_VISIBILITY_TIMEOUT=20s, NOTION_TIMEOUT=5m,
+// NOTION_CONCURRENCY=3 and QUOTA_EMBEDDING_PRICE_PER_MILLION_TOKENS=0.1.
+type Config struct {
+	// Workers is the number of messages processed at the same time
+	Workers int         `yaml:"workers" env:"NUM_WORKERS"`
+	Queue   QueueConfig `yaml:"queue" env:"INDEX_QUEUE_"`
+
+	// Make sure not to use a connection pooler like pgbouncer, alternatively update the prepared statement mode (see
+	// https://github.com/jackc/pgx/issues/602)
+	PostgresURL string `yaml:"postgres_url" env:"POSTGRES_URL_NON_POOLING" secret:"true"`
+
+	OpenAIApiKey string `yaml:"openai_api_key" env:"OPENAI_API_KEY" secret:"true"`
+
+	// Secrets are sent to helper services as references resolved on /credentials/resolve, see
+	// credential_references.go. Leave CredentialsReferenceKey empty to send secrets in plaintext.
+	CredentialsReferenceKey string `yaml:"credentials_reference_key" env:"CREDENTIALS_REFERENCE_KEY" secret:"true"`
+	HelperCredentialsToken  string `yaml:"helper_credentials_token" env:"HELPER_CREDENTIALS_TOKEN" secret:"true"`
+	// CredentialsResolverAddr is the address of the internal listener serving /credentials/resolve, which must only
+	// be reachable by helper services
+	CredentialsResolverAddr string `yaml:"credentials_resolver_addr" env:"CREDENTIALS_RESOLVER_ADDR"`
+
+	DocumentHelper DocumentHelperConfig `yaml:"document_helper" env:"DOCUMENT_HELPER_"`
+
+	Notion      NotionConfig         `yaml:"notion" env:"NOTION_"`
+	Linear      LinearConfig         `yaml:"linear" env:"LINEAR_"`
+	Zendesk     ProviderConfig       `yaml:"zendesk" env:"ZENDESK_"`
+	Intercom    ProviderConfig       `yaml:"intercom" env:"INTERCOM_"`
+	Attachments HTTPClientConfig     `yaml:"attachments" env:"ATTACHMENTS_"`
+	Web         WebConfig            `yaml:"web" env:"WEB_"`
+	S3          S3Config             `yaml:"s3" env:"S3_"`
+	Filesystem  FilesystemConfig     `yaml:"filesystem" env:"FILESYSTEM_"`
+	Exec        ExecConnectorsConfig `yaml:"exec_connectors" env:"EXEC_CONNECTORS_"`
+
+	// Backoff configures the delays between attempts of all retried requests
+	Backoff BackoffConfig `yaml:"backoff" env:"BACKOFF_"`
+
+	// RateLimitStore is postgres to share provider rate limits between all worker replicas, or memory to limit each
+	// process on its own
+	RateLimitStore string `yaml:"rate_limit_store" env:"RATE_LIMIT_STORE"`
+
+	// AccountMaxConcurrentRuns limits how many runs of an account are processed at the same time across all workers
+	AccountMaxConcurrentRuns int `yaml:"account_max_concurrent_runs" env:"ACCOUNT_MAX_CONCURRENT_RUNS"`
+	// BulkRunBatchSize is how many changed documents a full index ingests before the rest is deferred back to the queue
+	BulkRunBatchSize int `yaml:"bulk_run_batch_size" env:"BULK_RUN_BATCH_SIZE"`
+
+	Quotas QuotasConfig `yaml:"quotas" env:"QUOTA_"`
+
+	Secrets SecretsConfig `yaml:"secrets" env:"SECRETS_"`
+	// Vault is used by the vault key management service, the environment variables are the ones of the Vault CLI
+	Vault VaultConfig `yaml:"vault" env:"VAULT_"`
+}
+
+// QueueConfig configures how messages are received from SQS. Messages are received with VisibilityTimeout and,
+// while they're processed, made invisible for VisibilityExtension every HeartbeatInterval.
+type QueueConfig struct {
+	URL                 string        `yaml:"url" env:"URL"`
+	VisibilityTimeout   time.Duration `yaml:"visibility_timeout" env:"VISIBILITY_TIMEOUT"`
+	VisibilityExtension time.Duration `yaml:"visibility_extension" env:"VISIBILITY_EXTENSION"`
+	HeartbeatInterval   time.Duration `yaml:"heartbeat_interval" env:"HEARTBEAT_INTERVAL"`
+}
+
+// HTTPClientConfig configures an HTTP client, MaxAttempts is how often requests are attempted before giving up
+type HTTPClientConfig struct {
+	Timeout     time.Duration `yaml:"timeout" env:"TIMEOUT"`
+	MaxAttempts int           `yaml:"max_attempts" env:"MAX_ATTEMPTS"`
+}
+
+// ProviderConfig configures the client of a provider API, Concurrency limits the number of documents fetched at the
+// same time
+type ProviderConfig struct {
+	HTTPClientConfig `yaml:",inline"`
+	Concurrency      int `yaml:"concurrency" env:"CONCURRENCY"`
+}
+
+// OAuthClientConfig is the OAuth app of an integration, which is used to refresh access tokens
+type OAuthClientConfig struct {
+	ClientId     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET" secret:"true"`
+}
+
+// BackoffConfig configures the exponential backoff between attempts, the delay is randomized by ±50%
+type BackoffConfig struct {
+	InitialInterval time.Duration `yaml:"initial_interval" env:"INITIAL_INTERVAL"`
+	MaxInterval     time.Duration `yaml:"max_interval" env:"MAX_INTERVAL"`
+	Multiplier      float64       `yaml:"multiplier" env:"MULTIPLIER"`
+	// MaxElapsedTime stops retrying even if attempts are left, set to 0 to only limit attempts
+	MaxElapsedTime time.Duration `yaml:"max_elapsed_time" env:"MAX_ELAPSED_TIME"`
+}
+
+type DocumentHelperConfig struct {
+	Endpoint       string `yaml:"endpoint" env:"ENDPOINT"`
+	ProviderConfig `yaml:",inline"`
+}
+
+type NotionConfig struct {
+	// UseHelper renders pages using the deprecated node helper at HelperEndpoint instead of the worker
+	UseHelper      bool   `yaml:"use_helper" env:"USE_HELPER"`
+	HelperEndpoint string `yaml:"helper_endpoint" env:"HELPER_ENDPOINT"`
+	// Notion doesn't send webhooks, so changes are polled in between full index runs, set to 0 to disable
+	PollInterval   time.Duration     `yaml:"poll_interval" env:"POLL_INTERVAL"`
+	OAuth          OAuthClientConfig `yaml:"oauth" env:"INTEGRATION_OAUTH_"`
+	ProviderConfig `yaml:",inline"`
+}
+
+type LinearConfig struct {
+	OAuth          OAuthClientConfig `yaml:"oauth" env:"INTEGRATION_OAUTH_"`
+	ProviderConfig `yaml:",inline"`
+}
+
+type WebConfig struct {
+	// Crawling internal addresses is only allowed for self-hosted setups indexing an intranet
+	AllowPrivateNetworks bool `yaml:"allow_private_networks" env:"ALLOW_PRIVATE_NETWORKS"`
+	HTTPClientConfig     `yaml:",inline"`
+}
+
+type S3Config struct {
+	// Custom endpoints (e.g. MinIO) may only point to private networks if AllowPrivateEndpoints is set
+	AllowPrivateEndpoints bool          `yaml:"allow_private_endpoints" env:"ALLOW_PRIVATE_ENDPOINTS"`
+	Timeout               time.Duration `yaml:"timeout" env:"TIMEOUT"`
+	Concurrency           int           `yaml:"concurrency" env:"CONCURRENCY"`
+}
+
+type FilesystemConfig struct {
+	// Local directories can only be indexed if they're located in RootPath, leave empty to disable
+	RootPath     string `yaml:"root_path" env:"ROOT_PATH"`
+	CheckoutPath string `yaml:"checkout_path" env:"CHECKOUT_PATH"`
+	// GitHub is used to look up the visibility of GitHub repositories, see filesystem_acl.go
+	GitHub HTTPClientConfig `yaml:"github" env:"GITHUB_"`
+}
+
+type ExecConnectorsConfig struct {
+	// Connector executables for exec data sources are resolved in Path, leave empty to disable
+	Path        string `yaml:"path" env:"PATH"`
+	Concurrency int    `yaml:"concurrency" env:"CONCURRENCY"`
+	MaxAttempts int    `yaml:"max_attempts" env:"MAX_ATTEMPTS"`
+}
+
+// QuotasConfig configures how usage is counted towards the quotas of plans, which are stored in the database
+type QuotasConfig struct {
+	// EmbeddingPricePerMillionTokens converts embedded tokens to the embedding spend of accounts, in USD
+	EmbeddingPricePerMillionTokens float64 `yaml:"embedding_price_per_million_tokens" env:"EMBEDDING_PRICE_PER_MILLION_TOKENS"`
+}
+
+// SecretsConfig selects the key management service which encrypts the secrets of integration connections and
+// pipelines, see secrets.go and secrets_kms.go
+type SecretsConfig struct {
+	// KMS is local, vault or aws, leave empty to keep secrets in plaintext
+	KMS string `yaml:"kms" env:"KMS"`
+	// LocalKeyFile holds the keys of the local key management service
+	LocalKeyFile      string `yaml:"local_key_file" env:"LOCAL_KEY_FILE"`
+	VaultTransitMount string `yaml:"vault_transit_mount" env:"VAULT_TRANSIT_MOUNT"`
+	VaultTransitKey   string `yaml:"vault_transit_key" env:"VAULT_TRANSIT_KEY"`
+	AWSKMSKeyId       string `yaml:"aws_kms_key_id" env:"AWS_KMS_KEY_ID"`
+	// Client configures requests to Vault and AWS KMS
+	Client HTTPClientConfig `yaml:"kms_client" env:"KMS_"`
+	// The app writes configs in plaintext, so every BackfillInterval the worker encrypts them just like
+	// `worker encrypt-secrets`. Set to 0 to only encrypt them by running the command.
+	BackfillInterval time.Duration `yaml:"backfill_interval" env:"BACKFILL_INTERVAL"`
+}
+
+type VaultConfig struct {
+	Addr  string `yaml:"addr" env:"ADDR"`
+	Token string `yaml:"token" env:"TOKEN" secret:"true"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Workers:                 4,
+		CredentialsResolverAddr: ":8081",
+		Queue: QueueConfig{
+			// The initial timeout is a bit longer to allow for the first heartbeat to run
+			VisibilityTimeout:   20 * time.Second,
+			VisibilityExtension: 15 * time.Second,
+			HeartbeatInterval:   5 * time.Second,
+		},
+		DocumentHelper: DocumentHelperConfig{
+			ProviderConfig: ProviderConfig{HTTPClientConfig{Timeout: 30 * time.Second, MaxAttempts: 5}, 5},
+		},
+		Notion: NotionConfig{
+			PollInterval: 5 * time.Minute,
+			// We're limited to 3 requests per second, so we definitely cannot allow more than 3 concurrent requests
+			ProviderConfig: ProviderConfig{HTTPClientConfig{Timeout: 5 * time.Minute, MaxAttempts: 10}, 3},
+		},
+		Linear: LinearConfig{
+			ProviderConfig: ProviderConfig{HTTPClientConfig{Timeout: 30 * time.Second, MaxAttempts: 10}, 5},
+		},
+		Zendesk:     ProviderConfig{HTTPClientConfig{Timeout: 30 * time.Second, MaxAttempts: 10}, 5},
+		Intercom:    ProviderConfig{HTTPClientConfig{Timeout: 30 * time.Second, MaxAttempts: 10}, 5},
+		Attachments: HTTPClientConfig{Timeout: 5 * time.Minute, MaxAttempts: 10},
+		Web: WebConfig{
+			HTTPClientConfig: HTTPClientConfig{Timeout: time.Minute, MaxAttempts: 5},
+		},
+		S3: S3Config{
+			Timeout:     5 * time.Minute,
+			Concurrency: 10,
+		},
+		Filesystem: FilesystemConfig{
+			CheckoutPath: filepath.Join(os.TempDir(), "langsync-checkouts"),
+			GitHub:       HTTPClientConfig{Timeout: 30 * time.Second, MaxAttempts: 5},
+		},
+		Exec: ExecConnectorsConfig{
+			Concurrency: 5,
+			MaxAttempts: 3,
+		},
+		RateLimitStore:           "postgres",
+		AccountMaxConcurrentRuns: 2,
+		BulkRunBatchSize:         500,
+		Quotas: QuotasConfig{
+			// text-embedding-ada-002
+			EmbeddingPricePerMillionTokens: 0.1,
+		},
+		// The defaults of github.com/cenkalti/backoff
+		Backoff: BackoffConfig{
+			InitialInterval: 500 * time.Millisecond,
+			MaxInterval:     time.Minute,
+			Multiplier:      1.5,
+			MaxElapsedTime:  15 * time.Minute,
+		},
+		Secrets: SecretsConfig{
+			VaultTransitMount: "transit",
+			Client:            HTTPClientConfig{Timeout: 10 * time.Second, MaxAttempts: 5},
+			BackfillInterval:  15 * time.Minute,
+		},
+	}
+}
+
+// loadConfig reads the configuration and validates it
+func loadConfig() (*Config, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// readConfig reads the configuration file at WORKER_CONFIG_FILE on top of the defaults and applies the environment
+// variables, commands which only need some of the settings validate them on their own
+func readConfig() (*Config, error) {
+	config := defaultConfig()
+
+	if path := os.Getenv("WORKER_CONFIG_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open config file, %w", err)
+		}
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		// Misspelled settings would otherwise silently fall back to their defaults
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse config file %q, %w", path, err)
+		}
+	}
+
+	var errs []error
+	walkConfig(reflect.ValueOf(&config).Elem(), "", "", func(field configField) {
+		value, ok := os.LookupEnv(field.env)
+		if !ok || value == "" {
+			return
+		}
+		err := setConfigField(field.value, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %w", field.env, err))
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return &config, nil
+}
+
+func (config *Config) validate() error {
+	var errs []error
+	require := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	require(config.Workers >= 1, "NUM_WORKERS must be at least 1")
+	require(config.Queue.URL != "", "INDEX_QUEUE_URL must be set")
+	// SQS doesn't accept visibility timeouts above 12 hours
+	require(config.Queue.VisibilityTimeout >= time.Second && config.Queue.VisibilityTimeout <= 12*time.Hour, "INDEX_QUEUE_VISIBILITY_TIMEOUT must be between 1s and 12h")
+	require(config.Queue.VisibilityExtension >= time.Second && config.Queue.VisibilityExtension <= 12*time.Hour, "INDEX_QUEUE_VISIBILITY_EXTENSION must be between 1s and 12h")
+	// Messages would become visible to other workers in between heartbeats
+	require(config.Queue.HeartbeatInterval > 0 && config.Queue.HeartbeatInterval < config.Queue.VisibilityTimeout && config.Queue.HeartbeatInterval < config.Queue.VisibilityExtension, "INDEX_QUEUE_HEARTBEAT_INTERVAL must be shorter than the visibility timeout and extension")
+
+	require(config.PostgresURL != "", "POSTGRES_URL_NON_POOLING must be set")
+	require(config.OpenAIApiKey != "", "OPENAI_API_KEY must be set")
+	require(config.CredentialsReferenceKey == "" || config.HelperCredentialsToken != "", "HELPER_CREDENTIALS_TOKEN must be set")
+	require(config.CredentialsReferenceKey == "" || config.CredentialsResolverAddr != "", "CREDENTIALS_RESOLVER_ADDR must be set")
+	require(config.DocumentHelper.Endpoint != "", "DOCUMENT_HELPER_ENDPOINT must be set")
+
+	providers := map[string]ProviderConfig{
+		"DOCUMENT_HELPER_": config.DocumentHelper.ProviderConfig,
+		"NOTION_":          config.Notion.ProviderConfig,
+		"LINEAR_":          config.Linear.ProviderConfig,
+		"ZENDESK_":         config.Zendesk,
+		"INTERCOM_":        config.Intercom,
+	}
+	httpClients := map[string]HTTPClientConfig{
+		"ATTACHMENTS_":       config.Attachments,
+		"WEB_":               config.Web.HTTPClientConfig,
+		"FILESYSTEM_GITHUB_": config.Filesystem.GitHub,
+	}
+	for prefix, provider := range providers {
+		require(provider.Concurrency >= 1, "%sCONCURRENCY must be at least 1", prefix)
+		httpClients[prefix] = provider.HTTPClientConfig
+	}
+	for prefix, httpClient := range httpClients {
+		require(httpClient.Timeout > 0, "%sTIMEOUT must be positive", prefix)
+		require(httpClient.MaxAttempts >= 1, "%sMAX_ATTEMPTS must be at least 1", prefix)
+	}
+	require(config.S3.Timeout > 0, "S3_TIMEOUT must be positive")
+	require(config.S3.Concurrency >= 1, "S3_CONCURRENCY must be at least 1")
+	require(config.Notion.PollInterval >= 0, "NOTION_POLL_INTERVAL must not be negative")
+	require(!config.Notion.UseHelper || config.Notion.HelperEndpoint != "", "NOTION_HELPER_ENDPOINT must be set if NOTION_USE_HELPER is set")
+	require(config.Exec.Concurrency >= 1, "EXEC_CONNECTORS_CONCURRENCY must be at least 1")
+	require(config.Exec.MaxAttempts >= 1, "EXEC_CONNECTORS_MAX_ATTEMPTS must be at least 1")
+	require(config.Filesystem.CheckoutPath != "", "FILESYSTEM_CHECKOUT_PATH must be set")
+
+	oauthClients := map[string]OAuthClientConfig{
+		"NOTION_INTEGRATION_OAUTH_": config.Notion.OAuth,
+		"LINEAR_INTEGRATION_OAUTH_": config.Linear.OAuth,
+	}
+	for prefix, client := range oauthClients {
+		// Without an OAuth app, tokens can't be refreshed and connections need to be reconnected once they expire
+		require((client.ClientId == "") == (client.ClientSecret == ""), "%sCLIENT_ID and %sCLIENT_SECRET must be set together", prefix, prefix)
+	}
+
+	require(config.Backoff.InitialInterval > 0, "BACKOFF_INITIAL_INTERVAL must be positive")
+	require(config.Backoff.MaxInterval >= config.Backoff.InitialInterval, "BACKOFF_MAX_INTERVAL must not be shorter than BACKOFF_INITIAL_INTERVAL")
+	require(config.Backoff.Multiplier >= 1, "BACKOFF_MULTIPLIER must be at least 1")
+	require(config.Backoff.MaxElapsedTime >= 0, "BACKOFF_MAX_ELAPSED_TIME must not be negative")
+
+	require(config.RateLimitStore == "postgres" || config.RateLimitStore == "memory", "RATE_LIMIT_STORE must be postgres or memory")
+	require(config.AccountMaxConcurrentRuns >= 1, "ACCOUNT_MAX_CONCURRENT_RUNS must be at least 1")
+	require(config.BulkRunBatchSize >= 1, "BULK_RUN_BATCH_SIZE must be at least 1")
+	require(config.Quotas.EmbeddingPricePerMillionTokens >= 0, "QUOTA_EMBEDDING_PRICE_PER_MILLION_TOKENS must not be negative")
+
+	errs = append(errs, config.validateSecrets())
+
+	return errors.Join(errs...)
+}
+
+// validateSecrets validates the settings of the key management service, which `worker encrypt-secrets` needs as well
+func (config *Config) validateSecrets() error {
+	var errs []error
+	require := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	switch config.Secrets.KMS {
+	case "":
+	case "local":
+		require(config.Secrets.LocalKeyFile != "", "SECRETS_LOCAL_KEY_FILE must be set")
+	case "vault":
+		require(config.Vault.Addr != "" && config.Vault.Token != "", "VAULT_ADDR and VAULT_TOKEN must be set")
+		require(config.Secrets.VaultTransitMount != "" && config.Secrets.VaultTransitKey != "", "SECRETS_VAULT_TRANSIT_MOUNT and SECRETS_VAULT_TRANSIT_KEY must be set")
+	case "aws":
+		require(config.Secrets.AWSKMSKeyId != "", "SECRETS_AWS_KMS_KEY_ID must be set")
+	default:
+		require(false, "SECRETS_KMS must be local, vault or aws")
+	}
+	require(config.Secrets.Client.Timeout > 0, "SECRETS_KMS_TIMEOUT must be positive")
+	require(config.Secrets.Client.MaxAttempts >= 1, "SECRETS_KMS_MAX_ATTEMPTS must be at least 1")
+	require(config.Secrets.BackfillInterval >= 0, "SECRETS_BACKFILL_INTERVAL must not be negative")
+
+	return errors.Join(errs...)
+}
+
+// String lists the effective settings with their environment variables, secrets are redacted
+func (config *Config) String() string {
+	var lines []string
+	walkConfig(reflect.ValueOf(config).Elem(), "", "", func(field configField) {
+		value := fmt.Sprint(field.value.Interface())
+		if field.secret && value != "" {
+			value = "[redacted]"
+		}
+		lines = append(lines, fmt.Sprintf("  %s (%s): %s", field.path, field.env, value))
+	})
+	return strings.Join(lines, "\n")
+}
+
+// configField is a setting of Config, path is its key in the configuration file
+type configField struct {
+	path   string
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+func walkConfig(value reflect.Value, path, envPrefix string, fn func(field configField)) {
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+
+		name, _, _ := strings.Cut(structField.Tag.Get("yaml"), ",")
+		fieldPath := path
+		if name != "" {
+			if fieldPath != "" {
+				fieldPath += "."
+			}
+			fieldPath += name
+		}
+
+		env := envPrefix + structField.Tag.Get("env")
+		if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Duration(0)) {
+			walkConfig(value.Field(i), fieldPath, env, fn)
+			continue
+		}
+
+		fn(configField{
+			path:   fieldPath,
+			env:    env,
+			secret: structField.Tag.Get("secret") == "true",
+			value:  value.Field(i),
+		})
+	}
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration, %w", err)
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		field.SetInt(int64(number))
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		field.SetFloat(number)
+	case reflect.Bool:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(enabled)
+	default:
+		return fmt.Errorf("has unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Helper services don't receive secrets in request bodies, which end up in request logs and traces. Instead, secrets
+// are replaced with credential references, which helpers resolve by calling the worker on an authenticated endpoint:
+//
+//	POST /credentials/resolve
+//	Authorization: Bearer <HELPER_CREDENTIALS_TOKEN>
+//	{"reference": "credref:v1:...", "account": "<account the helper request was sent for>"}
+//
+// The endpoint is served on its own listener at CREDENTIALS_RESOLVER_ADDR, which must only be reachable by the
+// helpers (CREDENTIALS_RESOLVER_URL in their environment), not on the public health check port.
+//
+// A reference is the secret, its account and expiry sealed with CREDENTIALS_REFERENCE_KEY, so it's meaningless to
+// anyone but the workers and any worker can resolve references created by another worker. Helpers pass the account
+// of their request along, so a reference is only resolved for the account it was created for.
+const credentialReferencePrefix = "credref:v1:"
+
+// References must outlive retries of a helper request, but not much longer
+const credentialReferenceTTL = 10 * time.Minute
+
+var (
+	errCredentialReferenceExpired      = errors.New("credential reference expired")
+	errCredentialReferenceWrongAccount = errors.New("credential reference belongs to another account")
+)
+
+type credentialReferencePayload struct {
+	Value     string `json:"v"`
+	Account   string `json:"a"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// CredentialReferences creates and resolves credential references, a nil *CredentialReferences passes secrets
+// through unchanged for setups which haven't configured CREDENTIALS_REFERENCE_KEY
+type CredentialReferences struct {
+	key           []byte
+	resolverToken string
+}
+
+func newCredentialReferences(encodedKey, resolverToken string) (*CredentialReferences, error) {
+	if encodedKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("CREDENTIALS_REFERENCE_KEY must be 32 bytes encoded as base64")
+	}
+	if resolverToken == "" {
+		return nil, fmt.Errorf("HELPER_CREDENTIALS_TOKEN must be set")
+	}
+
+	return &CredentialReferences{key: key, resolverToken: resolverToken}, nil
+}
+
+// Reference returns a reference to secret, which can only be resolved for account
+func (refs *CredentialReferences) Reference(account, secret string) (string, error) {
+	if refs == nil || secret == "" {
+		return secret, nil
+	}
+
+	return refs.seal(credentialReferencePayload{
+		Value:     secret,
+		Account:   account,
+		ExpiresAt: time.Now().Add(credentialReferenceTTL).Unix(),
+	})
+}
+
+func (refs *CredentialReferences) seal(payload credentialReferencePayload) (string, error) {
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(refs.key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	return credentialReferencePrefix + base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// ReferenceSecrets returns value as JSON with all secret fields replaced by references
+func (refs *CredentialReferences) ReferenceSecrets(account string, value any) (json.RawMessage, error) {
+	marshalled, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if refs == nil {
+		return marshalled, nil
+	}
+
+	document, err := decodeJSONDocument(marshalled)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err = walkJSONStrings(document, "", func(field, value string) (string, error) {
+		if !secretFields[field] {
+			return value, nil
+		}
+		return refs.Reference(account, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(document)
+}
+
+func (refs *CredentialReferences) resolve(reference, account string) (string, error) {
+	if !strings.HasPrefix(reference, credentialReferencePrefix) {
+		return "", fmt.Errorf("invalid credential reference")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(reference, credentialReferencePrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid credential reference")
+	}
+
+	gcm, err := newGCM(refs.key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid credential reference")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid credential reference")
+	}
+
+	var payload credentialReferencePayload
+	err = json.Unmarshal(plaintext, &payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid credential reference")
+	}
+	if time.Now().Unix() > payload.ExpiresAt {
+		return "", errCredentialReferenceExpired
+	}
+	if payload.Account != account {
+		return "", errCredentialReferenceWrongAccount
+	}
+
+	return payload.Value, nil
+}
+
+// ServeHTTP resolves credential references for helper services
+func (refs *CredentialReferences) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if refs == nil {
+		http.Error(w, "credential references are not configured", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(refs.resolverToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Reference string `json:"reference"`
+		Account   string `json:"account"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&body)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	value, err := refs.resolve(body.Reference, body.Account)
+	if errors.Is(err, errCredentialReferenceExpired) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if errors.Is(err, errCredentialReferenceWrongAccount) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]string{"value": value})
+}
+
+// startCredentialsResolver serves /credentials/resolve on the internal listener at addr until ctx is done
+func startCredentialsResolver(ctx context.Context, logger logrus.FieldLogger, addr string, refs *CredentialReferences) {
+	mux := http.NewServeMux()
+	mux.Handle("/credentials/resolve", refs)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	go func() {
+		logger.Printf("Serving credential references on %s.\n", addr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("unable to serve credential references, %v", err)
+		}
+	}()
+}
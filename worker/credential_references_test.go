@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCredentialReferences(t *testing.T) *CredentialReferences {
+	t.Helper()
+
+	refs, err := newCredentialReferences(base64.StdEncoding.EncodeToString(make([]byte, 32)), "helper-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs
+}
+
+// tamper flips a bit of the sealed reference
+func tamper(t *testing.T, reference string) string {
+	t.Helper()
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(reference, credentialReferencePrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	return credentialReferencePrefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func TestCredentialReferencesResolve(t *testing.T) {
+	refs := newTestCredentialReferences(t)
+
+	reference, err := refs.Reference("account-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reference, credentialReferencePrefix) || strings.Contains(reference, "secret") {
+		t.Fatalf("got reference %q", reference)
+	}
+
+	expired, err := refs.seal(credentialReferencePayload{Value: "secret", Account: "account-1", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := newCredentialReferences(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))), "helper-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyReference, err := otherKey.Reference("account-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		reference string
+		account   string
+		want      string
+		wantErr   error
+	}{
+		{name: "valid", reference: reference, account: "account-1", want: "secret"},
+		{name: "expired", reference: expired, account: "account-1", wantErr: errCredentialReferenceExpired},
+		{name: "wrong account", reference: reference, account: "account-2", wantErr: errCredentialReferenceWrongAccount},
+		{name: "tampered", reference: tamper(t, reference), account: "account-1"},
+		{name: "sealed with another key", reference: otherKeyReference, account: "account-1"},
+		{name: "not a reference", reference: "secret", account: "account-1"},
+		{name: "truncated", reference: credentialReferencePrefix + "AAAA", account: "account-1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := refs.resolve(test.reference, test.account)
+			if test.want != "" {
+				if err != nil || value != test.want {
+					t.Errorf("got %q, %v", value, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("resolved %q", value)
+			}
+			if test.wantErr != nil && err != test.wantErr {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCredentialReferencesReferenceSecrets(t *testing.T) {
+	refs := newTestCredentialReferences(t)
+
+	referenced, err := refs.ReferenceSecrets("account-1", map[string]any{"api_key": "secret", "index_name": "docs", "empty": map[string]any{"api_key": ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document map[string]any
+	err = json.Unmarshal(referenced, &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document["index_name"] != "docs" || document["empty"].(map[string]any)["api_key"] != "" {
+		t.Errorf("referenced fields which aren't secrets, %s", referenced)
+	}
+	value, err := refs.resolve(document["api_key"].(string), "account-1")
+	if err != nil || value != "secret" {
+		t.Errorf("got %q, %v", value, err)
+	}
+
+	// Without a key secrets are passed through
+	var plaintext *CredentialReferences
+	referenced, err = plaintext.ReferenceSecrets("account-1", map[string]any{"api_key": "secret"})
+	if err != nil || string(referenced) != `{"api_key":"secret"}` {
+		t.Errorf("got %s, %v", referenced, err)
+	}
+}
+
+func TestCredentialReferencesServeHTTP(t *testing.T) {
+	refs := newTestCredentialReferences(t)
+
+	reference, err := refs.Reference("account-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := refs.seal(credentialReferencePayload{Value: "secret", Account: "account-1", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{name: "valid", token: "helper-token", body: `{"reference":"` + reference + `","account":"account-1"}`, wantStatus: http.StatusOK},
+		{name: "wrong token", token: "other", body: `{"reference":"` + reference + `","account":"account-1"}`, wantStatus: http.StatusUnauthorized},
+		{name: "no token", body: `{"reference":"` + reference + `","account":"account-1"}`, wantStatus: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodGet, token: "helper-token", wantStatus: http.StatusMethodNotAllowed},
+		{name: "expired", token: "helper-token", body: `{"reference":"` + expired + `","account":"account-1"}`, wantStatus: http.StatusGone},
+		{name: "wrong account", token: "helper-token", body: `{"reference":"` + reference + `","account":"account-2"}`, wantStatus: http.StatusForbidden},
+		{name: "tampered", token: "helper-token", body: `{"reference":"` + tamper(t, reference) + `","account":"account-1"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid body", token: "helper-token", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/credentials/resolve", strings.NewReader(test.body))
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			recorder := httptest.NewRecorder()
+			refs.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, test.wantStatus, recorder.Body)
+			}
+			if test.wantStatus == http.StatusOK && strings.TrimSpace(recorder.Body.String()) != `{"value":"secret"}` {
+				t.Errorf("got body %s", recorder.Body)
+			}
+			if test.wantStatus != http.StatusOK && strings.Contains(recorder.Body.String(), "secret") {
+				t.Errorf("leaked the secret, %s", recorder.Body)
+			}
+		})
+	}
+
+	// Deployments without CREDENTIALS_REFERENCE_KEY don't resolve references
+	var plaintext *CredentialReferences
+	recorder := httptest.NewRecorder()
+	plaintext.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/credentials/resolve", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d without key", recorder.Code)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrIntegrationUnauthorized is returned by data sources when the provider rejects the credentials of a connection,
+// e.g. because access was revoked. Retrying doesn't help, the integration must be connected again.
+var ErrIntegrationUnauthorized = errors.New("integration unauthorized")
+
+// Tokens are refreshed ahead of their expiry, so they don't expire while a run is in progress
+const credentialRefreshMargin = 15 * time.Minute
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// CredentialManager refreshes OAuth tokens of integration connections before they expire and writes them back to the
+// connection config. Notion and Linear issue refresh tokens, other integrations use tokens which don't expire.
+type CredentialManager struct {
+	logger    logrus.FieldLogger
+	pool      *pgxpool.Pool
+	secrets   *Secrets
+	transport *ProviderTransport
+
+	// Same OAuth apps as used by the app to connect integrations
+	linearOAuth OAuthClientConfig
+	notionOAuth OAuthClientConfig
+}
+
+func newCredentialManager(logger logrus.FieldLogger, pool *pgxpool.Pool, secrets *Secrets, linearOAuth, notionOAuth OAuthClientConfig) *CredentialManager {
+	return &CredentialManager{
+		logger:  logger,
+		pool:    pool,
+		secrets: secrets,
+		// Refreshes are never retried, see EnsureFresh
+		transport: newProviderTransport("oauth", &http.Client{
+			Timeout: time.Second * 30,
+		}, nil, 0, classifyOAuthTokenResponse),
+		linearOAuth: linearOAuth,
+		notionOAuth: notionOAuth,
+	}
+}
+
+// credentialExpiry returns when the access token of a connection expires and whether it can be refreshed, nil if the
+// token doesn't expire
+func credentialExpiry(connection *IntegrationConnection) (*time.Time, bool) {
+	switch connection.Integration {
+	case IntegrationLinear:
+		config := connection.LinearIntegrationConnection.Config
+		if config.ExpiresAt != nil {
+			return config.ExpiresAt, config.RefreshToken != ""
+		}
+		if config.ExpiresIn <= 0 {
+			return nil, false
+		}
+		connectedAt, err := time.Parse(time.RFC3339, connection.ConnectedAt)
+		if err != nil {
+			return nil, false
+		}
+		expiresAt := connectedAt.Add(time.Duration(config.ExpiresIn) * time.Second)
+		return &expiresAt, config.RefreshToken != ""
+	case IntegrationNotion:
+		config := connection.NotionIntegrationConnection.Config
+		return config.ExpiresAt, config.RefreshToken != ""
+	default:
+		return nil, false
+	}
+}
+
+func needsRefresh(connection *IntegrationConnection) bool {
+	expiresAt, _ := credentialExpiry(connection)
+	return expiresAt != nil && time.Until(*expiresAt) < credentialRefreshMargin
+}
+
+// EnsureFresh refreshes the access token of a connection which is about to expire and updates connection in place.
+// Returns ErrIntegrationUnauthorized if the token expired and can't be refreshed.
+func (manager *CredentialManager) EnsureFresh(ctx context.Context, connection *IntegrationConnection) error {
+	if connection.NeedsReauth {
+		return ErrIntegrationUnauthorized
+	}
+	if !needsRefresh(connection) {
+		return nil
+	}
+
+	tx, err := manager.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction, %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Refresh tokens may only be used once, so the connection is locked while refreshing. Another worker may have
+	// refreshed the token in the meantime.
+	locked, err := LockIntegrationConnection(ctx, tx, manager.secrets, connection.Account, connection.Integration)
+	if err != nil {
+		return fmt.Errorf("unable to lock integration connection, %w", err)
+	}
+	if locked == nil {
+		return fmt.Errorf("integration connection not found")
+	}
+	if locked.NeedsReauth {
+		return ErrIntegrationUnauthorized
+	}
+	if !needsRefresh(locked) {
+		*connection = *locked
+		return nil
+	}
+
+	expiresAt, refreshable := credentialExpiry(locked)
+	if !refreshable {
+		if time.Now().Before(*expiresAt) {
+			// Still usable for now, the connection is marked once the provider rejects the token
+			*connection = *locked
+			return nil
+		}
+		return ErrIntegrationUnauthorized
+	}
+
+	manager.logger.Printf("Refreshing %s access token for account %q\n", locked.Integration, locked.Account)
+
+	token, err := manager.refresh(ctx, locked)
+	if err != nil {
+		// The provider may have rotated the refresh token even if its response got lost, so the refresh isn't sent
+		// again, which could be taken for reuse of the old one. The stored token is used while it's still valid, the
+		// next run refreshes it.
+		if !errors.Is(err, ErrIntegrationUnauthorized) && time.Now().Before(*expiresAt) {
+			manager.logger.Printf("Unable to refresh %s access token for account %q, using the stored token until it expires, %v\n", locked.Integration, locked.Account, err)
+			*connection = *locked
+			return nil
+		}
+		return err
+	}
+
+	fields := map[string]any{
+		"access_token": token.AccessToken,
+	}
+	if token.RefreshToken != "" {
+		fields["refresh_token"] = token.RefreshToken
+	}
+	if token.ExpiresIn > 0 {
+		fields["expires_in"] = token.ExpiresIn
+		fields["expires_at"] = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second).UTC().Format(time.RFC3339)
+	}
+
+	marshalledFields, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	// Refreshed tokens are stored encrypted if secrets are encrypted
+	encryptedFields, _, err := manager.secrets.EncryptJSON(ctx, marshalledFields)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt refreshed token, %w", err)
+	}
+
+	err = UpdateIntegrationConnectionConfig(ctx, tx, locked.Account, locked.Integration, encryptedFields)
+	if err != nil {
+		return fmt.Errorf("unable to store refreshed token, %w", err)
+	}
+
+	refreshed, err := GetIntegrationConnection(ctx, tx, manager.secrets, locked.Account, locked.Integration)
+	if err != nil {
+		return fmt.Errorf("unable to get integration connection, %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction, %w", err)
+	}
+
+	*connection = *refreshed
+
+	return nil
+}
+
+// classifyOAuthTokenResponse treats refresh tokens rejected with invalid_grant as revoked credentials. Other errors,
+// e.g. invalid_client, are caused by the configuration of the OAuth app and don't tell anything about the connection.
+// https://www.rfc-editor.org/rfc/rfc6749#section-5.2
+func classifyOAuthTokenResponse(res *http.Response) error {
+	if res.StatusCode != http.StatusBadRequest && res.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&errResp)
+
+	// The refresh token was revoked or already used
+	if errResp.Error == "invalid_grant" {
+		return backoff.Permanent(ErrIntegrationUnauthorized)
+	}
+	return backoff.Permanent(fmt.Errorf("unable to refresh token, status code %d, error %q", res.StatusCode, errResp.Error))
+}
+
+// refresh exchanges the refresh token of a connection for a new access token
+func (manager *CredentialManager) refresh(ctx context.Context, connection *IntegrationConnection) (*oauthTokenResponse, error) {
+	var request providerRequest
+
+	switch connection.Integration {
+	case IntegrationLinear:
+		// https://developers.linear.app/docs/oauth/authentication#refresh-an-access-token
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", connection.LinearIntegrationConnection.Config.RefreshToken)
+		form.Set("client_id", manager.linearOAuth.ClientId)
+		form.Set("client_secret", manager.linearOAuth.ClientSecret)
+
+		request = providerRequest{
+			Method: http.MethodPost,
+			URL:    "https://api.linear.app/oauth/token",
+			Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			Body:   []byte(form.Encode()),
+		}
+	case IntegrationNotion:
+		// https://developers.notion.com/reference/refresh-a-token
+		body, err := json.Marshal(map[string]any{
+			"grant_type":    "refresh_token",
+			"refresh_token": connection.NotionIntegrationConnection.Config.RefreshToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		request = providerRequest{
+			Method: http.MethodPost,
+			URL:    "https://api.notion.com/v1/oauth/token",
+			Header: http.Header{
+				"Content-Type":   {"application/json"},
+				"Notion-Version": {"2022-06-28"},
+			},
+			Body: body,
+			Prepare: func(req *http.Request) error {
+				req.SetBasicAuth(manager.notionOAuth.ClientId, manager.notionOAuth.ClientSecret)
+				return nil
+			},
+		}
+	default:
+		return nil, fmt.Errorf("integration %q doesn't support refreshing tokens", connection.Integration)
+	}
+
+	var token oauthTokenResponse
+	err := manager.transport.DoJSON(ctx, request, &token)
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response is missing access token")
+	}
+
+	return &token, nil
+}
+
+// MarkUnauthorized flags the connection if err was caused by rejected credentials, returns whether it was
+func (manager *CredentialManager) MarkUnauthorized(ctx context.Context, connection *IntegrationConnection, err error) (bool, error) {
+	if !errors.Is(err, ErrIntegrationUnauthorized) {
+		return false, nil
+	}
+
+	manager.logger.Printf("Credentials for %s were rejected, marking connection of account %q as needing reauthorization\n", connection.Integration, connection.Account)
+
+	err = MarkIntegrationConnectionNeedsReauth(ctx, manager.pool, connection.Account, connection.Integration)
+	if err != nil {
+		return true, fmt.Errorf("unable to mark integration connection, %w", err)
+	}
+	connection.NeedsReauth = true
+
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestClassifyOAuthTokenResponse(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		body             string
+		wantUnauthorized bool
+		wantRetry        bool
+	}{
+		{name: "invalid grant", status: http.StatusBadRequest, body: `{"error":"invalid_grant"}`, wantUnauthorized: true},
+		{name: "invalid client", status: http.StatusUnauthorized, body: `{"error":"invalid_client"}`},
+		{name: "invalid request", status: http.StatusBadRequest, body: `{"error":"invalid_request"}`},
+		{name: "no error code", status: http.StatusBadRequest, body: `Bad Request`},
+		{name: "server error", status: http.StatusBadGateway, wantRetry: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestHTTPClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = io.WriteString(w, test.body)
+			}))
+
+			res, err := server.Get("https://api.linear.app/oauth/token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			err = classifyOAuthTokenResponse(res)
+			if test.wantRetry {
+				if err != nil {
+					t.Errorf("got %v, want the default classification", err)
+				}
+				return
+			}
+			if err == nil || errors.Is(err, ErrIntegrationUnauthorized) != test.wantUnauthorized {
+				t.Errorf("got %v, unauthorized = %v", err, test.wantUnauthorized)
+			}
+		})
+	}
+}
+
+func TestCredentialRefreshIsSentOnce(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	httpClient := newTestHTTPClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	manager := newCredentialManager(logger, nil, nil, OAuthClientConfig{}, OAuthClientConfig{})
+	manager.transport.httpClient = httpClient
+
+	connection := &IntegrationConnection{}
+	connection.Integration = IntegrationLinear
+	connection.LinearIntegrationConnection.Config.RefreshToken = "refresh"
+
+	_, err := manager.refresh(context.Background(), connection)
+	if err == nil || errors.Is(err, ErrIntegrationUnauthorized) {
+		t.Errorf("got %v, want a server error", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 1 {
+		t.Errorf("refresh was sent %d times, want once", attempts)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"time"
@@ -44,8 +46,14 @@ give the field a JSON tag of "-".
 */
 
 const (
-	IntegrationNotion Integration = "notion"
-	IntegrationLinear Integration = "linear"
+	IntegrationNotion     Integration = "notion"
+	IntegrationLinear     Integration = "linear"
+	IntegrationFilesystem Integration = "filesystem"
+	IntegrationWeb        Integration = "web"
+	IntegrationS3         Integration = "s3"
+	IntegrationZendesk    Integration = "zendesk"
+	IntegrationIntercom   Integration = "intercom"
+	IntegrationExec       Integration = "exec"
 )
 
 type Account struct {
@@ -81,23 +89,166 @@ func GetAccount(ctx context.Context, client Querier, accountId string) (*Account
 	return &account, nil
 }
 
-// IncreaseTotalIndexedCount simply increases total_indexed_document_count by countToAdd. Because multiple workers may
-// run at a given time, adding instead of overwriting is important. This way we don't lose any counts.
-func IncreaseTotalIndexedCount(ctx context.Context, client Querier, accountId string, countToAdd int) error {
+// Plan defines the quotas of accounts, limits apply per calendar month and are nil if unlimited
+type Plan struct {
+	Id                string   `json:"id"`
+	Name              string   `json:"name"`
+	MaxDocuments      *int     `json:"max_documents"`
+	MaxTokens         *int64   `json:"max_tokens"`
+	MaxEmbeddingSpend *float64 `json:"max_embedding_spend"`
+	MaxDocumentTokens int      `json:"max_document_tokens"`
+}
+
+// GetAccountPlan returns the plan of an account, accounts without a plan are on the free or subscriber plan
+func GetAccountPlan(ctx context.Context, client Querier, accountId string) (*Plan, error) {
+	row := client.QueryRow(ctx, `
+		SELECT p.id, p.name, p.max_documents, p.max_tokens, p.max_embedding_spend, p.max_document_tokens
+		FROM langsync.account a
+		JOIN langsync.plan p ON p.id = COALESCE(a.plan, CASE WHEN a.is_subscriber THEN 'subscriber' ELSE 'free' END)
+		WHERE a.id = $1
+	`, accountId)
+
+	var plan Plan
+	err := row.Scan(&plan.Id, &plan.Name, &plan.MaxDocuments, &plan.MaxTokens, &plan.MaxEmbeddingSpend, &plan.MaxDocumentTokens)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// QuotaAmount is an amount of usage, reserved or requested quota
+type QuotaAmount struct {
+	Documents      int     `json:"documents"`
+	Tokens         int64   `json:"tokens"`
+	EmbeddingSpend float64 `json:"embedding_spend"`
+}
+
+// QuotaLimits are the limits of a plan in a period, nil if unlimited
+type QuotaLimits struct {
+	MaxDocuments      *int
+	MaxTokens         *int64
+	MaxEmbeddingSpend *float64
+}
+
+// GetQuotaUsed returns the quota an account used in the period starting at periodStart, including quota reserved by
+// runs in progress
+func GetQuotaUsed(ctx context.Context, client Querier, accountId string, periodStart time.Time) (QuotaAmount, error) {
+	var used QuotaAmount
+	err := client.QueryRow(ctx, `
+		SELECT COALESCE(SUM(documents), 0)::integer, COALESCE(SUM(tokens), 0)::bigint, COALESCE(SUM(embedding_spend), 0)::double precision
+		FROM (
+			SELECT indexed_documents AS documents, indexed_tokens AS tokens, embedding_spend
+			FROM langsync.account_usage
+			WHERE account = $1 AND period_start = $2
+			UNION ALL
+			SELECT documents, tokens, embedding_spend
+			FROM langsync.quota_reservation
+			WHERE account = $1 AND period_start = $2 AND expires_at > now()
+		) used
+	`, accountId, periodStart).Scan(&used.Documents, &used.Tokens, &used.EmbeddingSpend)
+
+	return used, err
+}
+
+// ReserveQuota adds quota of an account to the reservation reservationId, which is created if it doesn't exist yet.
+// Documents are granted as far as limits allow, tokens and embedding spend either completely or not at all, the
+// returned reason tells which limit prevented granting all of request. Reserved quota counts towards the limits until
+// it's committed or released. Must be called in a transaction.
+func ReserveQuota(ctx context.Context, client Querier, reservationId, accountId string, periodStart time.Time, limits QuotaLimits, request QuotaAmount, ttl time.Duration) (QuotaAmount, string, error) {
+	// Serializes reservations per account, the lock is released with the transaction
+	_, err := client.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('quota_reservation:' || $1))`, accountId)
+	if err != nil {
+		return QuotaAmount{}, "", err
+	}
+
+	// Reservations of workers which didn't release them
+	_, err = client.Exec(ctx, `
+		DELETE FROM langsync.quota_reservation
+		WHERE account = $1 AND expires_at <= now()
+	`, accountId)
+	if err != nil {
+		return QuotaAmount{}, "", err
+	}
+
+	used, err := GetQuotaUsed(ctx, client, accountId, periodStart)
+	if err != nil {
+		return QuotaAmount{}, "", err
+	}
+
+	granted, reason := limits.grant(used, request)
+
+	_, err = client.Exec(ctx, `
+		INSERT INTO langsync.quota_reservation (id, account, period_start, documents, tokens, embedding_spend, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now() + $7::double precision * interval '1 second')
+		ON CONFLICT (id) DO UPDATE
+		SET documents = quota_reservation.documents + excluded.documents,
+		    tokens = quota_reservation.tokens + excluded.tokens,
+		    embedding_spend = quota_reservation.embedding_spend + excluded.embedding_spend,
+		    expires_at = excluded.expires_at
+	`, reservationId, accountId, periodStart, granted.Documents, granted.Tokens, granted.EmbeddingSpend, ttl.Seconds())
+	if err != nil {
+		return QuotaAmount{}, "", err
+	}
+
+	return granted, reason, nil
+}
+
+// ReleaseQuota returns amount of the reservation reservationId, so it no longer counts towards the limits
+func ReleaseQuota(ctx context.Context, client Querier, reservationId string, amount QuotaAmount, ttl time.Duration) error {
 	_, err := client.Exec(ctx, `
+		UPDATE langsync.quota_reservation
+		SET documents = GREATEST(documents - $2, 0),
+		    tokens = GREATEST(tokens - $3, 0),
+		    embedding_spend = GREATEST(embedding_spend - $4, 0),
+		    expires_at = now() + $5::double precision * interval '1 second'
+		WHERE id = $1
+	`, reservationId, amount.Documents, amount.Tokens, amount.EmbeddingSpend, ttl.Seconds())
+
+	return err
+}
+
+// CommitQuota moves amount of the reservation reservationId to the usage of the account. Usage is committed even if
+// the reservation expired in the meantime, as the quota was used nonetheless. Must be called in a transaction.
+func CommitQuota(ctx context.Context, client Querier, reservationId, accountId string, periodStart time.Time, amount QuotaAmount, ttl time.Duration) error {
+	err := ReleaseQuota(ctx, client, reservationId, amount, ttl)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Exec(ctx, `
+		INSERT INTO langsync.account_usage (account, period_start, indexed_documents, indexed_tokens, embedding_spend)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account, period_start) DO UPDATE
+		SET indexed_documents = account_usage.indexed_documents + excluded.indexed_documents,
+		    indexed_tokens = account_usage.indexed_tokens + excluded.indexed_tokens,
+		    embedding_spend = account_usage.embedding_spend + excluded.embedding_spend
+	`, accountId, periodStart, amount.Documents, amount.Tokens, amount.EmbeddingSpend)
+	if err != nil {
+		return err
+	}
+
+	// Lifetime totals are kept for statistics
+	_, err = client.Exec(ctx, `
 		UPDATE langsync.account
-		SET total_indexed_document_count = total_indexed_document_count + $2
+		SET total_indexed_document_count = total_indexed_document_count + $2,
+		    total_indexed_document_tokens = total_indexed_document_tokens + $3
 		WHERE id = $1
-	`, accountId, countToAdd)
+	`, accountId, amount.Documents, amount.Tokens)
+
 	return err
 }
 
-func IncreaseTotalDocumentTokens(ctx context.Context, client Querier, accountId string, tokensToAdd int) error {
+// ReleaseQuotaReservation returns all quota left in the reservation reservationId
+func ReleaseQuotaReservation(ctx context.Context, client Querier, reservationId string) error {
 	_, err := client.Exec(ctx, `
-		UPDATE langsync.account
-		SET total_indexed_document_tokens = total_indexed_document_tokens + $2
+		DELETE FROM langsync.quota_reservation
 		WHERE id = $1
-	`, accountId, tokensToAdd)
+	`, reservationId)
+
 	return err
 }
 
@@ -105,6 +256,9 @@ type IntegrationConnectionBase struct {
 	Account     string      `json:"account"`
 	Integration Integration `json:"integration_name"`
 	ConnectedAt string      `json:"connected_at"`
+
+	// NeedsReauth is set once the provider rejected the credentials, until the integration is connected again
+	NeedsReauth bool `json:"needs_reauth"`
 }
 
 type NotionIntegrationConnection struct {
@@ -114,6 +268,10 @@ type NotionIntegrationConnection struct {
 		WorkspaceId   string `json:"workspace_id"`
 		WorkspaceName string `json:"workspace_name"`
 		WorkspaceIcon string `json:"workspace_icon"`
+
+		// Only set for tokens which expire, see CredentialManager
+		RefreshToken string     `json:"refresh_token"`
+		ExpiresAt    *time.Time `json:"expires_at"`
 	} `json:"config"`
 }
 
@@ -121,14 +279,69 @@ type LinearIntegrationConnection struct {
 	Config struct {
 		AccessToken string `json:"access_token"`
 		TokenType   string `json:"token_type"`
+
+		// Connections made before expires_at was stored only have expires_in, relative to connected_at
+		ExpiresIn    int        `json:"expires_in"`
+		RefreshToken string     `json:"refresh_token"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+	} `json:"config"`
+}
+
+// FilesystemIntegrationConnection either points to a directory on the worker (must be located in FILESYSTEM_ROOT_PATH)
+// or to a Git repository which is cloned into FILESYSTEM_CHECKOUT_PATH
+type FilesystemIntegrationConnection struct {
+	Config struct {
+		RootPath      string `json:"root_path"`
+		RepositoryURL string `json:"repository_url"`
+		Branch        string `json:"branch"`
+	} `json:"config"`
+}
+
+// S3IntegrationConnection holds credentials for AWS S3 or an S3-compatible store like MinIO (set Endpoint and UsePathStyle)
+type S3IntegrationConnection struct {
+	Config struct {
+		Endpoint        string `json:"endpoint"`
+		Region          string `json:"region"`
+		AccessKeyId     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+		UsePathStyle    bool   `json:"use_path_style"`
+	} `json:"config"`
+}
+
+// ZendeskIntegrationConnection authenticates either with an OAuth access token or with an agent's email and API token
+type ZendeskIntegrationConnection struct {
+	Config struct {
+		Subdomain   string `json:"subdomain"`
+		AccessToken string `json:"access_token"`
+		Email       string `json:"email"`
+		ApiToken    string `json:"api_token"`
+	} `json:"config"`
+}
+
+type IntercomIntegrationConnection struct {
+	Config struct {
+		AccessToken string `json:"access_token"`
+		WorkspaceId string `json:"workspace_id"`
 	} `json:"config"`
 }
 
+// ExecIntegrationConnection holds the credentials of an exec connector, the worker doesn't interpret them and passes
+// them to the connector as-is
+type ExecIntegrationConnection struct {
+	Config json.RawMessage `json:"config"`
+}
+
 type IntegrationConnection struct {
 	// see annotation above
 	IntegrationConnectionBase
 	NotionIntegrationConnection
 	LinearIntegrationConnection
+	// Connections of other integrations are named fields, so their config fields don't collide with the embedded ones
+	FilesystemIntegrationConnection FilesystemIntegrationConnection `json:"-"`
+	S3IntegrationConnection         S3IntegrationConnection         `json:"-"`
+	ZendeskIntegrationConnection    ZendeskIntegrationConnection    `json:"-"`
+	IntercomIntegrationConnection   IntercomIntegrationConnection   `json:"-"`
+	ExecIntegrationConnection       ExecIntegrationConnection       `json:"-"`
 }
 
 // Write UnmarshalJSON methods for IntegrationConnection
@@ -151,6 +364,36 @@ func (i *IntegrationConnection) UnmarshalJSON(data []byte) error {
 			return err
 		}
 		return nil
+	} else if i.Integration == IntegrationFilesystem {
+		err := json.Unmarshal(data, &i.FilesystemIntegrationConnection)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if i.Integration == IntegrationS3 {
+		err := json.Unmarshal(data, &i.S3IntegrationConnection)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if i.Integration == IntegrationZendesk {
+		err := json.Unmarshal(data, &i.ZendeskIntegrationConnection)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if i.Integration == IntegrationIntercom {
+		err := json.Unmarshal(data, &i.IntercomIntegrationConnection)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if i.Integration.base() == IntegrationExec {
+		err := json.Unmarshal(data, &i.ExecIntegrationConnection)
+		if err != nil {
+			return err
+		}
+		return nil
 	}
 
 	return nil
@@ -301,16 +544,110 @@ type PipelineDataSourceBase struct {
 }
 
 type NotionDataSource struct {
+	Config struct {
+		// If neither RootPageIds nor DatabaseIds are set, all pages shared with the integration are indexed. Root pages
+		// are indexed including their child pages, databases including their rows. Excluded pages and databases are
+		// skipped together with everything below them.
+		RootPageIds        []string `json:"root_page_ids"`
+		DatabaseIds        []string `json:"database_ids"`
+		ExcludePageIds     []string `json:"exclude_page_ids"`
+		ExcludeDatabaseIds []string `json:"exclude_database_ids"`
+		// Comments are appended to the page content ("inline") or indexed as one document per page ("documents"),
+		// defaults to not indexing comments. Comments are refreshed whenever the page is edited.
+		Comments string `json:"comments"`
+		// Files and PDFs uploaded to pages are indexed as attachments of the page
+		IncludeAttachments bool `json:"include_attachments"`
+	} `json:"config"`
 }
 
 type LinearDataSource struct {
+	Config struct {
+		// DocumentTypes selects the Linear entities to index (issue, project, document, cycle), defaults to issues
+		DocumentTypes []string `json:"document_types"`
+
+		// Issue filters, empty filters match all issues. StateTypes are workflow state types (triage, backlog,
+		// unstarted, started, completed, canceled), an issue matches Labels if it has any of them.
+		TeamKeys     []string   `json:"team_keys"`
+		ProjectIds   []string   `json:"project_ids"`
+		StateTypes   []string   `json:"state_types"`
+		Labels       []string   `json:"labels"`
+		CreatedAfter *time.Time `json:"created_after"`
+
+		// Files uploaded to issues and their comments are indexed as attachments of the issue
+		IncludeAttachments bool `json:"include_attachments"`
+	} `json:"config"`
+}
+
+type FilesystemDataSource struct {
+	Config struct {
+		// Include and Exclude are glob patterns (supporting **) matched against slash-separated paths relative
+		// to the root. If Include is empty, all files are included.
+		Include []string `json:"include"`
+		Exclude []string `json:"exclude"`
+	} `json:"config"`
+}
+
+type WebDataSource struct {
+	Config struct {
+		// SeedURLs are crawled by following links, pages listed in SitemapURLs are indexed without following links
+		SeedURLs    []string `json:"seed_urls"`
+		SitemapURLs []string `json:"sitemap_urls"`
+
+		// AllowedDomains (including subdomains) default to the hosts of seed and sitemap URLs
+		AllowedDomains []string `json:"allowed_domains"`
+		PathPrefixes   []string `json:"path_prefixes"`
+
+		MaxPages     int `json:"max_pages"`
+		MaxDepth     int `json:"max_depth"`
+		CrawlDelayMs int `json:"crawl_delay_ms"`
+	} `json:"config"`
+}
+
+type S3DataSource struct {
+	Config struct {
+		Bucket string `json:"bucket"`
+		// Prefixes to list objects in, defaults to the whole bucket
+		Prefixes []string `json:"prefixes"`
+	} `json:"config"`
+}
+
+type ZendeskDataSource struct {
+	Config struct {
+		// Help Center articles are always indexed, solved tickets (public comments only) are opt-in
+		IncludeTickets bool   `json:"include_tickets"`
+		Locale         string `json:"locale"`
+	} `json:"config"`
+}
+
+type IntercomDataSource struct {
+	Config struct {
+		// Help Center articles are always indexed, closed conversations are opt-in
+		IncludeConversations bool `json:"include_conversations"`
+	} `json:"config"`
+}
+
+type ExecDataSource struct {
+	Config struct {
+		// Connector is the name of the connector executable in EXEC_CONNECTORS_PATH, the data source uses the account's
+		// integration connection of the connector
+		Connector string          `json:"connector"`
+		Args      []string        `json:"args"`
+		Config    json.RawMessage `json:"config"`
+	} `json:"config"`
 }
 
 type PipelineDataSource struct {
 	// see annotation above
 	PipelineDataSourceBase
 	NotionDataSource
-	LinearDataSource
+	// Data sources of other integrations are named fields, so their config fields don't collide with the embedded one
+	LinearDataSource     LinearDataSource     `json:"-"`
+	FilesystemDataSource FilesystemDataSource `json:"-"`
+	WebDataSource        WebDataSource        `json:"-"`
+	S3DataSource         S3DataSource         `json:"-"`
+	ZendeskDataSource    ZendeskDataSource    `json:"-"`
+	IntercomDataSource   IntercomDataSource   `json:"-"`
+	ExecDataSource       ExecDataSource       `json:"-"`
 }
 
 func (p *PipelineDataSource) UnmarshalJSON(data []byte) error {
@@ -331,6 +668,42 @@ func (p *PipelineDataSource) UnmarshalJSON(data []byte) error {
 			return err
 		}
 		return nil
+	} else if p.IntegrationName == IntegrationFilesystem {
+		err := json.Unmarshal(data, &p.FilesystemDataSource)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if p.IntegrationName == IntegrationWeb {
+		err := json.Unmarshal(data, &p.WebDataSource)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if p.IntegrationName == IntegrationS3 {
+		err := json.Unmarshal(data, &p.S3DataSource)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if p.IntegrationName == IntegrationZendesk {
+		err := json.Unmarshal(data, &p.ZendeskDataSource)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if p.IntegrationName == IntegrationIntercom {
+		err := json.Unmarshal(data, &p.IntercomDataSource)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else if p.IntegrationName == IntegrationExec {
+		err := json.Unmarshal(data, &p.ExecDataSource)
+		if err != nil {
+			return err
+		}
+		return nil
 	}
 
 	return nil
@@ -535,7 +908,7 @@ type PipelineRunStep struct {
 	Status      PipelineRunStepStatus `json:"status"`
 }
 
-func GetPipeline(ctx context.Context, client Querier, pipelineId string) (*Pipeline, error) {
+func GetPipeline(ctx context.Context, client Querier, secrets *Secrets, pipelineId string) (*Pipeline, error) {
 	row := client.QueryRow(ctx, `
 		SELECT json_build_object('account', account, 'name', name, 'created_at', created_at, 'updated_at', updated_at, 'config', config, 'is_enabled', is_enabled, 'id', id, 'is_default', is_default)::text
 		FROM langsync.pipeline
@@ -551,8 +924,13 @@ func GetPipeline(ctx context.Context, client Querier, pipelineId string) (*Pipel
 		return nil, err
 	}
 
+	decrypted, err := secrets.DecryptJSON(ctx, []byte(pipelineStr))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt pipeline config, %w", err)
+	}
+
 	pipeline := Pipeline{}
-	err = json.Unmarshal([]byte(pipelineStr), &pipeline)
+	err = json.Unmarshal(decrypted, &pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -560,6 +938,93 @@ func GetPipeline(ctx context.Context, client Querier, pipelineId string) (*Pipel
 	return &pipeline, nil
 }
 
+func GetPipelines(ctx context.Context, client Querier, secrets *Secrets, accountId string) ([]Pipeline, error) {
+	rows, err := client.Query(ctx, `
+		SELECT json_build_object('account', account, 'name', name, 'created_at', created_at, 'updated_at', updated_at, 'config', config, 'is_enabled', is_enabled, 'id', id, 'is_default', is_default)::text
+		FROM langsync.pipeline
+		WHERE account = $1
+	`, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelines := make([]Pipeline, 0)
+
+	for rows.Next() {
+		var pipelineStr string
+		err := rows.Scan(&pipelineStr)
+		if err != nil {
+			return nil, err
+		}
+
+		decrypted, err := secrets.DecryptJSON(ctx, []byte(pipelineStr))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt pipeline config, %w", err)
+		}
+
+		pipeline := Pipeline{}
+		err = json.Unmarshal(decrypted, &pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		pipelines = append(pipelines, pipeline)
+	}
+
+	return pipelines, nil
+}
+
+func GetPipelineIds(ctx context.Context, client Querier) ([]string, error) {
+	rows, err := client.Query(ctx, `
+		SELECT id
+		FROM langsync.pipeline
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0)
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// LockPipelineConfig returns the raw config of a pipeline and locks it until the transaction ends, configs are
+// returned as stored without decrypting secrets
+func LockPipelineConfig(ctx context.Context, client Querier, pipelineId string) ([]byte, error) {
+	var config string
+	err := client.QueryRow(ctx, `
+		SELECT config::text
+		FROM langsync.pipeline
+		WHERE id = $1
+		FOR UPDATE
+	`, pipelineId).Scan(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(config), nil
+}
+
+func SetPipelineConfig(ctx context.Context, client Querier, pipelineId string, config []byte) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.pipeline
+		SET config = $2::jsonb
+		WHERE id = $1
+	`, pipelineId, string(config))
+
+	return err
+}
+
 func GetPipelineRun(ctx context.Context, client Querier, pipelineRunId string) (*PipelineRun, error) {
 	row := client.QueryRow(ctx, `
 		SELECT pipeline, trigger, created_at, updated_at, id, sync_mode, integration_change_event
@@ -627,6 +1092,49 @@ func GetPipelineStep(ctx context.Context, client Querier, pipelineRunId string,
 	return &step, nil
 }
 
+const idAlphabet = "useandom-26T198340PX75pxJACKVERYMINDBUSHWOLF_GQZbfghjklqvwyzrict"
+
+// newId generates ids in the same format as nanoid, which is used by the app
+func newId() (string, error) {
+	bytes := make([]byte, 21)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, len(bytes))
+	for i, b := range bytes {
+		id[i] = idAlphabet[b&63]
+	}
+	return string(id), nil
+}
+
+func CreatePipelineRun(ctx context.Context, client Querier, pipelineId string, trigger PipelineRunTrigger, syncMode SyncMode, changeEvent *IntegrationChangeEvent) (string, error) {
+	runId, err := newId()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = client.Exec(ctx, `
+		INSERT INTO langsync.pipeline_run (id, pipeline, trigger, created_at, sync_mode, integration_change_event)
+		VALUES ($1, $2, $3, now(), $4, $5)
+	`, runId, pipelineId, trigger, syncMode, changeEvent)
+	if err != nil {
+		return "", err
+	}
+
+	return runId, nil
+}
+
+func CreatePipelineRunStep(ctx context.Context, client Querier, pipelineId string, pipelineRunId string, dataSourceId string) error {
+	_, err := client.Exec(ctx, `
+		INSERT INTO langsync.pipeline_run_step (pipeline, pipeline_run, status, data_source, created_at)
+		VALUES ($1, $2, $3, $4, now())
+	`, pipelineId, pipelineRunId, PipelineRunStepStatusPending, dataSourceId)
+
+	return err
+}
+
 type RunError struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
@@ -649,6 +1157,32 @@ func UpdatePipelineRunStep(ctx context.Context, client Querier, pipelineRunId st
 	return err
 }
 
+// ExcludedDocuments lists documents of a run step which were left out because of quotas, only the first
+// excludedDocumentsMaxCount documents are listed
+type ExcludedDocuments struct {
+	Count     int                `json:"count"`
+	Documents []ExcludedDocument `json:"documents"`
+}
+
+type ExcludedDocument struct {
+	Integration  Integration `json:"integration"`
+	DocumentType string      `json:"document_type"`
+	Id           string      `json:"id"`
+	Title        string      `json:"title"`
+	URL          string      `json:"url"`
+	Reason       string      `json:"reason"`
+}
+
+func UpdatePipelineRunStepExcludedDocuments(ctx context.Context, client Querier, pipelineRunId string, dataSourceId string, excluded *ExcludedDocuments) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.pipeline_run_step
+		SET excluded_documents = $3
+		WHERE pipeline_run = $1 AND data_source = $2
+	`, pipelineRunId, dataSourceId, excluded)
+
+	return err
+}
+
 type Document struct {
 	AccountId   string      `json:"account"`
 	PipelineId  string      `json:"pipeline"`
@@ -667,18 +1201,24 @@ type Document struct {
 
 	TokenCount        int  `json:"token_count"`
 	ExceedsTokenLimit bool `json:"exceeds_token_limit"`
+
+	// Set if the document couldn't be indexed, e.g. because of an unsupported file format
+	SkipReason *string `json:"skip_reason"`
+
+	// Set for attachments, which are removed together with their parent
+	ParentId *string `json:"parent_id"`
 }
 
 func GetDocumentForIntegration(ctx context.Context, client Querier, accountId, pipelineId string, integration Integration, documentType string, documentId string) (*Document, error) {
 	row := client.QueryRow(ctx, `
-		SELECT account, pipeline, integration_name, document_type, id, created_at, updated_at, title, url, freshness_indicator::text, token_count, exceeds_token_limit
+		SELECT account, pipeline, integration_name, document_type, id, created_at, updated_at, title, url, freshness_indicator::text, token_count, exceeds_token_limit, skip_reason
 		FROM langsync.document
 		WHERE account = $1 AND "pipeline" = $2 AND integration_name = $3 AND document_type = $4 AND id = $5
 	`, accountId, pipelineId, integration, documentType, documentId)
 
 	document := Document{}
 
-	err := row.Scan(&document.AccountId, &document.PipelineId, &document.Integration, &document.DocumentType, &document.Id, &document.CreatedAt, &document.UpdatedAt, &document.Title, &document.URL, &document.FreshnessIndicator, &document.TokenCount, &document.ExceedsTokenLimit)
+	err := row.Scan(&document.AccountId, &document.PipelineId, &document.Integration, &document.DocumentType, &document.Id, &document.CreatedAt, &document.UpdatedAt, &document.Title, &document.URL, &document.FreshnessIndicator, &document.TokenCount, &document.ExceedsTokenLimit, &document.SkipReason)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
@@ -689,12 +1229,21 @@ func GetDocumentForIntegration(ctx context.Context, client Querier, accountId, p
 	return &document, nil
 }
 
-func GetIntegrationConnection(ctx context.Context, client Querier, accountId string, integration Integration) (*IntegrationConnection, error) {
+func GetIntegrationConnection(ctx context.Context, client Querier, secrets *Secrets, accountId string, integration Integration) (*IntegrationConnection, error) {
+	return getIntegrationConnection(ctx, client, secrets, accountId, integration, "")
+}
+
+// LockIntegrationConnection gets the connection and locks it until the transaction ends
+func LockIntegrationConnection(ctx context.Context, client Querier, secrets *Secrets, accountId string, integration Integration) (*IntegrationConnection, error) {
+	return getIntegrationConnection(ctx, client, secrets, accountId, integration, "FOR UPDATE")
+}
+
+func getIntegrationConnection(ctx context.Context, client Querier, secrets *Secrets, accountId string, integration Integration, lock string) (*IntegrationConnection, error) {
 	row := client.QueryRow(ctx, `
-		SELECT json_build_object('account', account, 'integration_name', integration_name, 'connected_at', connected_at, 'config', config)::text
+		SELECT json_build_object('account', account, 'integration_name', integration_name, 'connected_at', connected_at, 'needs_reauth', needs_reauth, 'config', config)::text
 		FROM langsync.integration_connection
 		WHERE account = $1 AND integration_name = $2
-	`, accountId, integration)
+	`+lock, accountId, integration)
 
 	var connectionStr string
 
@@ -706,8 +1255,13 @@ func GetIntegrationConnection(ctx context.Context, client Querier, accountId str
 		return nil, err
 	}
 
+	decrypted, err := secrets.DecryptJSON(ctx, []byte(connectionStr))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt integration connection config, %w", err)
+	}
+
 	connection := IntegrationConnection{}
-	err = json.Unmarshal([]byte(connectionStr), &connection)
+	err = json.Unmarshal(decrypted, &connection)
 	if err != nil {
 		return nil, err
 	}
@@ -715,13 +1269,143 @@ func GetIntegrationConnection(ctx context.Context, client Querier, accountId str
 	return &connection, nil
 }
 
+// UpdateIntegrationConnectionConfig merges fields, a JSON object, into the config of a connection, e.g. refreshed tokens
+func UpdateIntegrationConnectionConfig(ctx context.Context, client Querier, accountId string, integration Integration, fields []byte) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.integration_connection
+		SET config = config || $3::jsonb
+		WHERE account = $1 AND integration_name = $2
+	`, accountId, integration, string(fields))
+
+	return err
+}
+
+// GetIntegrationConnectionKeys returns the account and integration of all connections
+func GetIntegrationConnectionKeys(ctx context.Context, client Querier) ([]IntegrationConnectionBase, error) {
+	rows, err := client.Query(ctx, `
+		SELECT account, integration_name
+		FROM langsync.integration_connection
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]IntegrationConnectionBase, 0)
+
+	for rows.Next() {
+		key := IntegrationConnectionBase{}
+
+		err := rows.Scan(&key.Account, &key.Integration)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// LockIntegrationConnectionConfig returns the raw config of a connection and locks it until the transaction ends,
+// configs are returned as stored without decrypting secrets
+func LockIntegrationConnectionConfig(ctx context.Context, client Querier, accountId string, integration Integration) ([]byte, error) {
+	var config string
+	err := client.QueryRow(ctx, `
+		SELECT config::text
+		FROM langsync.integration_connection
+		WHERE account = $1 AND integration_name = $2
+		FOR UPDATE
+	`, accountId, integration).Scan(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(config), nil
+}
+
+func SetIntegrationConnectionConfig(ctx context.Context, client Querier, accountId string, integration Integration, config []byte) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.integration_connection
+		SET config = $3::jsonb
+		WHERE account = $1 AND integration_name = $2
+	`, accountId, integration, string(config))
+
+	return err
+}
+
+// MarkIntegrationConnectionNeedsReauth flags a connection whose credentials were rejected, connecting the integration
+// again resets the flag
+func MarkIntegrationConnectionNeedsReauth(ctx context.Context, client Querier, accountId string, integration Integration) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.integration_connection
+		SET needs_reauth = true
+		WHERE account = $1 AND integration_name = $2
+	`, accountId, integration)
+
+	return err
+}
+
+// IntegrationPollState is the progress of polling an integration connection for changes
+type IntegrationPollState struct {
+	AccountId    string
+	Cursor       *time.Time
+	ReconciledAt *time.Time
+}
+
+// ClaimIntegrationConnectionsForPolling marks up to limit connections which haven't been polled within interval as
+// polled and returns them. Connections locked by other workers are skipped, so each connection is polled by a single
+// worker at a time.
+func ClaimIntegrationConnectionsForPolling(ctx context.Context, client Querier, integration Integration, interval time.Duration, limit int) ([]IntegrationPollState, error) {
+	rows, err := client.Query(ctx, `
+		UPDATE langsync.integration_connection
+		SET polled_at = now()
+		WHERE (account, integration_name) IN (
+			SELECT account, integration_name
+			FROM langsync.integration_connection
+			WHERE integration_name = $1 AND NOT needs_reauth AND (polled_at IS NULL OR polled_at < now() - make_interval(secs => $2))
+			ORDER BY polled_at NULLS FIRST
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING account, poll_cursor, reconciled_at
+	`, integration, interval.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]IntegrationPollState, 0)
+
+	for rows.Next() {
+		state := IntegrationPollState{}
+
+		err := rows.Scan(&state.AccountId, &state.Cursor, &state.ReconciledAt)
+		if err != nil {
+			return nil, err
+		}
+
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+func UpdateIntegrationPollState(ctx context.Context, client Querier, integration Integration, state IntegrationPollState) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.integration_connection
+		SET poll_cursor = $3, reconciled_at = $4
+		WHERE account = $1 AND integration_name = $2
+	`, state.AccountId, integration, state.Cursor, state.ReconciledAt)
+
+	return err
+}
+
 func UpsertDocument(ctx context.Context, client Querier, document *Document) error {
 	_, err := client.Exec(ctx, `
-		INSERT INTO langsync.document (account, pipeline, integration_name, document_type, id, created_at, updated_at, title, url, freshness_indicator, token_count, exceeds_token_limit)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		INSERT INTO langsync.document (account, pipeline, integration_name, document_type, id, created_at, updated_at, title, url, freshness_indicator, token_count, exceeds_token_limit, skip_reason, parent_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 		ON CONFLICT (account, pipeline, integration_name, document_type, id) DO UPDATE
-		SET created_at = $6, updated_at = $7, title = $8, url = $9, freshness_indicator = $10, token_count = $11, exceeds_token_limit = $12
-	`, document.AccountId, document.PipelineId, document.Integration, document.DocumentType, document.Id, document.CreatedAt, document.UpdatedAt, document.Title, document.URL, document.FreshnessIndicator, document.TokenCount, document.ExceedsTokenLimit)
+		SET created_at = $6, updated_at = $7, title = $8, url = $9, freshness_indicator = $10, token_count = $11, exceeds_token_limit = $12, skip_reason = $13, parent_id = $14
+	`, document.AccountId, document.PipelineId, document.Integration, document.DocumentType, document.Id, document.CreatedAt, document.UpdatedAt, document.Title, document.URL, document.FreshnessIndicator, document.TokenCount, document.ExceedsTokenLimit, document.SkipReason, document.ParentId)
 
 	return err
 }
@@ -735,13 +1419,157 @@ func DeleteDocument(ctx context.Context, client Querier, accountId string, pipel
 	return err
 }
 
-func GetMissingDocuments(ctx context.Context, client Querier, accountId string, pipelineId string, integration Integration, existingIds []string) ([]Document, error) {
-	// Find all documents with ids not in existingIds
+// GetMissingDocuments returns the documents which aren't in existingTypes and existingIds, the document type and id at
+// the same index identify an existing document. Ids are only unique per document type.
+func GetMissingDocuments(ctx context.Context, client Querier, accountId string, pipelineId string, integration Integration, existingTypes []string, existingIds []string) ([]Document, error) {
+	// Attachments aren't listed and are removed with their parent
+	rows, err := client.Query(ctx, `
+		SELECT account, pipeline, integration_name, document_type, id, created_at, updated_at, title, url, freshness_indicator::text, token_count, exceeds_token_limit, skip_reason
+		FROM langsync.document
+		WHERE account = $1 AND pipeline = $2 AND integration_name = $3 AND parent_id IS NULL AND (document_type, id) NOT IN (SELECT * FROM unnest($4::text[], $5::text[]))
+	`, accountId, pipelineId, integration, existingTypes, existingIds)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]Document, 0)
+
+	for rows.Next() {
+		document := Document{}
+
+		err := rows.Scan(&document.AccountId, &document.PipelineId, &document.Integration, &document.DocumentType, &document.Id, &document.CreatedAt, &document.UpdatedAt, &document.Title, &document.URL, &document.FreshnessIndicator, &document.TokenCount, &document.ExceedsTokenLimit, &document.SkipReason)
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, document)
+	}
+
+	return documents, nil
+}
+
+// GetDocumentFreshnessIndicators returns the freshness indicators of the documents of an integration in a pipeline by
+// document type and id ("<type>/<id>"), attachments aren't included
+func GetDocumentFreshnessIndicators(ctx context.Context, client Querier, accountId string, pipelineId string, integration Integration) (map[string]string, error) {
+	rows, err := client.Query(ctx, `
+		SELECT document_type, id, freshness_indicator::text
+		FROM langsync.document
+		WHERE account = $1 AND pipeline = $2 AND integration_name = $3 AND parent_id IS NULL AND freshness_indicator IS NOT NULL
+	`, accountId, pipelineId, integration)
+	if err != nil {
+		return nil, err
+	}
+
+	freshnessIndicators := make(map[string]string)
+
+	for rows.Next() {
+		var documentType, id, freshnessIndicator string
+		err := rows.Scan(&documentType, &id, &freshnessIndicator)
+		if err != nil {
+			return nil, err
+		}
+
+		freshnessIndicators[documentType+"/"+id] = freshnessIndicator
+	}
+
+	return freshnessIndicators, nil
+}
+
+// GetIndexedDocuments returns the freshness indicators of all documents of an integration in a pipeline by document
+// type and id ("<type>/<id>"), documents without freshness indicator map to an empty string. Attachments aren't
+// included.
+func GetIndexedDocuments(ctx context.Context, client Querier, accountId string, pipelineId string, integration Integration) (map[string]string, error) {
+	rows, err := client.Query(ctx, `
+		SELECT document_type, id, COALESCE(freshness_indicator::text, '')
+		FROM langsync.document
+		WHERE account = $1 AND pipeline = $2 AND integration_name = $3 AND parent_id IS NULL
+	`, accountId, pipelineId, integration)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make(map[string]string)
+
+	for rows.Next() {
+		var documentType, id, freshnessIndicator string
+		err := rows.Scan(&documentType, &id, &freshnessIndicator)
+		if err != nil {
+			return nil, err
+		}
+
+		documents[documentType+"/"+id] = freshnessIndicator
+	}
+
+	return documents, nil
+}
+
+// GetDataSourceCursor returns the cursor stored by the last completed run of a data source, empty if there is none
+func GetDataSourceCursor(ctx context.Context, client Querier, pipelineId string, dataSourceId string) (string, error) {
+	var cursor string
+	err := client.QueryRow(ctx, `
+		SELECT cursor
+		FROM langsync.pipeline_data_source_cursor
+		WHERE pipeline = $1 AND data_source = $2
+	`, pipelineId, dataSourceId).Scan(&cursor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+
+	return cursor, err
+}
+
+// UpdateDataSourceCursor stores the cursor for the next run of a data source, an empty cursor removes it
+func UpdateDataSourceCursor(ctx context.Context, client Querier, pipelineId string, dataSourceId string, cursor string) error {
+	if cursor == "" {
+		_, err := client.Exec(ctx, `
+			DELETE FROM langsync.pipeline_data_source_cursor
+			WHERE pipeline = $1 AND data_source = $2
+		`, pipelineId, dataSourceId)
+
+		return err
+	}
+
+	_, err := client.Exec(ctx, `
+		INSERT INTO langsync.pipeline_data_source_cursor (pipeline, data_source, cursor, updated_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (pipeline, data_source) DO UPDATE
+		SET cursor = $3, updated_at = now()
+	`, pipelineId, dataSourceId, cursor)
+
+	return err
+}
+
+func GetDocumentIds(ctx context.Context, client Querier, accountId string, pipelineId string, integration Integration, documentType string) ([]string, error) {
 	rows, err := client.Query(ctx, `
-		SELECT account, pipeline, integration_name, document_type, id, created_at, updated_at, title, url, freshness_indicator::text, token_count, exceeds_token_limit
+		SELECT id
 		FROM langsync.document
-		WHERE account = $1 AND pipeline = $2 AND integration_name = $3 AND id NOT IN (SELECT * FROM unnest($4::text[]))
-	`, accountId, pipelineId, integration, existingIds)
+		WHERE account = $1 AND pipeline = $2 AND integration_name = $3 AND document_type = $4
+	`, accountId, pipelineId, integration, documentType)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0)
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func GetChildDocuments(ctx context.Context, client Querier, accountId string, pipelineId string, integration Integration, parentId string) ([]Document, error) {
+	rows, err := client.Query(ctx, `
+		SELECT account, pipeline, integration_name, document_type, id, created_at, updated_at, title, url, freshness_indicator::text, token_count, exceeds_token_limit, skip_reason, parent_id
+		FROM langsync.document
+		WHERE account = $1 AND pipeline = $2 AND integration_name = $3 AND parent_id = $4
+	`, accountId, pipelineId, integration, parentId)
 	if err != nil {
 		return nil, err
 	}
@@ -751,7 +1579,7 @@ func GetMissingDocuments(ctx context.Context, client Querier, accountId string,
 	for rows.Next() {
 		document := Document{}
 
-		err := rows.Scan(&document.AccountId, &document.PipelineId, &document.Integration, &document.DocumentType, &document.Id, &document.CreatedAt, &document.UpdatedAt, &document.Title, &document.URL, &document.FreshnessIndicator, &document.TokenCount, &document.ExceedsTokenLimit)
+		err := rows.Scan(&document.AccountId, &document.PipelineId, &document.Integration, &document.DocumentType, &document.Id, &document.CreatedAt, &document.UpdatedAt, &document.Title, &document.URL, &document.FreshnessIndicator, &document.TokenCount, &document.ExceedsTokenLimit, &document.SkipReason, &document.ParentId)
 		if err != nil {
 			return nil, err
 		}
@@ -761,3 +1589,148 @@ func GetMissingDocuments(ctx context.Context, client Querier, accountId string,
 
 	return documents, nil
 }
+
+// ReserveRateLimitToken takes a token from a rate limit bucket, refilling it at rate up to burst tokens, and returns
+// how many seconds to wait until the token is available. Concurrent reservations are serialized by the row lock.
+func ReserveRateLimitToken(ctx context.Context, client Querier, key string, rate float64, burst float64) (float64, error) {
+	row := client.QueryRow(ctx, `
+		INSERT INTO langsync.rate_limit_bucket AS bucket (key, tokens, updated_at)
+		VALUES ($1, $3::double precision - 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = LEAST($3::double precision, bucket.tokens + GREATEST(0, EXTRACT(EPOCH FROM now() - bucket.updated_at)) *
+				CASE WHEN now() < bucket.adapted_until THEN bucket.adapted_rate ELSE $2::double precision END) - 1,
+			updated_at = GREATEST(bucket.updated_at, now())
+		RETURNING (GREATEST(0, EXTRACT(EPOCH FROM bucket.updated_at - now())) + GREATEST(0, -bucket.tokens) /
+			CASE WHEN now() < bucket.adapted_until THEN bucket.adapted_rate ELSE $2::double precision END)::double precision
+	`, key, rate, burst)
+
+	var delay float64
+	err := row.Scan(&delay)
+	if err != nil {
+		return 0, err
+	}
+
+	return delay, nil
+}
+
+// GetRateLimitBlock returns for how many more seconds requests of a rate limit bucket are blocked
+func GetRateLimitBlock(ctx context.Context, client Querier, key string) (float64, error) {
+	row := client.QueryRow(ctx, `
+		SELECT COALESCE(GREATEST(0, EXTRACT(EPOCH FROM blocked_until - now())), 0)::double precision
+		FROM langsync.rate_limit_bucket
+		WHERE key = $1
+	`, key)
+
+	var blockedFor float64
+	err := row.Scan(&blockedFor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return blockedFor, nil
+}
+
+// BlockRateLimit stops requests of a rate limit bucket for the given number of seconds
+func BlockRateLimit(ctx context.Context, client Querier, key string, seconds float64) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.rate_limit_bucket
+		SET blocked_until = GREATEST(blocked_until, now() + $2::double precision * interval '1 second'),
+			updated_at = GREATEST(updated_at, now() + $2::double precision * interval '1 second'),
+			tokens = LEAST(tokens, 0)
+		WHERE key = $1
+	`, key, seconds)
+
+	return err
+}
+
+// AdaptRateLimit replaces the rate of a rate limit bucket for the given number of seconds
+func AdaptRateLimit(ctx context.Context, client Querier, key string, rate float64, seconds float64) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.rate_limit_bucket
+		SET adapted_rate = $2::double precision,
+			adapted_until = now() + $3::double precision * interval '1 second'
+		WHERE key = $1
+	`, key, rate, seconds)
+
+	return err
+}
+
+// DeleteIdleRateLimitBuckets deletes rate limit buckets which weren't used for the given number of seconds and are
+// neither blocked nor adapted anymore
+func DeleteIdleRateLimitBuckets(ctx context.Context, client Querier, seconds float64) (int64, error) {
+	tag, err := client.Exec(ctx, `
+		DELETE FROM langsync.rate_limit_bucket
+		WHERE updated_at < now() - $1::double precision * interval '1 second'
+			AND (blocked_until IS NULL OR blocked_until < now())
+			AND (adapted_until IS NULL OR adapted_until < now())
+	`, seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// AcquireAccountRunLease leases one of limit run slots of an account for a message, returns false if all slots are
+// taken. Expired leases of workers which didn't release them are removed first.
+func AcquireAccountRunLease(ctx context.Context, client Querier, accountId string, messageId string, limit int, ttl time.Duration) (bool, error) {
+	// Serializes lease acquisition per account, the lock is released with the transaction
+	_, err := client.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('account_run_lease:' || $1))`, accountId)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.Exec(ctx, `
+		DELETE FROM langsync.account_run_lease
+		WHERE account = $1 AND expires_at <= now()
+	`, accountId)
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = client.QueryRow(ctx, `
+		SELECT count(*)
+		FROM langsync.account_run_lease
+		WHERE account = $1 AND message_id != $2
+	`, accountId, messageId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if count >= limit {
+		return false, nil
+	}
+
+	_, err = client.Exec(ctx, `
+		INSERT INTO langsync.account_run_lease (message_id, account, expires_at)
+		VALUES ($1, $2, now() + $3::double precision * interval '1 second')
+		ON CONFLICT (message_id) DO UPDATE SET expires_at = excluded.expires_at
+	`, messageId, accountId, ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func ExtendAccountRunLease(ctx context.Context, client Querier, messageId string, ttl time.Duration) error {
+	_, err := client.Exec(ctx, `
+		UPDATE langsync.account_run_lease
+		SET expires_at = now() + $2::double precision * interval '1 second'
+		WHERE message_id = $1
+	`, messageId, ttl.Seconds())
+
+	return err
+}
+
+func ReleaseAccountRunLease(ctx context.Context, client Querier, messageId string) error {
+	_, err := client.Exec(ctx, `
+		DELETE FROM langsync.account_run_lease
+		WHERE message_id = $1
+	`, messageId)
+
+	return err
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,22 +8,23 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
 	"io"
-	"net"
 	"net/http"
-	"time"
 )
 
 type DocumentHelper interface {
-	IngestDocument(ctx context.Context, splitter TextSplitter, embeddings PipelineEmbeddingConfig, sinks []PipelineDataSink, openAIApiKeys string, document IndexedDocument, textContent string, metadata map[string]any) error
-	DeleteDocument(ctx context.Context, sinks []PipelineDataSink, integration Integration, documentType, documentId string) error
+	IngestDocument(ctx context.Context, account string, splitter TextSplitter, embeddings PipelineEmbeddingConfig, sinks []PipelineDataSink, openAIApiKeys string, document IndexedDocument, textContent string, metadata map[string]any) error
+	DeleteDocument(ctx context.Context, account string, sinks []PipelineDataSink, integration Integration, documentType, documentId string) error
 	CountDocumentTokens(ctx context.Context, textContent string) (int, error)
 }
 
 type DocumentHelperImpl struct {
 	logger                 logrus.FieldLogger
 	documentHelperEndpoint string
-	httpClient             *http.Client
+	transport              *ProviderTransport
 	sema                   *semaphore.Weighted
+
+	// Secrets are sent as credential references, see credential_references.go
+	references *CredentialReferences
 }
 
 func (helper *DocumentHelperImpl) CountDocumentTokens(ctx context.Context, textContent string) (int, error) {
@@ -42,19 +42,14 @@ func (helper *DocumentHelperImpl) CountDocumentTokens(ctx context.Context, textC
 		return 0, fmt.Errorf("unable to marshal request body, %w", err)
 	}
 
-	resp, err := helper.sendRequest(ctx, http.MethodPost, "count", bytes.NewReader(marshalledBody))
-	if err != nil {
-		return 0, fmt.Errorf("unable to count document tokens, %w", err)
-	}
-
 	type countResponse struct {
 		TokenCount int `json:"token_count"`
 	}
 
 	countResp := countResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&countResp)
+	err = helper.sendRequest(ctx, http.MethodPost, "count", marshalledBody, &countResp)
 	if err != nil {
-		return 0, fmt.Errorf("unable to decode count response, %w", err)
+		return 0, fmt.Errorf("unable to count document tokens, %w", err)
 	}
 
 	return countResp.TokenCount, nil
@@ -84,74 +79,81 @@ func (e *DocumentHelperError) Error() string {
 	return fmt.Sprintf("document helper error (%s): %s", e.Code, e.Message)
 }
 
-func (helper *DocumentHelperImpl) sendRequest(ctx context.Context, method string, endpoint string, body io.Reader) (*http.Response, error) {
-	return backoff.RetryWithData[*http.Response](func() (*http.Response, error) {
-		req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/%s", helper.documentHelperEndpoint, endpoint), body)
-		if err != nil {
-			return nil, backoff.Permanent(fmt.Errorf("unable to create request, %w", err))
-		}
-		req.Header.Set("Content-Type", "application/json")
+// classifyDocumentHelperResponse retries rate limits and transient helper errors, other errors are permanent
+func classifyDocumentHelperResponse(res *http.Response) error {
+	if res.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("too many requests")
+	}
 
-		res, err := helper.httpClient.Do(req)
+	if res.StatusCode == http.StatusBadRequest {
+		type errorResp struct {
+			Error DocumentHelperError `json:"error"`
+		}
+		resp := errorResp{}
+		err := json.NewDecoder(res.Body).Decode(&resp)
 		if err != nil {
-			// if http client timeout, retry
-			if err, ok := err.(net.Error); ok && err.Timeout() {
-				return nil, err
-			}
-			return nil, backoff.Permanent(fmt.Errorf("unable to make request, %w", err))
+			return backoff.Permanent(fmt.Errorf("unable to decode error response, %w", err))
 		}
 
-		if res.StatusCode == http.StatusTooManyRequests {
-			return res, fmt.Errorf("too many requests")
+		if resp.Error.Code != "" {
+			if resp.Error.IsTransient {
+				return &resp.Error
+			}
+			return backoff.Permanent(&resp.Error)
 		}
 
-		if res.StatusCode == http.StatusBadRequest {
-			type errorResp struct {
-				Error DocumentHelperError `json:"error"`
-			}
-			resp := errorResp{}
-			err = json.NewDecoder(res.Body).Decode(&resp)
-			if err != nil {
-				return res, backoff.Permanent(fmt.Errorf("unable to decode error response, %w", err))
-			}
+		return backoff.Permanent(fmt.Errorf("bad request"))
+	}
 
-			if resp.Error.Code != "" {
-				if resp.Error.IsTransient {
-					return res, &resp.Error
-				}
-				return res, backoff.Permanent(&resp.Error)
-			}
+	return backoff.Permanent(fmt.Errorf("unexpected status code %d", res.StatusCode))
+}
 
-			return res, backoff.Permanent(fmt.Errorf("bad request"))
-		}
+// sendRequest sends body to an endpoint of the helper and decodes the response into result unless it's nil
+func (helper *DocumentHelperImpl) sendRequest(ctx context.Context, method string, endpoint string, body []byte, result any) error {
+	request := providerRequest{
+		Method: method,
+		URL:    fmt.Sprintf("%s/%s", helper.documentHelperEndpoint, endpoint),
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   body,
+	}
 
-		if res.StatusCode != http.StatusOK {
-			return res, backoff.Permanent(fmt.Errorf("unexpected status code %d", res.StatusCode))
-		}
+	if result != nil {
+		return helper.transport.DoJSON(ctx, request, result)
+	}
 
-		return res, nil
-	}, newBackOff(ctx, 5))
+	res, err := helper.transport.Do(ctx, request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	return res.Body.Close()
 }
 
-func (helper *DocumentHelperImpl) DeleteDocument(ctx context.Context, sinks []PipelineDataSink, integration Integration, documentType, documentId string) error {
+func (helper *DocumentHelperImpl) DeleteDocument(ctx context.Context, account string, sinks []PipelineDataSink, integration Integration, documentType, documentId string) error {
 	err := helper.sema.Acquire(ctx, 1)
 	if err != nil {
 		return fmt.Errorf("unable to acquire semaphore, %w", err)
 	}
 	defer helper.sema.Release(1)
 
+	referencedSinks, err := helper.references.ReferenceSecrets(account, sinks)
+	if err != nil {
+		return fmt.Errorf("unable to reference data sink secrets, %w", err)
+	}
+
 	body := map[string]any{
+		"account":       account,
 		"integration":   integration,
 		"document_type": documentType,
 
-		"data_sinks": sinks,
+		"data_sinks": referencedSinks,
 	}
 	marshalledBody, err := json.Marshal(body)
 	if err != nil {
 		return fmt.Errorf("unable to marshal request body, %w", err)
 	}
 
-	_, err = helper.sendRequest(ctx, http.MethodDelete, fmt.Sprintf("documents/%s", documentId), bytes.NewReader(marshalledBody))
+	err = helper.sendRequest(ctx, http.MethodDelete, fmt.Sprintf("documents/%s", documentId), marshalledBody, nil)
 	if err != nil {
 		return fmt.Errorf("unable to delete document, %w", err)
 	}
@@ -159,29 +161,43 @@ func (helper *DocumentHelperImpl) DeleteDocument(ctx context.Context, sinks []Pi
 	return nil
 }
 
-func (helper *DocumentHelperImpl) IngestDocument(ctx context.Context, splitter TextSplitter, embeddings PipelineEmbeddingConfig, sinks []PipelineDataSink, openAIApiKey string, doc IndexedDocument, textContent string, metadata map[string]any) error {
+func (helper *DocumentHelperImpl) IngestDocument(ctx context.Context, account string, splitter TextSplitter, embeddings PipelineEmbeddingConfig, sinks []PipelineDataSink, openAIApiKey string, doc IndexedDocument, textContent string, metadata map[string]any) error {
 	err := helper.sema.Acquire(ctx, 1)
 	if err != nil {
 		return fmt.Errorf("unable to acquire semaphore, %w", err)
 	}
 	defer helper.sema.Release(1)
 
+	referencedEmbeddings, err := helper.references.ReferenceSecrets(account, embeddings)
+	if err != nil {
+		return fmt.Errorf("unable to reference embedding secrets, %w", err)
+	}
+	referencedSinks, err := helper.references.ReferenceSecrets(account, sinks)
+	if err != nil {
+		return fmt.Errorf("unable to reference data sink secrets, %w", err)
+	}
+	openAIApiKeyReference, err := helper.references.Reference(account, openAIApiKey)
+	if err != nil {
+		return fmt.Errorf("unable to reference OpenAI API key, %w", err)
+	}
+
 	body := map[string]any{
+		"account":           account,
 		"document":          doc,
 		"document_text":     textContent,
 		"document_metadata": metadata,
 
 		"text_splitter":  splitter,
-		"embeddings":     embeddings,
-		"data_sinks":     sinks,
-		"openai_api_key": openAIApiKey,
+		"embeddings":     referencedEmbeddings,
+		"data_sinks":     referencedSinks,
+		"openai_api_key": openAIApiKeyReference,
 	}
 	marshalledBody, err := json.Marshal(body)
 	if err != nil {
 		return fmt.Errorf("unable to marshal request body, %w", err)
 	}
 
-	_, err = helper.sendRequest(ctx, http.MethodPost, "ingest", bytes.NewReader(marshalledBody))
+	err = helper.sendRequest(ctx, http.MethodPost, "ingest", marshalledBody, nil)
 	if err != nil {
 		return fmt.Errorf("unable to ingest document, %w", err)
 	}
@@ -189,13 +205,14 @@ func (helper *DocumentHelperImpl) IngestDocument(ctx context.Context, splitter T
 	return nil
 }
 
-func newDocumentHelper(documentHelperEndpoint string, logger logrus.FieldLogger) DocumentHelper {
+func newDocumentHelper(config DocumentHelperConfig, references *CredentialReferences, logger logrus.FieldLogger) DocumentHelper {
 	return &DocumentHelperImpl{
 		logger:                 logger,
-		documentHelperEndpoint: documentHelperEndpoint,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		sema: semaphore.NewWeighted(5),
+		documentHelperEndpoint: config.Endpoint,
+		transport: newProviderTransport("document-helper", &http.Client{
+			Timeout: config.Timeout,
+		}, nil, config.MaxAttempts, classifyDocumentHelperResponse),
+		sema:       semaphore.NewWeighted(int64(config.Concurrency)),
+		references: references,
 	}
 }
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The exec connector runs data sources implemented as standalone executables. Every call starts the connector once,
+// writes a single request line to stdin and reads JSON lines from stdout until the process exits:
+//
+//	request:  {"type": "list_documents" | "get_document" | "get_document_content", "protocol_version": 1,
+//	           "config": <data source config>, "connection": <integration connection config>,
+//	           "document_type": "...", "id": "..."}
+//	response: {"type": "document", "document": {"document_type", "id", "title", "url", "freshness_indicator"}}
+//	          {"type": "content", "text": "...", "metadata": {...}}
+//	          {"type": "skipped", "reason": "..."}
+//	          {"type": "log", "level": "info", "message": "..."}
+//	          {"type": "error", "message": "...", "retryable": false}
+//
+// list_documents emits one document message per document, get_document exactly one document message and
+// get_document_content exactly one content or skipped message. Anything written to stderr is included in errors.
+//
+// Integration connections and documents of a connector are stored under the integration name "exec:<connector>", see
+// execIntegration, so every connector of an account has its own connection and several connectors run side by side.
+
+const execProtocolVersion = 1
+
+const (
+	execListTimeout     = 30 * time.Minute
+	execDocumentTimeout = 5 * time.Minute
+	execMaxMessageSize  = 32 * 1024 * 1024
+	execMaxStderrSize   = 4 * 1024
+)
+
+type ExecRequestType string
+
+const (
+	ExecRequestTypeListDocuments      ExecRequestType = "list_documents"
+	ExecRequestTypeGetDocument        ExecRequestType = "get_document"
+	ExecRequestTypeGetDocumentContent ExecRequestType = "get_document_content"
+)
+
+type ExecMessageType string
+
+const (
+	ExecMessageTypeDocument ExecMessageType = "document"
+	ExecMessageTypeContent  ExecMessageType = "content"
+	ExecMessageTypeSkipped  ExecMessageType = "skipped"
+	ExecMessageTypeLog      ExecMessageType = "log"
+	ExecMessageTypeError    ExecMessageType = "error"
+)
+
+type execRequest struct {
+	Type            ExecRequestType `json:"type"`
+	ProtocolVersion int             `json:"protocol_version"`
+	Config          json.RawMessage `json:"config"`
+	Connection      json.RawMessage `json:"connection"`
+	DocumentType    string          `json:"document_type,omitempty"`
+	Id              string          `json:"id,omitempty"`
+}
+
+type execDocument struct {
+	DocumentType       string `json:"document_type"`
+	Id                 string `json:"id"`
+	Title              string `json:"title"`
+	URL                string `json:"url"`
+	FreshnessIndicator string `json:"freshness_indicator"`
+	// ACL optionally lists who may access the document, as principals described in acl.go
+	ACL []string `json:"acl"`
+}
+
+type execMessage struct {
+	Type      ExecMessageType `json:"type"`
+	Document  *execDocument   `json:"document"`
+	Text      string          `json:"text"`
+	Metadata  map[string]any  `json:"metadata"`
+	Reason    string          `json:"reason"`
+	Level     string          `json:"level"`
+	Message   string          `json:"message"`
+	Retryable bool            `json:"retryable"`
+}
+
+type ExecConnectorClientImpl struct {
+	logger logrus.FieldLogger
+
+	// connectorsPath is the directory connector executables are resolved in, if empty, exec connectors are disabled
+	connectorsPath string
+
+	sema        *semaphore.Weighted
+	maxAttempts int
+}
+
+func newExecConnectorClient(config ExecConnectorsConfig, logger logrus.FieldLogger) DataSourceApiClient {
+	return &ExecConnectorClientImpl{
+		logger:         logger,
+		connectorsPath: config.Path,
+		sema:           semaphore.NewWeighted(int64(config.Concurrency)),
+		maxAttempts:    config.MaxAttempts,
+	}
+}
+
+// execIntegration returns the integration name connections and documents of connector are stored under
+func execIntegration(connector string) Integration {
+	return IntegrationExec + ":" + Integration(connector)
+}
+
+// base returns the integration whose client handles integration, all exec connectors share the exec client
+func (integration Integration) base() Integration {
+	if strings.HasPrefix(string(integration), string(IntegrationExec)+":") {
+		return IntegrationExec
+	}
+	return integration
+}
+
+// integration returns the integration name the connection and documents of the data source are stored under
+func (dataSource *PipelineDataSource) integration() Integration {
+	if dataSource.IntegrationName == IntegrationExec {
+		return execIntegration(dataSource.ExecDataSource.Config.Connector)
+	}
+	return dataSource.IntegrationName
+}
+
+// resolveConnector maps the configured connector name to an executable in connectorsPath. Only plain file names are
+// accepted so that data sources can't run arbitrary binaries on the worker.
+func (client *ExecConnectorClientImpl) resolveConnector(name string) (string, error) {
+	if client.connectorsPath == "" {
+		return "", fmt.Errorf("exec connectors are not enabled on this worker")
+	}
+
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid connector name %q", name)
+	}
+	// The name is part of the integration name, which is limited to 64 characters
+	if len(execIntegration(name)) > 64 {
+		return "", fmt.Errorf("connector name %q is too long", name)
+	}
+
+	fullPath := filepath.Join(client.connectorsPath, name)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to find connector %q, %w", name, err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return "", fmt.Errorf("connector %q is not executable", name)
+	}
+
+	return fullPath, nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > t.max {
+		p = p[len(p)-t.max:]
+	}
+	if t.buf.Len()+len(p) > t.max {
+		t.buf.Next(t.buf.Len() + len(p) - t.max)
+	}
+	t.buf.Write(p)
+	return n, nil
+}
+
+func (t *tailBuffer) String() string {
+	return strings.TrimSpace(t.buf.String())
+}
+
+// run executes the connector for a single request and passes every message except logs and errors to handle.
+// Failures are retried if the connector marks them as retryable.
+func (client *ExecConnectorClientImpl) run(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection, request execRequest, timeout time.Duration, handle func(message execMessage) error) error {
+	if dataSource == nil {
+		return fmt.Errorf("missing data source")
+	}
+
+	connector := dataSource.ExecDataSource.Config.Connector
+	connectorPath, err := client.resolveConnector(connector)
+	if err != nil {
+		return err
+	}
+
+	// Never pass the credentials of one connector to another
+	if integration.Integration != execIntegration(connector) {
+		return fmt.Errorf("connection of %q can't be used by connector %q", integration.Integration, connector)
+	}
+
+	request.ProtocolVersion = execProtocolVersion
+	request.Config = dataSource.ExecDataSource.Config.Config
+	request.Connection = integration.ExecIntegrationConnection.Config
+
+	marshalledRequest, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("unable to marshal request, %w", err)
+	}
+	marshalledRequest = append(marshalledRequest, '\n')
+
+	logger := client.logger.WithField("connector", connector)
+
+	return backoff.Retry(
+		func() error {
+			err := client.sema.Acquire(ctx, 1)
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+			defer client.sema.Release(1)
+
+			runCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			cmd := exec.CommandContext(runCtx, connectorPath, dataSource.ExecDataSource.Config.Args...)
+			cmd.Dir = client.connectorsPath
+			// Don't leak the worker's secrets to connectors, credentials are passed in the request
+			cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "TMPDIR=" + os.TempDir()}
+			cmd.Stdin = bytes.NewReader(marshalledRequest)
+			cmd.WaitDelay = 10 * time.Second
+
+			stderr := &tailBuffer{max: execMaxStderrSize}
+			cmd.Stderr = stderr
+
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+
+			err = cmd.Start()
+			if err != nil {
+				return backoff.Permanent(fmt.Errorf("unable to start connector %q, %w", connector, err))
+			}
+
+			var connectorErr error
+			retryable := false
+
+			scanner := bufio.NewScanner(stdout)
+			scanner.Buffer(make([]byte, 64*1024), execMaxMessageSize)
+			for scanner.Scan() {
+				line := bytes.TrimSpace(scanner.Bytes())
+				if len(line) == 0 || connectorErr != nil {
+					continue
+				}
+
+				var message execMessage
+				err := json.Unmarshal(line, &message)
+				if err != nil {
+					connectorErr = fmt.Errorf("invalid message, %w", err)
+					continue
+				}
+
+				switch message.Type {
+				case ExecMessageTypeLog:
+					logExecMessage(logger, message)
+				case ExecMessageTypeError:
+					connectorErr = errors.New(message.Message)
+					retryable = message.Retryable
+				default:
+					connectorErr = handle(message)
+				}
+			}
+			scanErr := scanner.Err()
+			if scanErr != nil {
+				// Drain the remaining output so the connector doesn't block on a full pipe
+				_, _ = io.Copy(io.Discard, stdout)
+			}
+
+			waitErr := cmd.Wait()
+
+			if runCtx.Err() != nil && ctx.Err() == nil {
+				return backoff.Permanent(fmt.Errorf("connector %q timed out after %s", connector, timeout))
+			}
+			if ctx.Err() != nil {
+				return backoff.Permanent(ctx.Err())
+			}
+
+			if connectorErr == nil && scanErr != nil {
+				connectorErr = fmt.Errorf("unable to read output, %w", scanErr)
+			}
+			if connectorErr == nil && waitErr != nil {
+				connectorErr = waitErr
+			}
+			if connectorErr == nil {
+				return nil
+			}
+
+			err = fmt.Errorf("connector %q failed, %w", connector, connectorErr)
+			if tail := stderr.String(); tail != "" {
+				err = fmt.Errorf("%w: %s", err, tail)
+			}
+
+			if retryable {
+				logger.Printf("Retrying connector after error: %v\n", err)
+				return err
+			}
+			return backoff.Permanent(err)
+		},
+		newBackOff(ctx, uint64(client.maxAttempts)),
+	)
+}
+
+func logExecMessage(logger logrus.FieldLogger, message execMessage) {
+	switch message.Level {
+	case "debug":
+		logger.Debugf("%s", message.Message)
+	case "warn", "warning":
+		logger.Warnf("%s", message.Message)
+	case "error":
+		logger.Errorf("%s", message.Message)
+	default:
+		logger.Infof("%s", message.Message)
+	}
+}
+
+func (document execDocument) toIndexedDocument(integration Integration) (IndexedDocument, error) {
+	if document.DocumentType == "" || document.Id == "" {
+		return IndexedDocument{}, fmt.Errorf("document is missing type or id")
+	}
+	if len(document.Id) > 1024 {
+		return IndexedDocument{}, fmt.Errorf("document id %q is too long", document.Id)
+	}
+
+	return IndexedDocument{
+		Integration:        integration,
+		DocumentType:       document.DocumentType,
+		Id:                 document.Id,
+		Title:              document.Title,
+		URL:                document.URL,
+		FreshnessIndicator: document.FreshnessIndicator,
+		ACL:                normalizeACL(document.ACL),
+	}, nil
+}
+
+func (client *ExecConnectorClientImpl) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	indexedDocuments := make(map[string]IndexedDocument)
+
+	err := client.run(ctx, dataSource, integration, execRequest{Type: ExecRequestTypeListDocuments}, execListTimeout, func(message execMessage) error {
+		if message.Type != ExecMessageTypeDocument || message.Document == nil {
+			return fmt.Errorf("unexpected message of type %q", message.Type)
+		}
+
+		doc, err := message.Document.toIndexedDocument(integration.Integration)
+		if err != nil {
+			return err
+		}
+
+		indexedDocuments[doc.DocumentType+"/"+doc.Id] = doc
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client.logger.Printf("Connector returned %d documents\n", len(indexedDocuments))
+
+	return indexedDocuments, nil
+}
+
+func (client *ExecConnectorClientImpl) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+	var doc *IndexedDocument
+
+	request := execRequest{Type: ExecRequestTypeGetDocument, DocumentType: documentType, Id: id}
+	err := client.run(ctx, dataSource, integration, request, execDocumentTimeout, func(message execMessage) error {
+		if message.Type != ExecMessageTypeDocument || message.Document == nil || doc != nil {
+			return fmt.Errorf("unexpected message of type %q", message.Type)
+		}
+
+		d, err := message.Document.toIndexedDocument(integration.Integration)
+		if err != nil {
+			return err
+		}
+		doc = &d
+		return nil
+	})
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+
+	if doc == nil {
+		return IndexedDocument{}, fmt.Errorf("connector returned no document")
+	}
+
+	return *doc, nil
+}
+
+func (client *ExecConnectorClientImpl) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
+	var content *execMessage
+
+	request := execRequest{Type: ExecRequestTypeGetDocumentContent, DocumentType: documentType, Id: id}
+	err := client.run(ctx, dataSource, integration, request, execDocumentTimeout, func(message execMessage) error {
+		if (message.Type != ExecMessageTypeContent && message.Type != ExecMessageTypeSkipped) || content != nil {
+			return fmt.Errorf("unexpected message of type %q", message.Type)
+		}
+		content = &message
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if content == nil {
+		return "", nil, fmt.Errorf("connector returned no content")
+	}
+
+	if content.Type == ExecMessageTypeSkipped {
+		reason := content.Reason
+		if reason == "" {
+			reason = SkipReasonUnsupportedFormat
+		}
+		if len(reason) > 64 {
+			reason = reason[:64]
+		}
+		return "", nil, &SkippedDocumentError{Reason: reason}
+	}
+
+	return content.Text, content.Metadata, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testExecConnector is a fake connector answering requests by type, it echoes the request as content and counts
+// attempts so retries can be observed
+const testExecConnector = `#!/bin/sh
+read -r request
+echo x >> attempts
+case "$request" in
+*'"type":"list_documents"'*)
+	echo '{"type": "log", "level": "info", "message": "listing"}'
+	echo '{"type": "document", "document": {"document_type": "issue", "id": "1", "title": "One", "freshness_indicator": "a"}}'
+	echo ''
+	echo '{"type": "document", "document": {"document_type": "issue", "id": "2", "title": "Two", "freshness_indicator": "b"}}'
+	;;
+*'"type":"get_document"'*)
+	echo '{"type": "document", "document": {"document_type": "issue", "id": "1", "url": "https://example.com/1"}}'
+	;;
+*'"id":"skipped"'*)
+	echo '{"type": "skipped", "reason": "too_large"}'
+	;;
+*'"id":"broken"'*)
+	echo 'connection refused' >&2
+	echo '{"type": "error", "message": "upstream unavailable", "retryable": false}'
+	;;
+*'"id":"flaky"'*)
+	if [ "$(wc -l < attempts)" -lt 2 ]; then
+		echo '{"type": "error", "message": "try again", "retryable": true}'
+		exit 1
+	fi
+	echo '{"type": "content", "text": "recovered"}'
+	;;
+*)
+	text=$(printf '%s' "$request" | sed 's/\\/\\\\/g; s/"/\\"/g')
+	printf '{"type": "content", "text": "%s", "metadata": {"pages": 2}}\n' "$text"
+	;;
+esac
+`
+
+func newExecDataSource(connector string) *PipelineDataSource {
+	dataSource := &PipelineDataSource{}
+	dataSource.IntegrationName = IntegrationExec
+	dataSource.IsEnabled = true
+	dataSource.ExecDataSource.Config.Connector = connector
+	dataSource.ExecDataSource.Config.Config = []byte(`{"project":"LS"}`)
+	return dataSource
+}
+
+func newExecConnection(connector string) IntegrationConnection {
+	connection := IntegrationConnection{}
+	connection.Integration = execIntegration(connector)
+	connection.ExecIntegrationConnection.Config = []byte(`{"token":"secret"}`)
+	return connection
+}
+
+func newTestExecClient(t *testing.T) (*ExecConnectorClientImpl, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "fake"), []byte(testExecConnector), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	client := newExecConnectorClient(ExecConnectorsConfig{Path: dir, Concurrency: 1, MaxAttempts: 3}, logger)
+	return client.(*ExecConnectorClientImpl), dir
+}
+
+func TestExecConnectorListDocuments(t *testing.T) {
+	client, _ := newTestExecClient(t)
+
+	documents, err := client.ListDocuments(context.Background(), newExecDataSource("fake"), newExecConnection("fake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(documents) != 2 {
+		t.Fatalf("got %d documents, want 2", len(documents))
+	}
+	doc := documents["issue/2"]
+	if doc.Id != "2" || doc.Title != "Two" || doc.FreshnessIndicator != "b" || doc.Integration != "exec:fake" {
+		t.Errorf("got document %+v", doc)
+	}
+}
+
+func TestExecConnectorGetDocument(t *testing.T) {
+	client, _ := newTestExecClient(t)
+
+	doc, err := client.GetDocument(context.Background(), newExecDataSource("fake"), "issue", "1", newExecConnection("fake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Id != "1" || doc.URL != "https://example.com/1" || doc.Integration != "exec:fake" {
+		t.Errorf("got document %+v", doc)
+	}
+}
+
+func TestExecConnectorGetDocumentContent(t *testing.T) {
+	client, _ := newTestExecClient(t)
+	ctx := context.Background()
+
+	content, metadata, err := client.GetDocumentContent(ctx, newExecDataSource("fake"), "issue", "1", newExecConnection("fake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake connector returns the request it received
+	for _, want := range []string{`"type":"get_document_content"`, `"protocol_version":1`, `"config":{"project":"LS"}`, `"connection":{"token":"secret"}`, `"document_type":"issue"`} {
+		if !strings.Contains(content, want) {
+			t.Errorf("request %s doesn't contain %s", content, want)
+		}
+	}
+	if metadata["pages"] != float64(2) {
+		t.Errorf("got metadata %v", metadata)
+	}
+
+	_, _, err = client.GetDocumentContent(ctx, newExecDataSource("fake"), "issue", "skipped", newExecConnection("fake"))
+	var skipped *SkippedDocumentError
+	if !errors.As(err, &skipped) || skipped.Reason != "too_large" {
+		t.Errorf("got error %v, want skipped document", err)
+	}
+}
+
+func TestExecConnectorErrors(t *testing.T) {
+	client, dir := newTestExecClient(t)
+	ctx := context.Background()
+	attempts := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "attempts"))
+		_ = os.Remove(filepath.Join(dir, "attempts"))
+		return strings.Count(string(data), "\n")
+	}
+
+	_, _, err := client.GetDocumentContent(ctx, newExecDataSource("fake"), "issue", "broken", newExecConnection("fake"))
+	if err == nil || !strings.Contains(err.Error(), "upstream unavailable") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("got error %v, want the message and stderr of the connector", err)
+	}
+	if n := attempts(); n != 1 {
+		t.Errorf("non retryable error was attempted %d times", n)
+	}
+
+	content, _, err := client.GetDocumentContent(ctx, newExecDataSource("fake"), "issue", "flaky", newExecConnection("fake"))
+	if err != nil || content != "recovered" {
+		t.Errorf("got %q, %v after retrying", content, err)
+	}
+	if n := attempts(); n != 2 {
+		t.Errorf("retryable error was attempted %d times, want 2", n)
+	}
+
+	// Connectors can't use the connection of another connector
+	_, err = client.ListDocuments(ctx, newExecDataSource("fake"), newExecConnection("other"))
+	if err == nil {
+		t.Errorf("connector ran with the connection of another connector")
+	}
+	if n := attempts(); n != 0 {
+		t.Errorf("connector was started %d times", n)
+	}
+}
+
+func TestExecConnectorResolve(t *testing.T) {
+	client, _ := newTestExecClient(t)
+
+	for _, name := range []string{"", "..", "../fake", "/bin/sh", "missing", strings.Repeat("a", 60)} {
+		_, err := client.resolveConnector(name)
+		if err == nil {
+			t.Errorf("connector %q was resolved", name)
+		}
+	}
+
+	_, err := client.resolveConnector("fake")
+	if err != nil {
+		t.Errorf("unable to resolve connector, %v", err)
+	}
+}
+
+func TestExecIntegration(t *testing.T) {
+	dataSource := newExecDataSource("jira")
+	if integration := dataSource.integration(); integration != "exec:jira" || integration.base() != IntegrationExec {
+		t.Errorf("got integration %q with base %q", integration, integration.base())
+	}
+
+	notion := PipelineDataSource{}
+	notion.IntegrationName = IntegrationNotion
+	if integration := notion.integration(); integration != IntegrationNotion || integration.base() != IntegrationNotion {
+		t.Errorf("got integration %q with base %q", integration, integration.base())
+	}
+}
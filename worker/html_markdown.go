@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Elements that never contain main content
+var skippedHTMLElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Button:   true,
+	atom.Template: true,
+	atom.Head:     true,
+}
+
+// listIndent is used instead of spaces while rendering so that whitespace cleanup doesn't remove list indentation
+const listIndent = "\x00\x00"
+
+var (
+	whitespaceRegexp     = regexp.MustCompile(`\s+`)
+	excessNewlinesRegexp = regexp.MustCompile(`\n{3,}`)
+)
+
+type HTMLDocument struct {
+	Title       string
+	Description string
+	Language    string
+	Markdown    string
+}
+
+// htmlToMarkdown parses an HTML document, locates the main content (main, article or role=main, falling back to body)
+// and renders it as Markdown. Relative links and images are resolved against baseURL if set.
+func htmlToMarkdown(content string, baseURL *url.URL) (HTMLDocument, error) {
+	root, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return HTMLDocument{}, fmt.Errorf("unable to parse html, %w", err)
+	}
+
+	doc := HTMLDocument{}
+
+	if htmlNode := findHTMLElement(root, func(n *html.Node) bool { return n.DataAtom == atom.Html }); htmlNode != nil {
+		doc.Language = htmlAttr(htmlNode, "lang")
+	}
+
+	if titleNode := findHTMLElement(root, func(n *html.Node) bool { return n.DataAtom == atom.Title }); titleNode != nil {
+		doc.Title = strings.TrimSpace(whitespaceRegexp.ReplaceAllString(htmlText(titleNode), " "))
+	}
+
+	if descriptionNode := findHTMLElement(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.Meta && strings.EqualFold(htmlAttr(n, "name"), "description")
+	}); descriptionNode != nil {
+		doc.Description = strings.TrimSpace(htmlAttr(descriptionNode, "content"))
+	}
+
+	mainNode := findHTMLElement(root, func(n *html.Node) bool { return n.DataAtom == atom.Main })
+	if mainNode == nil {
+		mainNode = findHTMLElement(root, func(n *html.Node) bool { return n.DataAtom == atom.Article })
+	}
+	if mainNode == nil {
+		mainNode = findHTMLElement(root, func(n *html.Node) bool { return htmlAttr(n, "role") == "main" })
+	}
+	if mainNode == nil {
+		mainNode = findHTMLElement(root, func(n *html.Node) bool { return n.DataAtom == atom.Body })
+	}
+	if mainNode == nil {
+		mainNode = root
+	}
+
+	renderer := &markdownRenderer{baseURL: baseURL}
+	doc.Markdown = cleanupMarkdown(renderer.children(mainNode))
+
+	if doc.Title == "" {
+		if h1 := findHTMLElement(mainNode, func(n *html.Node) bool { return n.DataAtom == atom.H1 }); h1 != nil {
+			doc.Title = strings.TrimSpace(whitespaceRegexp.ReplaceAllString(htmlText(h1), " "))
+		}
+	}
+
+	return doc, nil
+}
+
+// findHTMLElement returns the first element node (depth-first) matching the predicate
+func findHTMLElement(n *html.Node, predicate func(n *html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && predicate(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findHTMLElement(c, predicate); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func htmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(htmlText(c))
+	}
+	return sb.String()
+}
+
+// htmlLinks returns all absolute link targets of a document
+func htmlLinks(content string, baseURL *url.URL) ([]*url.URL, error) {
+	root, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse html, %w", err)
+	}
+
+	var links []*url.URL
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			if href := htmlAttr(n, "href"); href != "" {
+				if u, err := baseURL.Parse(href); err == nil {
+					links = append(links, u)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	return links, nil
+}
+
+type markdownRenderer struct {
+	baseURL *url.URL
+
+	// depth of pre elements, whitespace is preserved inside
+	pre int
+}
+
+func (r *markdownRenderer) resolve(ref string) string {
+	if r.baseURL == nil {
+		return ref
+	}
+	u, err := r.baseURL.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+func (r *markdownRenderer) children(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(r.node(c))
+	}
+	return sb.String()
+}
+
+func (r *markdownRenderer) node(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		if r.pre > 0 {
+			return n.Data
+		}
+		return whitespaceRegexp.ReplaceAllString(n.Data, " ")
+	case html.ElementNode:
+		break
+	case html.DocumentNode:
+		return r.children(n)
+	default:
+		return ""
+	}
+
+	if skippedHTMLElements[n.DataAtom] {
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		text := strings.TrimSpace(r.children(n))
+		if text == "" {
+			return ""
+		}
+		return "\n\n" + strings.Repeat("#", level) + " " + text + "\n\n"
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Figure, atom.Details, atom.Dl:
+		return "\n\n" + strings.TrimSpace(r.children(n)) + "\n\n"
+	case atom.Br:
+		return "\n"
+	case atom.Hr:
+		return "\n\n---\n\n"
+	case atom.Strong, atom.B:
+		return wrapInline(r.children(n), "**")
+	case atom.Em, atom.I:
+		return wrapInline(r.children(n), "_")
+	case atom.Del, atom.S:
+		return wrapInline(r.children(n), "~~")
+	case atom.Code:
+		if r.pre > 0 {
+			return r.children(n)
+		}
+		return wrapInline(r.children(n), "`")
+	case atom.Pre:
+		r.pre++
+		text := r.children(n)
+		r.pre--
+
+		language := ""
+		if code := findHTMLElement(n, func(c *html.Node) bool { return c.DataAtom == atom.Code }); code != nil {
+			for _, class := range strings.Fields(htmlAttr(code, "class")) {
+				if strings.HasPrefix(class, "language-") {
+					language = strings.TrimPrefix(class, "language-")
+				}
+			}
+		}
+
+		return "\n\n```" + language + "\n" + strings.Trim(text, "\n") + "\n```\n\n"
+	case atom.A:
+		text := strings.TrimSpace(r.children(n))
+		href := htmlAttr(n, "href")
+		if text == "" {
+			return ""
+		}
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return text
+		}
+		return "[" + text + "](" + r.resolve(href) + ")"
+	case atom.Img:
+		src := htmlAttr(n, "src")
+		if src == "" {
+			return ""
+		}
+		return "![" + htmlAttr(n, "alt") + "](" + r.resolve(src) + ")"
+	case atom.Ul:
+		return r.list(n, false)
+	case atom.Ol:
+		return r.list(n, true)
+	case atom.Blockquote:
+		text := cleanupMarkdown(r.children(n))
+		if text == "" {
+			return ""
+		}
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	case atom.Table:
+		return r.table(n)
+	case atom.Dt:
+		return "\n\n**" + strings.TrimSpace(r.children(n)) + "**\n"
+	case atom.Dd:
+		return strings.TrimSpace(r.children(n)) + "\n"
+	}
+
+	return r.children(n)
+}
+
+func wrapInline(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+
+	// keep surrounding whitespace outside the markers, "** bold **" isn't valid markdown
+	leading := text[:len(text)-len(strings.TrimLeft(text, " "))]
+	trailing := text[len(strings.TrimRight(text, " ")):]
+	return leading + marker + trimmed + marker + trailing
+}
+
+func (r *markdownRenderer) list(n *html.Node, ordered bool) string {
+	var items []string
+	index := 1
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", index)
+			index++
+		}
+
+		content := excessNewlinesRegexp.ReplaceAllString(strings.TrimSpace(r.children(c)), "\n")
+		content = strings.ReplaceAll(content, "\n\n", "\n")
+
+		lines := strings.Split(content, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+			if i > 0 && lines[i] != "" {
+				lines[i] = listIndent + lines[i]
+			}
+		}
+
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+
+	if len(items) == 0 {
+		return ""
+	}
+
+	return "\n\n" + strings.Join(items, "\n") + "\n\n"
+}
+
+func (r *markdownRenderer) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			case atom.Tr:
+				var cells []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+						continue
+					}
+					text := whitespaceRegexp.ReplaceAllString(r.children(cell), " ")
+					cells = append(cells, strings.ReplaceAll(strings.TrimSpace(text), "|", "\\|"))
+				}
+				rows = append(rows, cells)
+			}
+		}
+	}
+	walk(n)
+
+	return markdownTable(rows)
+}
+
+// markdownTable renders rows as a Markdown table, using the first row as header
+func markdownTable(rows [][]string) string {
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\n")
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		sb.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			sb.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	sb.WriteString("\n")
+
+	return sb.String()
+}
+
+// cleanupMarkdown strips surrounding whitespace of lines outside of code blocks and collapses blank lines
+func cleanupMarkdown(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	inCodeBlock := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCodeBlock = !inCodeBlock
+			lines[i] = strings.TrimSpace(line)
+			continue
+		}
+		if inCodeBlock {
+			continue
+		}
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	markdown = strings.Join(lines, "\n")
+	markdown = strings.ReplaceAll(markdown, listIndent, "  ")
+	markdown = excessNewlinesRegexp.ReplaceAllString(markdown, "\n\n")
+
+	return strings.TrimSpace(markdown)
+}
@@ -20,14 +20,81 @@ type IndexedDocument struct {
 	Title              string      `json:"title"`
 	URL                string      `json:"url"`
 	FreshnessIndicator string      `json:"freshnessIndicator"`
+
+	// ParentId is set for attachments, which are indexed as child documents of the document they're attached to
+	ParentId string `json:"parentId,omitempty"`
+
+	// ACL lists the principals which may access the document in the source, see acl.go
+	ACL []string `json:"acl,omitempty"`
+
+	// EditedAt prioritizes recently edited documents when quotas are exceeded, zero if the source doesn't tell
+	EditedAt time.Time `json:"-"`
+}
+
+// freshness returns the freshness indicator stored for the document, which includes its access list
+func (doc IndexedDocument) freshness() string {
+	return aclFreshness(doc.FreshnessIndicator, doc.ACL)
+}
+
+// parseEditedAt parses RFC 3339 timestamps of provider APIs, returning the zero time for invalid timestamps
+func parseEditedAt(value string) time.Time {
+	editedAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return editedAt
+}
+
+func (doc IndexedDocument) parentId() *string {
+	if doc.ParentId == "" {
+		return nil
+	}
+	return &doc.ParentId
 }
 
 type DataSourceApiClient interface {
-	ListDocuments(ctx context.Context, integration IntegrationConnection) (map[string]IndexedDocument, error)
-	GetDocumentContent(ctx context.Context, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error)
-	GetDocument(ctx context.Context, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error)
+	ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error)
+	GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error)
+	GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error)
+}
+
+// SkippedDocumentError is returned by data sources for documents that can't be indexed, e.g. due to an unsupported
+// file format. Skipped documents are recorded instead of failing the run.
+type SkippedDocumentError struct {
+	Reason string
+}
+
+func (e *SkippedDocumentError) Error() string {
+	return fmt.Sprintf("document skipped: %s", e.Reason)
+}
+
+// IncompleteListError is returned by ListDocuments together with the documents that could be listed if others couldn't
+// be listed for the time being, e.g. because a web server failed. The listed documents are indexed, but indexed
+// documents missing from the list aren't deleted as they may still exist.
+type IncompleteListError struct {
+	Err error
+}
+
+func (e *IncompleteListError) Error() string {
+	return fmt.Sprintf("incomplete document list: %v", e.Err)
+}
+
+func (e *IncompleteListError) Unwrap() error {
+	return e.Err
+}
+
+// IncrementalLister is implemented by data sources which can list the changes since their last run instead of
+// fetching every document again. ListDocumentsSince returns the complete list just like ListDocuments, documents which
+// didn't change are taken from indexed ("<type>/<id>" to freshness indicator). The returned cursor is stored once the
+// run completed and passed to the next run, an empty cursor lists everything.
+type IncrementalLister interface {
+	ListDocumentsSince(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection, cursor string, indexed map[string]string) (map[string]IndexedDocument, string, error)
 }
 
+// ErrDocumentOutOfScope is returned by GetDocument for documents excluded by the data source configuration, e.g. when
+// a webhook reports a change to a document type that isn't indexed
+var ErrDocumentOutOfScope = errors.New("document out of scope")
+
 func getDataSource(sources []PipelineDataSource, id string) *PipelineDataSource {
 	for _, source := range sources {
 		if source.Id == id {
@@ -38,22 +105,57 @@ func getDataSource(sources []PipelineDataSource, id string) *PipelineDataSource
 	return nil
 }
 
+// retrieveAllDocuments lists all documents of a data source, along with the cursor to store for the next run if the
+// data source lists changes incrementally
 func retrieveAllDocuments(
 	ctx context.Context,
+	pool *pgxpool.Pool,
+	pipeline Pipeline,
 	dataSource *PipelineDataSource,
 	integration IntegrationConnection,
 	clients map[Integration]DataSourceApiClient,
-) (map[string]IndexedDocument, error) {
-	return clients[integration.Integration].ListDocuments(ctx, integration)
+) (map[string]IndexedDocument, string, error) {
+	client := clients[integration.Integration.base()]
+
+	lister, ok := client.(IncrementalLister)
+	if !ok {
+		docs, err := client.ListDocuments(ctx, dataSource, integration)
+		return docs, "", err
+	}
+
+	cursor, err := GetDataSourceCursor(ctx, pool, pipeline.Id, dataSource.Id)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to get cursor, %w", err)
+	}
+
+	indexed, err := GetIndexedDocuments(ctx, pool, pipeline.Account, pipeline.Id, integration.Integration)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to get indexed documents, %w", err)
+	}
+
+	return lister.ListDocumentsSince(ctx, dataSource, integration, cursor, indexed)
 }
 
+// getDocumentTextContent returns the content of a document and its attachments, if the data source has any
 func getDocumentTextContent(
 	ctx context.Context,
+	dataSource *PipelineDataSource,
 	document IndexedDocument,
 	integration IntegrationConnection,
 	clients map[Integration]DataSourceApiClient,
-) (string, map[string]any, error) {
-	return clients[integration.Integration].GetDocumentContent(ctx, document.DocumentType, document.Id, integration)
+) (string, map[string]any, []IndexedDocument, error) {
+	client := clients[integration.Integration.base()]
+
+	lister, ok := client.(AttachmentLister)
+	if !ok {
+		textContent, metadata, err := client.GetDocumentContent(ctx, dataSource, document.DocumentType, document.Id, integration)
+		return textContent, metadata, nil, err
+	}
+	if document.ParentId != "" {
+		textContent, metadata, err := lister.GetAttachmentContent(ctx, dataSource, document, integration)
+		return textContent, metadata, nil, err
+	}
+	return lister.GetDocumentContentAndAttachments(ctx, dataSource, document.DocumentType, document.Id, integration)
 }
 
 func now() *time.Time {
@@ -74,22 +176,8 @@ type IndexMessagePayload struct {
 	DataSourceId string `json:"dataSourceId"`
 }
 
-func totalIndexedDocumentsLimit(isSubscriber bool) int {
-	if isSubscriber {
-		return 1000 // 1 document * 1000 syncs or 100 documents * 10 syncs, etc.
-	}
-	return 100
-}
-
-func documentTokenLimit(isSubscriber bool) int {
-	if isSubscriber {
-		return 100_000 // 100_000 tokens = $0,01 * 1000 syncs = $10
-	}
-	return 1000
-}
-
 // processIndexMessage handles index messages, returning an error ONLY if message should be re-delivered to other worker
-func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application, clients map[Integration]DataSourceApiClient, documentHelper DocumentHelper, openAIApiKey string) workerHandlerFunc {
+func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application, clients map[Integration]DataSourceApiClient, documentHelper DocumentHelper, secrets *Secrets, credentials *CredentialManager, scheduler *Scheduler, quotas *QuotaManager, openAIApiKey string) workerHandlerFunc {
 	return func(ctx context.Context, logger logrus.FieldLogger, msg types.Message) error {
 		newrelicTxn := newrelicApp.StartTransaction("ProcessIndexMessage")
 		defer newrelicTxn.End()
@@ -126,7 +214,7 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 				return fmt.Errorf("unable to get step in stage, %w", err)
 			}
 
-			pipeline, err := GetPipeline(ctx, pool, deserializedMsg.Payload.PipelineId)
+			pipeline, err := GetPipeline(ctx, pool, secrets, deserializedMsg.Payload.PipelineId)
 			if err != nil {
 				return fmt.Errorf("unable to get pipeline, %w", err)
 			}
@@ -147,7 +235,7 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 				return fmt.Errorf("data source not found")
 			}
 
-			integrationConnection, err := GetIntegrationConnection(ctx, pool, pipeline.Account, dataSource.IntegrationName)
+			integrationConnection, err := GetIntegrationConnection(ctx, pool, secrets, pipeline.Account, dataSource.integration())
 			if err != nil {
 				return fmt.Errorf("unable to get integration connection, %w", err)
 			}
@@ -159,6 +247,16 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 
 			segment.End()
 
+			// Runs over the account's concurrency limit are deferred, so one account can't take all workers
+			priority := triggerPriority(pipelineRun.Trigger)
+			release, err := scheduler.Admit(ctx, account.Id, *msg.MessageId, priority)
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			newrelicTxn.AddAttribute("priority", priority.String())
+
 			segment = newrelicTxn.StartSegment("RunIndex")
 			defer segment.End()
 
@@ -182,10 +280,59 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 			}
 
 			if integrationConnection == nil {
-				logger.Printf("Integration connection not found for integration %q\n", dataSource.IntegrationName)
+				logger.Printf("Integration connection not found for integration %q\n", dataSource.integration())
 				return nil
 			}
 
+			// Rejected credentials can't be fixed by retrying, the integration must be connected again
+			failUnauthorized := func(err error) (bool, error) {
+				unauthorized, err := credentials.MarkUnauthorized(ctx, integrationConnection, err)
+				if !unauthorized || err != nil {
+					return unauthorized, err
+				}
+
+				err = UpdatePipelineRunStep(ctx, pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, PipelineRunStepStatusFailed, &RunError{
+					Code:    "integration_unauthorized",
+					Message: fmt.Sprintf("Integration %q must be connected again", dataSource.IntegrationName),
+				}, startedAt, nil)
+				if err != nil {
+					return true, fmt.Errorf("unable to update pipeline run step, %w", err)
+				}
+
+				return true, nil
+			}
+
+			err = credentials.EnsureFresh(ctx, integrationConnection)
+			if err != nil {
+				if unauthorized, err := failUnauthorized(err); unauthorized {
+					return err
+				}
+				return fmt.Errorf("unable to refresh credentials, %w", err)
+			}
+
+			quota, err := quotas.ForAccount(ctx, *account)
+			if err != nil {
+				return fmt.Errorf("unable to load quotas, %w", err)
+			}
+			defer func() {
+				// Quota reserved for documents which weren't ingested is refunded
+				err := quota.Close(context.Background())
+				if err != nil {
+					logger.Printf("Unable to release quotas, %v\n", err)
+				}
+
+				excluded := quota.Excluded()
+				if excluded == nil {
+					return
+				}
+				logger.Printf("Excluded %d documents because of quotas\n", excluded.Count)
+
+				err = UpdatePipelineRunStepExcludedDocuments(context.Background(), pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, excluded)
+				if err != nil {
+					logger.Printf("Unable to report excluded documents, %v\n", err)
+				}
+			}()
+
 			checkFlaggedAndSuspend := func(err error) error {
 				docHelperError := &DocumentHelperError{}
 				if errors.As(err, &docHelperError) {
@@ -205,10 +352,18 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 			case FullIndexSyncMode:
 				logger.Printf("Running full index for pipeline %q\n", pipeline.Id)
 
-				err = runFullIndex(ctx, logger, newrelicTxn, clients, pool, documentHelper, openAIApiKey, dataSource, integrationConnection, *pipeline, *pipelineRunStep, startedAt, *account)
+				err = runFullIndex(ctx, logger, newrelicTxn, clients, pool, documentHelper, openAIApiKey, dataSource, integrationConnection, *pipeline, *pipelineRunStep, startedAt, quota, scheduler, priority)
+				var deferred *DeferredError
+				if errors.As(err, &deferred) {
+					return err
+				}
 				if err != nil {
 					logger.Printf("unable to run full index, %v", err)
 
+					if unauthorized, err := failUnauthorized(err); unauthorized {
+						return err
+					}
+
 					err = checkFlaggedAndSuspend(err)
 					if err != nil {
 						return fmt.Errorf("unable to check flagged and suspend: %w", err)
@@ -226,30 +381,35 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 					return nil
 				}
 			case SingleDocumentSyncMode:
-				if !account.IsUnlimited {
-					if account.TotalIndexedDocumentCount+1 >= totalIndexedDocumentsLimit(account.IsSubscriber) {
-						// Set step to failed
-						err = UpdatePipelineRunStep(ctx, pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, PipelineRunStepStatusFailed, &RunError{
-							Code:    "limit_exceeded",
-							Message: "Exceeded total indexed document limit",
-						}, startedAt, nil)
-						if err != nil {
-							return fmt.Errorf("unable to update pipeline run step, %w", err)
-						}
-
-						return nil
+				reason := quota.Exhausted()
+				if reason == "" {
+					taken, err := quota.Take(ctx)
+					if err != nil {
+						return err
+					}
+					if !taken {
+						reason = excludedReasonDocumentLimit
 					}
-					err = IncreaseTotalIndexedCount(ctx, pool, account.Id, 1)
+				}
+				if reason != "" {
+					// Set step to failed
+					err = UpdatePipelineRunStep(ctx, pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, PipelineRunStepStatusFailed, quotaExceededError(reason), startedAt, nil)
 					if err != nil {
-						return fmt.Errorf("unable to update quotas: %w", err)
+						return fmt.Errorf("unable to update pipeline run step, %w", err)
 					}
+
+					return nil
 				}
 
 				if pipelineRun.Trigger == PipelineRunTriggerIntegrationChangeEvent {
-					err = handleDocumentChange(ctx, logger, newrelicTxn, clients, pool, documentHelper, openAIApiKey, dataSource, integrationConnection, *pipeline, pipelineRun.IntegrationChangeEvent.Change, documentTokenLimit(account.IsSubscriber))
+					err = handleDocumentChange(ctx, logger, newrelicTxn, clients, pool, documentHelper, openAIApiKey, dataSource, integrationConnection, *pipeline, pipelineRun.IntegrationChangeEvent.Change, quota)
 					if err != nil {
 						logger.Printf("unable to handle document change, %v", err)
 
+						if unauthorized, err := failUnauthorized(err); unauthorized {
+							return err
+						}
+
 						err = checkFlaggedAndSuspend(err)
 						if err != nil {
 							return fmt.Errorf("unable to check flagged and suspend: %w", err)
@@ -279,6 +439,10 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 		}
 
 		err = innerHandler()
+		var deferred *DeferredError
+		if errors.As(err, &deferred) {
+			return err
+		}
 		if err != nil {
 			newrelicTxn.NoticeError(err)
 			return fmt.Errorf("unable to process index message, %w", err)
@@ -288,7 +452,14 @@ func processIndexMessage(pool *pgxpool.Pool, newrelicApp *newrelic.Application,
 	}
 }
 
-func retrieveIngestAndUpsert(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, pool *pgxpool.Pool, doc IndexedDocument, pipeline Pipeline, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, clients map[Integration]DataSourceApiClient, documentHelper DocumentHelper, openAIApiKey string, tokenLimit int) error {
+func retrieveIngestAndUpsert(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, pool *pgxpool.Pool, doc IndexedDocument, pipeline Pipeline, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, clients map[Integration]DataSourceApiClient, documentHelper DocumentHelper, openAIApiKey string, quota *AccountQuota) error {
+	_, err := indexDocument(ctx, logger, newrelicTxn, pool, doc, pipeline, dataSource, integrationConnection, clients, documentHelper, openAIApiKey, quota)
+	return err
+}
+
+// indexDocument retrieves, ingests and records a document and its attachments. It returns false if the document or one
+// of its attachments was excluded by quotas, in which case the document isn't recorded as fresh.
+func indexDocument(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, pool *pgxpool.Pool, doc IndexedDocument, pipeline Pipeline, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, clients map[Integration]DataSourceApiClient, documentHelper DocumentHelper, openAIApiKey string, quota *AccountQuota) (bool, error) {
 	segment := newrelicTxn.StartSegment(fmt.Sprintf("RetrieveAndIngestDocument/%s/%s/%s", doc.Integration, doc.DocumentType, doc.Id))
 	defer segment.End()
 
@@ -299,11 +470,12 @@ func retrieveIngestAndUpsert(ctx context.Context, logger logrus.FieldLogger, new
 	//  Only update if indexed document is newer than existing document
 	existingDoc, err := GetDocumentForIntegration(ctx, pool, pipeline.Account, pipeline.Id, doc.Integration, doc.DocumentType, doc.Id)
 	if err != nil {
-		return fmt.Errorf("unable to get existing document, %w", err)
+		return false, fmt.Errorf("unable to get existing document, %w", err)
 	}
-	if existingDoc != nil && existingDoc.FreshnessIndicator != nil && *existingDoc.FreshnessIndicator == doc.FreshnessIndicator {
+	if existingDoc != nil && existingDoc.FreshnessIndicator != nil && *existingDoc.FreshnessIndicator == doc.freshness() {
 		logger.Printf("Skipping document %q, already fresh\n", doc.Id)
-		return nil
+		// Unchanged documents aren't indexed again, so they don't count towards the quota
+		return true, quota.Release(ctx, 1, 0)
 	}
 
 	segment = newrelicTxn.StartSegment(fmt.Sprintf("RetrieveDocument/%s/%s/%s", doc.Integration, doc.DocumentType, doc.Id))
@@ -312,9 +484,18 @@ func retrieveIngestAndUpsert(ctx context.Context, logger logrus.FieldLogger, new
 	logger.Printf("Retrieving text content for document %q\n", doc.Id)
 
 	// Load full document content as text  (using helper API)
-	textContent, metadata, err := getDocumentTextContent(ctx, doc, *integrationConnection, clients)
+	textContent, metadata, attachments, err := getDocumentTextContent(ctx, dataSource, doc, *integrationConnection, clients)
 	if err != nil {
-		return fmt.Errorf("unable to get document text content, %w", err)
+		skippedErr := &SkippedDocumentError{}
+		if errors.As(err, &skippedErr) {
+			logger.Printf("Skipping document %q, %s\n", doc.Id, skippedErr.Reason)
+			err = quota.Commit(ctx, 1, 0)
+			if err != nil {
+				return false, err
+			}
+			return true, upsertSkippedDocument(ctx, pool, documentHelper, doc, existingDoc, pipeline, skippedErr.Reason)
+		}
+		return false, fmt.Errorf("unable to get document text content, %w", err)
 	}
 
 	segment.End()
@@ -324,21 +505,40 @@ func retrieveIngestAndUpsert(ctx context.Context, logger logrus.FieldLogger, new
 
 	tokenCount, err := documentHelper.CountDocumentTokens(ctx, textContent)
 	if err != nil {
-		return fmt.Errorf("unable to count document tokens, %w", err)
+		return false, fmt.Errorf("unable to count document tokens, %w", err)
 	}
 
 	segment.End()
 
+	tokenLimit := quota.DocumentTokenLimit()
 	if tokenCount > tokenLimit {
 		logger.Printf("Skipping ingestion for document %q, token limit exceeded\n", doc.Id)
+
+		// The document is recorded, but not embedded, so it doesn't count towards the quota
+		err = quota.Release(ctx, 1, 0)
+		if err != nil {
+			return false, err
+		}
 	} else {
+		reason, err := quota.ReserveTokens(ctx, tokenCount)
+		if err != nil {
+			return false, err
+		}
+		if reason != "" {
+			// The document isn't recorded, so it's ingested by a run once the quota resets
+			logger.Printf("Excluding document %q, %s reached\n", doc.Id, reason)
+			quota.Exclude(doc, reason)
+			return false, quota.Release(ctx, 1, 0)
+		}
+
 		segment = newrelicTxn.StartSegment(fmt.Sprintf("IngestDocument/%s/%s/%s", doc.Integration, doc.DocumentType, doc.Id))
 		defer segment.End()
 
 		logger.Printf("Ingesting document %q\n", doc.Id)
 
-		err := documentHelper.IngestDocument(
+		err = documentHelper.IngestDocument(
 			ctx,
+			pipeline.Account,
 			dataSource.TextSplitter,
 			pipeline.Config.Embeddings,
 			pipeline.Config.DataSinks,
@@ -348,17 +548,30 @@ func retrieveIngestAndUpsert(ctx context.Context, logger logrus.FieldLogger, new
 			metadata,
 		)
 		if err != nil {
-			return fmt.Errorf("unable to ingest document, %w", err)
+			releaseErr := quota.Release(ctx, 1, tokenCount)
+			if releaseErr != nil {
+				logger.Printf("Unable to release quotas of document %q, %v\n", doc.Id, releaseErr)
+			}
+			return false, fmt.Errorf("unable to ingest document, %w", err)
 		}
 
 		segment.End()
 
-		err = IncreaseTotalDocumentTokens(ctx, pool, pipeline.Account, tokenCount)
+		err = quota.Commit(ctx, 1, tokenCount)
 		if err != nil {
-			logger.Errorf("unable to increase total document tokens: %v", err)
+			return false, err
 		}
 	}
 
+	// The document is only fresh once all of its attachments are indexed, otherwise attachments which failed or were
+	// excluded by quotas would never be indexed, as the next run would skip the unchanged document
+	complete, attachmentsErr := indexAttachments(ctx, logger, newrelicTxn, pool, doc, attachments, pipeline, dataSource, integrationConnection, clients, documentHelper, openAIApiKey, quota)
+	freshnessIndicator := doc.freshness()
+	storedFreshnessIndicator := &freshnessIndicator
+	if !complete || attachmentsErr != nil {
+		storedFreshnessIndicator = nil
+	}
+
 	now := time.Now()
 	upsertDoc := &Document{
 		AccountId:          pipeline.Account,
@@ -370,23 +583,116 @@ func retrieveIngestAndUpsert(ctx context.Context, logger logrus.FieldLogger, new
 		UpdatedAt:          &now,
 		Title:              doc.Title,
 		URL:                doc.URL,
-		FreshnessIndicator: &doc.FreshnessIndicator,
+		FreshnessIndicator: storedFreshnessIndicator,
 		TokenCount:         tokenCount,
 		ExceedsTokenLimit:  tokenCount > tokenLimit,
+		ParentId:           doc.parentId(),
 	}
 	err = UpsertDocument(ctx, pool, upsertDoc)
 	if err != nil {
-		return fmt.Errorf("unable to upsert document, %w", err)
+		return false, fmt.Errorf("unable to upsert document, %w", err)
+	}
+	if attachmentsErr != nil {
+		return false, attachmentsErr
 	}
 
 	logger.Printf("Ingested document %q\n", doc.Id)
 
+	return complete, nil
+}
+
+// indexAttachments indexes the attachments of a document as child documents and removes attachments which are gone. It
+// returns false if an attachment was excluded by quotas.
+func indexAttachments(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, pool *pgxpool.Pool, doc IndexedDocument, attachments []IndexedDocument, pipeline Pipeline, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, clients map[Integration]DataSourceApiClient, documentHelper DocumentHelper, openAIApiKey string, quota *AccountQuota) (bool, error) {
+	_, ok := clients[doc.Integration.base()].(AttachmentLister)
+	// Attachments don't have attachments themselves
+	if !ok || doc.ParentId != "" {
+		return true, nil
+	}
+
+	if len(attachments) > attachmentMaxCount {
+		logger.Printf("Document %q has %d attachments, only indexing the first %d\n", doc.Id, len(attachments), attachmentMaxCount)
+		attachments = attachments[:attachmentMaxCount]
+	}
+
+	// Attachments excluded by quotas still exist, so they're not deleted
+	found := make(map[string]bool, len(attachments))
+	for _, attachment := range attachments {
+		found[attachment.DocumentType+"/"+attachment.Id] = true
+	}
+
+	taken, err := quota.TakeDocuments(ctx, attachments)
+	if err != nil {
+		return false, err
+	}
+	complete := len(taken) == len(attachments)
+
+	for _, attachment := range taken {
+		attachment.ParentId = doc.Id
+		// Attachments are accessible to whoever may access the document they're attached to
+		attachment.ACL = doc.ACL
+
+		indexed, err := indexDocument(ctx, logger, newrelicTxn, pool, attachment, pipeline, dataSource, integrationConnection, clients, documentHelper, openAIApiKey, quota)
+		if err != nil {
+			return false, fmt.Errorf("unable to index attachment %q, %w", attachment.Id, err)
+		}
+		complete = complete && indexed
+	}
+
+	children, err := GetChildDocuments(ctx, pool, pipeline.Account, pipeline.Id, doc.Integration, doc.Id)
+	if err != nil {
+		return false, fmt.Errorf("unable to get attachments, %w", err)
+	}
+	for _, child := range children {
+		if found[child.DocumentType+"/"+child.Id] {
+			continue
+		}
+
+		err = deleteDocument(ctx, pool, documentHelper, child.Integration, child.DocumentType, child.Id, pipeline)
+		if err != nil {
+			return false, fmt.Errorf("unable to delete attachment %q, %w", child.Id, err)
+		}
+	}
+
+	return complete, nil
+}
+
+// upsertSkippedDocument records a document that couldn't be indexed, so it's not retried until it changes. If a
+// previous version was ingested, it is removed from the sinks.
+func upsertSkippedDocument(ctx context.Context, pool *pgxpool.Pool, documentHelper DocumentHelper, doc IndexedDocument, existingDoc *Document, pipeline Pipeline, reason string) error {
+	if existingDoc != nil && existingDoc.SkipReason == nil {
+		err := documentHelper.DeleteDocument(ctx, pipeline.Account, pipeline.Config.DataSinks, doc.Integration, doc.DocumentType, doc.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete document from sinks: %w", err)
+		}
+	}
+
+	now := time.Now()
+	freshnessIndicator := doc.freshness()
+	err := UpsertDocument(ctx, pool, &Document{
+		AccountId:          pipeline.Account,
+		PipelineId:         pipeline.Id,
+		Integration:        doc.Integration,
+		DocumentType:       doc.DocumentType,
+		Id:                 doc.Id,
+		CreatedAt:          now,
+		UpdatedAt:          &now,
+		Title:              doc.Title,
+		URL:                doc.URL,
+		FreshnessIndicator: &freshnessIndicator,
+		SkipReason:         &reason,
+		ParentId:           doc.parentId(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upsert skipped document, %w", err)
+	}
+
 	return nil
 }
 
 func deleteDocument(ctx context.Context, pool *pgxpool.Pool, documentHelper DocumentHelper, integration Integration, docType, docId string, pipeline Pipeline) error {
 	// Delete documents from downstream stores
-	err := documentHelper.DeleteDocument(ctx, pipeline.Config.DataSinks, integration, docType, docId)
+	err := documentHelper.DeleteDocument(ctx, pipeline.Account, pipeline.Config.DataSinks, integration, docType, docId)
 	if err != nil {
 		return fmt.Errorf("unable to delete document from sinks: %w", err)
 	}
@@ -397,69 +703,105 @@ func deleteDocument(ctx context.Context, pool *pgxpool.Pool, documentHelper Docu
 		return fmt.Errorf("unable to delete document from db: %w", err)
 	}
 
+	// Attachments are removed together with their parent
+	children, err := GetChildDocuments(ctx, pool, pipeline.Account, pipeline.Id, integration, docId)
+	if err != nil {
+		return fmt.Errorf("unable to get attachments: %w", err)
+	}
+	for _, child := range children {
+		err = deleteDocument(ctx, pool, documentHelper, child.Integration, child.DocumentType, child.Id, pipeline)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func runFullIndex(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, clients map[Integration]DataSourceApiClient, pool *pgxpool.Pool, documentHelper DocumentHelper, openAIApiKey string, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, pipeline Pipeline, pipelineRunStep PipelineRunStep, startedAt *time.Time, account Account) error {
+func runFullIndex(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, clients map[Integration]DataSourceApiClient, pool *pgxpool.Pool, documentHelper DocumentHelper, openAIApiKey string, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, pipeline Pipeline, pipelineRunStep PipelineRunStep, startedAt *time.Time, quota *AccountQuota, scheduler *Scheduler, priority runPriority) error {
 	// Perform full ETL run/index: Load all documents from integration, upsert into database, sync to downstream stores and delete documents that no longer exist
 
 	segment := newrelicTxn.StartSegment("RetrieveAllDocuments")
 	defer segment.End()
 
 	// Find all documents (pages, tickets, etc. from the integration)
-	indexedDocs, err := retrieveAllDocuments(ctx, dataSource, *integrationConnection, clients)
-	if err != nil {
+	indexedDocs, cursor, err := retrieveAllDocuments(ctx, pool, pipeline, dataSource, *integrationConnection, clients)
+	incompleteErr := &IncompleteListError{}
+	incomplete := errors.As(err, &incompleteErr)
+	if incomplete {
+		logger.Printf("Not deleting documents missing from the list, %v\n", err)
+	} else if err != nil {
 		return fmt.Errorf("unable to run index, %w", err)
 	}
 
 	segment.End()
 
-	if !account.IsUnlimited {
-		segment = newrelicTxn.StartSegment("CheckQuotas")
-		defer segment.End()
+	segment = newrelicTxn.StartSegment("CheckQuotas")
+	defer segment.End()
 
-		remainingAllowedDocs := totalIndexedDocumentsLimit(account.IsSubscriber) - account.TotalIndexedDocumentCount
-		if remainingAllowedDocs == 0 {
-			err = UpdatePipelineRunStep(ctx, pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, PipelineRunStepStatusFailed, &RunError{
-				Code:    "limit_exceeded",
-				Message: "Exceeded total indexed document limit",
-			}, nil, nil)
-			if err != nil {
-				return fmt.Errorf("unable to update pipeline run step, %w", err)
-			}
-		}
+	freshnessIndicators, err := GetDocumentFreshnessIndicators(ctx, pool, pipeline.Account, pipeline.Id, integrationConnection.Integration)
+	if err != nil {
+		return fmt.Errorf("unable to get freshness indicators, %w", err)
+	}
 
-		sliced := make(map[string]IndexedDocument)
-		for k, v := range indexedDocs {
-			if len(sliced) == remainingAllowedDocs {
-				break
-			}
+	// Documents excluded by quotas and unchanged documents still exist in the integration, so they must not be deleted
+	// below. Unchanged documents aren't indexed again, so they don't take any quota.
+	foundDocTypes := make([]string, 0, len(indexedDocs))
+	foundDocIds := make([]string, 0, len(indexedDocs))
+	docs := make([]IndexedDocument, 0, len(indexedDocs))
+	for _, doc := range indexedDocs {
+		foundDocTypes = append(foundDocTypes, doc.DocumentType)
+		foundDocIds = append(foundDocIds, doc.Id)
+		if freshnessIndicator, ok := freshnessIndicators[doc.DocumentType+"/"+doc.Id]; ok && freshnessIndicator == doc.freshness() {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	changedDocs := len(docs)
 
-			sliced[k] = v
-			delete(indexedDocs, k)
+	// Runs of exhausted accounts stop without ingesting or deleting anything
+	if reason := quota.Exhausted(); reason != "" && changedDocs > 0 {
+		err = UpdatePipelineRunStep(ctx, pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, PipelineRunStepStatusFailed, quotaExceededError(reason), startedAt, nil)
+		if err != nil {
+			return fmt.Errorf("unable to update pipeline run step, %w", err)
 		}
-		indexedDocs = sliced
 
-		err = IncreaseTotalIndexedCount(ctx, pool, account.Id, len(indexedDocs))
+		return nil
+	}
+
+	docs, err = quota.TakeDocuments(ctx, docs)
+	if err != nil {
+		return err
+	}
+	// Other runs of the account may have used up the quota since it was loaded
+	if len(docs) == 0 && changedDocs > 0 {
+		err = UpdatePipelineRunStep(ctx, pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, PipelineRunStepStatusFailed, quotaExceededError(excludedReasonDocumentLimit), startedAt, nil)
 		if err != nil {
-			return fmt.Errorf("unable to update quotas: %w", err)
+			return fmt.Errorf("unable to update pipeline run step, %w", err)
 		}
 
-		segment.End()
+		return nil
 	}
 
+	// Bulk runs ingest a batch and leave the rest to a deferred message, so they give up their slot in between. The
+	// deferred run lists all documents again and skips the ones ingested by earlier batches, as they're fresh now.
+	// Quota taken for the rest is refunded once the run is closed.
+	docs, rest := scheduler.splitBulkRun(priority, docs)
+
+	segment.End()
+
 	segment = newrelicTxn.StartSegment("RetrieveAndIngestDocuments")
 	defer segment.End()
 
-	logger.Printf("Ingesting %d documents\n", len(indexedDocs))
+	logger.Printf("Ingesting %d documents\n", len(docs))
 
 	// Simply insert all docs (so database will now have old (potentially deleted + just updated docs) + newly-created docs)
 	{
 		g, ctx := errgroup.WithContext(ctx)
-		for _, doc := range indexedDocs {
+		for _, doc := range docs {
 			doc := doc // https://golang.org/doc/faq#closures_and_goroutines
 			g.Go(func() error {
-				return retrieveIngestAndUpsert(ctx, logger, newrelicTxn, pool, doc, pipeline, dataSource, integrationConnection, clients, documentHelper, openAIApiKey, documentTokenLimit(account.IsSubscriber))
+				return retrieveIngestAndUpsert(ctx, logger, newrelicTxn, pool, doc, pipeline, dataSource, integrationConnection, clients, documentHelper, openAIApiKey, quota)
 			})
 		}
 
@@ -471,19 +813,22 @@ func runFullIndex(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *n
 
 	segment.End()
 
+	// Messages waiting in the queue are received before the rest, documents are only deleted by the last batch
+	if len(rest) > 0 {
+		return &DeferredError{Reason: fmt.Sprintf("%d documents left to ingest", len(rest))}
+	}
+
 	segment = newrelicTxn.StartSegment("DeleteDocuments")
 	defer segment.End()
 
-	foundDocIds := make([]string, 0)
-	for _, doc := range indexedDocs {
-		foundDocIds = append(foundDocIds, doc.Id)
-	}
-
 	// Since we just performed a full load of all documents, we can assume that previously-indexed
-	// documents not part of foundDocIds have been deleted in the integration (source of truth)
-	deletedDocs, err := GetMissingDocuments(ctx, pool, pipeline.Account, pipeline.Id, integrationConnection.Integration, foundDocIds)
-	if err != nil {
-		return fmt.Errorf("unable to get deleted documents, %w", err)
+	// documents not part of foundDocTypes and foundDocIds have been deleted in the integration (source of truth)
+	var deletedDocs []Document
+	if !incomplete {
+		deletedDocs, err = GetMissingDocuments(ctx, pool, pipeline.Account, pipeline.Id, integrationConnection.Integration, foundDocTypes, foundDocIds)
+		if err != nil {
+			return fmt.Errorf("unable to get deleted documents, %w", err)
+		}
 	}
 
 	{
@@ -503,6 +848,16 @@ func runFullIndex(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *n
 
 	segment.End()
 
+	// Changes listed after the stored cursor must be listed again by the next run if any of them weren't indexed. An
+	// empty cursor removes the stored one, e.g. once tickets are no longer included.
+	_, incremental := clients[integrationConnection.Integration.base()].(IncrementalLister)
+	if incremental && !incomplete && quota.Excluded() == nil {
+		err = UpdateDataSourceCursor(ctx, pool, pipeline.Id, dataSource.Id, cursor)
+		if err != nil {
+			return fmt.Errorf("unable to update cursor, %w", err)
+		}
+	}
+
 	// Set step to completed
 	err = UpdatePipelineRunStep(ctx, pool, pipelineRunStep.PipelineRun, pipelineRunStep.DataSource, PipelineRunStepStatusCompleted, nil, startedAt, now())
 	if err != nil {
@@ -512,18 +867,22 @@ func runFullIndex(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *n
 	return nil
 }
 
-func handleDocumentChange(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, clients map[Integration]DataSourceApiClient, pool *pgxpool.Pool, documentHelper DocumentHelper, openAIApiKey string, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, pipeline Pipeline, change DocumentChange, tokenLimit int) error {
-	linearClient := clients[integrationConnection.Integration]
+func handleDocumentChange(ctx context.Context, logger logrus.FieldLogger, newrelicTxn *newrelic.Transaction, clients map[Integration]DataSourceApiClient, pool *pgxpool.Pool, documentHelper DocumentHelper, openAIApiKey string, dataSource *PipelineDataSource, integrationConnection *IntegrationConnection, pipeline Pipeline, change DocumentChange, quota *AccountQuota) error {
+	linearClient := clients[integrationConnection.Integration.base()]
 	switch change.Action {
 	case ChangeActionCreate:
 		fallthrough
 	case ChangeActionUpdate:
-		doc, err := linearClient.GetDocument(ctx, change.DocumentType, change.DocumentId, *integrationConnection)
+		doc, err := linearClient.GetDocument(ctx, dataSource, change.DocumentType, change.DocumentId, *integrationConnection)
+		if errors.Is(err, ErrDocumentOutOfScope) {
+			// The document may have been indexed before it moved out of scope
+			return deleteDocument(ctx, pool, documentHelper, integrationConnection.Integration, change.DocumentType, change.DocumentId, pipeline)
+		}
 		if err != nil {
 			return fmt.Errorf("unable to get document, %w", err)
 		}
 
-		return retrieveIngestAndUpsert(ctx, logger, newrelicTxn, pool, doc, pipeline, dataSource, integrationConnection, clients, documentHelper, openAIApiKey, tokenLimit)
+		return retrieveIngestAndUpsert(ctx, logger, newrelicTxn, pool, doc, pipeline, dataSource, integrationConnection, clients, documentHelper, openAIApiKey, quota)
 	case ChangeActionDelete:
 		return deleteDocument(ctx, pool, documentHelper, integrationConnection.Integration, change.DocumentType, change.DocumentId, pipeline)
 	}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type IntercomDocumentType string
+
+const (
+	IntercomDocumentTypeArticle      IntercomDocumentType = "article"
+	IntercomDocumentTypeConversation IntercomDocumentType = "conversation"
+)
+
+const intercomAPIVersion = "2.10"
+
+type IntercomAPIClientImpl struct {
+	logger    logrus.FieldLogger
+	transport *ProviderTransport
+	sema      *semaphore.Weighted
+}
+
+func newIntercomApiClient(config ProviderConfig, rateLimits rateLimitStore, logger logrus.FieldLogger) DataSourceApiClient {
+	httpClient := &http.Client{
+		Timeout: config.Timeout,
+	}
+
+	return &IntercomAPIClientImpl{
+		// 1,000 requests per minute per workspace, distributed over 10 second windows
+		transport: newProviderTransport("intercom", httpClient, newRateLimiter("intercom", rateLimits, 1000.0/60, 10), config.MaxAttempts, nil).withAPIHosts("api.intercom.io"),
+		logger:    logger,
+
+		// https://developers.intercom.com/docs/references/rest-api/errors/rate-limiting
+		sema: semaphore.NewWeighted(int64(config.Concurrency)),
+	}
+}
+
+func (client *IntercomAPIClientImpl) sendRequest(ctx context.Context, integration IntercomIntegrationConnection, method, endpoint string, body any, result any) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+integration.Config.AccessToken)
+	header.Set("Intercom-Version", intercomAPIVersion)
+	header.Set("Accept", "application/json")
+
+	var marshalledBody []byte
+	if body != nil {
+		var err error
+		marshalledBody, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("unable to marshal body, %w", err)
+		}
+		header.Set("Content-Type", "application/json")
+	}
+
+	return client.transport.DoJSON(ctx, providerRequest{
+		Method:     method,
+		URL:        "https://api.intercom.io" + endpoint,
+		Header:     header,
+		Body:       marshalledBody,
+		LimiterKey: intercomLimiterKey(integration),
+	}, result)
+}
+
+// intercomLimiterKey selects the rate limit bucket, Intercom limits requests per workspace
+func intercomLimiterKey(integration IntercomIntegrationConnection) string {
+	if integration.Config.WorkspaceId != "" {
+		return integration.Config.WorkspaceId
+	}
+	return rateLimitKey(integration.Config.AccessToken)
+}
+
+type IntercomArticle struct {
+	Id          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Body        string `json:"body"`
+	URL         string `json:"url"`
+	State       string `json:"state"`
+	AuthorId    int64  `json:"author_id"`
+	ParentId    *int64 `json:"parent_id"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+func (article IntercomArticle) isPublished() bool {
+	return article.State == "published"
+}
+
+func (article IntercomArticle) toIndexedDocument() IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationIntercom,
+		DocumentType:       string(IntercomDocumentTypeArticle),
+		Id:                 article.Id,
+		Title:              article.Title,
+		URL:                article.URL,
+		FreshnessIndicator: fmt.Sprint(article.UpdatedAt),
+		EditedAt:           time.Unix(article.UpdatedAt, 0),
+	}
+}
+
+type IntercomAuthor struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type IntercomConversation struct {
+	Id        string `json:"id"`
+	Title     string `json:"title"`
+	State     string `json:"state"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	Source    struct {
+		Subject string         `json:"subject"`
+		Body    string         `json:"body"`
+		Author  IntercomAuthor `json:"author"`
+	} `json:"source"`
+	Tags struct {
+		Tags []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+	} `json:"tags"`
+	ConversationParts struct {
+		ConversationParts []struct {
+			PartType  string         `json:"part_type"`
+			Body      string         `json:"body"`
+			CreatedAt int64          `json:"created_at"`
+			Author    IntercomAuthor `json:"author"`
+		} `json:"conversation_parts"`
+	} `json:"conversation_parts"`
+}
+
+func (conversation IntercomConversation) isClosed() bool {
+	return conversation.State == "closed"
+}
+
+func (conversation IntercomConversation) title() string {
+	if conversation.Title != "" {
+		return conversation.Title
+	}
+	if conversation.Source.Subject != "" {
+		return conversation.Source.Subject
+	}
+	return fmt.Sprintf("Conversation %s", conversation.Id)
+}
+
+func (conversation IntercomConversation) toIndexedDocument() IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationIntercom,
+		DocumentType:       string(IntercomDocumentTypeConversation),
+		Id:                 conversation.Id,
+		Title:              conversation.title(),
+		URL:                fmt.Sprintf("https://app.intercom.com/a/inbox/_/inbox/conversation/%s", conversation.Id),
+		FreshnessIndicator: fmt.Sprint(conversation.UpdatedAt),
+		EditedAt:           time.Unix(conversation.UpdatedAt, 0),
+	}
+}
+
+// https://developers.intercom.com/docs/references/rest-api/api.intercom.io/articles/listarticles
+func (client *IntercomAPIClientImpl) listArticles(ctx context.Context, integration IntercomIntegrationConnection) (map[string]IndexedDocument, error) {
+	indexedDocuments := make(map[string]IndexedDocument)
+
+	for page := 1; ; page++ {
+		type articlesResp struct {
+			Data  []IntercomArticle `json:"data"`
+			Pages struct {
+				Page       int `json:"page"`
+				TotalPages int `json:"total_pages"`
+			} `json:"pages"`
+		}
+
+		var resp articlesResp
+		err := client.sendRequest(ctx, integration, http.MethodGet, fmt.Sprintf("/articles?page=%d&per_page=50", page), nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, article := range resp.Data {
+			if !article.isPublished() {
+				continue
+			}
+			doc := article.toIndexedDocument()
+			indexedDocuments[doc.DocumentType+"/"+doc.Id] = doc
+		}
+
+		if page >= resp.Pages.TotalPages {
+			break
+		}
+	}
+
+	return indexedDocuments, nil
+}
+
+// listClosedConversations pages through the conversation search sorted by update time, using the cursor returned
+// by the previous page
+// https://developers.intercom.com/docs/references/rest-api/api.intercom.io/conversations/searchconversations
+func (client *IntercomAPIClientImpl) listClosedConversations(ctx context.Context, integration IntercomIntegrationConnection) (map[string]IndexedDocument, error) {
+	indexedDocuments := make(map[string]IndexedDocument)
+
+	var cursor *string
+	for {
+		pagination := map[string]any{
+			"per_page": 150,
+		}
+		if cursor != nil {
+			pagination["starting_after"] = *cursor
+		}
+
+		body := map[string]any{
+			"query": map[string]any{
+				"operator": "AND",
+				"value": []map[string]any{
+					{"field": "state", "operator": "=", "value": "closed"},
+					{"field": "updated_at", "operator": ">", "value": 0},
+				},
+			},
+			"sort": map[string]any{
+				"field": "updated_at",
+				"order": "ascending",
+			},
+			"pagination": pagination,
+		}
+
+		type conversationsResp struct {
+			Conversations []IntercomConversation `json:"conversations"`
+			Pages         struct {
+				Next *struct {
+					StartingAfter string `json:"starting_after"`
+				} `json:"next"`
+			} `json:"pages"`
+		}
+
+		var resp conversationsResp
+		err := client.sendRequest(ctx, integration, http.MethodPost, "/conversations/search", body, &resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, conversation := range resp.Conversations {
+			if !conversation.isClosed() {
+				continue
+			}
+			doc := conversation.toIndexedDocument()
+			indexedDocuments[doc.DocumentType+"/"+doc.Id] = doc
+		}
+
+		if resp.Pages.Next == nil || resp.Pages.Next.StartingAfter == "" {
+			break
+		}
+		cursor = &resp.Pages.Next.StartingAfter
+	}
+
+	return indexedDocuments, nil
+}
+
+func (client *IntercomAPIClientImpl) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer client.sema.Release(1)
+
+	client.logger.Printf("Listing all Intercom articles\n")
+
+	allDocuments, err := client.listArticles(ctx, integration.IntercomIntegrationConnection)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list articles, %w", err)
+	}
+
+	if dataSource.IntercomDataSource.Config.IncludeConversations {
+		client.logger.Printf("Listing all closed Intercom conversations\n")
+
+		conversations, err := client.listClosedConversations(ctx, integration.IntercomIntegrationConnection)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list conversations, %w", err)
+		}
+
+		for key, conversation := range conversations {
+			allDocuments[key] = conversation
+		}
+	}
+
+	client.logger.Printf("Found %d Intercom documents\n", len(allDocuments))
+
+	return allDocuments, nil
+}
+
+func (client *IntercomAPIClientImpl) getArticle(ctx context.Context, integration IntercomIntegrationConnection, id string) (*IntercomArticle, error) {
+	var article IntercomArticle
+	err := client.sendRequest(ctx, integration, http.MethodGet, fmt.Sprintf("/articles/%s", url.PathEscape(id)), nil, &article)
+	if err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+func (client *IntercomAPIClientImpl) getConversation(ctx context.Context, integration IntercomIntegrationConnection, id string) (*IntercomConversation, error) {
+	var conversation IntercomConversation
+	err := client.sendRequest(ctx, integration, http.MethodGet, fmt.Sprintf("/conversations/%s?display_as=plaintext", url.PathEscape(id)), nil, &conversation)
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+func (client *IntercomAPIClientImpl) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+	defer client.sema.Release(1)
+
+	switch documentType {
+	case string(IntercomDocumentTypeArticle):
+		article, err := client.getArticle(ctx, integration.IntercomIntegrationConnection, id)
+		if err != nil {
+			return IndexedDocument{}, err
+		}
+		// Articles which were unpublished are removed, just like ListDocuments leaves them out
+		if !article.isPublished() {
+			return IndexedDocument{}, ErrDocumentOutOfScope
+		}
+		return article.toIndexedDocument(), nil
+	case string(IntercomDocumentTypeConversation):
+		if !dataSource.IntercomDataSource.Config.IncludeConversations {
+			return IndexedDocument{}, ErrDocumentOutOfScope
+		}
+
+		conversation, err := client.getConversation(ctx, integration.IntercomIntegrationConnection, id)
+		if err != nil {
+			return IndexedDocument{}, err
+		}
+		// Conversations which were reopened are removed until they're closed again
+		if !conversation.isClosed() {
+			return IndexedDocument{}, ErrDocumentOutOfScope
+		}
+		return conversation.toIndexedDocument(), nil
+	default:
+		return IndexedDocument{}, fmt.Errorf("unknown document type %q", documentType)
+	}
+}
+
+func intercomTimestamp(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+func (client *IntercomAPIClientImpl) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	defer client.sema.Release(1)
+
+	switch documentType {
+	case string(IntercomDocumentTypeArticle):
+		article, err := client.getArticle(ctx, integration.IntercomIntegrationConnection, id)
+		if err != nil {
+			return "", nil, err
+		}
+
+		body, err := htmlToMarkdown(article.Body, nil)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "# " + article.Title + "\n\n" + body.Markdown, map[string]any{
+			"title":       article.Title,
+			"description": article.Description,
+			"created_at":  intercomTimestamp(article.CreatedAt),
+			"updated_at":  intercomTimestamp(article.UpdatedAt),
+		}, nil
+	case string(IntercomDocumentTypeConversation):
+		conversation, err := client.getConversation(ctx, integration.IntercomIntegrationConnection, id)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var sb strings.Builder
+		sb.WriteString("# " + conversation.title() + "\n")
+		sb.WriteString(fmt.Sprintf("\n## %s (%s)\n\n%s\n", conversation.Source.Author.Name, intercomTimestamp(conversation.CreatedAt), strings.TrimSpace(conversation.Source.Body)))
+
+		for _, part := range conversation.ConversationParts.ConversationParts {
+			// Notes are internal to the team, assignments and state changes don't carry any content
+			if part.PartType != "comment" || strings.TrimSpace(part.Body) == "" {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("\n## %s (%s)\n\n%s\n", part.Author.Name, intercomTimestamp(part.CreatedAt), strings.TrimSpace(part.Body)))
+		}
+
+		tags := make([]string, 0, len(conversation.Tags.Tags))
+		for _, tag := range conversation.Tags.Tags {
+			tags = append(tags, tag.Name)
+		}
+
+		return sb.String(), map[string]any{
+			"title":      conversation.title(),
+			"state":      conversation.State,
+			"tags":       tags,
+			"created_at": intercomTimestamp(conversation.CreatedAt),
+			"updated_at": intercomTimestamp(conversation.UpdatedAt),
+		}, nil
+	default:
+		return "", nil, fmt.Errorf("unknown document type %q", documentType)
+	}
+}
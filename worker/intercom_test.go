@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+func newTestIntercomClient(t *testing.T, handler http.Handler) *IntercomAPIClientImpl {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return &IntercomAPIClientImpl{
+		transport: newProviderTransport("intercom", newTestHTTPClient(t, handler), nil, 1, nil),
+		logger:    logger,
+		sema:      semaphore.NewWeighted(1),
+	}
+}
+
+// testIntercomWorkspace serves articles and conversations, the conversation search returns every conversation in
+// two pages
+type testIntercomWorkspace struct {
+	articles      []IntercomArticle
+	conversations []map[string]any
+}
+
+func (workspace *testIntercomWorkspace) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "api.intercom.io" || r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Intercom-Version") != intercomAPIVersion {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var response any
+	switch {
+	case r.URL.Path == "/articles":
+		response = map[string]any{"data": workspace.articles, "pages": map[string]any{"page": 1, "total_pages": 1}}
+	case r.URL.Path == "/conversations/search" && r.Method == http.MethodPost:
+		var body struct {
+			Pagination struct {
+				StartingAfter string `json:"starting_after"`
+			} `json:"pagination"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Pagination.StartingAfter == "" {
+			response = map[string]any{"conversations": workspace.conversations[:1], "pages": map[string]any{"next": map[string]any{"starting_after": "next"}}}
+		} else {
+			response = map[string]any{"conversations": workspace.conversations[1:], "pages": map[string]any{}}
+		}
+	case strings.HasPrefix(r.URL.Path, "/articles/"):
+		for _, article := range workspace.articles {
+			if r.URL.Path == "/articles/"+article.Id {
+				response = article
+			}
+		}
+	case strings.HasPrefix(r.URL.Path, "/conversations/"):
+		for _, conversation := range workspace.conversations {
+			if r.URL.Path == "/conversations/"+conversation["id"].(string) {
+				response = conversation
+			}
+		}
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func newTestIntercomWorkspace() *testIntercomWorkspace {
+	return &testIntercomWorkspace{
+		articles: []IntercomArticle{
+			{Id: "10", Title: "Published", Body: "<p>Hello</p>", State: "published", UpdatedAt: 1672531200},
+			{Id: "11", Title: "Draft", State: "draft"},
+		},
+		conversations: []map[string]any{
+			{
+				"id": "1", "title": "", "state": "closed", "created_at": 1672531200, "updated_at": 1672534800,
+				"source": map[string]any{"subject": "Login fails", "body": "I can't log in", "author": map[string]any{"name": "Ada"}},
+				"tags":   map[string]any{"tags": []any{map[string]any{"name": "login"}}},
+				"conversation_parts": map[string]any{"conversation_parts": []any{
+					map[string]any{"part_type": "note", "body": "Internal note", "created_at": 1672531300, "author": map[string]any{"name": "Bob"}},
+					map[string]any{"part_type": "comment", "body": "Reset your password", "created_at": 1672531400, "author": map[string]any{"name": "Bob"}},
+					map[string]any{"part_type": "close", "body": "", "created_at": 1672534800, "author": map[string]any{"name": "Bob"}},
+				}},
+			},
+			{"id": "2", "state": "open", "created_at": 1672531200, "updated_at": 1672531200},
+		},
+	}
+}
+
+func newIntercomConnection() IntegrationConnection {
+	connection := IntegrationConnection{}
+	connection.Integration = IntegrationIntercom
+	connection.IntercomIntegrationConnection.Config.AccessToken = "token"
+	return connection
+}
+
+func newIntercomDataSource(includeConversations bool) *PipelineDataSource {
+	dataSource := &PipelineDataSource{}
+	dataSource.IntegrationName = IntegrationIntercom
+	dataSource.IntercomDataSource.Config.IncludeConversations = includeConversations
+	return dataSource
+}
+
+func TestIntercomListDocuments(t *testing.T) {
+	client := newTestIntercomClient(t, newTestIntercomWorkspace())
+
+	tests := []struct {
+		name                 string
+		includeConversations bool
+		want                 []string
+	}{
+		{name: "articles only", want: []string{"article/10"}},
+		{name: "closed conversations", includeConversations: true, want: []string{"article/10", "conversation/1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docs, err := client.ListDocuments(context.Background(), newIntercomDataSource(test.includeConversations), newIntercomConnection())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := documentKeys(docs); strings.Join(keys, ",") != strings.Join(test.want, ",") {
+				t.Errorf("listed %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestIntercomGetDocumentScope(t *testing.T) {
+	client := newTestIntercomClient(t, newTestIntercomWorkspace())
+
+	tests := []struct {
+		name                 string
+		includeConversations bool
+		documentType         string
+		id                   string
+		wantOutOfScope       bool
+	}{
+		{name: "published article", documentType: "article", id: "10"},
+		{name: "draft article", documentType: "article", id: "11", wantOutOfScope: true},
+		{name: "closed conversation", includeConversations: true, documentType: "conversation", id: "1"},
+		{name: "open conversation", includeConversations: true, documentType: "conversation", id: "2", wantOutOfScope: true},
+		{name: "conversations not included", documentType: "conversation", id: "1", wantOutOfScope: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := client.GetDocument(context.Background(), newIntercomDataSource(test.includeConversations), test.documentType, test.id, newIntercomConnection())
+			if test.wantOutOfScope {
+				if !errors.Is(err, ErrDocumentOutOfScope) {
+					t.Errorf("got document %+v, error %v, want out of scope", doc, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.DocumentType != test.documentType || doc.Id != test.id {
+				t.Errorf("got document %+v", doc)
+			}
+		})
+	}
+}
+
+func TestIntercomConversationContent(t *testing.T) {
+	client := newTestIntercomClient(t, newTestIntercomWorkspace())
+
+	content, metadata, err := client.GetDocumentContent(context.Background(), newIntercomDataSource(true), "conversation", "1", newIntercomConnection())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Notes are internal and left out, just like parts without content
+	want := "# Login fails\n\n## Ada (2023-01-01T00:00:00Z)\n\nI can't log in\n\n## Bob (2023-01-01T00:03:20Z)\n\nReset your password\n"
+	if content != want {
+		t.Errorf("got content %q, want %q", content, want)
+	}
+	if metadata["title"] != "Login fails" || metadata["state"] != "closed" || strings.Join(metadata["tags"].([]string), ",") != "login" {
+		t.Errorf("got metadata %v", metadata)
+	}
+}
@@ -1,47 +1,231 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
-	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type LinearDocumentType string
 
 const (
-	LinearDocumentTypeIssue LinearDocumentType = "issue"
+	LinearDocumentTypeIssue    LinearDocumentType = "issue"
+	LinearDocumentTypeProject  LinearDocumentType = "project"
+	LinearDocumentTypeDocument LinearDocumentType = "document"
+	LinearDocumentTypeCycle    LinearDocumentType = "cycle"
+
+	// Attachments are indexed as children of their issue, they can't be selected as document type
+	LinearDocumentTypeAttachment LinearDocumentType = "attachment"
 )
 
 type LinearAPIClientImpl struct {
-	logger     logrus.FieldLogger
-	httpClient *http.Client
-	sema       *semaphore.Weighted
+	logger            logrus.FieldLogger
+	transport         *ProviderTransport
+	downloadTransport *ProviderTransport
+	sema              *semaphore.Weighted
+
+	// access caches the teams of a workspace by access token
+	access sync.Map
 }
 
-func newLinearApiClient(logger logrus.FieldLogger) DataSourceApiClient {
+func newLinearApiClient(config ProviderConfig, attachments HTTPClientConfig, rateLimits rateLimitStore, logger logrus.FieldLogger) DataSourceApiClient {
+	httpClient := &http.Client{
+		Timeout: config.Timeout,
+	}
+
 	return &LinearAPIClientImpl{
-		httpClient: &http.Client{
-			Timeout: time.Second * 30,
-		},
-		logger: logger,
+		// 1,500 requests per hour, the complexity budget is picked up from response headers
+		transport:         newProviderTransport("linear", httpClient, newRateLimiter("linear", rateLimits, 1500.0/3600, 50), config.MaxAttempts, classifyLinearResponse).withAPIHosts("api.linear.app"),
+		downloadTransport: newAttachmentTransport("linear-attachments", attachments),
+		logger:            logger,
 
 		// https://developers.linear.app/docs/graphql/working-with-the-graphql-api/rate-limiting
-		sema: semaphore.NewWeighted(5),
+		sema: semaphore.NewWeighted(int64(config.Concurrency)),
 	}
 }
 
+// linearDocumentTypes returns the document types selected in the data source, issues are indexed by default
+func linearDocumentTypes(dataSource *PipelineDataSource) []LinearDocumentType {
+	if dataSource == nil || len(dataSource.LinearDataSource.Config.DocumentTypes) == 0 {
+		return []LinearDocumentType{LinearDocumentTypeIssue}
+	}
+
+	documentTypes := make([]LinearDocumentType, 0, len(dataSource.LinearDataSource.Config.DocumentTypes))
+	for _, documentType := range dataSource.LinearDataSource.Config.DocumentTypes {
+		documentTypes = append(documentTypes, LinearDocumentType(documentType))
+	}
+	return documentTypes
+}
+
+func linearDocumentTypeSelected(dataSource *PipelineDataSource, documentType LinearDocumentType) bool {
+	for _, selected := range linearDocumentTypes(dataSource) {
+		if selected == documentType {
+			return true
+		}
+	}
+	return false
+}
+
+type linearErrorResponse struct {
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code string `json:"code"`
+		}
+	} `json:"errors"`
+}
+
+// sendRequest runs a GraphQL query and decodes the data field of the response into result
+// https://studio.apollographql.com/public/Linear-API/variant/current/explorer
+func (client *LinearAPIClientImpl) sendRequest(ctx context.Context, integration LinearIntegrationConnection, query string, variables map[string]any, result any) error {
+	marshalledBody, err := json.Marshal(map[string]any{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+integration.Config.AccessToken)
+	header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		linearErrorResponse
+		Data json.RawMessage `json:"data"`
+	}
+	err = client.transport.DoJSON(ctx, providerRequest{
+		Method:     http.MethodPost,
+		URL:        "https://api.linear.app/graphql",
+		Header:     header,
+		Body:       marshalledBody,
+		LimiterKey: rateLimitKey(integration.Config.AccessToken),
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	// Partial failures are returned with status 200
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("unexpected error %q: %s", resp.Errors[0].Extensions.Code, resp.Errors[0].Message)
+	}
+
+	return json.Unmarshal(resp.Data, result)
+}
+
+// classifyLinearResponse maps GraphQL errors, which Linear also returns for rate limits
+func classifyLinearResponse(res *http.Response) error {
+	// The token was revoked or the app was removed from the workspace
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return backoff.Permanent(ErrIntegrationUnauthorized)
+	}
+
+	var errResp linearErrorResponse
+	err := json.NewDecoder(res.Body).Decode(&errResp)
+	if err != nil {
+		return err
+	}
+
+	if len(errResp.Errors) <= 0 {
+		return backoff.Permanent(fmt.Errorf("unexpected error %d", res.StatusCode))
+	}
+
+	linearErr := errResp.Errors[0]
+
+	if linearErr.Extensions.Code == "RATELIMITED" {
+		return fmt.Errorf("rate limited")
+	}
+
+	if linearErr.Extensions.Code == "AUTHENTICATION_ERROR" {
+		return backoff.Permanent(ErrIntegrationUnauthorized)
+	}
+
+	return backoff.Permanent(fmt.Errorf("unexpected error %q: %s", linearErr.Extensions.Code, linearErr.Message))
+}
+
+type linearConnection[T any] struct {
+	Nodes    []T `json:"nodes"`
+	PageInfo struct {
+		EndCursor   *string `json:"endCursor"`
+		HasNextPage bool    `json:"hasNextPage"`
+	} `json:"pageInfo"`
+}
+
+// listAll pages through the connection returned in field by query, which must accept $after and $first
+// https://developers.linear.app/docs/graphql/working-with-the-graphql-api/pagination
+func listAll[T any](ctx context.Context, client *LinearAPIClientImpl, integration LinearIntegrationConnection, query, field string, variables map[string]any, handle func(node T)) error {
+	var cursor *string
+
+	for {
+		pageVariables := map[string]any{
+			"after": cursor,
+			"first": 100,
+		}
+		for k, v := range variables {
+			pageVariables[k] = v
+		}
+
+		var resp map[string]linearConnection[T]
+		err := client.sendRequest(ctx, integration, query, pageVariables, &resp)
+		if err != nil {
+			return err
+		}
+
+		connection := resp[field]
+		for _, node := range connection.Nodes {
+			handle(node)
+		}
+
+		if !connection.PageInfo.HasNextPage {
+			return nil
+		}
+
+		cursor = connection.PageInfo.EndCursor
+	}
+}
+
+type LinearUser struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// linearLatest holds the most recently updated child of an entity (comment, project update, cycle issue). Changes to
+// children are not reflected in the parent's updatedAt, so they're part of the freshness indicator.
+type linearLatest struct {
+	Nodes []struct {
+		UpdatedAt string `json:"updatedAt"`
+	} `json:"nodes"`
+}
+
+func (latest linearLatest) freshnessIndicator(updatedAt string) string {
+	if len(latest.Nodes) == 0 {
+		return updatedAt
+	}
+	return updatedAt + "/" + latest.Nodes[0].UpdatedAt
+}
+
+// issueFragment is used when listing, so it must not contain nested connections besides the latest comment and
+// attachment to stay within Linear's query complexity limit
 const issueFragment = `id
+      identifier
       title
 	  updatedAt
 	  url
       description
+      priority
+      priorityLabel
+      estimate
+      dueDate
       creator {
         id
         name
@@ -57,318 +241,1038 @@ const issueFragment = `id
       state {
         name
         type
+      }
+      createdAt
+      team {
+        id
+        key
+        name
+      }
+      project {
+        id
+        name
+      }
+      cycle {
+        id
+        number
+        name
+      }
+      parent {
+        identifier
+        title
+      }
+      latestComment: comments(first: 1, orderBy: updatedAt) {
+        nodes {
+          updatedAt
+        }
+      }
+      latestAttachment: attachments(first: 1, orderBy: updatedAt) {
+        nodes {
+          updatedAt
+        }
       }  `
 
+const issueDetailsFragment = `labels {
+      nodes {
+        name
+      }
+    }
+    children(first: 100) {
+      nodes {
+        identifier
+        title
+        state {
+          name
+        }
+      }
+    }
+    relations(first: 100) {
+      nodes {
+        type
+        relatedIssue {
+          identifier
+          title
+        }
+      }
+    }
+    inverseRelations(first: 100) {
+      nodes {
+        type
+        issue {
+          identifier
+          title
+        }
+      }
+    }
+    comments(first: 250) {
+      nodes {
+        id
+        body
+        createdAt
+        updatedAt
+        user {
+          id
+          name
+          email
+        }
+      }
+    }
+    attachments(first: 50) {
+      nodes {
+        id
+        title
+        url
+      }
+    }`
+
+type LinearComment struct {
+	Id        string      `json:"id"`
+	Body      string      `json:"body"`
+	CreatedAt string      `json:"createdAt"`
+	UpdatedAt string      `json:"updatedAt"`
+	User      *LinearUser `json:"user"`
+	Health    string      `json:"health"`
+}
+
+type linearIssueRef struct {
+	Identifier string `json:"identifier"`
+	Title      string `json:"title"`
+	State      *struct {
+		Name string `json:"name"`
+	} `json:"state"`
+}
+
 type LinearIssue struct {
-	Id          string `json:"id"`
-	Title       string `json:"title"`
-	URL         string `json:"url"`
-	Description string `json:"description"`
-	UpdatedAt   string `json:"updatedAt"`
-	Creator     struct {
-		Id    string `json:"id"`
-		Name  string `json:"name"`
-		Email string `json:"email"`
-	}
-	Assignee struct {
-		Id    string `json:"id"`
-		Name  string `json:"name"`
-		Email string `json:"email"`
-	}
-	State struct {
+	Id            string   `json:"id"`
+	Identifier    string   `json:"identifier"`
+	Title         string   `json:"title"`
+	URL           string   `json:"url"`
+	Description   string   `json:"description"`
+	UpdatedAt     string   `json:"updatedAt"`
+	Priority      float64  `json:"priority"`
+	PriorityLabel string   `json:"priorityLabel"`
+	Estimate      *float64 `json:"estimate"`
+	DueDate       *string  `json:"dueDate"`
+	Creator       *LinearUser
+	Assignee      *LinearUser
+	State         struct {
 		Name string `json:"name"`
 		Type string `json:"type"`
 	}
+	CreatedAt time.Time `json:"createdAt"`
+	Team      struct {
+		Id   string `json:"id"`
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"team"`
+	Project *struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"project"`
+	Cycle *struct {
+		Id     string  `json:"id"`
+		Number float64 `json:"number"`
+		Name   string  `json:"name"`
+	} `json:"cycle"`
+	Parent           *linearIssueRef `json:"parent"`
+	LatestComment    linearLatest    `json:"latestComment"`
+	LatestAttachment linearLatest    `json:"latestAttachment"`
+
+	// Only set when retrieving a single issue
+	Labels struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Children struct {
+		Nodes []linearIssueRef `json:"nodes"`
+	} `json:"children"`
+	Relations struct {
+		Nodes []struct {
+			Type         string         `json:"type"`
+			RelatedIssue linearIssueRef `json:"relatedIssue"`
+		} `json:"nodes"`
+	} `json:"relations"`
+	InverseRelations struct {
+		Nodes []struct {
+			Type  string         `json:"type"`
+			Issue linearIssueRef `json:"issue"`
+		} `json:"nodes"`
+	} `json:"inverseRelations"`
+	Comments struct {
+		Nodes []LinearComment `json:"nodes"`
+	} `json:"comments"`
+	Attachments struct {
+		Nodes []struct {
+			Id    string `json:"id"`
+			Title string `json:"title"`
+			URL   string `json:"url"`
+		} `json:"nodes"`
+	} `json:"attachments"`
 }
 
-func (client *LinearAPIClientImpl) listIssues(ctx context.Context, integration LinearIntegrationConnection) (map[string]IndexedDocument, error) {
-	var cursor *string
-	var indexedDocuments = make(map[string]IndexedDocument)
+func (issue LinearIssue) labelNames() []string {
+	labels := make([]string, 0, len(issue.Labels.Nodes))
+	for _, label := range issue.Labels.Nodes {
+		labels = append(labels, label.Name)
+	}
+	return labels
+}
 
-	for {
-		body := map[string]any{
-			// https://developers.linear.app/docs/graphql/working-with-the-graphql-api/pagination
-			"variables": map[string]any{
-				"after": cursor,
-				"first": 100,
-			},
-			"query": `
-query getIssues($after: String, $first: Int) {
-  issues(after: $after, first: $first) {
-    nodes {
-      ` + issueFragment + `
-    }
-    pageInfo {
-      endCursor
-      hasNextPage
-    }
-  }
-}`,
+// relationsByType groups related issue identifiers from the perspective of this issue. Relations are stored on one of
+// the issues only, so inverse relations are mapped to their counterpart (blocks -> blocked_by, duplicate ->
+// duplicated_by).
+func (issue LinearIssue) relationsByType() map[string][]linearIssueRef {
+	relations := make(map[string][]linearIssueRef)
+	for _, relation := range issue.Relations.Nodes {
+		key := relation.Type
+		if key == "duplicate" {
+			key = "duplicate_of"
 		}
-
-		marshalledBody, err := json.Marshal(body)
-		if err != nil {
-			return nil, err
+		relations[key] = append(relations[key], relation.RelatedIssue)
+	}
+	for _, relation := range issue.InverseRelations.Nodes {
+		key := relation.Type
+		switch key {
+		case "blocks":
+			key = "blocked_by"
+		case "duplicate":
+			key = "duplicated_by"
 		}
+		relations[key] = append(relations[key], relation.Issue)
+	}
+	return relations
+}
 
-		// https://studio.apollographql.com/public/Linear-API/variant/current/explorer
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.linear.app/graphql", bytes.NewBuffer(marshalledBody))
-		if err != nil {
-			return nil, err
+// Relation types in the order they're rendered
+var linearRelationTypes = []string{"blocks", "blocked_by", "related", "similar", "duplicate_of", "duplicated_by"}
+
+func identifiers(refs []linearIssueRef) []string {
+	result := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		result = append(result, ref.Identifier)
+	}
+	return result
+}
+
+func formatEstimate(estimate float64) string {
+	return strconv.FormatFloat(estimate, 'f', -1, 64)
+}
+
+func (issue LinearIssue) render() string {
+	var sb strings.Builder
+
+	sb.WriteString("# " + issue.Identifier + " " + issue.Title + "\n\n")
+
+	fields := [][2]string{
+		{"Team", issue.Team.Name},
+		{"State", issue.State.Name},
+		{"Priority", issue.PriorityLabel},
+	}
+	if issue.Assignee != nil {
+		fields = append(fields, [2]string{"Assignee", issue.Assignee.Name})
+	}
+	if issue.Creator != nil {
+		fields = append(fields, [2]string{"Creator", issue.Creator.Name})
+	}
+	if issue.Estimate != nil {
+		fields = append(fields, [2]string{"Estimate", formatEstimate(*issue.Estimate)})
+	}
+	if labels := issue.labelNames(); len(labels) > 0 {
+		fields = append(fields, [2]string{"Labels", strings.Join(labels, ", ")})
+	}
+	if issue.Project != nil {
+		fields = append(fields, [2]string{"Project", issue.Project.Name})
+	}
+	if issue.Cycle != nil {
+		cycle := fmt.Sprintf("Cycle %d", int(issue.Cycle.Number))
+		if issue.Cycle.Name != "" {
+			cycle += " (" + issue.Cycle.Name + ")"
 		}
+		fields = append(fields, [2]string{"Cycle", cycle})
+	}
+	if issue.DueDate != nil {
+		fields = append(fields, [2]string{"Due date", *issue.DueDate})
+	}
+	if issue.Parent != nil {
+		fields = append(fields, [2]string{"Parent", issue.Parent.Identifier + " " + issue.Parent.Title})
+	}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		sb.WriteString("- **" + field[0] + ":** " + field[1] + "\n")
+	}
 
-		req.Header.Set("Authorization", "Bearer "+integration.Config.AccessToken)
+	if description := strings.TrimSpace(issue.Description); description != "" {
+		sb.WriteString("\n" + description + "\n")
+	}
 
-		req.Header.Set("Content-Type", "application/json")
+	if len(issue.Children.Nodes) > 0 {
+		sb.WriteString("\n## Sub-issues\n\n")
+		for _, child := range issue.Children.Nodes {
+			sb.WriteString("- " + child.Identifier + " " + child.Title)
+			if child.State != nil {
+				sb.WriteString(" (" + child.State.Name + ")")
+			}
+			sb.WriteString("\n")
+		}
+	}
 
-		res, err := backoff.RetryWithData[*http.Response](
-			func() (*http.Response, error) {
-				res, err := client.httpClient.Do(req)
-				if err != nil {
-					if err, ok := err.(net.Error); ok && err.Timeout() {
-						return nil, err
-					}
-					return nil, backoff.Permanent(err)
-				}
+	relations := issue.relationsByType()
+	if len(relations) > 0 {
+		sb.WriteString("\n## Relations\n\n")
+		for _, relationType := range linearRelationTypes {
+			for _, related := range relations[relationType] {
+				sb.WriteString("- " + strings.ReplaceAll(relationType, "_", " ") + " " + related.Identifier + " " + related.Title + "\n")
+			}
+		}
+	}
 
-				if res.StatusCode != http.StatusOK {
-					type errorResponse struct {
-						Errors []struct {
-							Message    string `json:"message"`
-							Extensions struct {
-								Code string `json:"code"`
-							}
-						} `json:"errors"`
-					}
+	sb.WriteString(renderLinearComments("Comments", issue.Comments.Nodes))
 
-					var errResp errorResponse
-					err = json.NewDecoder(res.Body).Decode(&errResp)
-					if err != nil {
-						return nil, err
-					}
+	return sb.String()
+}
 
-					if len(errResp.Errors) <= 0 {
-						return nil, backoff.Permanent(fmt.Errorf("unexpected error %d", res.StatusCode))
-					}
+// metadata returns flat fields only (strings, numbers and lists of strings) so that they can be used in vector store
+// filters. Unset fields are omitted as most stores don't support null values.
+func (issue LinearIssue) metadata() map[string]any {
+	metadata := map[string]any{
+		"title":          issue.Title,
+		"identifier":     issue.Identifier,
+		"team":           issue.Team.Key,
+		"team_name":      issue.Team.Name,
+		"state":          issue.State.Name,
+		"state_type":     issue.State.Type,
+		"priority":       issue.Priority,
+		"priority_label": issue.PriorityLabel,
+		"labels":         issue.labelNames(),
+		"created_at":     issue.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if issue.Creator != nil {
+		metadata["creator"] = issue.Creator.Name
+		metadata["creator_email"] = issue.Creator.Email
+	}
+	if issue.Assignee != nil {
+		metadata["assignee"] = issue.Assignee.Name
+		metadata["assignee_email"] = issue.Assignee.Email
+	}
+	if issue.Estimate != nil {
+		metadata["estimate"] = *issue.Estimate
+	}
+	if issue.DueDate != nil {
+		metadata["due_date"] = *issue.DueDate
+	}
+	if issue.Project != nil {
+		metadata["project"] = issue.Project.Name
+		metadata["project_id"] = issue.Project.Id
+	}
+	if issue.Cycle != nil {
+		metadata["cycle"] = issue.Cycle.Number
+	}
+	if issue.Parent != nil {
+		metadata["parent"] = issue.Parent.Identifier
+	}
+	if len(issue.Children.Nodes) > 0 {
+		metadata["sub_issues"] = identifiers(issue.Children.Nodes)
+	}
+	for relationType, related := range issue.relationsByType() {
+		metadata[relationType] = identifiers(related)
+	}
 
-					linearErr := errResp.Errors[0]
+	return metadata
+}
 
-					if linearErr.Extensions.Code == "RATELIMITED" {
-						return res, fmt.Errorf("rate limited")
-					}
+func (issue LinearIssue) toIndexedDocument(access *linearAccess) IndexedDocument {
+	// Attachments are indexed as child documents, so changes to them must refresh the issue
+	freshnessIndicator := issue.LatestAttachment.freshnessIndicator(issue.LatestComment.freshnessIndicator(issue.UpdatedAt))
 
-					return res, backoff.Permanent(fmt.Errorf("unexpected error %q: %s", linearErr.Extensions.Code, linearErr.Message))
+	return IndexedDocument{
+		Integration:        IntegrationLinear,
+		DocumentType:       string(LinearDocumentTypeIssue),
+		Id:                 issue.Id,
+		Title:              issue.Title,
+		URL:                issue.URL,
+		FreshnessIndicator: freshnessIndicator,
+		EditedAt:           parseEditedAt(issue.UpdatedAt),
+		ACL:                access.acl([]string{issue.Team.Id}),
+	}
+}
 
-				}
+// renderLinearComments renders a thread of comments or project updates in chronological order, the preceding content
+// must end with a newline
+func renderLinearComments(heading string, comments []LinearComment) string {
+	if len(comments) == 0 {
+		return ""
+	}
 
-				return res, nil
-			},
-			newBackOff(ctx, 10),
-		)
-		if err != nil {
-			return nil, err
+	sorted := make([]LinearComment, len(comments))
+	copy(sorted, comments)
+	// Timestamps are ISO 8601, so they sort lexicographically
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt < sorted[j].CreatedAt
+	})
+
+	var sb strings.Builder
+	sb.WriteString("\n## " + heading + "\n")
+	for _, comment := range sorted {
+		author := "Unknown"
+		if comment.User != nil {
+			author = comment.User.Name
 		}
 
-		type searchResp struct {
-			Data struct {
-				Issues struct {
-					Nodes    []LinearIssue `json:"nodes"`
-					PageInfo struct {
-						EndCursor   *string `json:"endCursor"`
-						HasNextPage bool    `json:"hasNextPage"`
-					} `json:"pageInfo"`
-				}
-			} `json:"data"`
+		sb.WriteString("\n### " + author + " (" + comment.CreatedAt + ")\n\n")
+		if comment.Health != "" {
+			sb.WriteString("Health: " + comment.Health + "\n\n")
 		}
+		sb.WriteString(strings.TrimSpace(comment.Body) + "\n")
+	}
 
-		var searchResponse searchResp
-		err = json.NewDecoder(res.Body).Decode(&searchResponse)
-		if err != nil {
-			return nil, err
+	return sb.String()
+}
+
+// linearIssueFilter translates the data source filters into an IssueFilter, returns nil if no filters are configured
+// https://developers.linear.app/docs/graphql/working-with-the-graphql-api/filtering
+func linearIssueFilter(dataSource *PipelineDataSource) map[string]any {
+	if dataSource == nil {
+		return nil
+	}
+	config := dataSource.LinearDataSource.Config
+
+	filter := make(map[string]any)
+	if len(config.TeamKeys) > 0 {
+		filter["team"] = map[string]any{"key": map[string]any{"in": config.TeamKeys}}
+	}
+	if len(config.ProjectIds) > 0 {
+		filter["project"] = map[string]any{"id": map[string]any{"in": config.ProjectIds}}
+	}
+	if len(config.StateTypes) > 0 {
+		filter["state"] = map[string]any{"type": map[string]any{"in": config.StateTypes}}
+	}
+	if len(config.Labels) > 0 {
+		filter["labels"] = map[string]any{"some": map[string]any{"name": map[string]any{"in": config.Labels}}}
+	}
+	if config.CreatedAfter != nil {
+		filter["createdAt"] = map[string]any{"gt": config.CreatedAfter.UTC().Format(time.RFC3339)}
+	}
+
+	if len(filter) == 0 {
+		return nil
+	}
+	return filter
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
+	}
+	return false
+}
 
-		for _, result := range searchResponse.Data.Issues.Nodes {
-			indexedDocuments[result.Id] = IndexedDocument{
-				Integration:        IntegrationLinear,
-				DocumentType:       string(LinearDocumentTypeIssue),
-				Id:                 result.Id,
-				Title:              result.Title,
-				URL:                result.URL,
-				FreshnessIndicator: result.UpdatedAt,
+// matchesLinearIssueFilter applies the data source filters to a single issue, for changes received via webhook
+func matchesLinearIssueFilter(dataSource *PipelineDataSource, issue *LinearIssue) bool {
+	if dataSource == nil {
+		return true
+	}
+	config := dataSource.LinearDataSource.Config
+
+	if len(config.TeamKeys) > 0 && !containsString(config.TeamKeys, issue.Team.Key) {
+		return false
+	}
+	if len(config.ProjectIds) > 0 && (issue.Project == nil || !containsString(config.ProjectIds, issue.Project.Id)) {
+		return false
+	}
+	if len(config.StateTypes) > 0 && !containsString(config.StateTypes, issue.State.Type) {
+		return false
+	}
+	if len(config.Labels) > 0 {
+		found := false
+		for _, label := range issue.Labels.Nodes {
+			if containsString(config.Labels, label.Name) {
+				found = true
+				break
 			}
 		}
-
-		if !searchResponse.Data.Issues.PageInfo.HasNextPage {
-			break
+		if !found {
+			return false
 		}
+	}
+	if config.CreatedAfter != nil && !issue.CreatedAt.After(*config.CreatedAfter) {
+		return false
+	}
+
+	return true
+}
 
-		cursor = searchResponse.Data.Issues.PageInfo.EndCursor
+func (client *LinearAPIClientImpl) listIssues(ctx context.Context, dataSource *PipelineDataSource, integration LinearIntegrationConnection, access *linearAccess) (map[string]IndexedDocument, error) {
+	var indexedDocuments = make(map[string]IndexedDocument)
+
+	err := listAll[LinearIssue](ctx, client, integration, `
+query getIssues($after: String, $first: Int, $filter: IssueFilter) {
+  issues(after: $after, first: $first, filter: $filter) {
+    nodes {
+      `+issueFragment+`
+    }
+    pageInfo {
+      endCursor
+      hasNextPage
+    }
+  }
+}`, "issues", map[string]any{
+		"filter": linearIssueFilter(dataSource),
+	}, func(issue LinearIssue) {
+		indexedDocuments[issue.Id] = issue.toIndexedDocument(access)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return indexedDocuments, nil
 }
 
-func (client *LinearAPIClientImpl) ListDocuments(ctx context.Context, integration IntegrationConnection) (map[string]IndexedDocument, error) {
-	err := client.sema.Acquire(ctx, 1)
+func (client *LinearAPIClientImpl) getIssue(ctx context.Context, issueId string, integration IntegrationConnection) (*LinearIssue, error) {
+	type getIssueResp struct {
+		Issue LinearIssue `json:"issue"`
+	}
+
+	var issueResp getIssueResp
+	err := client.sendRequest(ctx, integration.LinearIntegrationConnection, `query getIssue($issueId: String!) {
+  issue(id: $issueId) {
+    `+issueFragment+`
+    `+issueDetailsFragment+`
+  }
+}
+`, map[string]any{
+		"issueId": issueId,
+	}, &issueResp)
 	if err != nil {
 		return nil, err
 	}
-	defer client.sema.Release(1)
 
-	client.logger.Printf("Listing all Linear issues\n")
+	return &issueResp.Issue, nil
+}
+
+const projectFragment = `id
+      name
+      description
+      content
+      url
+      state
+      startDate
+      targetDate
+      updatedAt
+      lead {
+        id
+        name
+        email
+      }
+      teams(first: 10) {
+        nodes {
+          id
+        }
+      }
+      latestUpdate: projectUpdates(first: 1, orderBy: updatedAt) {
+        nodes {
+          updatedAt
+        }
+      }`
 
-	issues, err := client.listIssues(ctx, integration.LinearIntegrationConnection)
+const projectUpdatesFragment = `projectUpdates(first: 50) {
+      nodes {
+        id
+        body
+        health
+        createdAt
+        updatedAt
+        user {
+          id
+          name
+          email
+        }
+      }
+    }`
+
+type LinearProject struct {
+	Id           string       `json:"id"`
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	Content      string       `json:"content"`
+	URL          string       `json:"url"`
+	State        string       `json:"state"`
+	StartDate    string       `json:"startDate"`
+	TargetDate   string       `json:"targetDate"`
+	UpdatedAt    string       `json:"updatedAt"`
+	Lead         *LinearUser  `json:"lead"`
+	LatestUpdate linearLatest `json:"latestUpdate"`
+	Updates      struct {
+		Nodes []LinearComment `json:"nodes"`
+	} `json:"projectUpdates"`
+	Teams struct {
+		Nodes []linearTeamRef `json:"nodes"`
+	} `json:"teams"`
+}
+
+func (project LinearProject) toIndexedDocument(access *linearAccess) IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationLinear,
+		DocumentType:       string(LinearDocumentTypeProject),
+		Id:                 project.Id,
+		Title:              project.Name,
+		URL:                project.URL,
+		FreshnessIndicator: project.LatestUpdate.freshnessIndicator(project.UpdatedAt),
+		EditedAt:           parseEditedAt(project.UpdatedAt),
+		ACL:                access.acl(teamIds(project.Teams.Nodes)),
+	}
+}
+
+func (client *LinearAPIClientImpl) listProjects(ctx context.Context, integration LinearIntegrationConnection, access *linearAccess) (map[string]IndexedDocument, error) {
+	var indexedDocuments = make(map[string]IndexedDocument)
+
+	err := listAll[LinearProject](ctx, client, integration, `
+query getProjects($after: String, $first: Int) {
+  projects(after: $after, first: $first) {
+    nodes {
+      `+projectFragment+`
+    }
+    pageInfo {
+      endCursor
+      hasNextPage
+    }
+  }
+}`, "projects", nil, func(project LinearProject) {
+		indexedDocuments[project.Id] = project.toIndexedDocument(access)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	allDocuments := make(map[string]IndexedDocument)
+	return indexedDocuments, nil
+}
 
-	for id, issue := range issues {
-		allDocuments[id] = issue
+func (client *LinearAPIClientImpl) getProject(ctx context.Context, projectId string, integration IntegrationConnection) (*LinearProject, error) {
+	type getProjectResp struct {
+		Project LinearProject `json:"project"`
 	}
 
-	return allDocuments, nil
+	var projectResp getProjectResp
+	err := client.sendRequest(ctx, integration.LinearIntegrationConnection, `query getProject($projectId: String!) {
+  project(id: $projectId) {
+    `+projectFragment+`
+    `+projectUpdatesFragment+`
+  }
 }
+`, map[string]any{
+		"projectId": projectId,
+	}, &projectResp)
+	if err != nil {
+		return nil, err
+	}
 
-func (client *LinearAPIClientImpl) GetDocumentContent(ctx context.Context, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
-	err := client.sema.Acquire(ctx, 1)
+	return &projectResp.Project, nil
+}
+
+const documentFragment = `id
+      title
+      content
+      url
+      updatedAt
+      creator {
+        id
+        name
+        email
+      }
+      project {
+        id
+        name
+        teams(first: 10) {
+          nodes {
+            id
+          }
+        }
+      }`
+
+type LinearDocument struct {
+	Id        string      `json:"id"`
+	Title     string      `json:"title"`
+	Content   string      `json:"content"`
+	URL       string      `json:"url"`
+	UpdatedAt string      `json:"updatedAt"`
+	Creator   *LinearUser `json:"creator"`
+	Project   *struct {
+		Id    string `json:"id"`
+		Name  string `json:"name"`
+		Teams struct {
+			Nodes []linearTeamRef `json:"nodes"`
+		} `json:"teams"`
+	} `json:"project"`
+}
+
+func (document LinearDocument) toIndexedDocument(access *linearAccess) IndexedDocument {
+	var teams []string
+	if document.Project != nil {
+		teams = teamIds(document.Project.Teams.Nodes)
+	}
+
+	return IndexedDocument{
+		Integration:        IntegrationLinear,
+		DocumentType:       string(LinearDocumentTypeDocument),
+		Id:                 document.Id,
+		Title:              document.Title,
+		URL:                document.URL,
+		FreshnessIndicator: document.UpdatedAt,
+		EditedAt:           parseEditedAt(document.UpdatedAt),
+		ACL:                access.acl(teams),
+	}
+}
+
+func (client *LinearAPIClientImpl) listDocuments(ctx context.Context, integration LinearIntegrationConnection, access *linearAccess) (map[string]IndexedDocument, error) {
+	var indexedDocuments = make(map[string]IndexedDocument)
+
+	// Content is only fetched when retrieving a single document
+	err := listAll[LinearDocument](ctx, client, integration, `
+query getDocuments($after: String, $first: Int) {
+  documents(after: $after, first: $first) {
+    nodes {
+      id
+      title
+      url
+      updatedAt
+      project {
+        teams(first: 10) {
+          nodes {
+            id
+          }
+        }
+      }
+    }
+    pageInfo {
+      endCursor
+      hasNextPage
+    }
+  }
+}`, "documents", nil, func(document LinearDocument) {
+		indexedDocuments[document.Id] = document.toIndexedDocument(access)
+	})
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
-	defer client.sema.Release(1)
 
-	switch documentType {
-	case string(LinearDocumentTypeIssue):
-		{
-			issue, err := client.getIssue(ctx, id, integration)
-			if err != nil {
-				return "", nil, err
-			}
+	return indexedDocuments, nil
+}
 
-			textContent := "# " + issue.Title + "\n" + issue.Description
+func (client *LinearAPIClientImpl) getDocument(ctx context.Context, documentId string, integration IntegrationConnection) (*LinearDocument, error) {
+	type getDocumentResp struct {
+		Document LinearDocument `json:"document"`
+	}
 
-			stringifiedCreator, err := json.Marshal(issue.Creator)
-			if err != nil {
-				return "", nil, err
-			}
-			stringifiedAssignee, err := json.Marshal(issue.Assignee)
-			if err != nil {
-				return "", nil, err
-			}
+	var documentResp getDocumentResp
+	err := client.sendRequest(ctx, integration.LinearIntegrationConnection, `query getDocument($documentId: String!) {
+  document(id: $documentId) {
+    `+documentFragment+`
+  }
+}
+`, map[string]any{
+		"documentId": documentId,
+	}, &documentResp)
+	if err != nil {
+		return nil, err
+	}
 
-			return textContent, map[string]any{
-				"title":    issue.Title,
-				"creator":  string(stringifiedCreator),
-				"assignee": string(stringifiedAssignee),
-				"state":    issue.State.Name,
-			}, nil
-		}
-	default:
-		return "", nil, fmt.Errorf("unknown document type %q", documentType)
+	return &documentResp.Document, nil
+}
+
+const cycleFragment = `id
+      number
+      name
+      description
+      startsAt
+      endsAt
+      completedAt
+      updatedAt
+      team {
+        id
+        key
+        name
+      }
+      latestIssue: issues(first: 1, orderBy: updatedAt) {
+        nodes {
+          updatedAt
+        }
+      }`
+
+const cycleIssuesFragment = `issues(first: 250) {
+      nodes {
+        identifier
+        title
+        url
+        state {
+          name
+        }
+      }
+    }`
+
+type LinearCycle struct {
+	Id          string  `json:"id"`
+	Number      float64 `json:"number"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	StartsAt    string  `json:"startsAt"`
+	EndsAt      string  `json:"endsAt"`
+	CompletedAt string  `json:"completedAt"`
+	UpdatedAt   string  `json:"updatedAt"`
+	Team        struct {
+		Id   string `json:"id"`
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"team"`
+	LatestIssue linearLatest `json:"latestIssue"`
+	Issues      struct {
+		Nodes []struct {
+			Identifier string `json:"identifier"`
+			Title      string `json:"title"`
+			URL        string `json:"url"`
+			State      struct {
+				Name string `json:"name"`
+			} `json:"state"`
+		} `json:"nodes"`
+	} `json:"issues"`
+}
+
+func (cycle LinearCycle) title() string {
+	if cycle.Name != "" {
+		return fmt.Sprintf("%s: %s", cycle.Team.Name, cycle.Name)
 	}
+	return fmt.Sprintf("%s: Cycle %d", cycle.Team.Name, int(cycle.Number))
+}
 
+// Cycles have no URL in the API, issues added to or removed from the cycle are picked up through the latest issue
+func (cycle LinearCycle) toIndexedDocument(access *linearAccess) IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationLinear,
+		DocumentType:       string(LinearDocumentTypeCycle),
+		Id:                 cycle.Id,
+		Title:              cycle.title(),
+		FreshnessIndicator: cycle.LatestIssue.freshnessIndicator(cycle.UpdatedAt),
+		EditedAt:           parseEditedAt(cycle.UpdatedAt),
+		ACL:                access.acl([]string{cycle.Team.Id}),
+	}
 }
 
-func (client *LinearAPIClientImpl) getIssue(ctx context.Context, issueId string, integration IntegrationConnection) (*LinearIssue, error) {
-	body := map[string]any{
-		// https://developers.linear.app/docs/graphql/working-with-the-graphql-api/pagination
-		"variables": map[string]any{
-			"issueId": issueId,
-		},
-		"query": `query getIssues($issueId: String!) {
-  issue(id: $issueId) {
-    ` + issueFragment + `
+func (client *LinearAPIClientImpl) listCycles(ctx context.Context, integration LinearIntegrationConnection, access *linearAccess) (map[string]IndexedDocument, error) {
+	var indexedDocuments = make(map[string]IndexedDocument)
+
+	err := listAll[LinearCycle](ctx, client, integration, `
+query getCycles($after: String, $first: Int) {
+  cycles(after: $after, first: $first) {
+    nodes {
+      `+cycleFragment+`
+    }
+    pageInfo {
+      endCursor
+      hasNextPage
+    }
+  }
+}`, "cycles", nil, func(cycle LinearCycle) {
+		indexedDocuments[cycle.Id] = cycle.toIndexedDocument(access)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return indexedDocuments, nil
+}
+
+func (client *LinearAPIClientImpl) getCycle(ctx context.Context, cycleId string, integration IntegrationConnection) (*LinearCycle, error) {
+	type getCycleResp struct {
+		Cycle LinearCycle `json:"cycle"`
+	}
+
+	var cycleResp getCycleResp
+	err := client.sendRequest(ctx, integration.LinearIntegrationConnection, `query getCycle($cycleId: String!) {
+  cycle(id: $cycleId) {
+    `+cycleFragment+`
+    `+cycleIssuesFragment+`
   }
 }
-`,
+`, map[string]any{
+		"cycleId": cycleId,
+	}, &cycleResp)
+	if err != nil {
+		return nil, err
 	}
 
-	marshalledBody, err := json.Marshal(body)
+	return &cycleResp.Cycle, nil
+}
+
+func (client *LinearAPIClientImpl) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	err := client.sema.Acquire(ctx, 1)
 	if err != nil {
 		return nil, err
 	}
+	defer client.sema.Release(1)
 
-	// https://studio.apollographql.com/public/Linear-API/variant/current/explorer
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.linear.app/graphql", bytes.NewBuffer(marshalledBody))
+	access, err := client.getAccess(ctx, integration.LinearIntegrationConnection)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+integration.LinearIntegrationConnection.Config.AccessToken)
+	allDocuments := make(map[string]IndexedDocument)
+
+	for _, documentType := range linearDocumentTypes(dataSource) {
+		client.logger.Printf("Listing all Linear %ss\n", documentType)
+
+		var documents map[string]IndexedDocument
+		switch documentType {
+		case LinearDocumentTypeIssue:
+			documents, err = client.listIssues(ctx, dataSource, integration.LinearIntegrationConnection, access)
+		case LinearDocumentTypeProject:
+			documents, err = client.listProjects(ctx, integration.LinearIntegrationConnection, access)
+		case LinearDocumentTypeDocument:
+			documents, err = client.listDocuments(ctx, integration.LinearIntegrationConnection, access)
+		case LinearDocumentTypeCycle:
+			documents, err = client.listCycles(ctx, integration.LinearIntegrationConnection, access)
+		default:
+			return nil, fmt.Errorf("unknown document type %q", documentType)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Ids are UUIDs, so they're unique across document types
+		for id, document := range documents {
+			allDocuments[id] = document
+		}
+	}
+
+	return allDocuments, nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
+func (client *LinearAPIClientImpl) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	defer client.sema.Release(1)
 
-	res, err := backoff.RetryWithData[*http.Response](
-		func() (*http.Response, error) {
-			res, err := client.httpClient.Do(req)
+	switch documentType {
+	case string(LinearDocumentTypeIssue):
+		{
+			issue, err := client.getIssue(ctx, id, integration)
 			if err != nil {
-				if err, ok := err.(net.Error); ok && err.Timeout() {
-					return nil, err
-				}
-				return nil, backoff.Permanent(err)
+				return "", nil, err
 			}
 
-			if res.StatusCode != http.StatusOK {
-				type errorResponse struct {
-					Errors []struct {
-						Message    string `json:"message"`
-						Extensions struct {
-							Code string `json:"code"`
-						}
-					} `json:"errors"`
-				}
+			return issue.render(), issue.metadata(), nil
+		}
+	case string(LinearDocumentTypeProject):
+		{
+			project, err := client.getProject(ctx, id, integration)
+			if err != nil {
+				return "", nil, err
+			}
 
-				var errResp errorResponse
-				err = json.NewDecoder(res.Body).Decode(&errResp)
-				if err != nil {
-					return nil, err
-				}
+			textContent := "# " + project.Name + "\n"
+			if project.Description != "" {
+				textContent += project.Description + "\n"
+			}
+			if project.Content != "" {
+				textContent += "\n" + strings.TrimSpace(project.Content) + "\n"
+			}
+			textContent += renderLinearComments("Project updates", project.Updates.Nodes)
 
-				if len(errResp.Errors) <= 0 {
-					return nil, backoff.Permanent(fmt.Errorf("unexpected error %d", res.StatusCode))
-				}
+			metadata := map[string]any{
+				"title": project.Name,
+				"state": project.State,
+			}
+			if project.Lead != nil {
+				metadata["lead"] = project.Lead.Name
+			}
+			if project.StartDate != "" {
+				metadata["start_date"] = project.StartDate
+			}
+			if project.TargetDate != "" {
+				metadata["target_date"] = project.TargetDate
+			}
 
-				linearErr := errResp.Errors[0]
+			return textContent, metadata, nil
+		}
+	case string(LinearDocumentTypeDocument):
+		{
+			document, err := client.getDocument(ctx, id, integration)
+			if err != nil {
+				return "", nil, err
+			}
 
-				if linearErr.Extensions.Code == "RATELIMITED" {
-					return res, fmt.Errorf("rate limited")
-				}
+			textContent := "# " + document.Title + "\n" + document.Content
 
-				return res, backoff.Permanent(fmt.Errorf("unexpected error %q: %s", linearErr.Extensions.Code, linearErr.Message))
+			metadata := map[string]any{
+				"title": document.Title,
+			}
+			if document.Creator != nil {
+				metadata["creator"] = document.Creator.Name
+			}
+			if document.Project != nil {
+				metadata["project"] = document.Project.Name
+			}
 
+			return textContent, metadata, nil
+		}
+	case string(LinearDocumentTypeCycle):
+		{
+			cycle, err := client.getCycle(ctx, id, integration)
+			if err != nil {
+				return "", nil, err
 			}
 
-			return res, nil
-		},
-		newBackOff(ctx, 10),
-	)
-	if err != nil {
-		return nil, err
-	}
+			var sb strings.Builder
+			sb.WriteString("# " + cycle.title() + "\n")
+			sb.WriteString(fmt.Sprintf("\n%s to %s\n", cycle.StartsAt, cycle.EndsAt))
+			if cycle.Description != "" {
+				sb.WriteString("\n" + cycle.Description + "\n")
+			}
+			if len(cycle.Issues.Nodes) > 0 {
+				sb.WriteString("\n## Issues\n\n")
+				for _, issue := range cycle.Issues.Nodes {
+					sb.WriteString(fmt.Sprintf("- %s %s (%s)\n", issue.Identifier, issue.Title, issue.State.Name))
+				}
+			}
 
-	type getIssueResp struct {
-		Data struct {
-			Issue LinearIssue `json:"issue"`
-		} `json:"data"`
-	}
+			metadata := map[string]any{
+				"title":     cycle.title(),
+				"team":      cycle.Team.Key,
+				"starts_at": cycle.StartsAt,
+				"ends_at":   cycle.EndsAt,
+			}
+			if cycle.CompletedAt != "" {
+				metadata["completed_at"] = cycle.CompletedAt
+			}
 
-	var issueResp getIssueResp
-	err = json.NewDecoder(res.Body).Decode(&issueResp)
-	if err != nil {
-		return nil, err
+			return sb.String(), metadata, nil
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown document type %q", documentType)
 	}
 
-	return &issueResp.Data.Issue, nil
 }
 
-func (client *LinearAPIClientImpl) GetDocument(ctx context.Context, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+func (client *LinearAPIClientImpl) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+	if !linearDocumentTypeSelected(dataSource, LinearDocumentType(documentType)) {
+		return IndexedDocument{}, ErrDocumentOutOfScope
+	}
+
 	err := client.sema.Acquire(ctx, 1)
 	if err != nil {
 		return IndexedDocument{}, err
 	}
 	defer client.sema.Release(1)
 
+	access, err := client.getAccess(ctx, integration.LinearIntegrationConnection)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+
 	switch documentType {
 	case string(LinearDocumentTypeIssue):
 		{
@@ -377,14 +1281,38 @@ func (client *LinearAPIClientImpl) GetDocument(ctx context.Context, documentType
 				return IndexedDocument{}, err
 			}
 
-			return IndexedDocument{
-				Integration:        IntegrationLinear,
-				DocumentType:       string(LinearDocumentTypeIssue),
-				Id:                 issue.Id,
-				Title:              issue.Title,
-				URL:                issue.URL,
-				FreshnessIndicator: issue.UpdatedAt,
-			}, nil
+			if !matchesLinearIssueFilter(dataSource, issue) {
+				return IndexedDocument{}, ErrDocumentOutOfScope
+			}
+
+			return issue.toIndexedDocument(access), nil
+		}
+	case string(LinearDocumentTypeProject):
+		{
+			project, err := client.getProject(ctx, id, integration)
+			if err != nil {
+				return IndexedDocument{}, err
+			}
+
+			return project.toIndexedDocument(access), nil
+		}
+	case string(LinearDocumentTypeDocument):
+		{
+			document, err := client.getDocument(ctx, id, integration)
+			if err != nil {
+				return IndexedDocument{}, err
+			}
+
+			return document.toIndexedDocument(access), nil
+		}
+	case string(LinearDocumentTypeCycle):
+		{
+			cycle, err := client.getCycle(ctx, id, integration)
+			if err != nil {
+				return IndexedDocument{}, err
+			}
+
+			return cycle.toIndexedDocument(access), nil
 		}
 	default:
 		return IndexedDocument{}, fmt.Errorf("unknown document type %q", documentType)
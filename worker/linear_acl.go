@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Team membership rarely changes, so it's shared between the runs of an account for a while
+const linearAccessTTL = 10 * time.Minute
+
+// Only the first members of a private team are listed, the team's group principal covers the rest
+const linearTeamMembersLimit = 250
+
+type linearTeamRef struct {
+	Id string `json:"id"`
+}
+
+type linearTeamAccess struct {
+	private bool
+	members []string
+}
+
+// linearAccess holds the teams of a workspace and who may access them. Issues and cycles belong to a team, projects
+// to one or more teams and documents to a project. Everything in public teams is visible to the whole workspace.
+type linearAccess struct {
+	organizationId string
+	teams          map[string]linearTeamAccess
+	fetchedAt      time.Time
+}
+
+// acl returns who may access an entity belonging to the given teams. Entities without teams are visible to the
+// workspace, teams we don't know about are treated as private.
+func (access *linearAccess) acl(teamIds []string) []string {
+	workspace := []string{aclWorkspace(IntegrationLinear, access.organizationId)}
+	if len(teamIds) == 0 {
+		return workspace
+	}
+
+	var principals []string
+	for _, teamId := range teamIds {
+		team, ok := access.teams[teamId]
+		if ok && !team.private {
+			return workspace
+		}
+
+		principals = append(principals, aclGroup(IntegrationLinear, teamId))
+		for _, email := range team.members {
+			principals = append(principals, aclUser(email))
+		}
+	}
+
+	return normalizeACL(principals)
+}
+
+func teamIds(teams []linearTeamRef) []string {
+	ids := make([]string, 0, len(teams))
+	for _, team := range teams {
+		ids = append(ids, team.Id)
+	}
+	return ids
+}
+
+// getAccess returns the teams of the workspace the integration belongs to, callers must hold the semaphore
+func (client *LinearAPIClientImpl) getAccess(ctx context.Context, integration LinearIntegrationConnection) (*linearAccess, error) {
+	if cached, ok := client.access.Load(integration.Config.AccessToken); ok && time.Since(cached.(*linearAccess).fetchedAt) < linearAccessTTL {
+		return cached.(*linearAccess), nil
+	}
+
+	type getOrganizationResp struct {
+		Organization struct {
+			Id string `json:"id"`
+		} `json:"organization"`
+	}
+
+	var organizationResp getOrganizationResp
+	err := client.sendRequest(ctx, integration, `query getOrganization {
+  organization {
+    id
+  }
+}
+`, nil, &organizationResp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get organization, %w", err)
+	}
+
+	type linearTeam struct {
+		Id      string `json:"id"`
+		Private bool   `json:"private"`
+	}
+
+	access := &linearAccess{
+		organizationId: organizationResp.Organization.Id,
+		teams:          make(map[string]linearTeamAccess),
+	}
+
+	var privateTeams []string
+	err = listAll[linearTeam](ctx, client, integration, `
+query getTeams($after: String, $first: Int) {
+  teams(after: $after, first: $first) {
+    nodes {
+      id
+      private
+    }
+    pageInfo {
+      endCursor
+      hasNextPage
+    }
+  }
+}`, "teams", nil, func(team linearTeam) {
+		access.teams[team.Id] = linearTeamAccess{private: team.Private}
+		if team.Private {
+			privateTeams = append(privateTeams, team.Id)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list teams, %w", err)
+	}
+
+	for _, teamId := range privateTeams {
+		members, err := client.getTeamMembers(ctx, integration, teamId)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list members of team %q, %w", teamId, err)
+		}
+		access.teams[teamId] = linearTeamAccess{private: true, members: members}
+	}
+
+	access.fetchedAt = time.Now()
+	client.access.Store(integration.Config.AccessToken, access)
+
+	return access, nil
+}
+
+// getTeamMembers returns the emails of the members of a team
+func (client *LinearAPIClientImpl) getTeamMembers(ctx context.Context, integration LinearIntegrationConnection, teamId string) ([]string, error) {
+	type getTeamMembersResp struct {
+		Team struct {
+			Members struct {
+				Nodes []LinearUser `json:"nodes"`
+			} `json:"members"`
+		} `json:"team"`
+	}
+
+	var membersResp getTeamMembersResp
+	err := client.sendRequest(ctx, integration, `query getTeamMembers($teamId: String!, $first: Int) {
+  team(id: $teamId) {
+    members(first: $first) {
+      nodes {
+        email
+      }
+    }
+  }
+}
+`, map[string]any{
+		"teamId": teamId,
+		"first":  linearTeamMembersLimit,
+	}, &membersResp)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(membersResp.Team.Members.Nodes))
+	for _, member := range membersResp.Team.Members.Nodes {
+		if member.Email != "" {
+			members = append(members, member.Email)
+		}
+	}
+
+	return members, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const linearUploadsPrefix = "https://uploads.linear.app/"
+
+// linearUploadLink matches Markdown links to files uploaded to Linear, images are embedded with a leading !
+var linearUploadLink = regexp.MustCompile(`(!?)\[([^\]]*)\]\((https://uploads\.linear\.app/[^)\s]+)\)`)
+
+type linearUpload struct {
+	name string
+	url  string
+}
+
+func linearIncludeAttachments(dataSource *PipelineDataSource) bool {
+	return dataSource != nil && dataSource.LinearDataSource.Config.IncludeAttachments
+}
+
+// uploads returns the files uploaded to the description, the comments and the attachments of the issue. Images can't
+// be extracted and links to other services aren't downloaded.
+func (issue LinearIssue) uploads() []linearUpload {
+	var uploads []linearUpload
+	seen := make(map[string]bool)
+	add := func(name, url string) {
+		if seen[url] {
+			return
+		}
+		seen[url] = true
+
+		if name == "" {
+			name = path.Base(url)
+		}
+		uploads = append(uploads, linearUpload{name: name, url: url})
+	}
+
+	texts := []string{issue.Description}
+	for _, comment := range issue.Comments.Nodes {
+		texts = append(texts, comment.Body)
+	}
+	for _, text := range texts {
+		for _, match := range linearUploadLink.FindAllStringSubmatch(text, -1) {
+			if match[1] == "!" {
+				continue
+			}
+			add(match[2], match[3])
+		}
+	}
+
+	for _, attachment := range issue.Attachments.Nodes {
+		if strings.HasPrefix(attachment.URL, linearUploadsPrefix) {
+			add(attachment.Title, attachment.URL)
+		}
+	}
+
+	return uploads
+}
+
+// linearAttachmentId combines the issue id with the upload path, as the same file may be linked from several issues
+func linearAttachmentId(issueId, url string) string {
+	return issueId + "/" + strings.TrimPrefix(url, linearUploadsPrefix)
+}
+
+// GetDocumentContentAndAttachments renders an issue and lists the files uploaded to it. Uploads are only referenced
+// from the description, comments and attachments, which are all covered by the issue's freshness indicator.
+func (client *LinearAPIClientImpl) GetDocumentContentAndAttachments(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, []IndexedDocument, error) {
+	if documentType != string(LinearDocumentTypeIssue) || !linearIncludeAttachments(dataSource) {
+		content, metadata, err := client.GetDocumentContent(ctx, dataSource, documentType, id, integration)
+		return content, metadata, nil, err
+	}
+
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer client.sema.Release(1)
+
+	issue, err := client.getIssue(ctx, id, integration)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	uploads := issue.uploads()
+	attachments := make([]IndexedDocument, 0, len(uploads))
+	for _, upload := range uploads {
+		attachments = append(attachments, IndexedDocument{
+			Integration:  IntegrationLinear,
+			DocumentType: string(LinearDocumentTypeAttachment),
+			Id:           linearAttachmentId(issue.Id, upload.url),
+			Title:        upload.name,
+			URL:          upload.url,
+			// Uploads are immutable, a changed file gets a new URL
+			FreshnessIndicator: upload.url,
+			ParentId:           issue.Id,
+		})
+	}
+
+	return issue.render(), issue.metadata(), attachments, nil
+}
+
+// GetAttachmentContent downloads a file uploaded to an issue, which requires the access token
+func (client *LinearAPIClientImpl) GetAttachmentContent(ctx context.Context, dataSource *PipelineDataSource, attachment IndexedDocument, integration IntegrationConnection) (string, map[string]any, error) {
+	// The access token is sent along, so only uploads listed with the issue are downloaded
+	if !strings.HasPrefix(attachment.URL, linearUploadsPrefix) || linearAttachmentId(attachment.ParentId, attachment.URL) != attachment.Id {
+		return "", nil, fmt.Errorf("invalid attachment %q", attachment.Id)
+	}
+
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	defer client.sema.Release(1)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+integration.LinearIntegrationConnection.Config.AccessToken)
+
+	textContent, err := extractAttachmentText(ctx, client.downloadTransport, attachment.URL, header, attachment.Title)
+	if err != nil {
+		return "", nil, err
+	}
+
+	metadata := map[string]any{
+		"title":    attachment.Title,
+		"issue_id": attachment.ParentId,
+	}
+
+	return textContent, metadata, nil
+}
@@ -16,8 +16,6 @@ import (
 	"time"
 )
 
-const NUM_WORKERS = 4
-
 func main() {
 	_ = godotenv.Load("../.env")
 
@@ -60,33 +58,45 @@ func main() {
 
 	sqsClient := sqs.NewFromConfig(awsConfig)
 
-	notionHelperEndpoint := os.Getenv("NOTION_HELPER_ENDPOINT")
-	if notionHelperEndpoint == "" {
-		logger.Fatalf("NOTION_HELPER_ENDPOINT must be set")
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-secrets" {
+		err = runEncryptSecretsCommand(ctx, logger, awsConfig, os.Args[2:])
+		if err != nil {
+			logger.Fatalf("unable to encrypt secrets, %v", err)
+		}
+		return
 	}
 
-	documentHelperEndpoint := os.Getenv("DOCUMENT_HELPER_ENDPOINT")
-	if documentHelperEndpoint == "" {
-		logger.Fatalf("DOCUMENT_HELPER_ENDPOINT must be set")
+	// Settings are read from WORKER_CONFIG_FILE and the environment, see config.go
+	workerConfig, err := loadConfig()
+	if err != nil {
+		logger.Fatalf("invalid configuration, %v", err)
 	}
+	logger.Printf("Effective configuration:\n%s\n", workerConfig)
+
+	backOffConfig = workerConfig.Backoff
 
-	openAIApiKey := os.Getenv("OPENAI_API_KEY")
-	if openAIApiKey == "" {
-		logger.Fatalf("OPENAI_API_KEY must be set")
+	// Secrets in integration connection and pipeline configs are encrypted if SECRETS_KMS is set, see secrets.go
+	kms, err := newKeyManagementService(workerConfig, awsConfig)
+	if err != nil {
+		logger.Fatalf("unable to create key management service, %v", err)
 	}
+	secrets := newSecrets(kms)
 
-	notionApiClient := newNotionApiClient(notionHelperEndpoint, logger)
-	linearApiClient := newLinearApiClient(logger)
-	documentHelper := newDocumentHelper(documentHelperEndpoint, logger)
+	references, err := newCredentialReferences(workerConfig.CredentialsReferenceKey, workerConfig.HelperCredentialsToken)
+	if err != nil {
+		logger.Fatalf("unable to configure credential references, %v", err)
+	}
+	if references == nil {
+		logger.Println("CREDENTIALS_REFERENCE_KEY is not set, sending secrets to helper services in plaintext")
+	}
 
-	clients := map[Integration]DataSourceApiClient{
-		IntegrationNotion: notionApiClient,
-		IntegrationLinear: linearApiClient,
+	if workerConfig.Notion.UseHelper {
+		logger.Println("NOTION_USE_HELPER is set, rendering Notion pages using the node helper")
+	} else if workerConfig.Notion.HelperEndpoint != "" {
+		logger.Println("NOTION_HELPER_ENDPOINT is ignored unless NOTION_USE_HELPER is set")
 	}
 
-	// Make sure not to use a connection pooler like pgbouncer, alternatively update the
-	// prepared statement mode (see https://github.com/jackc/pgx/issues/602)
-	pool, err := pgxpool.New(ctx, os.Getenv("POSTGRES_URL_NON_POOLING"))
+	pool, err := pgxpool.New(ctx, workerConfig.PostgresURL)
 	if err != nil {
 		logger.Fatalf("unable to connect to database, %v", err)
 	}
@@ -104,9 +114,48 @@ func main() {
 	}
 	testClient.Release()
 
-	// Start NUM_WORKERS goroutines and receive messages from SQS
-	for i := 0; i < NUM_WORKERS; i++ {
-		startSQSWorker(ctx, logger, sqsClient, os.Getenv("INDEX_QUEUE_URL"), processIndexMessage(pool, newrelicApp, clients, documentHelper, openAIApiKey))
+	var rateLimits rateLimitStore
+	if workerConfig.RateLimitStore == "postgres" {
+		rateLimits = newPostgresRateLimitStore(pool)
+	}
+
+	notionApiClient := newNotionApiClient(workerConfig.Notion, workerConfig.Attachments, references, rateLimits, logger)
+	linearApiClient := newLinearApiClient(workerConfig.Linear.ProviderConfig, workerConfig.Attachments, rateLimits, logger)
+	filesystemClient := newFilesystemClient(workerConfig.Filesystem, logger)
+	webClient := newWebClient(workerConfig.Web, logger)
+	s3Client := newS3Client(workerConfig.S3, logger)
+	zendeskApiClient := newZendeskApiClient(workerConfig.Zendesk, rateLimits, logger)
+	intercomApiClient := newIntercomApiClient(workerConfig.Intercom, rateLimits, logger)
+	execConnectorClient := newExecConnectorClient(workerConfig.Exec, logger)
+	documentHelper := newDocumentHelper(workerConfig.DocumentHelper, references, logger)
+
+	clients := map[Integration]DataSourceApiClient{
+		IntegrationNotion:     notionApiClient,
+		IntegrationLinear:     linearApiClient,
+		IntegrationFilesystem: filesystemClient,
+		IntegrationWeb:        webClient,
+		IntegrationS3:         s3Client,
+		IntegrationZendesk:    zendeskApiClient,
+		IntegrationIntercom:   intercomApiClient,
+		IntegrationExec:       execConnectorClient,
+	}
+
+	credentials := newCredentialManager(logger, pool, secrets, workerConfig.Linear.OAuth, workerConfig.Notion.OAuth)
+
+	scheduler := newScheduler(logger, newPostgresRunLeaseStore(pool), workerConfig.Workers, workerConfig.AccountMaxConcurrentRuns, workerConfig.BulkRunBatchSize)
+	quotas := newQuotaManager(pool, workerConfig.Quotas.EmbeddingPricePerMillionTokens)
+
+	// Start a goroutine per worker and receive messages from SQS
+	for i := 0; i < workerConfig.Workers; i++ {
+		startSQSWorker(ctx, logger, sqsClient, workerConfig.Queue, processIndexMessage(pool, newrelicApp, clients, documentHelper, secrets, credentials, scheduler, quotas, workerConfig.OpenAIApiKey))
+	}
+
+	if kms != nil && workerConfig.Secrets.BackfillInterval > 0 {
+		startSecretsBackfill(ctx, logger, pool, secrets, workerConfig.Secrets.BackfillInterval)
+	}
+
+	if workerConfig.Notion.PollInterval > 0 {
+		startNotionPoller(ctx, logger, pool, sqsClient, workerConfig.Queue.URL, notionApiClient.(*NotionAPIClientImpl), secrets, credentials, workerConfig.Notion.PollInterval, quotas)
 	}
 
 	// Keep the main thread alive
@@ -125,9 +174,15 @@ func main() {
 		logger.Println("Server shut down.")
 	}()
 
-	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if references != nil {
+		startCredentialsResolver(ctx, logger, workerConfig.CredentialsResolverAddr, references)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("ok"))
 	})
+	srv.Handler = mux
 
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.Fatalf("listen: %s\n", err)
@@ -2,6 +2,36 @@ import { APIErrorCode, APIResponseError, Client } from "@notionhq/client";
 import { NotionToMarkdown } from "notion-to-md";
 import express, { json, RequestHandler } from "express";
 
+// Secrets are sent by the worker as credential references, which are resolved by calling the worker
+const credentialReferencePrefix = "credref:v1:";
+
+async function resolveCredential(
+  value: string,
+  account: string,
+): Promise<string> {
+  if (!value || !value.startsWith(credentialReferencePrefix)) {
+    return value;
+  }
+
+  const resp = await fetch(
+    `${process.env.CREDENTIALS_RESOLVER_URL}/credentials/resolve`,
+    {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Authorization: `Bearer ${process.env.HELPER_CREDENTIALS_TOKEN}`,
+      },
+      body: JSON.stringify({ reference: value, account }),
+    },
+  );
+  if (!resp.ok) {
+    throw new Error(`Resolving credential failed with status ${resp.status}`);
+  }
+
+  const { value: resolved } = await resp.json();
+  return resolved;
+}
+
 async function main() {
   const app = express();
 
@@ -19,7 +49,8 @@ async function main() {
     "/notion/markdown",
     json(),
     wrapExpressErrors(async (req, res) => {
-      const { token, pageId } = req.body;
+      const { pageId, account } = req.body;
+      const token = await resolveCredential(req.body.token, account);
 
       console.log(`Attempting to convert page ${pageId} to markdown`);
 
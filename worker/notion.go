@@ -1,488 +1,684 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cenkalti/backoff/v4"
-	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
-	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
 type NotionAPIClientImpl struct {
-	httpClient           *http.Client
+	transport            *ProviderTransport
+	helperTransport      *ProviderTransport
 	logger               logrus.FieldLogger
 	notionHelperEndpoint string
 	sema                 *semaphore.Weighted
+	// references replaces the token sent to the node helper, see credential_references.go
+	references *CredentialReferences
+	// commentsPolled is set if the poller compares indexed comments documents against their comments, see
+	// ListDocumentsSince
+	commentsPolled bool
+
+	// databaseTitles holds one notionCachedName per database id, userNames one per user id
+	databaseTitles sync.Map
+	userNames      sync.Map
+
+	downloadTransport *ProviderTransport
 }
 
-func (client *NotionAPIClientImpl) GetDocument(ctx context.Context, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+func (client *NotionAPIClientImpl) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
 	err := client.sema.Acquire(ctx, 1)
 	if err != nil {
 		return IndexedDocument{}, err
 	}
 	defer client.sema.Release(1)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.notion.com/v1/pages/%s", id), nil)
-	if err != nil {
-		return IndexedDocument{}, err
+	if documentType == NotionDocumentTypeComments {
+		id = strings.TrimSuffix(id, notionCommentsIdSuffix)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+integration.NotionIntegrationConnection.Config.AcessToken)
-
-	req.Header.Set("Notion-Version", "2022-06-28")
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := backoff.RetryWithData[*http.Response](
-		func() (*http.Response, error) {
-			res, err := client.httpClient.Do(req)
-			if err != nil {
-				if err, ok := err.(net.Error); ok && err.Timeout() {
-					return nil, err
-				}
-				return nil, backoff.Permanent(err)
-			}
-
-			// While only 3 requests can be run at the same time, requests will be quicker than a second,
-			// so we still need to handle rate-limiting and retry gracefully
-			if res.StatusCode == http.StatusTooManyRequests {
-				return nil, fmt.Errorf("rate limited")
-			}
-
-			if res.StatusCode != http.StatusOK {
-				return nil, backoff.Permanent(fmt.Errorf("unexpected status code: %d", res.StatusCode))
-			}
-
-			return res, nil
-		},
-		newBackOff(ctx, 10),
-	)
+	page, err := client.getPage(ctx, integration.NotionIntegrationConnection, id)
+	// Pages which were trashed or are no longer shared with the integration are removed
+	if errors.Is(err, errNotionNotFound) {
+		return IndexedDocument{}, ErrDocumentOutOfScope
+	}
 	if err != nil {
 		return IndexedDocument{}, err
 	}
-
-	type searchResp struct {
-		Id             string         `json:"id" mapstructure:"id"`
-		LastEditedTime string         `json:"last_edited_time" mapstructure:"last_edited_time"`
-		Properties     map[string]any `json:"properties" mapstructure:"properties"`
-		URL            string         `json:"url" mapstructure:"url"`
+	if page.Archived {
+		return IndexedDocument{}, ErrDocumentOutOfScope
+	}
+	if documentType == NotionDocumentTypeComments && notionCommentsMode(dataSource) != NotionCommentsDocuments {
+		return IndexedDocument{}, ErrDocumentOutOfScope
 	}
 
-	var searchResponse searchResp
-	err = json.NewDecoder(res.Body).Decode(&searchResponse)
+	scope := newNotionScope(client, integration.NotionIntegrationConnection, dataSource)
+	inScope, err := scope.contains(ctx, page)
 	if err != nil {
-		return IndexedDocument{}, err
+		return IndexedDocument{}, fmt.Errorf("unable to check scope, %w", err)
+	}
+	if !inScope {
+		return IndexedDocument{}, ErrDocumentOutOfScope
 	}
 
-	return IndexedDocument{
-		Integration:        IntegrationNotion,
-		DocumentType:       "page",
-		Id:                 searchResponse.Id,
-		Title:              extractTitle(searchResponse.Properties),
-		URL:                searchResponse.URL,
-		FreshnessIndicator: searchResponse.LastEditedTime,
-	}, nil
-
+	if documentType == NotionDocumentTypeComments {
+		return client.commentsDocument(ctx, integration.NotionIntegrationConnection, page.toIndexedDocument(integration.NotionIntegrationConnection))
+	}
+	return page.toIndexedDocument(integration.NotionIntegrationConnection), nil
 }
 
-func newNotionApiClient(notionHelperEndpoint string, logger logrus.FieldLogger) DataSourceApiClient {
+func newNotionApiClient(config NotionConfig, attachments HTTPClientConfig, references *CredentialReferences, rateLimits rateLimitStore, logger logrus.FieldLogger) DataSourceApiClient {
+	httpClient := &http.Client{
+		Timeout: config.Timeout,
+	}
+
+	// Pages are rendered by the worker unless the node helper is explicitly opted into
+	notionHelperEndpoint := ""
+	if config.UseHelper {
+		notionHelperEndpoint = config.HelperEndpoint
+	}
+
 	return &NotionAPIClientImpl{
-		httpClient: &http.Client{
-			Timeout: time.Minute * 5,
-		},
+		// https://developers.notion.com/reference/request-limits
+		transport:            newProviderTransport("notion", httpClient, newRateLimiter("notion", rateLimits, 3, 3), config.MaxAttempts, classifyNotionResponse).withAPIHosts("api.notion.com"),
+		helperTransport:      newProviderTransport("notion-helper", httpClient, nil, config.MaxAttempts, classifyNotionHelperResponse),
+		downloadTransport:    newAttachmentTransport("notion-attachments", attachments),
 		logger:               logger,
 		notionHelperEndpoint: notionHelperEndpoint,
-		// We're limited to 3 requests per second, so we definitely cannot allow more than 3 concurrent requests
-		// However, each request could be faster than a second, so we might still run into rate limiting issues
-		sema: semaphore.NewWeighted(3),
+		references:           references,
+		commentsPolled:       config.PollInterval > 0,
+		// Requests could be faster than a second, so even 3 concurrent requests might run into rate limiting issues
+		sema: semaphore.NewWeighted(int64(config.Concurrency)),
 	}
 }
 
-type TitleProperty struct {
-	Type  string     `json:"type" mapstructure:"type"` // title
-	Title []RichText `json:"title" mapstructure:"title"`
-}
-
 type RichText struct {
-	Type string `json:"type" mapstructure:"type"` // text
+	Type string `json:"type"` // text, mention or equation
 	Text struct {
-		Content string  `json:"content" mapstructure:"content"`
-		Link    *string `json:"link" mapstructure:"link"`
-	} `json:"text" mapstructure:"text"`
-	PlainText string `json:"plain_text" mapstructure:"plain_text"`
+		Content string `json:"content"`
+		Link    *struct {
+			URL string `json:"url"`
+		} `json:"link"`
+	} `json:"text"`
+	Equation *struct {
+		Expression string `json:"expression"`
+	} `json:"equation"`
+	Annotations struct {
+		Bold          bool `json:"bold"`
+		Italic        bool `json:"italic"`
+		Strikethrough bool `json:"strikethrough"`
+		Code          bool `json:"code"`
+	} `json:"annotations"`
+	PlainText string  `json:"plain_text"`
+	Href      *string `json:"href"`
 }
 
-func (client *NotionAPIClientImpl) loadAllPages(ctx context.Context, integration NotionIntegrationConnection) (map[string]IndexedDocument, error) {
-	var cursor *string
-	var indexedDocuments = make(map[string]IndexedDocument)
+var (
+	errNotionNotFound  = errors.New("not found")
+	errNotionForbidden = errors.New("forbidden")
+)
 
-	for {
-		body := map[string]any{
-			"query":     "",
-			"page_size": 100,
-			"filter": map[string]any{
-				"property": "object",
-				"value":    "page",
-			},
-		}
-		if cursor != nil {
-			body["start_cursor"] = *cursor
-		}
-		marshalledBody, err := json.Marshal(body)
-		if err != nil {
-			return nil, err
-		}
+// sendRequest sends a request to the Notion API and decodes the response into result
+func (client *NotionAPIClientImpl) sendRequest(ctx context.Context, integration NotionIntegrationConnection, method, endpoint string, body any, result any) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+integration.Config.AcessToken)
+	header.Set("Notion-Version", "2022-06-28")
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.notion.com/v1/search", bytes.NewBuffer(marshalledBody))
+	var marshalledBody []byte
+	if body != nil {
+		var err error
+		marshalledBody, err = json.Marshal(body)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		header.Set("Content-Type", "application/json")
+	}
 
-		req.Header.Set("Authorization", "Bearer "+integration.Config.AcessToken)
+	return client.transport.DoJSON(ctx, providerRequest{
+		Method:     method,
+		URL:        "https://api.notion.com/v1" + endpoint,
+		Header:     header,
+		Body:       marshalledBody,
+		LimiterKey: notionLimiterKey(integration),
+	}, result)
+}
 
-		req.Header.Set("Notion-Version", "2022-06-28")
-		req.Header.Set("Content-Type", "application/json")
+// notionLimiterKey selects the rate limit bucket, Notion limits requests per workspace
+func notionLimiterKey(integration NotionIntegrationConnection) string {
+	if integration.Config.WorkspaceId != "" {
+		return integration.Config.WorkspaceId
+	}
+	return rateLimitKey(integration.Config.AcessToken)
+}
 
-		res, err := backoff.RetryWithData[*http.Response](
-			func() (*http.Response, error) {
-				res, err := client.httpClient.Do(req)
-				if err != nil {
-					if err, ok := err.(net.Error); ok && err.Timeout() {
-						return nil, err
-					}
-					return nil, backoff.Permanent(err)
-				}
+func classifyNotionResponse(res *http.Response) error {
+	switch res.StatusCode {
+	// Pages and databases not shared with the integration are reported as not found
+	case http.StatusNotFound:
+		return backoff.Permanent(errNotionNotFound)
+	// Capabilities like reading comments or user information may not be granted to the integration
+	case http.StatusForbidden:
+		return backoff.Permanent(errNotionForbidden)
+	default:
+		return nil
+	}
+}
 
-				// While only 3 requests can be run at the same time, requests will be quicker than a second,
-				// so we still need to handle rate-limiting and retry gracefully
-				if res.StatusCode == http.StatusTooManyRequests {
-					return nil, fmt.Errorf("rate limited")
-				}
+// classifyNotionHelperResponse doesn't retry server errors, the node helper retries rate limited Notion requests
+// itself and fails for good otherwise
+func classifyNotionHelperResponse(res *http.Response) error {
+	if res.StatusCode >= 500 {
+		return backoff.Permanent(fmt.Errorf("unexpected status code: %d", res.StatusCode))
+	}
+	return nil
+}
 
-				if res.StatusCode != http.StatusOK {
-					return nil, backoff.Permanent(fmt.Errorf("unexpected status code: %d", res.StatusCode))
-				}
+type NotionParent struct {
+	Type       string `json:"type"` // page_id, database_id, block_id or workspace
+	PageId     string `json:"page_id"`
+	DatabaseId string `json:"database_id"`
+	BlockId    string `json:"block_id"`
+}
 
-				return res, nil
-			},
-			newBackOff(ctx, 10),
-		)
-		if err != nil {
-			return nil, err
-		}
+func (parent NotionParent) id() string {
+	switch parent.Type {
+	case "page_id":
+		return parent.PageId
+	case "database_id":
+		return parent.DatabaseId
+	case "block_id":
+		return parent.BlockId
+	default:
+		return ""
+	}
+}
 
-		type searchResp struct {
-			Results []struct {
-				Id             string         `json:"id" mapstructure:"id"`
-				LastEditedTime string         `json:"last_edited_time" mapstructure:"last_edited_time"`
-				Properties     map[string]any `json:"properties" mapstructure:"properties"`
-				URL            string         `json:"url" mapstructure:"url"`
-			} `json:"results"`
-			NextCursor *string `json:"next_cursor"`
-			HasMore    bool    `json:"has_more"`
-		}
+// NotionObject holds the fields shared by pages and databases
+type NotionObject struct {
+	Object         string       `json:"object"`
+	Id             string       `json:"id"`
+	LastEditedTime string       `json:"last_edited_time"`
+	URL            string       `json:"url"`
+	Parent         NotionParent `json:"parent"`
+	Archived       bool         `json:"archived"`
+	// PublicURL is set if the page was published to the web
+	PublicURL *string `json:"public_url"`
+}
+
+type NotionPage struct {
+	NotionObject
+	Properties map[string]NotionPropertyValue `json:"properties"`
+}
+
+// NotionDatabase omits the properties, which describe the schema of the database rows
+type NotionDatabase struct {
+	NotionObject
+	Title []RichText `json:"title"`
+}
+
+func (page NotionPage) toIndexedDocument(integration NotionIntegrationConnection) IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationNotion,
+		DocumentType:       "page",
+		Id:                 page.Id,
+		Title:              extractTitle(page.Properties),
+		URL:                page.URL,
+		FreshnessIndicator: page.LastEditedTime,
+		EditedAt:           parseEditedAt(page.LastEditedTime),
+		ACL:                page.acl(integration),
+	}
+}
+
+// acl returns who may access the page. The API doesn't expose whether a page is private, restricted or shared with
+// the whole workspace, so pages which aren't public are restricted to the members of the workspace.
+func (page NotionPage) acl(integration NotionIntegrationConnection) []string {
+	if page.PublicURL != nil && *page.PublicURL != "" {
+		return []string{ACLPublic}
+	}
+	return []string{aclWorkspace(IntegrationNotion, integration.Config.WorkspaceId)}
+}
+
+type notionList[T any] struct {
+	Results    []T     `json:"results"`
+	NextCursor *string `json:"next_cursor"`
+	HasMore    bool    `json:"has_more"`
+}
 
-		var searchResponse searchResp
-		err = json.NewDecoder(res.Body).Decode(&searchResponse)
+// paginate calls load with the cursor of the previous page until all results have been loaded
+func paginate[T any](load func(cursor *string) (*notionList[T], error), handle func(result T) error) error {
+	var cursor *string
+
+	for {
+		list, err := load(cursor)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		for _, result := range searchResponse.Results {
-			indexedDocuments[result.Id] = IndexedDocument{
-				Integration:        IntegrationNotion,
-				DocumentType:       "page",
-				Id:                 result.Id,
-				Title:              extractTitle(result.Properties),
-				URL:                result.URL,
-				FreshnessIndicator: result.LastEditedTime,
+		for _, result := range list.Results {
+			err = handle(result)
+			if err != nil {
+				return err
 			}
 		}
 
-		if !searchResponse.HasMore {
-			break
+		if !list.HasMore {
+			return nil
 		}
 
-		cursor = searchResponse.NextCursor
+		cursor = list.NextCursor
 	}
-
-	return indexedDocuments, nil
 }
 
-func (client *NotionAPIClientImpl) loadDatabaseIds(ctx context.Context, integration NotionIntegrationConnection) ([]string, error) {
-	var cursor *string
-	var databaseIds []string
-
-	for {
+// notionSearch lists all pages or databases shared with the integration
+func notionSearch[T any](ctx context.Context, client *NotionAPIClientImpl, integration NotionIntegrationConnection, objectType string, handle func(result T) error) error {
+	return paginate[T](func(cursor *string) (*notionList[T], error) {
 		body := map[string]any{
 			"query":     "",
 			"page_size": 100,
 			"filter": map[string]any{
 				"property": "object",
-				"value":    "database",
+				"value":    objectType,
 			},
 		}
 		if cursor != nil {
 			body["start_cursor"] = *cursor
 		}
-		marshalledBody, err := json.Marshal(body)
+
+		var list notionList[T]
+		err := client.sendRequest(ctx, integration, http.MethodPost, "/search", body, &list)
 		if err != nil {
 			return nil, err
 		}
+		return &list, nil
+	}, handle)
+}
+
+func (client *NotionAPIClientImpl) queryDatabase(ctx context.Context, integration NotionIntegrationConnection, databaseId string, handle func(result NotionPage) error) error {
+	return paginate[NotionPage](func(cursor *string) (*notionList[NotionPage], error) {
+		body := map[string]any{
+			"page_size": 100,
+		}
+		if cursor != nil {
+			body["start_cursor"] = *cursor
+		}
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.notion.com/v1/search", bytes.NewBuffer(marshalledBody))
+		var list notionList[NotionPage]
+		err := client.sendRequest(ctx, integration, http.MethodPost, fmt.Sprintf("/databases/%s/query", url.PathEscape(databaseId)), body, &list)
 		if err != nil {
 			return nil, err
 		}
+		return &list, nil
+	}, handle)
+}
 
-		req.Header.Set("Authorization", "Bearer "+integration.Config.AcessToken)
+type NotionBlock struct {
+	Id             string       `json:"id"`
+	Type           string       `json:"type"`
+	HasChildren    bool         `json:"has_children"`
+	LastEditedTime string       `json:"last_edited_time"`
+	Parent         NotionParent `json:"parent"`
 
-		req.Header.Set("Notion-Version", "2022-06-28")
-		req.Header.Set("Content-Type", "application/json")
+	// Content holds the type-specific fields stored under the key named after the block type
+	Content NotionBlockContent `json:"-"`
+}
 
-		res, err := backoff.RetryWithData[*http.Response](
-			func() (*http.Response, error) {
-				res, err := client.httpClient.Do(req)
-				if err != nil {
-					if err, ok := err.(net.Error); ok && err.Timeout() {
-						return nil, err
-					}
-					return nil, backoff.Permanent(err)
-				}
+// NotionBlockContent holds the union of the fields of all supported block types
+// https://developers.notion.com/reference/block
+type NotionBlockContent struct {
+	RichText []RichText `json:"rich_text"`
+	Caption  []RichText `json:"caption"`
+
+	// to_do
+	Checked bool `json:"checked"`
+	// code
+	Language string `json:"language"`
+	// callout
+	Icon *struct {
+		Type  string `json:"type"`
+		Emoji string `json:"emoji"`
+	} `json:"icon"`
+	// equation
+	Expression string `json:"expression"`
+	// child_page, child_database
+	Title string `json:"title"`
+	// bookmark, embed, link_preview
+	URL string `json:"url"`
+	// table
+	HasColumnHeader bool `json:"has_column_header"`
+	// table_row
+	Cells [][]RichText `json:"cells"`
+	// synced_block, set if the block is a copy of another synced block
+	SyncedFrom *struct {
+		BlockId string `json:"block_id"`
+	} `json:"synced_from"`
+	// link_to_page
+	PageId     string `json:"page_id"`
+	DatabaseId string `json:"database_id"`
+	// image, file, pdf, video, audio
+	Name     string `json:"name"`
+	External *struct {
+		URL string `json:"url"`
+	} `json:"external"`
+	// Set for files uploaded to Notion, the URL is signed and expires after an hour
+	File *struct {
+		URL string `json:"url"`
+	} `json:"file"`
+}
 
-				// While only 3 requests can be run at the same time, requests will be quicker than a second,
-				// so we still need to handle rate-limiting and retry gracefully
-				if res.StatusCode == http.StatusTooManyRequests {
-					return nil, fmt.Errorf("rate limited")
-				}
+func (block *NotionBlock) UnmarshalJSON(data []byte) error {
+	type notionBlock NotionBlock
+	err := json.Unmarshal(data, (*notionBlock)(block))
+	if err != nil {
+		return err
+	}
 
-				if res.StatusCode != http.StatusOK {
-					return nil, backoff.Permanent(fmt.Errorf("unexpected status code: %d", res.StatusCode))
-				}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
 
-				return res, nil
-			},
-			newBackOff(ctx, 10),
-		)
+	if content, ok := fields[block.Type]; ok {
+		err = json.Unmarshal(content, &block.Content)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("unable to decode %s block, %w", block.Type, err)
 		}
+	}
 
-		type searchResp struct {
-			Results []struct {
-				Id string `json:"id" mapstructure:"id"`
-			} `json:"results"`
-			NextCursor *string `json:"next_cursor"`
-			HasMore    bool    `json:"has_more"`
+	return nil
+}
+
+func (client *NotionAPIClientImpl) listBlockChildren(ctx context.Context, integration NotionIntegrationConnection, blockId string, handle func(block NotionBlock) error) error {
+	return paginate[NotionBlock](func(cursor *string) (*notionList[NotionBlock], error) {
+		endpoint := fmt.Sprintf("/blocks/%s/children?page_size=100", url.PathEscape(blockId))
+		if cursor != nil {
+			endpoint += "&start_cursor=" + url.QueryEscape(*cursor)
 		}
 
-		var searchResponse searchResp
-		err = json.NewDecoder(res.Body).Decode(&searchResponse)
+		var list notionList[NotionBlock]
+		err := client.sendRequest(ctx, integration, http.MethodGet, endpoint, nil, &list)
 		if err != nil {
 			return nil, err
 		}
+		return &list, nil
+	}, handle)
+}
 
-		for _, result := range searchResponse.Results {
-			databaseIds = append(databaseIds, result.Id)
-		}
+func (client *NotionAPIClientImpl) getPage(ctx context.Context, integration NotionIntegrationConnection, pageId string) (*NotionPage, error) {
+	var page NotionPage
+	err := client.sendRequest(ctx, integration, http.MethodGet, fmt.Sprintf("/pages/%s", url.PathEscape(pageId)), nil, &page)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
 
-		if !searchResponse.HasMore {
-			break
-		}
+func (client *NotionAPIClientImpl) getDatabase(ctx context.Context, integration NotionIntegrationConnection, databaseId string) (*NotionDatabase, error) {
+	var database NotionDatabase
+	err := client.sendRequest(ctx, integration, http.MethodGet, fmt.Sprintf("/databases/%s", url.PathEscape(databaseId)), nil, &database)
+	if err != nil {
+		return nil, err
+	}
+	return &database, nil
+}
 
-		cursor = searchResponse.NextCursor
+func (client *NotionAPIClientImpl) getBlock(ctx context.Context, integration NotionIntegrationConnection, blockId string) (*NotionBlock, error) {
+	var block NotionBlock
+	err := client.sendRequest(ctx, integration, http.MethodGet, fmt.Sprintf("/blocks/%s", url.PathEscape(blockId)), nil, &block)
+	if err != nil {
+		return nil, err
 	}
+	return &block, nil
+}
 
-	return databaseIds, nil
+func (client *NotionAPIClientImpl) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	documents, _, err := client.ListDocumentsSince(ctx, dataSource, integration, "", nil)
+	return documents, err
 }
 
-func (client *NotionAPIClientImpl) loadDatabasePages(ctx context.Context, integration NotionIntegrationConnection, databaseId string) ([]IndexedDocument, error) {
-	// TODO Reliably list _all_ pages integration can access
-	// TODO Maybe load databases separately and then fetch all their items
+// ListDocumentsSince lists all pages in scope. Listing the comments of a page takes a request per block, so comments
+// documents of pages which didn't change are taken from indexed if the poller keeps them up to date, see
+// withCommentsDocuments. Notion has no change cursor, the returned cursor is always empty.
+func (client *NotionAPIClientImpl) ListDocumentsSince(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection, cursor string, indexed map[string]string) (map[string]IndexedDocument, string, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer client.sema.Release(1)
 
-	var cursor *string
-	var indexedDocuments []IndexedDocument
+	scope := newNotionScope(client, integration.NotionIntegrationConnection, dataSource)
 
-	for {
-		body := map[string]any{
-			"page_size": 100,
-		}
-		if cursor != nil {
-			body["start_cursor"] = *cursor
-		}
-		marshalledBody, err := json.Marshal(body)
-		if err != nil {
-			return nil, err
-		}
+	var allPages map[string]IndexedDocument
+	if scope.hasRoots() {
+		client.logger.Printf("Listing Notion pages below configured roots\n")
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://api.notion.com/v1/databases/%s/query", databaseId), bytes.NewBuffer(marshalledBody))
-		if err != nil {
-			return nil, err
-		}
+		allPages, err = scope.walk(ctx)
+	} else {
+		allPages, err = client.listAllPages(ctx, integration.NotionIntegrationConnection, scope)
+	}
+	if err != nil {
+		return nil, "", err
+	}
 
-		req.Header.Set("Authorization", "Bearer "+integration.Config.AcessToken)
+	client.logger.Printf("Found %d shared Notion pages\n", len(allPages))
 
-		req.Header.Set("Notion-Version", "2022-06-28")
-		req.Header.Set("Content-Type", "application/json")
+	if notionCommentsMode(dataSource) == NotionCommentsDocuments {
+		documents, err := client.withCommentsDocuments(ctx, integration.NotionIntegrationConnection, allPages, indexed)
+		return documents, "", err
+	}
+	return allPages, "", nil
+}
 
-		res, err := backoff.RetryWithData[*http.Response](
-			func() (*http.Response, error) {
-				res, err := client.httpClient.Do(req)
-				if err != nil {
-					if err, ok := err.(net.Error); ok && err.Timeout() {
-						return nil, err
-					}
-					return nil, backoff.Permanent(err)
-				}
+// listAllPages lists all pages shared with the integration, including database rows
+func (client *NotionAPIClientImpl) listAllPages(ctx context.Context, integration NotionIntegrationConnection, scope *notionScope) (map[string]IndexedDocument, error) {
+	var pages []NotionPage
 
-				// While only 3 requests can be run at the same time, requests will be quicker than a second,
-				// so we still need to handle rate-limiting and retry gracefully
-				if res.StatusCode == http.StatusTooManyRequests {
-					return nil, fmt.Errorf("rate limited")
-				}
+	client.logger.Printf("Listing all Notion pages\n")
 
-				if res.StatusCode != http.StatusOK {
-					return nil, backoff.Permanent(fmt.Errorf("unexpected status code: %d", res.StatusCode))
-				}
+	err := notionSearch[NotionPage](ctx, client, integration, "page", func(page NotionPage) error {
+		scope.remember(page.NotionObject)
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-				return res, nil
-			},
-			newBackOff(ctx, 10),
-		)
-		if err != nil {
-			return nil, err
-		}
+	client.logger.Printf("Listing all Notion databases\n")
 
-		type searchResp struct {
-			Results []struct {
-				Id             string         `json:"id" mapstructure:"id"`
-				LastEditedTime string         `json:"last_edited_time" mapstructure:"last_edited_time"`
-				Properties     map[string]any `json:"properties" mapstructure:"properties"`
-				URL            string         `json:"url" mapstructure:"url"`
-			} `json:"results"`
-			NextCursor *string `json:"next_cursor"`
-			HasMore    bool    `json:"has_more"`
-		}
+	var databases []NotionDatabase
+	err = notionSearch[NotionDatabase](ctx, client, integration, "database", func(database NotionDatabase) error {
+		scope.remember(database.NotionObject)
+		databases = append(databases, database)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		var searchResponse searchResp
-		err = json.NewDecoder(res.Body).Decode(&searchResponse)
+	for _, database := range databases {
+		client.logger.Printf("Listing all Notion database pages for database %q\n", database.Id)
+		err = client.queryDatabase(ctx, integration, database.Id, func(page NotionPage) error {
+			scope.remember(page.NotionObject)
+			pages = append(pages, page)
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		for _, result := range searchResponse.Results {
-			indexedDocuments = append(indexedDocuments, IndexedDocument{
-				Integration:        IntegrationNotion,
-				DocumentType:       "page",
-				Id:                 result.Id,
-				Title:              extractTitle(result.Properties),
-				URL:                result.URL,
-				FreshnessIndicator: result.LastEditedTime,
-			})
+	allPages := make(map[string]IndexedDocument)
+	for _, page := range pages {
+		if page.Archived {
+			continue
 		}
 
-		if !searchResponse.HasMore {
-			break
+		inScope, err := scope.contains(ctx, &page)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check scope, %w", err)
+		}
+		if !inScope {
+			continue
 		}
 
-		cursor = searchResponse.NextCursor
+		allPages[page.Id] = page.toIndexedDocument(integration)
 	}
 
-	return indexedDocuments, nil
+	return allPages, nil
 }
 
-func (client *NotionAPIClientImpl) ListDocuments(ctx context.Context, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+func (client *NotionAPIClientImpl) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
 	err := client.sema.Acquire(ctx, 1)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	defer client.sema.Release(1)
 
-	client.logger.Printf("Listing all Notion pages\n")
+	if documentType == NotionDocumentTypeComments {
+		return client.getCommentsDocumentContent(ctx, integration.NotionIntegrationConnection, id)
+	}
 
-	allPages, err := client.loadAllPages(ctx, integration.NotionIntegrationConnection)
+	markdown, metadata, _, err := client.getPageContent(ctx, dataSource, id, integration)
+	return markdown, metadata, err
+}
+
+// getPageContent renders a page and returns the files uploaded to it if attachments are included
+func (client *NotionAPIClientImpl) getPageContent(ctx context.Context, dataSource *PipelineDataSource, id string, integration IntegrationConnection) (string, map[string]any, []IndexedDocument, error) {
+	page, err := client.getPage(ctx, integration.NotionIntegrationConnection, id)
 	if err != nil {
-		return nil, err
+		return "", nil, nil, fmt.Errorf("unable to get page, %w", err)
 	}
 
-	client.logger.Printf("Listing all Notion databases\n")
-
-	databaseIds, err := client.loadDatabaseIds(ctx, integration.NotionIntegrationConnection)
+	inlineComments := notionCommentsMode(dataSource) == NotionCommentsInline
+	includeAttachments := notionIncludeAttachments(dataSource)
+
+	var markdown string
+	// The node helper doesn't return blocks, so only comments on the page itself are included
+	var blocks []notionBlockExcerpt
+	var files []NotionBlock
+	if client.notionHelperEndpoint != "" {
+		markdown, err = client.getPageMarkdown(ctx, id, integration)
+		if err == nil && includeAttachments {
+			// Blocks are only rendered to find the files uploaded to the page
+			renderer := &notionRenderer{client: client, integration: integration.NotionIntegrationConnection, recordFiles: true}
+			_, err = renderer.renderPage(ctx, id)
+			files = renderer.files
+		}
+	} else {
+		renderer := &notionRenderer{client: client, integration: integration.NotionIntegrationConnection, recordBlocks: inlineComments, recordFiles: includeAttachments}
+		markdown, err = renderer.renderPage(ctx, id)
+		blocks = renderer.blocks
+		files = renderer.files
+	}
 	if err != nil {
-		return nil, err
+		return "", nil, nil, fmt.Errorf("unable to render page, %w", err)
 	}
 
-	for _, databaseId := range databaseIds {
-		client.logger.Printf("Listing all Notion database pages for database %q\n", databaseId)
-		databasePages, err := client.loadDatabasePages(ctx, integration.NotionIntegrationConnection, databaseId)
-		if err != nil {
-			return nil, err
+	var attachments []IndexedDocument
+	if includeAttachments {
+		attachments = make([]IndexedDocument, 0, len(files))
+		for _, file := range files {
+			attachments = append(attachments, notionFileDocument(page.Id, file))
 		}
+	}
 
-		for _, databasePage := range databasePages {
-			allPages[databasePage.Id] = databasePage
+	if inlineComments {
+		comments, err := client.getPageComments(ctx, integration.NotionIntegrationConnection, id, blocks)
+		if err != nil {
+			return "", nil, nil, err
 		}
+		markdown += comments
 	}
 
-	client.logger.Printf("Found %d shared Notion pages\n", len(allPages))
+	title := extractTitle(page.Properties)
+	metadata := map[string]any{
+		"title": title,
+	}
 
-	return allPages, nil
-}
+	if page.Parent.Type != "database_id" {
+		return markdown, metadata, attachments, nil
+	}
 
-func (client *NotionAPIClientImpl) GetDocumentContent(ctx context.Context, documentType, id string, integration IntegrationConnection) (string, map[string]any, error) {
-	err := client.sema.Acquire(ctx, 1)
+	// Database rows often have little content besides their properties, so these are rendered as a header
+	databaseName, err := client.getDatabaseTitle(ctx, integration.NotionIntegrationConnection, page.Parent.DatabaseId)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, fmt.Errorf("unable to get database, %w", err)
 	}
-	defer client.sema.Release(1)
 
-	body := map[string]any{
-		"pageId": id,
-		"token":  integration.NotionIntegrationConnection.Config.AcessToken,
+	metadata["database_id"] = page.Parent.DatabaseId
+	if databaseName != "" {
+		metadata["database_name"] = databaseName
 	}
-	marshalledBody, err := json.Marshal(body)
-	if err != nil {
-		return "", nil, fmt.Errorf("unable to marshal body, %w", err)
+	for key, value := range notionPropertyMetadata(page.Properties) {
+		metadata[key] = value
 	}
 
-	res, err := backoff.RetryWithData[*http.Response](
-		func() (*http.Response, error) {
-			req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/notion/markdown", client.notionHelperEndpoint), bytes.NewBuffer(marshalledBody))
-			if err != nil {
-				return nil, err
-			}
+	var sb strings.Builder
+	sb.WriteString("# " + title + "\n\n")
+	if databaseName != "" {
+		sb.WriteString("- **Database:** " + databaseName + "\n")
+	}
+	sb.WriteString(renderNotionProperties(page.Properties))
+	if markdown != "" {
+		sb.WriteString("\n" + markdown)
+	}
 
-			req.Header.Set("Content-Type", "application/json")
+	return sb.String(), metadata, attachments, nil
+}
 
-			res, err := client.httpClient.Do(req)
-			if err != nil {
-				if err, ok := err.(net.Error); ok && err.Timeout() {
-					return nil, err
-				}
-				return nil, backoff.Permanent(err)
-			}
+const notionCacheTTL = 10 * time.Minute
 
-			// While only 3 requests can be run at the same time, requests will be quicker than a second,
-			// so we still need to handle rate-limiting and retry gracefully
-			if res.StatusCode == http.StatusTooManyRequests {
-				return nil, fmt.Errorf("rate limited")
-			}
+type notionCachedName struct {
+	name      string
+	fetchedAt time.Time
+}
 
-			if res.StatusCode != http.StatusOK {
-				return nil, backoff.Permanent(fmt.Errorf("unexpected status code: %d", res.StatusCode))
-			}
+// getDatabaseTitle returns the title of a database, titles are cached as all rows of a database are usually
+// retrieved in the same run
+func (client *NotionAPIClientImpl) getDatabaseTitle(ctx context.Context, integration NotionIntegrationConnection, databaseId string) (string, error) {
+	if cached, ok := client.databaseTitles.Load(databaseId); ok && time.Since(cached.(notionCachedName).fetchedAt) < notionCacheTTL {
+		return cached.(notionCachedName).name, nil
+	}
+
+	database, err := client.getDatabase(ctx, integration, databaseId)
+	// Rows of databases that aren't shared themselves can still be shared
+	if errors.Is(err, errNotionNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	title := plainText(database.Title)
+	client.databaseTitles.Store(databaseId, notionCachedName{name: title, fetchedAt: time.Now()})
+
+	return title, nil
+}
+
+// getPageMarkdown renders the page using the node helper, which is only used if NOTION_USE_HELPER is set
+func (client *NotionAPIClientImpl) getPageMarkdown(ctx context.Context, id string, integration IntegrationConnection) (string, error) {
+	token, err := client.references.Reference(integration.Account, integration.NotionIntegrationConnection.Config.AcessToken)
+	if err != nil {
+		return "", fmt.Errorf("unable to reference token, %w", err)
+	}
 
-			return res, nil
-		},
-		newBackOff(ctx, 10),
-	)
+	body := map[string]any{
+		"pageId":  id,
+		"account": integration.Account,
+		"token":   token,
+	}
+	marshalledBody, err := json.Marshal(body)
 	if err != nil {
-		return "", nil, fmt.Errorf("unable to get page markdown content, %w", err)
+		return "", fmt.Errorf("unable to marshal body, %w", err)
 	}
 
 	type notionMarkdownResponse struct {
@@ -490,37 +686,25 @@ func (client *NotionAPIClientImpl) GetDocumentContent(ctx context.Context, docum
 	}
 
 	var notionMarkdownResponseData notionMarkdownResponse
-	err = json.NewDecoder(res.Body).Decode(&notionMarkdownResponseData)
+	err = client.helperTransport.DoJSON(ctx, providerRequest{
+		Method: http.MethodPost,
+		URL:    fmt.Sprintf("%s/notion/markdown", client.notionHelperEndpoint),
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   marshalledBody,
+	}, &notionMarkdownResponseData)
 	if err != nil {
-		return "", nil, fmt.Errorf("unable to decode response, %w", err)
+		return "", fmt.Errorf("unable to get page markdown content, %w", err)
 	}
 
-	return notionMarkdownResponseData.Markdown, make(map[string]any), nil
+	return notionMarkdownResponseData.Markdown, nil
 }
 
-func extractTitle(props map[string]any) string {
-	var title string
-	for _, a := range props {
-		asMap, ok := a.(map[string]any)
-		if !ok {
-			continue
-		}
-
-		if asMap["type"] != "title" {
-			continue
-		}
-
-		titleProperty := TitleProperty{}
-		err := mapstructure.Decode(asMap, &titleProperty)
-		if err != nil {
-			continue
-		}
-
-		for _, text := range titleProperty.Title {
-			title += text.PlainText
+func extractTitle(props map[string]NotionPropertyValue) string {
+	for _, property := range props {
+		if property.Type == "title" {
+			return plainText(property.Title)
 		}
-		break
 	}
 
-	return title
+	return ""
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const NotionDocumentTypeFile = "file"
+
+func notionIncludeAttachments(dataSource *PipelineDataSource) bool {
+	return dataSource != nil && dataSource.NotionDataSource.Config.IncludeAttachments
+}
+
+func notionFileName(block NotionBlock) string {
+	if block.Content.Name != "" {
+		return block.Content.Name
+	}
+	if caption := plainText(block.Content.Caption); caption != "" {
+		return caption
+	}
+	return "Untitled file"
+}
+
+func notionFileDocument(pageId string, block NotionBlock) IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationNotion,
+		DocumentType:       NotionDocumentTypeFile,
+		Id:                 block.Id,
+		Title:              notionFileName(block),
+		URL:                notionPageURL(pageId) + "#" + normalizeNotionId(block.Id),
+		FreshnessIndicator: block.LastEditedTime,
+		ParentId:           pageId,
+	}
+}
+
+// GetDocumentContentAndAttachments renders a page and lists the files uploaded to it, which are found while rendering.
+// Replacing a file updates the page's last edited time, so the page's freshness indicator covers its attachments.
+func (client *NotionAPIClientImpl) GetDocumentContentAndAttachments(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, []IndexedDocument, error) {
+	if documentType != "page" {
+		content, metadata, err := client.GetDocumentContent(ctx, dataSource, documentType, id, integration)
+		return content, metadata, nil, err
+	}
+
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer client.sema.Release(1)
+
+	return client.getPageContent(ctx, dataSource, id, integration)
+}
+
+// GetAttachmentContent downloads a file uploaded to a page
+func (client *NotionAPIClientImpl) GetAttachmentContent(ctx context.Context, dataSource *PipelineDataSource, attachment IndexedDocument, integration IntegrationConnection) (string, map[string]any, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	defer client.sema.Release(1)
+
+	return client.getFileContent(ctx, integration.NotionIntegrationConnection, attachment.Id)
+}
+
+// getFileContent downloads a file uploaded to Notion, the block is retrieved to get a URL that hasn't expired yet
+func (client *NotionAPIClientImpl) getFileContent(ctx context.Context, integration NotionIntegrationConnection, id string) (string, map[string]any, error) {
+	block, err := client.getBlock(ctx, integration, id)
+	if errors.Is(err, errNotionNotFound) {
+		return "", nil, &SkippedDocumentError{Reason: "not_found"}
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to get block, %w", err)
+	}
+	if block.Content.File == nil {
+		// The file was replaced by an external link, which we don't download
+		return "", nil, &SkippedDocumentError{Reason: SkipReasonUnsupportedFormat}
+	}
+
+	name := notionFileName(*block)
+
+	textContent, err := extractAttachmentText(ctx, client.downloadTransport, block.Content.File.URL, nil, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	metadata := map[string]any{
+		"title": name,
+	}
+
+	return textContent, metadata, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// NotionCommentsInline appends the discussions of a page to its content
+	NotionCommentsInline = "inline"
+	// NotionCommentsDocuments indexes the discussions of each page as a separate document
+	NotionCommentsDocuments = "documents"
+)
+
+const NotionDocumentTypeComments = "comments"
+
+// Comment documents have their own id, as ids must be unique across document types of a data source
+const notionCommentsIdSuffix = "/comments"
+
+// Block texts are truncated when quoted as the context of a discussion
+const notionExcerptLength = 200
+
+// https://developers.notion.com/reference/comment-object
+type NotionComment struct {
+	Id             string       `json:"id"`
+	Parent         NotionParent `json:"parent"`
+	DiscussionId   string       `json:"discussion_id"`
+	CreatedTime    string       `json:"created_time"`
+	LastEditedTime string       `json:"last_edited_time"`
+	CreatedBy      NotionUser   `json:"created_by"`
+	RichText       []RichText   `json:"rich_text"`
+}
+
+type notionBlockExcerpt struct {
+	id   string
+	text string
+}
+
+// notionDiscussion is a thread of comments on a page or block, context holds the text of the block
+type notionDiscussion struct {
+	context  string
+	comments []NotionComment
+}
+
+func notionCommentsMode(dataSource *PipelineDataSource) string {
+	if dataSource == nil {
+		return ""
+	}
+	return dataSource.NotionDataSource.Config.Comments
+}
+
+func notionCommentsId(pageId string) string {
+	return pageId + notionCommentsIdSuffix
+}
+
+// notionCommentsFreshness returns the freshness indicator of the comments document of a page and when its comments
+// were last edited. Adding comments doesn't change the last_edited_time of the page, so it's derived from the latest
+// comment, the number of comments changes when one is resolved or deleted.
+func notionCommentsFreshness(discussions []notionDiscussion) (string, time.Time) {
+	count := 0
+	latest := ""
+	for _, discussion := range discussions {
+		for _, comment := range discussion.comments {
+			count++
+			// Timestamps are ISO 8601, so they sort lexicographically
+			if comment.CreatedTime > latest {
+				latest = comment.CreatedTime
+			}
+			if comment.LastEditedTime > latest {
+				latest = comment.LastEditedTime
+			}
+		}
+	}
+
+	if count == 0 {
+		return "none", time.Time{}
+	}
+	return fmt.Sprintf("%s/%d", latest, count), parseEditedAt(latest)
+}
+
+// getCommentsFreshness lists the discussions on a page and returns the freshness indicator of its comments document
+func (client *NotionAPIClientImpl) getCommentsFreshness(ctx context.Context, integration NotionIntegrationConnection, pageId string) (string, time.Time, error) {
+	// Blocks are only rendered to find out which of them have comments
+	renderer := &notionRenderer{client: client, integration: integration, recordBlocks: true}
+	_, err := renderer.renderPage(ctx, pageId)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to render page, %w", err)
+	}
+
+	discussions, err := client.listDiscussions(ctx, integration, pageId, renderer.blocks)
+	// Comments documents of integrations which may not read comments are skipped, see getCommentsDocumentContent
+	if errors.Is(err, errNotionForbidden) {
+		discussions = nil
+	} else if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to list comments, %w", err)
+	}
+
+	freshness, editedAt := notionCommentsFreshness(discussions)
+	return freshness, editedAt, nil
+}
+
+// commentsDocument returns the comments document of a page
+func (client *NotionAPIClientImpl) commentsDocument(ctx context.Context, integration NotionIntegrationConnection, page IndexedDocument) (IndexedDocument, error) {
+	freshness, editedAt, err := client.getCommentsFreshness(ctx, integration, page.Id)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+
+	page.DocumentType = NotionDocumentTypeComments
+	page.Id = notionCommentsId(page.Id)
+	page.Title = "Comments on " + page.Title
+	page.FreshnessIndicator = freshness
+	page.EditedAt = editedAt
+	return page, nil
+}
+
+// withCommentsDocuments adds a comments document for every page. Comments don't change the page they're on, but the
+// poller compares indexed comments documents against their comments, so only comments of pages which changed or whose
+// comments aren't indexed yet are listed. Otherwise the comments of every page are listed.
+func (client *NotionAPIClientImpl) withCommentsDocuments(ctx context.Context, integration NotionIntegrationConnection, pages map[string]IndexedDocument, indexed map[string]string) (map[string]IndexedDocument, error) {
+	documents := make(map[string]IndexedDocument, len(pages)*2)
+	for id, page := range pages {
+		documents[id] = page
+
+		commentsId := notionCommentsId(page.Id)
+		stored, ok := indexed[NotionDocumentTypeComments+"/"+commentsId]
+		if ok && client.commentsPolled && indexed[page.DocumentType+"/"+page.Id] == page.freshness() {
+			// The stored freshness indicator already includes the access list, which didn't change with the page
+			comments := page
+			comments.DocumentType = NotionDocumentTypeComments
+			comments.Id = commentsId
+			comments.Title = "Comments on " + page.Title
+			comments.FreshnessIndicator = stored
+			comments.ACL = nil
+			documents[commentsId] = comments
+			continue
+		}
+
+		comments, err := client.commentsDocument(ctx, integration, page)
+		// Pages may be trashed while they're listed, they're removed with their comments document
+		if errors.Is(err, errNotionNotFound) {
+			delete(documents, id)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to get comments of page %q, %w", id, err)
+		}
+		documents[comments.Id] = comments
+	}
+	return documents, nil
+}
+
+// listComments lists the unresolved comments on a page or block
+func (client *NotionAPIClientImpl) listComments(ctx context.Context, integration NotionIntegrationConnection, blockId string, handle func(comment NotionComment) error) error {
+	return paginate[NotionComment](func(cursor *string) (*notionList[NotionComment], error) {
+		endpoint := fmt.Sprintf("/comments?block_id=%s&page_size=100", url.QueryEscape(blockId))
+		if cursor != nil {
+			endpoint += "&start_cursor=" + url.QueryEscape(*cursor)
+		}
+
+		var list notionList[NotionComment]
+		err := client.sendRequest(ctx, integration, http.MethodGet, endpoint, nil, &list)
+		if err != nil {
+			return nil, err
+		}
+		return &list, nil
+	}, handle)
+}
+
+// listDiscussions retrieves the comments on the page and each of its blocks, which requires one request per block.
+// Discussions are returned in the order of the blocks they're attached to.
+func (client *NotionAPIClientImpl) listDiscussions(ctx context.Context, integration NotionIntegrationConnection, pageId string, blocks []notionBlockExcerpt) ([]notionDiscussion, error) {
+	var discussions []notionDiscussion
+	indexes := make(map[string]int)
+
+	targets := append([]notionBlockExcerpt{{id: pageId}}, blocks...)
+	for _, target := range targets {
+		err := client.listComments(ctx, integration, target.id, func(comment NotionComment) error {
+			index, ok := indexes[comment.DiscussionId]
+			if !ok {
+				index = len(discussions)
+				indexes[comment.DiscussionId] = index
+				discussions = append(discussions, notionDiscussion{context: target.text})
+			}
+			discussions[index].comments = append(discussions[index].comments, comment)
+			return nil
+		})
+		// Blocks may be deleted while the page is rendered
+		if errors.Is(err, errNotionNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return discussions, nil
+}
+
+// getUserName returns the name of a user, names can only be retrieved if the integration may read user information
+func (client *NotionAPIClientImpl) getUserName(ctx context.Context, integration NotionIntegrationConnection, userId string) (string, error) {
+	if cached, ok := client.userNames.Load(userId); ok && time.Since(cached.(notionCachedName).fetchedAt) < notionCacheTTL {
+		return cached.(notionCachedName).name, nil
+	}
+
+	var user NotionUser
+	err := client.sendRequest(ctx, integration, http.MethodGet, fmt.Sprintf("/users/%s", url.PathEscape(userId)), nil, &user)
+	if errors.Is(err, errNotionForbidden) || errors.Is(err, errNotionNotFound) {
+		user.Name = ""
+	} else if err != nil {
+		return "", err
+	}
+
+	client.userNames.Store(userId, notionCachedName{name: user.Name, fetchedAt: time.Now()})
+
+	return user.Name, nil
+}
+
+// renderDiscussions renders discussions as Markdown, discussions on blocks quote the block they're attached to
+func (client *NotionAPIClientImpl) renderDiscussions(ctx context.Context, integration NotionIntegrationConnection, discussions []notionDiscussion) (string, error) {
+	var sb strings.Builder
+	for _, discussion := range discussions {
+		comments := make([]NotionComment, len(discussion.comments))
+		copy(comments, discussion.comments)
+		// Timestamps are ISO 8601, so they sort lexicographically
+		sort.SliceStable(comments, func(i, j int) bool {
+			return comments[i].CreatedTime < comments[j].CreatedTime
+		})
+
+		excerpt := strings.Join(strings.Fields(discussion.context), " ")
+		if len(excerpt) > notionExcerptLength {
+			excerpt = strings.ToValidUTF8(excerpt[:notionExcerptLength], "") + "…"
+		}
+
+		if excerpt != "" {
+			sb.WriteString("\n### Discussion\n\n> " + excerpt + "\n")
+		} else {
+			sb.WriteString("\n### Discussion\n")
+		}
+
+		for _, comment := range comments {
+			author := comment.CreatedBy.Name
+			if author == "" {
+				var err error
+				author, err = client.getUserName(ctx, integration, comment.CreatedBy.Id)
+				if err != nil {
+					return "", fmt.Errorf("unable to get user, %w", err)
+				}
+			}
+			if author == "" {
+				author = "Unknown"
+			}
+
+			sb.WriteString("\n**" + author + "** (" + comment.CreatedTime + "):\n\n")
+			sb.WriteString(strings.TrimSpace(renderRichText(comment.RichText)) + "\n")
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// getPageComments renders the discussions on a page, blocks are passed if the page was already rendered. Returns an
+// empty string if the page has no discussions or the integration may not read comments.
+func (client *NotionAPIClientImpl) getPageComments(ctx context.Context, integration NotionIntegrationConnection, pageId string, blocks []notionBlockExcerpt) (string, error) {
+	discussions, err := client.listDiscussions(ctx, integration, pageId, blocks)
+	if errors.Is(err, errNotionForbidden) {
+		client.logger.Printf("Skipping Notion comments for page %q, integration may not read comments\n", pageId)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to list comments, %w", err)
+	}
+	if len(discussions) == 0 {
+		return "", nil
+	}
+
+	rendered, err := client.renderDiscussions(ctx, integration, discussions)
+	if err != nil {
+		return "", err
+	}
+
+	return "\n## Comments\n" + rendered, nil
+}
+
+// getCommentsDocumentContent renders the discussions of a page as a document of its own
+func (client *NotionAPIClientImpl) getCommentsDocumentContent(ctx context.Context, integration NotionIntegrationConnection, id string) (string, map[string]any, error) {
+	pageId := strings.TrimSuffix(id, notionCommentsIdSuffix)
+
+	page, err := client.getPage(ctx, integration, pageId)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to get page, %w", err)
+	}
+
+	// Blocks are only rendered to find out which of them have comments
+	renderer := &notionRenderer{client: client, integration: integration, recordBlocks: true}
+	_, err = renderer.renderPage(ctx, pageId)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to render page, %w", err)
+	}
+
+	comments, err := client.getPageComments(ctx, integration, pageId, renderer.blocks)
+	if err != nil {
+		return "", nil, err
+	}
+	if comments == "" {
+		return "", nil, &SkippedDocumentError{Reason: "no_comments"}
+	}
+
+	title := extractTitle(page.Properties)
+	metadata := map[string]any{
+		"title":   "Comments on " + title,
+		"page_id": page.Id,
+	}
+
+	return "# Comments on " + title + "\n" + comments, metadata, nil
+}
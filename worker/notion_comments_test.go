@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNotionCommentsFreshness(t *testing.T) {
+	discussions := []notionDiscussion{
+		{comments: []NotionComment{
+			{CreatedTime: "2024-03-01T10:00:00.000Z", LastEditedTime: "2024-03-01T10:00:00.000Z"},
+			{CreatedTime: "2024-03-02T10:00:00.000Z", LastEditedTime: "2024-03-04T10:00:00.000Z"},
+		}},
+		{comments: []NotionComment{
+			{CreatedTime: "2024-03-03T10:00:00.000Z", LastEditedTime: "2024-03-03T10:00:00.000Z"},
+		}},
+	}
+
+	freshness, editedAt := notionCommentsFreshness(discussions)
+	if want := "2024-03-04T10:00:00.000Z/3"; freshness != want {
+		t.Errorf("freshness = %q, want %q", freshness, want)
+	}
+	if want := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC); !editedAt.Equal(want) {
+		t.Errorf("editedAt = %v, want %v", editedAt, want)
+	}
+
+	// Resolving a comment doesn't add a newer one, but changes the freshness nonetheless
+	resolved, _ := notionCommentsFreshness(discussions[:1])
+	if resolved == freshness {
+		t.Errorf("freshness didn't change after a discussion was resolved")
+	}
+
+	none, editedAt := notionCommentsFreshness(nil)
+	if none == "" || none == freshness || !editedAt.IsZero() {
+		t.Errorf("freshness without comments = %q, %v", none, editedAt)
+	}
+}
+
+func TestNotionCommentsDocuments(t *testing.T) {
+	workspace := &testNotionWorkspace{
+		pages: map[string]NotionParent{
+			"root":  {Type: "workspace"},
+			"child": {Type: "page_id", PageId: "root"},
+		},
+		children: map[string][]map[string]any{
+			"root": {{"id": "child", "type": "child_page", "child_page": map[string]any{"title": "child"}}},
+		},
+		comments: map[string][]NotionComment{
+			"root": {{Id: "c1", DiscussionId: "d1", CreatedTime: "2024-03-01T10:00:00.000Z", LastEditedTime: "2024-03-01T10:00:00.000Z"}},
+		},
+	}
+
+	client := newTestNotionClient(t, workspace)
+	client.commentsPolled = true
+	dataSource := &PipelineDataSource{}
+	dataSource.NotionDataSource.Config.RootPageIds = []string{"root"}
+	dataSource.NotionDataSource.Config.Comments = NotionCommentsDocuments
+	integration := IntegrationConnection{}
+	integration.Integration = IntegrationNotion
+	ctx := context.Background()
+
+	// Without indexed documents the comments of every page are listed
+	docs, _, err := client.ListDocumentsSince(ctx, dataSource, integration, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := documentKeys(docs); len(keys) != 4 || docs["root/comments"].FreshnessIndicator != "2024-03-01T10:00:00.000Z/1" || docs["child/comments"].FreshnessIndicator != "none" {
+		t.Fatalf("got documents %v", docs)
+	}
+	if workspace.commentRequests["root"] != 1 || workspace.commentRequests["child"] != 1 {
+		t.Errorf("got comment requests %v", workspace.commentRequests)
+	}
+
+	// Comments of unchanged pages are taken from the indexed documents, comments of changed pages are listed again
+	indexed := map[string]string{
+		"page/root":               docs["root"].freshness(),
+		"comments/root/comments":  docs["root/comments"].freshness(),
+		"page/child":              "changed",
+		"comments/child/comments": docs["child/comments"].freshness(),
+	}
+	workspace.commentRequests = nil
+	again, _, err := client.ListDocumentsSince(ctx, dataSource, integration, "", indexed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workspace.commentRequests["root"] != 0 || workspace.commentRequests["child"] != 1 {
+		t.Errorf("got comment requests %v", workspace.commentRequests)
+	}
+	for _, id := range []string{"root/comments", "child/comments"} {
+		if again[id].freshness() != docs[id].freshness() || again[id].DocumentType != NotionDocumentTypeComments {
+			t.Errorf("got comments document %+v, want %+v", again[id], docs[id])
+		}
+	}
+
+	// Without the poller, comments can only be compared by listing them
+	client.commentsPolled = false
+	workspace.commentRequests = nil
+	_, _, err = client.ListDocumentsSince(ctx, dataSource, integration, "", indexed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workspace.commentRequests["root"] != 1 {
+		t.Errorf("got comment requests %v", workspace.commentRequests)
+	}
+}
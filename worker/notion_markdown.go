@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// notionRenderer converts the block tree of a page to Markdown, the same way notion-to-md did in the node helper.
+// Child pages and databases are not descended into, they're indexed as separate documents.
+type notionRenderer struct {
+	client      *NotionAPIClientImpl
+	integration NotionIntegrationConnection
+
+	// If recordBlocks is set, all rendered blocks are recorded so their comments can be retrieved
+	recordBlocks bool
+	blocks       []notionBlockExcerpt
+	// If recordFiles is set, blocks of files uploaded to Notion are recorded so they can be indexed as attachments
+	recordFiles bool
+	files       []NotionBlock
+}
+
+// renderRichText renders rich text with annotations and links as inline Markdown
+func renderRichText(richText []RichText) string {
+	var sb strings.Builder
+	for _, text := range richText {
+		content := text.PlainText
+		if text.Type == "equation" && text.Equation != nil {
+			sb.WriteString("$" + text.Equation.Expression + "$")
+			continue
+		}
+		if strings.TrimSpace(content) == "" {
+			sb.WriteString(content)
+			continue
+		}
+
+		// Markers must be adjacent to the text, so surrounding whitespace is moved outside
+		trimmed := strings.TrimSpace(content)
+		leading := content[:strings.Index(content, trimmed)]
+		trailing := content[len(leading)+len(trimmed):]
+
+		if text.Annotations.Code {
+			trimmed = "`" + trimmed + "`"
+		}
+		if text.Annotations.Bold {
+			trimmed = "**" + trimmed + "**"
+		}
+		if text.Annotations.Italic {
+			trimmed = "_" + trimmed + "_"
+		}
+		if text.Annotations.Strikethrough {
+			trimmed = "~~" + trimmed + "~~"
+		}
+		if text.Href != nil && *text.Href != "" {
+			trimmed = "[" + trimmed + "](" + *text.Href + ")"
+		}
+
+		sb.WriteString(leading + trimmed + trailing)
+	}
+	return sb.String()
+}
+
+func notionPageURL(id string) string {
+	return "https://www.notion.so/" + normalizeNotionId(id)
+}
+
+// indentLines prefixes all lines but the first with indent
+func indentLines(text, indent string) string {
+	return strings.ReplaceAll(text, "\n", "\n"+indent)
+}
+
+func (renderer *notionRenderer) listChildren(ctx context.Context, blockId string) ([]NotionBlock, error) {
+	var blocks []NotionBlock
+	err := renderer.client.listBlockChildren(ctx, renderer.integration, blockId, func(block NotionBlock) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list children of block %q, %w", blockId, err)
+	}
+	return blocks, nil
+}
+
+func (renderer *notionRenderer) renderPage(ctx context.Context, pageId string) (string, error) {
+	var sb strings.Builder
+	err := renderer.renderChildren(ctx, &sb, pageId, "", 0)
+	if err != nil {
+		return "", err
+	}
+
+	return collapseBlankLines(sb.String()), nil
+}
+
+// renderChildren renders the children of a block, every line is prefixed with indent
+func (renderer *notionRenderer) renderChildren(ctx context.Context, sb *strings.Builder, blockId, indent string, depth int) error {
+	if depth >= notionMaxDepth {
+		return nil
+	}
+
+	blocks, err := renderer.listChildren(ctx, blockId)
+	if err != nil {
+		return err
+	}
+
+	number := 0
+	previousListType := ""
+	for _, block := range blocks {
+		if block.Type == "numbered_list_item" {
+			number++
+		} else {
+			number = 0
+		}
+
+		// List items are only separated by a single newline, so a blank line is needed where a list ends
+		listType := ""
+		if isNotionListBlock(block.Type) {
+			listType = block.Type
+		}
+		if previousListType != "" && listType != previousListType {
+			sb.WriteString("\n")
+		}
+		previousListType = listType
+
+		err = renderer.renderBlock(ctx, sb, block, indent, depth, number)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (renderer *notionRenderer) renderBlock(ctx context.Context, sb *strings.Builder, block NotionBlock, indent string, depth int, number int) error {
+	content := block.Content
+	text := renderRichText(content.RichText)
+
+	// Comments on child pages belong to the child page
+	if renderer.recordBlocks && block.Type != "child_page" && block.Type != "child_database" {
+		renderer.blocks = append(renderer.blocks, notionBlockExcerpt{id: block.Id, text: plainText(content.RichText)})
+	}
+
+	// childIndent is used for nested blocks, list items indent their children below the item
+	childIndent := indent
+	// separator is written after the block, list items are kept together
+	separator := "\n\n"
+
+	switch block.Type {
+	case "paragraph":
+		sb.WriteString(indent + indentLines(text, indent))
+	case "heading_1", "heading_2", "heading_3":
+		level := int(block.Type[len(block.Type)-1] - '0')
+		sb.WriteString(indent + strings.Repeat("#", level) + " " + text)
+	case "bulleted_list_item", "toggle":
+		sb.WriteString(indent + "- " + indentLines(text, indent+"    "))
+		childIndent = indent + "    "
+		separator = "\n"
+	case "numbered_list_item":
+		sb.WriteString(indent + fmt.Sprintf("%d. ", number) + indentLines(text, indent+"    "))
+		childIndent = indent + "    "
+		separator = "\n"
+	case "to_do":
+		checkbox := "[ ]"
+		if content.Checked {
+			checkbox = "[x]"
+		}
+		sb.WriteString(indent + "- " + checkbox + " " + indentLines(text, indent+"    "))
+		childIndent = indent + "    "
+		separator = "\n"
+	case "quote":
+		sb.WriteString(indent + "> " + indentLines(text, indent+"> "))
+		childIndent = indent + "> "
+	case "callout":
+		if content.Icon != nil && content.Icon.Emoji != "" {
+			text = content.Icon.Emoji + " " + text
+		}
+		sb.WriteString(indent + "> " + indentLines(text, indent+"> "))
+		childIndent = indent + "> "
+	case "code":
+		code := plainText(content.RichText)
+		language := content.Language
+		if language == "plain text" {
+			language = ""
+		}
+		sb.WriteString(indent + "```" + language + "\n" + indent + indentLines(code, indent) + "\n" + indent + "```")
+	case "equation":
+		sb.WriteString(indent + "$$\n" + indent + indentLines(content.Expression, indent) + "\n" + indent + "$$")
+	case "divider":
+		sb.WriteString(indent + "---")
+	case "table":
+		table, err := renderer.renderTable(ctx, block)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(indent + indentLines(strings.TrimSpace(table), indent))
+		sb.WriteString(separator)
+		return nil
+	case "synced_block":
+		// Copies of synced blocks hold no children themselves, the content is stored in the original block
+		source := block.Id
+		if content.SyncedFrom != nil {
+			source = content.SyncedFrom.BlockId
+		}
+		err := renderer.renderChildren(ctx, sb, source, indent, depth+1)
+		if errors.Is(err, errNotionNotFound) {
+			// The original block is on a page that isn't shared with the integration
+			return nil
+		}
+		return err
+	case "column_list", "column":
+		return renderer.renderChildren(ctx, sb, block.Id, indent, depth+1)
+	case "child_page", "child_database":
+		sb.WriteString(indent + "[" + content.Title + "](" + notionPageURL(block.Id) + ")")
+		sb.WriteString(separator)
+		return nil
+	case "link_to_page":
+		target := content.PageId
+		if target == "" {
+			target = content.DatabaseId
+		}
+		if target == "" {
+			return nil
+		}
+		sb.WriteString(indent + "[Link to page](" + notionPageURL(target) + ")")
+	case "bookmark", "embed", "link_preview":
+		if content.URL == "" {
+			return nil
+		}
+		title := renderRichText(content.Caption)
+		if title == "" {
+			title = content.URL
+		}
+		sb.WriteString(indent + "[" + title + "](" + content.URL + ")")
+	case "image", "file", "pdf", "video", "audio":
+		if renderer.recordFiles && (block.Type == "file" || block.Type == "pdf") && content.File != nil {
+			renderer.files = append(renderer.files, block)
+		}
+
+		// Files uploaded to Notion have signed URLs which expire after an hour, so only external URLs are rendered
+		title := renderRichText(content.Caption)
+		if title == "" {
+			title = content.Name
+		}
+		if content.External != nil && content.External.URL != "" {
+			if title == "" {
+				title = content.External.URL
+			}
+			sb.WriteString(indent + "[" + title + "](" + content.External.URL + ")")
+		} else if title != "" {
+			sb.WriteString(indent + title)
+		} else {
+			return nil
+		}
+	default:
+		// Unsupported blocks (breadcrumbs, tables of contents, etc.) have no content worth indexing
+		if !block.HasChildren {
+			return nil
+		}
+	}
+
+	sb.WriteString(separator)
+
+	if block.HasChildren {
+		err := renderer.renderChildren(ctx, sb, block.Id, childIndent, depth+1)
+		if err != nil {
+			return err
+		}
+		if separator != "\n" {
+			sb.WriteString("\n")
+		}
+	}
+
+	return nil
+}
+
+func isNotionListBlock(blockType string) bool {
+	return blockType == "bulleted_list_item" || blockType == "numbered_list_item" || blockType == "to_do" || blockType == "toggle"
+}
+
+func (renderer *notionRenderer) renderTable(ctx context.Context, block NotionBlock) (string, error) {
+	rowBlocks, err := renderer.listChildren(ctx, block.Id)
+	if err != nil {
+		return "", err
+	}
+
+	var rows [][]string
+	for _, rowBlock := range rowBlocks {
+		if rowBlock.Type != "table_row" {
+			continue
+		}
+
+		row := make([]string, 0, len(rowBlock.Content.Cells))
+		for _, cell := range rowBlock.Content.Cells {
+			text := renderRichText(cell)
+			text = strings.ReplaceAll(text, "|", "\\|")
+			text = strings.ReplaceAll(text, "\n", " ")
+			row = append(row, text)
+		}
+		rows = append(rows, row)
+	}
+
+	// Markdown tables always have a header, add an empty one if the first row isn't one
+	if !block.Content.HasColumnHeader && len(rows) > 0 {
+		rows = append([][]string{make([]string, len(rows[0]))}, rows...)
+	}
+
+	return markdownTable(rows), nil
+}
+
+// collapseBlankLines removes trailing whitespace and collapses runs of blank lines outside of code blocks
+func collapseBlankLines(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	result := make([]string, 0, len(lines))
+	inCodeBlock := false
+	blank := 0
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCodeBlock = !inCodeBlock
+		}
+		if !inCodeBlock {
+			line = strings.TrimRight(line, " \t")
+		}
+
+		if line == "" && !inCodeBlock {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+
+		result = append(result, line)
+	}
+
+	return strings.TrimSpace(strings.Join(result, "\n")) + "\n"
+}
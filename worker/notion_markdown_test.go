@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func TestNotionRenderPageGolden(t *testing.T) {
+	data, err := os.ReadFile("testdata/notion_page.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace := &testNotionWorkspace{}
+	err = json.Unmarshal(data, &workspace.children)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renderer := &notionRenderer{client: newTestNotionClient(t, workspace), recordFiles: true}
+	markdown, err := renderer.renderPage(context.Background(), "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const golden = "testdata/notion_page.md"
+	if *updateGolden {
+		err = os.WriteFile(golden, []byte(markdown), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if markdown != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", markdown, want)
+	}
+
+	if len(renderer.files) != 1 || renderer.files[0].Id != "pdf" {
+		t.Errorf("recorded files %+v, want the uploaded PDF", renderer.files)
+	}
+}
+
+func TestRenderRichText(t *testing.T) {
+	href := "https://example.com"
+	text := func(content string, annotate func(text *RichText)) RichText {
+		richText := RichText{Type: "text", PlainText: content}
+		if annotate != nil {
+			annotate(&richText)
+		}
+		return richText
+	}
+
+	tests := []struct {
+		name     string
+		richText []RichText
+		want     string
+	}{
+		{name: "plain", richText: []RichText{text("Hello", nil), text(" world", nil)}, want: "Hello world"},
+		{name: "bold", richText: []RichText{text("bold", func(text *RichText) { text.Annotations.Bold = true })}, want: "**bold**"},
+		{name: "italic", richText: []RichText{text("italic", func(text *RichText) { text.Annotations.Italic = true })}, want: "_italic_"},
+		{name: "strikethrough", richText: []RichText{text("gone", func(text *RichText) { text.Annotations.Strikethrough = true })}, want: "~~gone~~"},
+		{name: "code", richText: []RichText{text("x := 1", func(text *RichText) { text.Annotations.Code = true })}, want: "`x := 1`"},
+		{
+			name: "combined",
+			richText: []RichText{text("all", func(text *RichText) {
+				text.Annotations.Bold = true
+				text.Annotations.Italic = true
+				text.Annotations.Code = true
+			})},
+			want: "_**`all`**_",
+		},
+		{name: "whitespace outside markers", richText: []RichText{text("a", nil), text(" b ", func(text *RichText) { text.Annotations.Bold = true }), text("c", nil)}, want: "a **b** c"},
+		{name: "whitespace only", richText: []RichText{text("a", nil), text("  ", func(text *RichText) { text.Annotations.Bold = true }), text("b", nil)}, want: "a  b"},
+		{name: "link", richText: []RichText{text(" docs ", func(text *RichText) { text.Href = &href })}, want: " [docs](https://example.com) "},
+		{name: "bold link", richText: []RichText{text("docs", func(text *RichText) { text.Href = &href; text.Annotations.Bold = true })}, want: "[**docs**](https://example.com)"},
+		{
+			name: "equation",
+			richText: []RichText{text("x^2", func(text *RichText) {
+				text.Type = "equation"
+				text.Equation = &struct {
+					Expression string `json:"expression"`
+				}{Expression: "x^2"}
+			})},
+			want: "$x^2$",
+		},
+		{name: "empty", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := renderRichText(test.richText); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCollapseBlankLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{name: "single line", markdown: "text", want: "text\n"},
+		{name: "blank lines", markdown: "a\n\n\n\nb\n", want: "a\n\nb\n"},
+		{name: "trailing whitespace", markdown: "a  \n \t\n\nb\t", want: "a\n\nb\n"},
+		{name: "surrounding blank lines", markdown: "\n\n a\n\n", want: "a\n"},
+		{name: "code blocks are kept", markdown: "```\nx  \n\n\n\ny\n```\n\n\nz", want: "```\nx  \n\n\n\ny\n```\n\nz\n"},
+		{name: "indented code blocks", markdown: "- item\n    ```go\n    a\n\n\n    ```\n", want: "- item\n    ```go\n    a\n\n\n    ```\n"},
+		{name: "empty", markdown: "", want: "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := collapseBlankLines(test.markdown); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// Connections are checked for due polls this often, the poll interval itself is configurable
+	notionPollTick = 30 * time.Second
+	// Notion rounds last_edited_time to the minute, so pages edited shortly before the cursor are checked again
+	notionPollOverlap = 2 * time.Minute
+	// Trashed pages are no longer returned by search, so indexed pages are reconciled against all shared pages
+	notionReconcileInterval = time.Hour
+	notionPollBatchSize     = 10
+	// If more pages changed than this, a full index run is cheaper than one run per page
+	notionPollMaxChanges = 250
+)
+
+var errNotionPollCaughtUp = errors.New("caught up")
+
+// notionPoller detects changes to Notion pages as Notion doesn't send webhooks to public integrations. Recently edited
+// pages are compared against the freshness indicators of indexed documents, changes are dispatched as single document
+// runs just like Linear webhooks.
+type notionPoller struct {
+	logger      logrus.FieldLogger
+	pool        *pgxpool.Pool
+	sqsClient   *sqs.Client
+	queueUrl    string
+	client      *NotionAPIClientImpl
+	secrets     *Secrets
+	credentials *CredentialManager
+	interval    time.Duration
+	quotas      *QuotaManager
+}
+
+// notionPollTarget is an enabled Notion data source of a pipeline
+type notionPollTarget struct {
+	pipeline   Pipeline
+	dataSource PipelineDataSource
+	changes    map[string]DocumentChange
+}
+
+func startNotionPoller(ctx context.Context, logger logrus.FieldLogger, pool *pgxpool.Pool, sqsClient *sqs.Client, queueUrl string, client *NotionAPIClientImpl, secrets *Secrets, credentials *CredentialManager, interval time.Duration, quotas *QuotaManager) {
+	poller := &notionPoller{
+		logger:      logger,
+		pool:        pool,
+		sqsClient:   sqsClient,
+		queueUrl:    queueUrl,
+		client:      client,
+		secrets:     secrets,
+		credentials: credentials,
+		interval:    interval,
+		quotas:      quotas,
+	}
+
+	logger.Printf("Starting Notion poller with interval %s.\n", interval)
+
+	go func() {
+		tick := notionPollTick
+		if interval < tick {
+			tick = interval
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Println("Exiting Notion poller.")
+				return
+			case <-ticker.C:
+				poller.pollDue(ctx)
+			}
+		}
+	}()
+}
+
+// pollDue polls all connections which are due, other workers may claim connections at the same time
+func (poller *notionPoller) pollDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		states, err := ClaimIntegrationConnectionsForPolling(ctx, poller.pool, IntegrationNotion, poller.interval, notionPollBatchSize)
+		if err != nil {
+			poller.logger.Printf("Unable to claim Notion connections for polling, %v\n", err)
+			return
+		}
+		if len(states) == 0 {
+			return
+		}
+
+		for _, state := range states {
+			err = poller.poll(ctx, state)
+			if err != nil {
+				poller.logger.Printf("Unable to poll Notion changes for account %q, %v\n", state.AccountId, err)
+			}
+		}
+	}
+}
+
+func (poller *notionPoller) poll(ctx context.Context, state IntegrationPollState) (err error) {
+	account, err := GetAccount(ctx, poller.pool, state.AccountId)
+	if err != nil {
+		return fmt.Errorf("unable to get account, %w", err)
+	}
+	if account == nil || account.IsSuspended {
+		return nil
+	}
+
+	integrationConnection, err := GetIntegrationConnection(ctx, poller.pool, poller.secrets, state.AccountId, IntegrationNotion)
+	if err != nil {
+		return fmt.Errorf("unable to get integration connection, %w", err)
+	}
+	if integrationConnection == nil {
+		return nil
+	}
+
+	// Connections whose credentials were rejected aren't polled until they're connected again
+	defer func() {
+		_, markErr := poller.credentials.MarkUnauthorized(ctx, integrationConnection, err)
+		if markErr != nil {
+			poller.logger.Printf("Unable to mark Notion connection of account %q, %v\n", state.AccountId, markErr)
+		}
+	}()
+
+	err = poller.credentials.EnsureFresh(ctx, integrationConnection)
+	if err != nil {
+		return fmt.Errorf("unable to refresh credentials, %w", err)
+	}
+
+	integration := integrationConnection.NotionIntegrationConnection
+
+	pipelines, err := GetPipelines(ctx, poller.pool, poller.secrets, state.AccountId)
+	if err != nil {
+		return fmt.Errorf("unable to get pipelines, %w", err)
+	}
+
+	targets := make([]*notionPollTarget, 0)
+	for _, pipeline := range pipelines {
+		for _, dataSource := range pipeline.Config.DataSources {
+			if dataSource.IntegrationName != IntegrationNotion || !dataSource.IsEnabled {
+				continue
+			}
+			targets = append(targets, &notionPollTarget{
+				pipeline:   pipeline,
+				dataSource: dataSource,
+				changes:    make(map[string]DocumentChange),
+			})
+		}
+	}
+
+	startedAt := time.Now()
+
+	// Without a cursor there's nothing to compare against yet, earlier edits are picked up by full index runs
+	if len(targets) == 0 || state.Cursor == nil {
+		state.Cursor = &startedAt
+		return UpdateIntegrationPollState(ctx, poller.pool, IntegrationNotion, state)
+	}
+
+	// Polling is skipped while the quota is exceeded. The cursor stays where it is, so changes made in the meantime are
+	// picked up once there's quota again.
+	quota, err := poller.quotas.ForAccount(ctx, *account)
+	if err != nil {
+		return fmt.Errorf("unable to load quotas, %w", err)
+	}
+	if reason := quota.Exhausted(); reason != "" {
+		poller.logger.Printf("Account %q reached its %s so we won't poll Notion changes\n", account.Id, reason)
+		return nil
+	}
+
+	err = poller.client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return err
+	}
+	defer poller.client.sema.Release(1)
+
+	pages, err := poller.listEditedPages(ctx, integration, state.Cursor.Add(-notionPollOverlap))
+	if err != nil {
+		return fmt.Errorf("unable to list edited pages, %w", err)
+	}
+
+	for _, target := range targets {
+		scope := newNotionScope(poller.client, integration, &target.dataSource)
+		for _, page := range pages {
+			err = poller.diff(ctx, integration, target, scope, page)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if state.ReconciledAt == nil || startedAt.Sub(*state.ReconciledAt) >= notionReconcileInterval {
+		err = poller.reconcile(ctx, integration, targets)
+		if err != nil {
+			return fmt.Errorf("unable to reconcile pages, %w", err)
+		}
+		state.ReconciledAt = &startedAt
+	}
+
+	err = poller.dispatch(ctx, targets)
+	if err != nil {
+		return fmt.Errorf("unable to dispatch changes, %w", err)
+	}
+
+	state.Cursor = &startedAt
+	return UpdateIntegrationPollState(ctx, poller.pool, IntegrationNotion, state)
+}
+
+// listEditedPages searches pages shared with the integration which were edited after since, most recent first
+func (poller *notionPoller) listEditedPages(ctx context.Context, integration NotionIntegrationConnection, since time.Time) ([]NotionPage, error) {
+	var pages []NotionPage
+
+	err := paginate[NotionPage](func(cursor *string) (*notionList[NotionPage], error) {
+		body := map[string]any{
+			"page_size": 100,
+			"filter": map[string]any{
+				"property": "object",
+				"value":    "page",
+			},
+			"sort": map[string]any{
+				"direction": "descending",
+				"timestamp": "last_edited_time",
+			},
+		}
+		if cursor != nil {
+			body["start_cursor"] = *cursor
+		}
+
+		var list notionList[NotionPage]
+		err := poller.client.sendRequest(ctx, integration, http.MethodPost, "/search", body, &list)
+		if err != nil {
+			return nil, err
+		}
+		return &list, nil
+	}, func(page NotionPage) error {
+		lastEditedTime, err := time.Parse(time.RFC3339, page.LastEditedTime)
+		if err != nil {
+			return fmt.Errorf("unable to parse last edited time of page %q, %w", page.Id, err)
+		}
+		if lastEditedTime.Before(since) {
+			return errNotionPollCaughtUp
+		}
+
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNotionPollCaughtUp) {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// diff compares page against the indexed documents and records the changes for target, if any
+func (poller *notionPoller) diff(ctx context.Context, integration NotionIntegrationConnection, target *notionPollTarget, scope *notionScope, page NotionPage) error {
+	// Stored freshness indicators include the access list, see aclFreshness
+	err := poller.diffDocument(ctx, target, scope, page, "page", page.Id, page.toIndexedDocument(integration).freshness())
+	if err != nil {
+		return err
+	}
+
+	if notionCommentsMode(&target.dataSource) == NotionCommentsDocuments {
+		freshness := ""
+		if !page.Archived {
+			freshness, _, err = poller.client.getCommentsFreshness(ctx, integration, page.Id)
+			if err != nil {
+				return fmt.Errorf("unable to get comments of page %q, %w", page.Id, err)
+			}
+			freshness = aclFreshness(freshness, page.acl(integration))
+		}
+		return poller.diffDocument(ctx, target, scope, page, NotionDocumentTypeComments, notionCommentsId(page.Id), freshness)
+	}
+	return nil
+}
+
+// diffComments records an update of the comments document id if its comments changed, which doesn't change the page
+func (poller *notionPoller) diffComments(ctx context.Context, integration NotionIntegrationConnection, target *notionPollTarget, id string, acl []string, freshnessIndicators map[string]string, commentsFreshness map[string]string) error {
+	pageId := strings.TrimSuffix(id, notionCommentsIdSuffix)
+	normalizedId := normalizeNotionId(pageId)
+
+	freshness, ok := commentsFreshness[normalizedId]
+	if !ok {
+		var err error
+		freshness, _, err = poller.client.getCommentsFreshness(ctx, integration, pageId)
+		// Pages trashed since they were listed are removed by the next reconcile
+		if errors.Is(err, errNotionNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to get comments of page %q, %w", pageId, err)
+		}
+		commentsFreshness[normalizedId] = freshness
+	}
+
+	if freshnessIndicators[NotionDocumentTypeComments+"/"+id] != aclFreshness(freshness, acl) {
+		target.changes[id] = DocumentChange{Action: ChangeActionUpdate, DocumentId: id, DocumentType: NotionDocumentTypeComments}
+	}
+	return nil
+}
+
+func (poller *notionPoller) diffDocument(ctx context.Context, target *notionPollTarget, scope *notionScope, page NotionPage, documentType, id, freshness string) error {
+	stored, err := GetDocumentForIntegration(ctx, poller.pool, target.pipeline.Account, target.pipeline.Id, IntegrationNotion, documentType, id)
+	if err != nil {
+		return fmt.Errorf("unable to get document, %w", err)
+	}
+
+	switch {
+	case page.Archived:
+		if stored != nil {
+			target.changes[id] = DocumentChange{Action: ChangeActionDelete, DocumentId: id, DocumentType: documentType}
+		}
+	case stored != nil:
+		if stored.FreshnessIndicator == nil || *stored.FreshnessIndicator != freshness {
+			// Pages which moved out of scope are removed by the change run
+			target.changes[id] = DocumentChange{Action: ChangeActionUpdate, DocumentId: id, DocumentType: documentType}
+		}
+	default:
+		inScope, err := scope.contains(ctx, &page)
+		if err != nil {
+			return fmt.Errorf("unable to check scope, %w", err)
+		}
+		if inScope {
+			target.changes[id] = DocumentChange{Action: ChangeActionCreate, DocumentId: id, DocumentType: documentType}
+		}
+	}
+
+	return nil
+}
+
+// reconcile records deletes for indexed pages which are no longer shared with the integration. Search results may
+// lag behind, so each missing page is confirmed before it's removed. Comments don't change the pages they're on, so
+// comments documents of shared pages are compared against their comments here as well.
+func (poller *notionPoller) reconcile(ctx context.Context, integration NotionIntegrationConnection, targets []*notionPollTarget) error {
+	shared, err := poller.client.listAllPages(ctx, integration, newNotionScope(poller.client, integration, nil))
+	if err != nil {
+		return err
+	}
+
+	// sharedACLs holds the access list of every shared page by normalized id
+	sharedACLs := make(map[string][]string, len(shared))
+	for id, page := range shared {
+		sharedACLs[normalizeNotionId(id)] = page.ACL
+	}
+
+	// gone and commentsFreshness cache confirmations and comments, as pipelines often index the same pages
+	gone := make(map[string]bool)
+	commentsFreshness := make(map[string]string)
+
+	for _, target := range targets {
+		var freshnessIndicators map[string]string
+		if notionCommentsMode(&target.dataSource) == NotionCommentsDocuments {
+			freshnessIndicators, err = GetDocumentFreshnessIndicators(ctx, poller.pool, target.pipeline.Account, target.pipeline.Id, IntegrationNotion)
+			if err != nil {
+				return fmt.Errorf("unable to get freshness indicators, %w", err)
+			}
+		}
+
+		for _, documentType := range []string{"page", NotionDocumentTypeComments} {
+			ids, err := GetDocumentIds(ctx, poller.pool, target.pipeline.Account, target.pipeline.Id, IntegrationNotion, documentType)
+			if err != nil {
+				return fmt.Errorf("unable to get document ids, %w", err)
+			}
+
+			for _, id := range ids {
+				pageId := strings.TrimSuffix(id, notionCommentsIdSuffix)
+				normalizedId := normalizeNotionId(pageId)
+				if acl, ok := sharedACLs[normalizedId]; ok {
+					if freshnessIndicators == nil || documentType != NotionDocumentTypeComments {
+						continue
+					}
+					if _, ok := target.changes[id]; ok {
+						continue
+					}
+					err = poller.diffComments(ctx, integration, target, id, acl, freshnessIndicators, commentsFreshness)
+					if err != nil {
+						return err
+					}
+					continue
+				}
+
+				isGone, ok := gone[normalizedId]
+				if !ok {
+					page, err := poller.client.getPage(ctx, integration, pageId)
+					if err != nil && !errors.Is(err, errNotionNotFound) {
+						return fmt.Errorf("unable to get page %q, %w", pageId, err)
+					}
+					isGone = err != nil || page.Archived
+					gone[normalizedId] = isGone
+				}
+
+				if isGone {
+					target.changes[id] = DocumentChange{Action: ChangeActionDelete, DocumentId: id, DocumentType: documentType}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// dispatch creates a run for every change and sends the index messages
+func (poller *notionPoller) dispatch(ctx context.Context, targets []*notionPollTarget) error {
+	indexMessages := make([]IndexMessage, 0)
+
+	addRun := func(target *notionPollTarget, trigger PipelineRunTrigger, syncMode SyncMode, changeEvent *IntegrationChangeEvent) error {
+		runId, err := CreatePipelineRun(ctx, poller.pool, target.pipeline.Id, trigger, syncMode, changeEvent)
+		if err != nil {
+			return fmt.Errorf("unable to create pipeline run, %w", err)
+		}
+
+		err = CreatePipelineRunStep(ctx, poller.pool, target.pipeline.Id, runId, target.dataSource.Id)
+		if err != nil {
+			return fmt.Errorf("unable to create pipeline run step, %w", err)
+		}
+
+		indexMessages = append(indexMessages, IndexMessage{
+			Kind:      "index",
+			AccountId: target.pipeline.Account,
+			MessageId: fmt.Sprintf("%s-%s-%s", target.pipeline.Id, runId, target.dataSource.Id),
+			Payload: IndexMessagePayload{
+				PipelineId:   target.pipeline.Id,
+				RunId:        runId,
+				DataSourceId: target.dataSource.Id,
+			},
+		})
+		return nil
+	}
+
+	for _, target := range targets {
+		if len(target.changes) == 0 {
+			continue
+		}
+
+		if len(target.changes) > notionPollMaxChanges {
+			poller.logger.Printf("Found %d Notion changes for pipeline %q, dispatching full index\n", len(target.changes), target.pipeline.Id)
+
+			err := addRun(target, PipelineRunTriggerSystem, FullIndexSyncMode, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		poller.logger.Printf("Found %d Notion changes for pipeline %q\n", len(target.changes), target.pipeline.Id)
+
+		for _, change := range target.changes {
+			err := addRun(target, PipelineRunTriggerIntegrationChangeEvent, SingleDocumentSyncMode, &IntegrationChangeEvent{
+				Integration: IntegrationNotion,
+				Change:      change,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(indexMessages) == 0 {
+		return nil
+	}
+
+	return sendIndexMessages(ctx, poller.sqsClient, poller.queueUrl, indexMessages)
+}
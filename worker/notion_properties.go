@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// https://developers.notion.com/reference/page-property-values
+
+type NotionSelectOption struct {
+	Name string `json:"name"`
+}
+
+type NotionDate struct {
+	Start    string  `json:"start"`
+	End      *string `json:"end"`
+	TimeZone *string `json:"time_zone"`
+}
+
+func (date NotionDate) String() string {
+	if date.End != nil && *date.End != "" {
+		return date.Start + " → " + *date.End
+	}
+	return date.Start
+}
+
+type NotionUser struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Person *struct {
+		Email string `json:"email"`
+	} `json:"person"`
+}
+
+type NotionFile struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // file or external
+	File *struct {
+		URL        string `json:"url"`
+		ExpiryTime string `json:"expiry_time"`
+	} `json:"file"`
+	External *struct {
+		URL string `json:"url"`
+	} `json:"external"`
+}
+
+type NotionFormula struct {
+	Type    string      `json:"type"` // string, number, boolean or date
+	String  *string     `json:"string"`
+	Number  *float64    `json:"number"`
+	Boolean *bool       `json:"boolean"`
+	Date    *NotionDate `json:"date"`
+}
+
+type NotionRollup struct {
+	Type     string                `json:"type"` // number, date or array
+	Number   *float64              `json:"number"`
+	Date     *NotionDate           `json:"date"`
+	Array    []NotionPropertyValue `json:"array"`
+	Function string                `json:"function"`
+}
+
+type NotionPropertyValue struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+
+	Title       []RichText           `json:"title"`
+	RichText    []RichText           `json:"rich_text"`
+	Number      *float64             `json:"number"`
+	Select      *NotionSelectOption  `json:"select"`
+	MultiSelect []NotionSelectOption `json:"multi_select"`
+	Status      *NotionSelectOption  `json:"status"`
+	Date        *NotionDate          `json:"date"`
+	People      []NotionUser         `json:"people"`
+	Relation    []struct {
+		Id string `json:"id"`
+	} `json:"relation"`
+	Checkbox       bool           `json:"checkbox"`
+	URL            *string        `json:"url"`
+	Email          *string        `json:"email"`
+	PhoneNumber    *string        `json:"phone_number"`
+	Formula        *NotionFormula `json:"formula"`
+	Rollup         *NotionRollup  `json:"rollup"`
+	Files          []NotionFile   `json:"files"`
+	CreatedTime    string         `json:"created_time"`
+	LastEditedTime string         `json:"last_edited_time"`
+	CreatedBy      *NotionUser    `json:"created_by"`
+	LastEditedBy   *NotionUser    `json:"last_edited_by"`
+	UniqueId       *struct {
+		Prefix *string  `json:"prefix"`
+		Number *float64 `json:"number"`
+	} `json:"unique_id"`
+}
+
+func plainText(richText []RichText) string {
+	var sb strings.Builder
+	for _, text := range richText {
+		sb.WriteString(text.PlainText)
+	}
+	return sb.String()
+}
+
+func formatNumber(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+func userNames(users []NotionUser) []string {
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		if user.Name != "" {
+			names = append(names, user.Name)
+		}
+	}
+	return names
+}
+
+// value returns the property value as a string, number, bool or list of strings, which are the types supported by
+// vector store metadata filters. Returns false for empty values.
+func (property NotionPropertyValue) value() (any, bool) {
+	switch property.Type {
+	case "title":
+		return nonEmptyString(plainText(property.Title))
+	case "rich_text":
+		return nonEmptyString(plainText(property.RichText))
+	case "number":
+		if property.Number == nil {
+			return nil, false
+		}
+		return *property.Number, true
+	case "select":
+		if property.Select == nil {
+			return nil, false
+		}
+		return nonEmptyString(property.Select.Name)
+	case "status":
+		if property.Status == nil {
+			return nil, false
+		}
+		return nonEmptyString(property.Status.Name)
+	case "multi_select":
+		names := make([]string, 0, len(property.MultiSelect))
+		for _, option := range property.MultiSelect {
+			names = append(names, option.Name)
+		}
+		return nonEmptyList(names)
+	case "date":
+		if property.Date == nil {
+			return nil, false
+		}
+		return nonEmptyString(property.Date.String())
+	case "people":
+		return nonEmptyList(userNames(property.People))
+	case "relation":
+		ids := make([]string, 0, len(property.Relation))
+		for _, relation := range property.Relation {
+			ids = append(ids, relation.Id)
+		}
+		return nonEmptyList(ids)
+	case "checkbox":
+		return property.Checkbox, true
+	case "url":
+		return nonEmptyStringPtr(property.URL)
+	case "email":
+		return nonEmptyStringPtr(property.Email)
+	case "phone_number":
+		return nonEmptyStringPtr(property.PhoneNumber)
+	case "formula":
+		if property.Formula == nil {
+			return nil, false
+		}
+		switch property.Formula.Type {
+		case "string":
+			return nonEmptyStringPtr(property.Formula.String)
+		case "number":
+			if property.Formula.Number == nil {
+				return nil, false
+			}
+			return *property.Formula.Number, true
+		case "boolean":
+			if property.Formula.Boolean == nil {
+				return nil, false
+			}
+			return *property.Formula.Boolean, true
+		case "date":
+			if property.Formula.Date == nil {
+				return nil, false
+			}
+			return nonEmptyString(property.Formula.Date.String())
+		}
+	case "rollup":
+		if property.Rollup == nil {
+			return nil, false
+		}
+		switch property.Rollup.Type {
+		case "number":
+			if property.Rollup.Number == nil {
+				return nil, false
+			}
+			return *property.Rollup.Number, true
+		case "date":
+			if property.Rollup.Date == nil {
+				return nil, false
+			}
+			return nonEmptyString(property.Rollup.Date.String())
+		case "array":
+			// Arrays are flattened to strings, lists of mixed types aren't supported by metadata filters
+			values := make([]string, 0, len(property.Rollup.Array))
+			for _, item := range property.Rollup.Array {
+				if text, ok := item.text(); ok {
+					values = append(values, text)
+				}
+			}
+			return nonEmptyList(values)
+		}
+	case "files":
+		names := make([]string, 0, len(property.Files))
+		for _, file := range property.Files {
+			names = append(names, file.Name)
+		}
+		return nonEmptyList(names)
+	case "created_time":
+		return nonEmptyString(property.CreatedTime)
+	case "last_edited_time":
+		return nonEmptyString(property.LastEditedTime)
+	case "created_by":
+		if property.CreatedBy == nil {
+			return nil, false
+		}
+		return nonEmptyString(property.CreatedBy.Name)
+	case "last_edited_by":
+		if property.LastEditedBy == nil {
+			return nil, false
+		}
+		return nonEmptyString(property.LastEditedBy.Name)
+	case "unique_id":
+		if property.UniqueId == nil || property.UniqueId.Number == nil {
+			return nil, false
+		}
+		id := formatNumber(*property.UniqueId.Number)
+		if property.UniqueId.Prefix != nil && *property.UniqueId.Prefix != "" {
+			id = *property.UniqueId.Prefix + "-" + id
+		}
+		return id, true
+	}
+
+	return nil, false
+}
+
+// text renders the property value for the properties header
+func (property NotionPropertyValue) text() (string, bool) {
+	value, ok := property.value()
+	if !ok {
+		return "", false
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return formatNumber(v), true
+	case bool:
+		if v {
+			return "Yes", true
+		}
+		return "No", true
+	case []string:
+		return strings.Join(v, ", "), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func nonEmptyString(value string) (any, bool) {
+	if value == "" {
+		return nil, false
+	}
+	return value, true
+}
+
+func nonEmptyStringPtr(value *string) (any, bool) {
+	if value == nil {
+		return nil, false
+	}
+	return nonEmptyString(*value)
+}
+
+func nonEmptyList(values []string) (any, bool) {
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values, true
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// notionPropertyKey maps a property name to a metadata key, keeping letters and digits of any script. Keys are
+// prefixed so that properties can't override document fields like id or title. Returns an empty string if the name
+// has neither letters nor digits, e.g. if it's an emoji.
+func notionPropertyKey(name string) string {
+	key := strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if key == "" {
+		return ""
+	}
+	return "property_" + key
+}
+
+func sortedPropertyNames(properties map[string]NotionPropertyValue) []string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderNotionProperties renders all non-empty properties except the title as a Markdown list
+func renderNotionProperties(properties map[string]NotionPropertyValue) string {
+	var sb strings.Builder
+	for _, name := range sortedPropertyNames(properties) {
+		property := properties[name]
+		if property.Type == "title" {
+			continue
+		}
+
+		text, ok := property.text()
+		if !ok {
+			continue
+		}
+
+		sb.WriteString("- **" + name + ":** " + text + "\n")
+	}
+	return sb.String()
+}
+
+// notionPropertyMetadata returns typed metadata for all non-empty properties except the title. Properties whose names
+// map to the same key, e.g. "Status" and "status", get a numeric suffix in the order of their names. Names without
+// letters or digits are keyed by the property id.
+func notionPropertyMetadata(properties map[string]NotionPropertyValue) map[string]any {
+	metadata := make(map[string]any)
+	for _, name := range sortedPropertyNames(properties) {
+		property := properties[name]
+		if property.Type == "title" {
+			continue
+		}
+
+		value, ok := property.value()
+		if !ok {
+			continue
+		}
+
+		key := notionPropertyKey(name)
+		if key == "" {
+			key = notionPropertyKey(property.Id)
+		}
+		if key == "" {
+			continue
+		}
+		unique := key
+		for i := 2; metadata[unique] != nil; i++ {
+			unique = fmt.Sprintf("%s_%d", key, i)
+		}
+		metadata[unique] = value
+	}
+	return metadata
+}
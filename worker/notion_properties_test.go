@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// testNotionProperties decodes properties as returned by the Notion API
+func testNotionProperties(t *testing.T, data string) map[string]NotionPropertyValue {
+	t.Helper()
+
+	var properties map[string]NotionPropertyValue
+	err := json.Unmarshal([]byte(data), &properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return properties
+}
+
+func TestNotionPropertyValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		property string
+		want     any
+		wantText string
+	}{
+		{name: "title", property: `{"type":"title","title":[{"plain_text":"Road"},{"plain_text":"map"}]}`, want: "Roadmap", wantText: "Roadmap"},
+		{name: "rich text", property: `{"type":"rich_text","rich_text":[{"plain_text":"Notes"}]}`, want: "Notes", wantText: "Notes"},
+		{name: "empty rich text", property: `{"type":"rich_text","rich_text":[]}`},
+		{name: "number", property: `{"type":"number","number":1.5}`, want: 1.5, wantText: "1.5"},
+		{name: "empty number", property: `{"type":"number","number":null}`},
+		{name: "select", property: `{"type":"select","select":{"name":"High"}}`, want: "High", wantText: "High"},
+		{name: "empty select", property: `{"type":"select","select":null}`},
+		{name: "status", property: `{"type":"status","status":{"name":"Done"}}`, want: "Done", wantText: "Done"},
+		{name: "multi select", property: `{"type":"multi_select","multi_select":[{"name":"a"},{"name":"b"}]}`, want: []string{"a", "b"}, wantText: "a, b"},
+		{name: "empty multi select", property: `{"type":"multi_select","multi_select":[]}`},
+		{name: "date", property: `{"type":"date","date":{"start":"2024-01-01"}}`, want: "2024-01-01", wantText: "2024-01-01"},
+		{name: "date range", property: `{"type":"date","date":{"start":"2024-01-01","end":"2024-01-31"}}`, want: "2024-01-01 → 2024-01-31", wantText: "2024-01-01 → 2024-01-31"},
+		{name: "people", property: `{"type":"people","people":[{"id":"1","name":"Ada"},{"id":"2"}]}`, want: []string{"Ada"}, wantText: "Ada"},
+		{name: "relation", property: `{"type":"relation","relation":[{"id":"p1"},{"id":"p2"}]}`, want: []string{"p1", "p2"}, wantText: "p1, p2"},
+		{name: "checked", property: `{"type":"checkbox","checkbox":true}`, want: true, wantText: "Yes"},
+		{name: "unchecked", property: `{"type":"checkbox","checkbox":false}`, want: false, wantText: "No"},
+		{name: "url", property: `{"type":"url","url":"https://example.com"}`, want: "https://example.com", wantText: "https://example.com"},
+		{name: "empty url", property: `{"type":"url","url":null}`},
+		{name: "email", property: `{"type":"email","email":"ada@example.com"}`, want: "ada@example.com", wantText: "ada@example.com"},
+		{name: "phone number", property: `{"type":"phone_number","phone_number":"+1 555"}`, want: "+1 555", wantText: "+1 555"},
+		{name: "string formula", property: `{"type":"formula","formula":{"type":"string","string":"x"}}`, want: "x", wantText: "x"},
+		{name: "number formula", property: `{"type":"formula","formula":{"type":"number","number":3}}`, want: 3.0, wantText: "3"},
+		{name: "boolean formula", property: `{"type":"formula","formula":{"type":"boolean","boolean":false}}`, want: false, wantText: "No"},
+		{name: "date formula", property: `{"type":"formula","formula":{"type":"date","date":{"start":"2024-02-01"}}}`, want: "2024-02-01", wantText: "2024-02-01"},
+		{name: "empty formula", property: `{"type":"formula","formula":{"type":"number","number":null}}`},
+		{name: "number rollup", property: `{"type":"rollup","rollup":{"type":"number","number":42,"function":"sum"}}`, want: 42.0, wantText: "42"},
+		{name: "array rollup", property: `{"type":"rollup","rollup":{"type":"array","array":[{"type":"number","number":1},{"type":"select","select":{"name":"b"}},{"type":"select","select":null}]}}`, want: []string{"1", "b"}, wantText: "1, b"},
+		{name: "files", property: `{"type":"files","files":[{"name":"spec.pdf","type":"external","external":{"url":"https://example.com/spec.pdf"}}]}`, want: []string{"spec.pdf"}, wantText: "spec.pdf"},
+		{name: "created time", property: `{"type":"created_time","created_time":"2024-01-01T00:00:00.000Z"}`, want: "2024-01-01T00:00:00.000Z", wantText: "2024-01-01T00:00:00.000Z"},
+		{name: "last edited by", property: `{"type":"last_edited_by","last_edited_by":{"id":"1","name":"Bob"}}`, want: "Bob", wantText: "Bob"},
+		{name: "unique id", property: `{"type":"unique_id","unique_id":{"prefix":"ENG","number":12}}`, want: "ENG-12", wantText: "ENG-12"},
+		{name: "unique id without prefix", property: `{"type":"unique_id","unique_id":{"prefix":null,"number":12}}`, want: "12", wantText: "12"},
+		{name: "unsupported", property: `{"type":"button","button":{}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			property := testNotionProperties(t, `{"p":`+test.property+`}`)["p"]
+
+			value, ok := property.value()
+			if ok != (test.want != nil) || !reflect.DeepEqual(value, test.want) {
+				t.Errorf("got value %#v, %v, want %#v", value, ok, test.want)
+			}
+
+			text, ok := property.text()
+			if ok != (test.wantText != "") || text != test.wantText {
+				t.Errorf("got text %q, %v, want %q", text, ok, test.wantText)
+			}
+		})
+	}
+}
+
+func TestNotionPropertyKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Status", want: "property_status"},
+		{name: "Due Date (UTC)", want: "property_due_date_utc"},
+		{name: "  leading and trailing  ", want: "property_leading_and_trailing"},
+		{name: "Priorität", want: "property_priorität"},
+		{name: "Статус", want: "property_статус"},
+		{name: "担当者", want: "property_担当者"},
+		{name: "Q3 2024", want: "property_q3_2024"},
+		{name: "🔥", want: ""},
+		{name: "", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if key := notionPropertyKey(test.name); key != test.want {
+				t.Errorf("got %q, want %q", key, test.want)
+			}
+		})
+	}
+}
+
+func TestNotionPropertyRendering(t *testing.T) {
+	properties := testNotionProperties(t, `{
+		"Name": {"id": "title", "type": "title", "title": [{"plain_text": "Launch"}]},
+		"Status": {"id": "a", "type": "status", "status": {"name": "Done"}},
+		"status": {"id": "b", "type": "select", "select": {"name": "Open"}},
+		"Status!": {"id": "c", "type": "number", "number": 2},
+		"Tags": {"id": "d", "type": "multi_select", "multi_select": [{"name": "x"}, {"name": "y"}]},
+		"Empty": {"id": "e", "type": "rich_text", "rich_text": []},
+		"Done": {"id": "f", "type": "checkbox", "checkbox": true},
+		"🔥": {"id": "%3AUPp", "type": "number", "number": 5}
+	}`)
+
+	wantHeader := "- **Done:** Yes\n" +
+		"- **Status:** Done\n" +
+		"- **Status!:** 2\n" +
+		"- **Tags:** x, y\n" +
+		"- **status:** Open\n" +
+		"- **🔥:** 5\n"
+	if header := renderNotionProperties(properties); header != wantHeader {
+		t.Errorf("got header\n%s\nwant\n%s", header, wantHeader)
+	}
+
+	wantMetadata := map[string]any{
+		"property_done":     true,
+		"property_status":   "Done",
+		"property_status_2": 2.0,
+		"property_tags":     []string{"x", "y"},
+		"property_status_3": "Open",
+		"property_3aupp":    5.0,
+	}
+	if metadata := notionPropertyMetadata(properties); !reflect.DeepEqual(metadata, wantMetadata) {
+		t.Errorf("got metadata %v, want %v", metadata, wantMetadata)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pages nested deeper than this are considered out of scope
+const notionMaxDepth = 64
+
+// notionScope decides which pages belong to a data source based on the configured roots and exclusions. Parents are
+// cached for the lifetime of the scope, which is a single run or document change.
+type notionScope struct {
+	client      *NotionAPIClientImpl
+	integration NotionIntegrationConnection
+
+	rootPages         map[string]bool
+	databases         map[string]bool
+	excludedPages     map[string]bool
+	excludedDatabases map[string]bool
+
+	// parents maps normalized page, database and block ids to their parent
+	parents map[string]NotionParent
+}
+
+// normalizeNotionId strips dashes so that ids copied from URLs match ids returned by the API
+func normalizeNotionId(id string) string {
+	return strings.ToLower(strings.ReplaceAll(id, "-", ""))
+}
+
+func notionIdSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[normalizeNotionId(id)] = true
+	}
+	return set
+}
+
+func newNotionScope(client *NotionAPIClientImpl, integration NotionIntegrationConnection, dataSource *PipelineDataSource) *notionScope {
+	scope := &notionScope{
+		client:      client,
+		integration: integration,
+		parents:     make(map[string]NotionParent),
+	}
+
+	if dataSource != nil {
+		config := dataSource.NotionDataSource.Config
+		scope.rootPages = notionIdSet(config.RootPageIds)
+		scope.databases = notionIdSet(config.DatabaseIds)
+		scope.excludedPages = notionIdSet(config.ExcludePageIds)
+		scope.excludedDatabases = notionIdSet(config.ExcludeDatabaseIds)
+	}
+
+	return scope
+}
+
+func (scope *notionScope) hasRoots() bool {
+	return len(scope.rootPages) > 0 || len(scope.databases) > 0
+}
+
+func (scope *notionScope) remember(object NotionObject) {
+	scope.parents[normalizeNotionId(object.Id)] = object.Parent
+}
+
+func (scope *notionScope) parentOf(ctx context.Context, parentType, id string) (NotionParent, error) {
+	if parent, ok := scope.parents[normalizeNotionId(id)]; ok {
+		return parent, nil
+	}
+
+	var parent NotionParent
+	switch parentType {
+	case "page_id":
+		page, err := scope.client.getPage(ctx, scope.integration, id)
+		if err != nil {
+			return NotionParent{}, err
+		}
+		parent = page.Parent
+	case "database_id":
+		database, err := scope.client.getDatabase(ctx, scope.integration, id)
+		if err != nil {
+			return NotionParent{}, err
+		}
+		parent = database.Parent
+	case "block_id":
+		block, err := scope.client.getBlock(ctx, scope.integration, id)
+		if err != nil {
+			return NotionParent{}, err
+		}
+		parent = block.Parent
+	default:
+		return NotionParent{}, fmt.Errorf("unknown parent type %q", parentType)
+	}
+
+	scope.parents[normalizeNotionId(id)] = parent
+	return parent, nil
+}
+
+// contains walks up the parent chain of page until it reaches a root, an exclusion or the top of the workspace
+func (scope *notionScope) contains(ctx context.Context, page *NotionPage) (bool, error) {
+	if len(scope.excludedPages) == 0 && len(scope.excludedDatabases) == 0 && !scope.hasRoots() {
+		return true, nil
+	}
+
+	scope.remember(page.NotionObject)
+
+	parentType, id := "page_id", page.Id
+	for depth := 0; depth < notionMaxDepth; depth++ {
+		normalizedId := normalizeNotionId(id)
+		switch parentType {
+		case "page_id":
+			if scope.excludedPages[normalizedId] {
+				return false, nil
+			}
+			if scope.rootPages[normalizedId] {
+				return true, nil
+			}
+		case "database_id":
+			if scope.excludedDatabases[normalizedId] {
+				return false, nil
+			}
+			if scope.databases[normalizedId] {
+				return true, nil
+			}
+		}
+
+		parent, err := scope.parentOf(ctx, parentType, id)
+		// Parents that aren't shared with the integration can't be retrieved, so the page is at the top of what we
+		// can see
+		if errors.Is(err, errNotionNotFound) {
+			return !scope.hasRoots(), nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if parent.id() == "" {
+			return !scope.hasRoots(), nil
+		}
+
+		parentType, id = parent.Type, parent.id()
+	}
+
+	return false, nil
+}
+
+// walk lists the configured root pages and the rows of the configured databases including their child pages, so it
+// lists the same pages contains accepts.
+func (scope *notionScope) walk(ctx context.Context) (map[string]IndexedDocument, error) {
+	walker := &notionWalker{
+		scope:     scope,
+		documents: make(map[string]IndexedDocument),
+		visited:   make(map[string]bool),
+	}
+
+	for _, pageId := range notionIds(scope.rootPages) {
+		err := walker.walkPage(ctx, pageId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, databaseId := range notionIds(scope.databases) {
+		err := walker.walkDatabase(ctx, databaseId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return walker.documents, nil
+}
+
+// notionIds returns the ids of a set, normalized ids are accepted by the API
+func notionIds(set map[string]bool) []string {
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+type notionWalker struct {
+	scope     *notionScope
+	documents map[string]IndexedDocument
+	visited   map[string]bool
+}
+
+func (walker *notionWalker) walkPage(ctx context.Context, pageId string) error {
+	normalizedId := normalizeNotionId(pageId)
+	if walker.visited[normalizedId] || walker.scope.excludedPages[normalizedId] {
+		return nil
+	}
+	walker.visited[normalizedId] = true
+
+	page, err := walker.scope.client.getPage(ctx, walker.scope.integration, pageId)
+	if errors.Is(err, errNotionNotFound) {
+		walker.scope.client.logger.Printf("Skipping Notion page %q, not shared with integration\n", pageId)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get page %q, %w", pageId, err)
+	}
+
+	if page.Archived {
+		return nil
+	}
+
+	walker.documents[page.Id] = page.toIndexedDocument(walker.scope.integration)
+
+	return walker.walkChildren(ctx, page.Id)
+}
+
+// walkChildren descends into all blocks with children, as child pages may be nested in toggles, columns, etc.
+func (walker *notionWalker) walkChildren(ctx context.Context, blockId string) error {
+	var blocks []NotionBlock
+	err := walker.scope.client.listBlockChildren(ctx, walker.scope.integration, blockId, func(block NotionBlock) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list children of block %q, %w", blockId, err)
+	}
+
+	for _, block := range blocks {
+		switch block.Type {
+		case "child_page":
+			err = walker.walkPage(ctx, block.Id)
+		case "child_database":
+			err = walker.walkDatabase(ctx, block.Id)
+		default:
+			if block.HasChildren {
+				err = walker.walkChildren(ctx, block.Id)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (walker *notionWalker) walkDatabase(ctx context.Context, databaseId string) error {
+	normalizedId := normalizeNotionId(databaseId)
+	if walker.visited[normalizedId] || walker.scope.excludedDatabases[normalizedId] {
+		return nil
+	}
+	walker.visited[normalizedId] = true
+
+	walker.scope.client.logger.Printf("Listing all Notion database pages for database %q\n", databaseId)
+
+	var rows []string
+	err := walker.scope.client.queryDatabase(ctx, walker.scope.integration, databaseId, func(row NotionPage) error {
+		normalizedRowId := normalizeNotionId(row.Id)
+		if row.Archived || walker.visited[normalizedRowId] || walker.scope.excludedPages[normalizedRowId] {
+			return nil
+		}
+		walker.visited[normalizedRowId] = true
+		walker.documents[row.Id] = row.toIndexedDocument(walker.scope.integration)
+		rows = append(rows, row.Id)
+		return nil
+	})
+	// Linked databases can't be queried through the API
+	if errors.Is(err, errNotionNotFound) {
+		walker.scope.client.logger.Printf("Skipping Notion database %q, not shared with integration\n", databaseId)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to query database %q, %w", databaseId, err)
+	}
+
+	// Rows are pages themselves and may contain child pages, the database query doesn't tell which do
+	for _, rowId := range rows {
+		err = walker.walkChildren(ctx, rowId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
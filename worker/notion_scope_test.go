@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+// testRewriteTransport sends all requests to the test server, regardless of the host they're addressed to. The Host
+// header keeps the original host.
+type testRewriteTransport struct {
+	target *url.URL
+}
+
+func (transport *testRewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	req.URL.Scheme = transport.target.Scheme
+	req.URL.Host = transport.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestNotionClient returns a client which sends its Notion API requests to handler
+func newTestNotionClient(t *testing.T, handler http.Handler) *NotionAPIClientImpl {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient := &http.Client{Timeout: time.Second, Transport: &testRewriteTransport{target: target}}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+
+	return &NotionAPIClientImpl{
+		transport: newProviderTransport("notion", httpClient, nil, 1, classifyNotionResponse),
+		logger:    logger,
+		sema:      semaphore.NewWeighted(1),
+	}
+}
+
+// testNotionWorkspace serves pages, blocks and databases of a fake workspace
+type testNotionWorkspace struct {
+	pages     map[string]NotionParent
+	blocks    map[string]NotionParent
+	databases map[string]NotionParent
+	// children holds the child blocks of pages and blocks, rows the rows of databases
+	children map[string][]map[string]any
+	rows     map[string][]string
+	// comments holds the comments by page or block, commentRequests counts the requests for them
+	comments        map[string][]NotionComment
+	mutex           sync.Mutex
+	commentRequests map[string]int
+}
+
+func (workspace *testNotionWorkspace) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+
+	var response any
+	switch {
+	case len(parts) == 2 && parts[0] == "pages":
+		parent, ok := workspace.pages[parts[1]]
+		if ok {
+			response = map[string]any{"object": "page", "id": parts[1], "parent": parent}
+		}
+	case len(parts) == 2 && parts[0] == "blocks":
+		parent, ok := workspace.blocks[parts[1]]
+		if ok {
+			response = map[string]any{"object": "block", "id": parts[1], "type": "toggle", "parent": parent}
+		}
+	case len(parts) == 3 && parts[0] == "blocks" && parts[2] == "children":
+		results := workspace.children[parts[1]]
+		if results == nil {
+			results = []map[string]any{}
+		}
+		response = map[string]any{"results": results, "has_more": false}
+	case len(parts) == 2 && parts[0] == "databases":
+		parent, ok := workspace.databases[parts[1]]
+		if ok {
+			response = map[string]any{"object": "database", "id": parts[1], "parent": parent}
+		}
+	case len(parts) == 1 && parts[0] == "comments":
+		blockId := r.URL.Query().Get("block_id")
+		workspace.mutex.Lock()
+		if workspace.commentRequests == nil {
+			workspace.commentRequests = make(map[string]int)
+		}
+		workspace.commentRequests[blockId]++
+		workspace.mutex.Unlock()
+
+		results := workspace.comments[blockId]
+		if results == nil {
+			results = []NotionComment{}
+		}
+		response = map[string]any{"results": results, "has_more": false}
+	case len(parts) == 3 && parts[0] == "databases" && parts[2] == "query":
+		results := []map[string]any{}
+		for _, row := range workspace.rows[parts[1]] {
+			results = append(results, map[string]any{"object": "page", "id": row, "parent": workspace.pages[row]})
+		}
+		response = map[string]any{"results": results, "has_more": false}
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"object":"error","status":404,"code":"object_not_found"}`))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func TestNotionScopeWalkMatchesContains(t *testing.T) {
+	pageParent := func(id string) NotionParent { return NotionParent{Type: "page_id", PageId: id} }
+	workspaceParent := NotionParent{Type: "workspace"}
+	childPage := func(id string) map[string]any {
+		return map[string]any{"id": id, "type": "child_page", "child_page": map[string]any{"title": id}}
+	}
+
+	// root
+	// ├── toggle
+	// │   └── nested
+	// ├── tasks (database)
+	// │   └── task
+	// │       └── subtask
+	// └── excluded
+	//     └── hidden
+	// outside
+	workspace := &testNotionWorkspace{
+		pages: map[string]NotionParent{
+			"root":     workspaceParent,
+			"nested":   {Type: "block_id", BlockId: "toggle"},
+			"task":     {Type: "database_id", DatabaseId: "tasks"},
+			"subtask":  pageParent("task"),
+			"excluded": pageParent("root"),
+			"hidden":   pageParent("excluded"),
+			"outside":  workspaceParent,
+		},
+		blocks:    map[string]NotionParent{"toggle": pageParent("root")},
+		databases: map[string]NotionParent{"tasks": pageParent("root")},
+		children: map[string][]map[string]any{
+			"root": {
+				{"id": "toggle", "type": "toggle", "has_children": true, "toggle": map[string]any{"rich_text": []any{}}},
+				{"id": "tasks", "type": "child_database", "child_database": map[string]any{"title": "Tasks"}},
+				childPage("excluded"),
+			},
+			"toggle":   {childPage("nested")},
+			"task":     {childPage("subtask")},
+			"excluded": {childPage("hidden")},
+		},
+		rows: map[string][]string{"tasks": {"task"}},
+	}
+
+	client := newTestNotionClient(t, workspace)
+	integration := NotionIntegrationConnection{}
+	dataSource := &PipelineDataSource{}
+	dataSource.NotionDataSource.Config.RootPageIds = []string{"root"}
+	dataSource.NotionDataSource.Config.ExcludePageIds = []string{"excluded"}
+	ctx := context.Background()
+
+	documents, err := newNotionScope(client, integration, dataSource).walk(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walked := make([]string, 0, len(documents))
+	for id := range documents {
+		walked = append(walked, id)
+	}
+	sort.Strings(walked)
+	if want := []string{"nested", "root", "subtask", "task"}; strings.Join(walked, ",") != strings.Join(want, ",") {
+		t.Errorf("walked %v, want %v", walked, want)
+	}
+
+	// Every page of the workspace is in scope if and only if it was walked
+	for id := range workspace.pages {
+		page, err := client.getPage(ctx, integration, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contained, err := newNotionScope(client, integration, dataSource).contains(ctx, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := documents[id]; contained != ok {
+			t.Errorf("page %q contained = %v, walked = %v", id, contained, ok)
+		}
+	}
+}
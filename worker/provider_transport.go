@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// providerRequest describes a request to a provider API. The *http.Request is created again for every attempt, so
+// a consumed body is never sent twice.
+type providerRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	// Body is sent as is, set the Content-Type in Header
+	Body []byte
+	// LimiterKey selects the rate limit bucket, the workspace id or rateLimitKey of the access token
+	LimiterKey string
+
+	// Prepare is called with the request of every attempt right before it's sent, e.g. to sign it or to wait for a
+	// politeness delay. Errors aren't retried.
+	Prepare func(req *http.Request) error
+	// AcceptStatus returns whether a response with a status other than 2xx is returned to the caller instead of
+	// being classified as an error
+	AcceptStatus func(statusCode int) bool
+}
+
+// ProviderTransport sends requests to a provider API. It waits for the rate limiter, retries timeouts, rate limits
+// and server errors, drains the bodies of failed responses and reports every attempt as an external segment and a
+// custom metric (Custom/Provider/<provider>/<status code or "error">) of the New Relic transaction in the context.
+type ProviderTransport struct {
+	provider   string
+	httpClient *http.Client
+	limiter    *RateLimiter
+	// maxAttempts is how often requests are attempted before giving up
+	maxAttempts int
+
+	// classify maps responses other than 2xx to errors before the default classification applies, returning nil
+	// falls back to the defaults. Errors are retried unless they're wrapped with backoff.Permanent.
+	classify func(res *http.Response) error
+	// apiHosts are the hosts whose 401 responses mean that the credentials of the connection were rejected, hosts
+	// starting with a dot match their subdomains. 401 responses of other hosts, e.g. attachment downloads, only fail
+	// the request.
+	apiHosts []string
+}
+
+func newProviderTransport(provider string, httpClient *http.Client, limiter *RateLimiter, maxAttempts int, classify func(res *http.Response) error) *ProviderTransport {
+	return &ProviderTransport{
+		provider:    provider,
+		httpClient:  httpClient,
+		limiter:     limiter,
+		maxAttempts: maxAttempts,
+		classify:    classify,
+	}
+}
+
+// withAPIHosts sets the hosts whose 401 responses are returned as ErrIntegrationUnauthorized, see apiHosts
+func (transport *ProviderTransport) withAPIHosts(hosts ...string) *ProviderTransport {
+	transport.apiHosts = hosts
+	return transport
+}
+
+func (transport *ProviderTransport) isAPIHost(host string) bool {
+	for _, apiHost := range transport.apiHosts {
+		if strings.EqualFold(host, apiHost) || strings.HasPrefix(apiHost, ".") && strings.HasSuffix(strings.ToLower(host), strings.ToLower(apiHost)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do sends the request and returns a response with a 2xx or accepted status, the caller must close its body
+func (transport *ProviderTransport) Do(ctx context.Context, request providerRequest) (*http.Response, error) {
+	return backoff.RetryWithData[*http.Response](
+		func() (*http.Response, error) {
+			return transport.attempt(ctx, request)
+		},
+		newBackOff(ctx, uint64(transport.maxAttempts)),
+	)
+}
+
+// DoJSON sends the request and decodes the JSON response into result
+func (transport *ProviderTransport) DoJSON(ctx context.Context, request providerRequest, result any) error {
+	res, err := transport.Do(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	err = json.NewDecoder(res.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("unable to decode response, %w", err)
+	}
+
+	return nil
+}
+
+func (transport *ProviderTransport) attempt(ctx context.Context, request providerRequest) (*http.Response, error) {
+	var body io.Reader
+	if request.Body != nil {
+		body = bytes.NewReader(request.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, request.Method, request.URL, body)
+	if err != nil {
+		return nil, backoff.Permanent(err)
+	}
+	for key, values := range request.Header {
+		req.Header[key] = values
+	}
+
+	if transport.limiter != nil && request.LimiterKey != "" {
+		err = transport.limiter.Wait(ctx, request.LimiterKey)
+		if err != nil {
+			return nil, backoff.Permanent(err)
+		}
+	}
+
+	if request.Prepare != nil {
+		err = request.Prepare(req)
+		if err != nil {
+			return nil, backoff.Permanent(err)
+		}
+	}
+
+	startedAt := time.Now()
+	segment := newrelic.StartExternalSegment(newrelic.FromContext(ctx), req)
+	res, err := transport.httpClient.Do(req)
+	segment.Response = res
+	segment.End()
+
+	if err != nil {
+		transport.report(ctx, 0, startedAt)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return nil, err
+		}
+		return nil, backoff.Permanent(err)
+	}
+
+	if transport.limiter != nil && request.LimiterKey != "" {
+		transport.limiter.Observe(ctx, request.LimiterKey, res)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 || request.AcceptStatus != nil && request.AcceptStatus(res.StatusCode) {
+		transport.report(ctx, res.StatusCode, startedAt)
+		return res, nil
+	}
+
+	err = transport.classifyResponse(res, req.URL.Hostname())
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	transport.report(ctx, res.StatusCode, startedAt)
+	return nil, err
+}
+
+func (transport *ProviderTransport) classifyResponse(res *http.Response, host string) error {
+	if transport.classify != nil {
+		if err := transport.classify(res); err != nil {
+			return err
+		}
+	}
+
+	// Rate limits are waited for by the limiter before the next attempt
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	// The token was revoked or the app was removed
+	if res.StatusCode == http.StatusUnauthorized && transport.isAPIHost(host) {
+		return backoff.Permanent(ErrIntegrationUnauthorized)
+	}
+
+	return backoff.Permanent(fmt.Errorf("unexpected status code: %d", res.StatusCode))
+}
+
+// report records the duration of an attempt, statusCode is 0 if no response was received
+func (transport *ProviderTransport) report(ctx context.Context, statusCode int, startedAt time.Time) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+
+	app := newrelic.FromContext(ctx).Application()
+	app.RecordCustomMetric(fmt.Sprintf("Provider/%s/%s", transport.provider, status), time.Since(startedAt).Seconds())
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/cenkalti/backoff/v4"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testProviderServer responds with the next status of statuses for every request and records the request bodies
+type testProviderServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func newTestProviderServer(t *testing.T, statuses ...int) *testProviderServer {
+	server := &testProviderServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		server.mutex.Lock()
+		attempt := len(server.bodies)
+		server.bodies = append(server.bodies, string(body))
+		server.headers = append(server.headers, r.Header.Clone())
+		status := http.StatusOK
+		if attempt < len(server.statuses) {
+			status = server.statuses[attempt]
+		}
+		server.mutex.Unlock()
+
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `{"ok":true}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *testProviderServer) attempts() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.bodies)
+}
+
+func newTestProviderTransport(classify func(res *http.Response) error) *ProviderTransport {
+	return newProviderTransport("test", &http.Client{Timeout: 200 * time.Millisecond}, nil, 3, classify)
+}
+
+func TestProviderTransportSendsBodyOnEveryAttempt(t *testing.T) {
+	server := newTestProviderServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	transport := newTestProviderTransport(nil)
+
+	var result struct {
+		Ok bool `json:"ok"`
+	}
+	err := transport.DoJSON(context.Background(), providerRequest{
+		Method: http.MethodPost,
+		URL:    server.URL,
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   []byte(`{"query":"test"}`),
+	}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Ok {
+		t.Errorf("response wasn't decoded")
+	}
+
+	if server.attempts() != 3 {
+		t.Fatalf("got %d attempts, want 3", server.attempts())
+	}
+	for i, body := range server.bodies {
+		if body != `{"query":"test"}` {
+			t.Errorf("attempt %d sent body %q", i+1, body)
+		}
+		if server.headers[i].Get("Content-Type") != "application/json" {
+			t.Errorf("attempt %d sent headers %v", i+1, server.headers[i])
+		}
+	}
+}
+
+func TestProviderTransportRetries(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := newTestProviderServer(t, status)
+
+			res, err := newTestProviderTransport(nil).Do(context.Background(), providerRequest{Method: http.MethodGet, URL: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+
+			if server.attempts() != 2 {
+				t.Errorf("got %d attempts, want 2", server.attempts())
+			}
+		})
+	}
+}
+
+func TestProviderTransportRetriesTimeouts(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		attempt := attempts
+		mutex.Unlock()
+
+		if attempt == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	res, err := newTestProviderTransport(nil).Do(context.Background(), providerRequest{Method: http.MethodGet, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+}
+
+func TestProviderTransportPermanentErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := newTestProviderServer(t, status)
+
+			_, err := newTestProviderTransport(nil).Do(context.Background(), providerRequest{Method: http.MethodGet, URL: server.URL})
+			if err == nil {
+				t.Fatal("request succeeded")
+			}
+			if errors.Is(err, ErrIntegrationUnauthorized) {
+				t.Errorf("got %v, want a status error", err)
+			}
+			if server.attempts() != 1 {
+				t.Errorf("got %d attempts, want 1", server.attempts())
+			}
+		})
+	}
+}
+
+func TestProviderTransportUnauthorized(t *testing.T) {
+	tests := []struct {
+		name             string
+		apiHosts         []string
+		wantUnauthorized bool
+	}{
+		{name: "api host", apiHosts: []string{"127.0.0.1"}, wantUnauthorized: true},
+		{name: "subdomain", apiHosts: []string{".0.0.1"}, wantUnauthorized: true},
+		// e.g. attachment downloads, whose 401 responses don't tell anything about the connection
+		{name: "other host", apiHosts: []string{"api.example.com"}},
+		{name: "no api hosts"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestProviderServer(t, http.StatusUnauthorized)
+
+			_, err := newTestProviderTransport(nil).withAPIHosts(test.apiHosts...).Do(context.Background(), providerRequest{Method: http.MethodGet, URL: server.URL})
+			if err == nil {
+				t.Fatal("request succeeded")
+			}
+			if errors.Is(err, ErrIntegrationUnauthorized) != test.wantUnauthorized {
+				t.Errorf("got %v, unauthorized = %v", err, test.wantUnauthorized)
+			}
+			if server.attempts() != 1 {
+				t.Errorf("got %d attempts, want 1", server.attempts())
+			}
+		})
+	}
+}
+
+func TestProviderTransportDrainsFailedResponses(t *testing.T) {
+	server := newTestProviderServer(t, http.StatusServiceUnavailable)
+
+	// Connections are only reused if the body of the previous response was read to the end and closed
+	var mutex sync.Mutex
+	var reused []bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			reused = append(reused, info.Reused)
+		},
+	})
+
+	res, err := newTestProviderTransport(nil).Do(ctx, providerRequest{Method: http.MethodGet, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(reused) != 2 || !reused[1] {
+		t.Errorf("connection of the failed attempt wasn't reused, %v", reused)
+	}
+}
+
+func TestProviderTransportClassify(t *testing.T) {
+	errNotFound := errors.New("not found")
+	errGraphQL := errors.New("graphql error")
+	classify := func(res *http.Response) error {
+		switch res.StatusCode {
+		case http.StatusNotFound:
+			return backoff.Permanent(errNotFound)
+		case http.StatusBadRequest:
+			return errGraphQL
+		}
+		return nil
+	}
+
+	t.Run("permanent", func(t *testing.T) {
+		server := newTestProviderServer(t, http.StatusNotFound)
+
+		_, err := newTestProviderTransport(classify).Do(context.Background(), providerRequest{Method: http.MethodGet, URL: server.URL})
+		if !errors.Is(err, errNotFound) {
+			t.Fatalf("got %v, want classified error", err)
+		}
+		if server.attempts() != 1 {
+			t.Errorf("got %d attempts, want 1", server.attempts())
+		}
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		server := newTestProviderServer(t, http.StatusBadRequest)
+
+		res, err := newTestProviderTransport(classify).Do(context.Background(), providerRequest{Method: http.MethodGet, URL: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if server.attempts() != 2 {
+			t.Errorf("got %d attempts, want 2", server.attempts())
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		server := newTestProviderServer(t, http.StatusUnauthorized)
+
+		_, err := newTestProviderTransport(classify).withAPIHosts("127.0.0.1").Do(context.Background(), providerRequest{Method: http.MethodGet, URL: server.URL})
+		if !errors.Is(err, ErrIntegrationUnauthorized) {
+			t.Fatalf("got %v, want ErrIntegrationUnauthorized", err)
+		}
+	})
+}
+
+func TestProviderTransportPrepareAndAcceptStatus(t *testing.T) {
+	server := newTestProviderServer(t, http.StatusServiceUnavailable, http.StatusNotFound)
+
+	prepared := 0
+	res, err := newTestProviderTransport(nil).Do(context.Background(), providerRequest{
+		Method: http.MethodGet,
+		URL:    server.URL,
+		Prepare: func(req *http.Request) error {
+			prepared++
+			req.Header.Set("Authorization", "signed")
+			return nil
+		},
+		AcceptStatus: func(statusCode int) bool {
+			return statusCode == http.StatusNotFound
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want accepted 404", res.StatusCode)
+	}
+	if prepared != 2 || server.headers[1].Get("Authorization") != "signed" {
+		t.Errorf("prepared %d requests, headers %v", prepared, server.headers)
+	}
+}
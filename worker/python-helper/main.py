@@ -1,4 +1,7 @@
+import os
+
 import flask
+import requests
 from flask import Flask, request
 from langchain.text_splitter import RecursiveCharacterTextSplitter
 from langchain.embeddings import OpenAIEmbeddings
@@ -14,6 +17,25 @@ enc = tiktoken.get_encoding("cl100k_base")
 
 app = Flask(__name__)
 
+# Secrets are sent by the worker as credential references, which are resolved by calling the worker
+CREDENTIAL_REFERENCE_PREFIX = "credref:v1:"
+CREDENTIALS_RESOLVER_URL = os.environ.get("CREDENTIALS_RESOLVER_URL")
+HELPER_CREDENTIALS_TOKEN = os.environ.get("HELPER_CREDENTIALS_TOKEN")
+
+
+def resolve_credential(value, account):
+    if not value or not value.startswith(CREDENTIAL_REFERENCE_PREFIX):
+        return value
+
+    resp = requests.post(
+        f"{CREDENTIALS_RESOLVER_URL}/credentials/resolve",
+        json={"reference": value, "account": account},
+        headers={"Authorization": f"Bearer {HELPER_CREDENTIALS_TOKEN}"},
+        timeout=10,
+    )
+    resp.raise_for_status()
+    return resp.json()["value"]
+
 
 @app.post("/ingest")
 def ingest_endpoint():
@@ -40,8 +62,18 @@ def ingest_endpoint():
 
     req_embeddings = request.json["embeddings"]
     if req_embeddings["type"] == "openai":
+        try:
+            openai_api_key = resolve_credential(req_embeddings["config"].get("api_key") or request.json["openai_api_key"],
+                                                request.json.get("account"))
+        except requests.RequestException:
+            return flask.jsonify({"error": {
+                "message": "failed to resolve credentials",
+                "code": "credentials_unavailable",
+                "is_transient": True
+            }}), 500
+
         embeddings = OpenAIEmbeddings(
-            openai_api_key=req_embeddings["config"].get("api_key") or request.json["openai_api_key"],
+            openai_api_key=openai_api_key,
         )
     else:
         return flask.jsonify({"error": {
@@ -63,7 +95,7 @@ def ingest_endpoint():
 
     moderation_chain_error = StrictOpenAIModerationChain(
         error=True,
-        openai_api_key=req_embeddings["config"].get("api_key") or request.json["openai_api_key"],
+        openai_api_key=openai_api_key,
     )
     try:
         for text in texts:
@@ -115,8 +147,17 @@ def ingest_endpoint():
         if vector_store_config["store_type"] == "pinecone":
             pineconeConfig = vector_store_config["config"]
 
+            try:
+                pinecone_api_key = resolve_credential(pineconeConfig["api_key"], request.json.get("account"))
+            except requests.RequestException:
+                return flask.jsonify({"error": {
+                    "message": "failed to resolve credentials",
+                    "code": "credentials_unavailable",
+                    "is_transient": True
+                }}), 500
+
             pineconeClient = PineconeClient(
-                api_key=pineconeConfig["api_key"],
+                api_key=pinecone_api_key,
                 region=pineconeConfig["environment"]
             )
 
@@ -172,8 +213,17 @@ def delete_document_endpoint(document_id):
         if vector_store_config["store_type"] == "pinecone":
             pineconeConfig = vector_store_config["config"]
 
+            try:
+                pinecone_api_key = resolve_credential(pineconeConfig["api_key"], request.json.get("account"))
+            except requests.RequestException:
+                return flask.jsonify({"error": {
+                    "message": "failed to resolve credentials",
+                    "code": "credentials_unavailable",
+                    "is_transient": True
+                }}), 500
+
             pineconeClient = PineconeClient(
-                api_key=pineconeConfig["api_key"],
+                api_key=pinecone_api_key,
                 region=pineconeConfig["environment"]
             )
 
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Quotas are defined by the plan of an account (see the plan table) and apply per calendar month in UTC. Runs reserve
+// quota before using it, so concurrent runs of an account across workers can't exceed its limits together: documents
+// are reserved in order of priority before they're retrieved, tokens before a document is embedded. Used quota is
+// committed to the usage of the account, the rest is released when the run ends. Documents which are left out are
+// reported on the run step.
+
+// Only the first excludedDocumentsMaxCount excluded documents are listed on a run step, all of them are counted
+const excludedDocumentsMaxCount = 1000
+
+// Reservations are extended whenever they change, so quota reserved by crashed workers is freed after
+// quotaReservationTTL
+const quotaReservationTTL = time.Hour
+
+// Reasons for excluding documents from a run
+const (
+	excludedReasonDocumentLimit       = "document_limit"
+	excludedReasonTokenLimit          = "token_limit"
+	excludedReasonEmbeddingSpendLimit = "embedding_spend_limit"
+)
+
+// quotaPeriodStart returns the start of the month t is in
+func quotaPeriodStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (plan Plan) limits() QuotaLimits {
+	return QuotaLimits{
+		MaxDocuments:      plan.MaxDocuments,
+		MaxTokens:         plan.MaxTokens,
+		MaxEmbeddingSpend: plan.MaxEmbeddingSpend,
+	}
+}
+
+// exhausted returns why nothing more can be used, or an empty string if no limit is reached
+func (limits QuotaLimits) exhausted(used QuotaAmount) string {
+	if limits.MaxDocuments != nil && used.Documents >= *limits.MaxDocuments {
+		return excludedReasonDocumentLimit
+	}
+	if limits.MaxTokens != nil && used.Tokens >= *limits.MaxTokens {
+		return excludedReasonTokenLimit
+	}
+	if limits.MaxEmbeddingSpend != nil && used.EmbeddingSpend >= *limits.MaxEmbeddingSpend {
+		return excludedReasonEmbeddingSpendLimit
+	}
+	return ""
+}
+
+// grant returns how much of request fits into the limits on top of used, and why the rest doesn't. Documents are
+// granted partially, tokens and embedding spend only as a whole, as a document is either embedded or not.
+func (limits QuotaLimits) grant(used, request QuotaAmount) (QuotaAmount, string) {
+	granted := request
+	reason := ""
+
+	if limits.MaxDocuments != nil {
+		remaining := *limits.MaxDocuments - used.Documents
+		if remaining < 0 {
+			remaining = 0
+		}
+		if granted.Documents > remaining {
+			granted.Documents = remaining
+			reason = excludedReasonDocumentLimit
+		}
+	}
+
+	tokensReason := ""
+	if limits.MaxTokens != nil && used.Tokens+request.Tokens > *limits.MaxTokens {
+		tokensReason = excludedReasonTokenLimit
+	} else if limits.MaxEmbeddingSpend != nil && used.EmbeddingSpend+request.EmbeddingSpend > *limits.MaxEmbeddingSpend {
+		tokensReason = excludedReasonEmbeddingSpendLimit
+	}
+	if request.Tokens > 0 && tokensReason != "" {
+		granted.Tokens = 0
+		granted.EmbeddingSpend = 0
+		reason = tokensReason
+	}
+
+	return granted, reason
+}
+
+type QuotaManager struct {
+	pool *pgxpool.Pool
+
+	// embeddingPrice is the price of embedding a million tokens in USD
+	embeddingPrice float64
+}
+
+func newQuotaManager(pool *pgxpool.Pool, embeddingPrice float64) *QuotaManager {
+	return &QuotaManager{
+		pool:           pool,
+		embeddingPrice: embeddingPrice,
+	}
+}
+
+// amount returns the quota used by indexing documents with tokenCount tokens in total
+func (manager *QuotaManager) amount(documents int, tokenCount int) QuotaAmount {
+	return QuotaAmount{
+		Documents:      documents,
+		Tokens:         int64(tokenCount),
+		EmbeddingSpend: float64(tokenCount) * manager.embeddingPrice / 1_000_000,
+	}
+}
+
+// AccountQuota is the plan of an account and the quota reserved by a run. Unlimited accounts are only limited in the
+// number of tokens of a single document, their usage is counted nonetheless.
+type AccountQuota struct {
+	manager       *QuotaManager
+	accountId     string
+	plan          Plan
+	limits        QuotaLimits
+	periodStart   time.Time
+	reservationId string
+
+	// used is the quota used by all runs of the account when the run started
+	used QuotaAmount
+
+	mutex    sync.Mutex
+	excluded ExcludedDocuments
+}
+
+// ForAccount loads the plan and the usage of the current month of account. Quota reserved through the returned
+// AccountQuota is released by Close.
+func (manager *QuotaManager) ForAccount(ctx context.Context, account Account) (*AccountQuota, error) {
+	plan, err := GetAccountPlan(ctx, manager.pool, account.Id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get plan, %w", err)
+	}
+	if plan == nil {
+		return nil, fmt.Errorf("account %q has no plan", account.Id)
+	}
+
+	periodStart := quotaPeriodStart(time.Now())
+	used, err := GetQuotaUsed(ctx, manager.pool, account.Id, periodStart)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get usage, %w", err)
+	}
+
+	reservationId, err := newId()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate reservation id, %w", err)
+	}
+
+	limits := plan.limits()
+	if account.IsUnlimited {
+		limits = QuotaLimits{}
+	}
+
+	return &AccountQuota{
+		manager:       manager,
+		accountId:     account.Id,
+		plan:          *plan,
+		limits:        limits,
+		periodStart:   periodStart,
+		reservationId: reservationId,
+		used:          used,
+	}, nil
+}
+
+// DocumentTokenLimit is the number of tokens a single document may have to be embedded
+func (quota *AccountQuota) DocumentTokenLimit() int {
+	return quota.plan.MaxDocumentTokens
+}
+
+// Exhausted returns why no more documents could be indexed when the quota was loaded, or an empty string if the
+// quota wasn't exhausted. Other runs may use up the quota in the meantime, so documents must still be reserved.
+func (quota *AccountQuota) Exhausted() string {
+	return quota.limits.exhausted(quota.used)
+}
+
+func (quota *AccountQuota) reserve(ctx context.Context, request QuotaAmount) (QuotaAmount, string, error) {
+	tx, err := quota.manager.pool.Begin(ctx)
+	if err != nil {
+		return QuotaAmount{}, "", fmt.Errorf("unable to begin transaction, %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	granted, reason, err := ReserveQuota(ctx, tx, quota.reservationId, quota.accountId, quota.periodStart, quota.limits, request, quotaReservationTTL)
+	if err != nil {
+		return QuotaAmount{}, "", fmt.Errorf("unable to reserve quota, %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return QuotaAmount{}, "", fmt.Errorf("unable to commit transaction, %w", err)
+	}
+
+	return granted, reason, nil
+}
+
+// TakeDocuments orders docs by priority and reserves as many as the document quota allows, the others are excluded.
+// Every returned document must be committed or released.
+func (quota *AccountQuota) TakeDocuments(ctx context.Context, docs []IndexedDocument) ([]IndexedDocument, error) {
+	sortDocumentsByPriority(docs)
+
+	if len(docs) == 0 {
+		return docs, nil
+	}
+
+	granted, _, err := quota.reserve(ctx, quota.manager.amount(len(docs), 0))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range docs[granted.Documents:] {
+		quota.Exclude(doc, excludedReasonDocumentLimit)
+	}
+
+	return docs[:granted.Documents], nil
+}
+
+// Take reserves a single document, returning false if the document quota is exhausted. A taken document must be
+// committed or released.
+func (quota *AccountQuota) Take(ctx context.Context) (bool, error) {
+	granted, _, err := quota.reserve(ctx, quota.manager.amount(1, 0))
+	if err != nil {
+		return false, err
+	}
+
+	return granted.Documents == 1, nil
+}
+
+// ReserveTokens reserves tokenCount tokens for embedding a taken document, returning why they exceed the quota or an
+// empty string if they were reserved. Documents exceeding DocumentTokenLimit are handled by the caller.
+func (quota *AccountQuota) ReserveTokens(ctx context.Context, tokenCount int) (string, error) {
+	_, reason, err := quota.reserve(ctx, quota.manager.amount(0, tokenCount))
+	if err != nil {
+		return "", err
+	}
+
+	return reason, nil
+}
+
+// Commit counts documents and tokenCount tokens the run reserved towards the usage
+func (quota *AccountQuota) Commit(ctx context.Context, documents int, tokenCount int) error {
+	tx, err := quota.manager.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction, %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = CommitQuota(ctx, tx, quota.reservationId, quota.accountId, quota.periodStart, quota.manager.amount(documents, tokenCount), quotaReservationTTL)
+	if err != nil {
+		return fmt.Errorf("unable to commit quota, %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction, %w", err)
+	}
+
+	return nil
+}
+
+// Release refunds documents and tokenCount tokens the run reserved but didn't use, e.g. because ingestion failed
+func (quota *AccountQuota) Release(ctx context.Context, documents int, tokenCount int) error {
+	err := ReleaseQuota(ctx, quota.manager.pool, quota.reservationId, quota.manager.amount(documents, tokenCount), quotaReservationTTL)
+	if err != nil {
+		return fmt.Errorf("unable to release quota, %w", err)
+	}
+
+	return nil
+}
+
+// Close releases all quota the run reserved but didn't commit
+func (quota *AccountQuota) Close(ctx context.Context) error {
+	err := ReleaseQuotaReservation(ctx, quota.manager.pool, quota.reservationId)
+	if err != nil {
+		return fmt.Errorf("unable to release quota reservation, %w", err)
+	}
+
+	return nil
+}
+
+// Exclude records that doc was left out of the run for reason
+func (quota *AccountQuota) Exclude(doc IndexedDocument, reason string) {
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+
+	quota.excluded.Count++
+	if len(quota.excluded.Documents) >= excludedDocumentsMaxCount {
+		return
+	}
+	quota.excluded.Documents = append(quota.excluded.Documents, ExcludedDocument{
+		Integration:  doc.Integration,
+		DocumentType: doc.DocumentType,
+		Id:           doc.Id,
+		Title:        doc.Title,
+		URL:          doc.URL,
+		Reason:       reason,
+	})
+}
+
+// Excluded returns the documents excluded so far, nil if there are none
+func (quota *AccountQuota) Excluded() *ExcludedDocuments {
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+
+	if quota.excluded.Count == 0 {
+		return nil
+	}
+	excluded := ExcludedDocuments{
+		Count:     quota.excluded.Count,
+		Documents: append([]ExcludedDocument(nil), quota.excluded.Documents...),
+	}
+	return &excluded
+}
+
+// sortDocumentsByPriority orders docs most recently edited first, documents of sources which don't tell when they
+// were edited come last. Ties are ordered by id, so the same documents are excluded on every run.
+func sortDocumentsByPriority(docs []IndexedDocument) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		a, b := docs[i], docs[j]
+		if !a.EditedAt.Equal(b.EditedAt) {
+			return a.EditedAt.After(b.EditedAt)
+		}
+		if a.DocumentType != b.DocumentType {
+			return a.DocumentType < b.DocumentType
+		}
+		return a.Id < b.Id
+	})
+}
+
+// quotaExceededError is the error of run steps which failed because the quota was exhausted
+func quotaExceededError(reason string) *RunError {
+	message := "Exceeded monthly indexed document limit"
+	switch reason {
+	case excludedReasonTokenLimit:
+		message = "Exceeded monthly token limit"
+	case excludedReasonEmbeddingSpendLimit:
+		message = "Exceeded monthly embedding spend limit"
+	}
+
+	return &RunError{
+		Code:    "limit_exceeded",
+		Message: message,
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotaLimitsGrant(t *testing.T) {
+	maxDocuments := 10
+	maxTokens := int64(1000)
+	maxEmbeddingSpend := 0.5
+
+	tests := []struct {
+		name        string
+		limits      QuotaLimits
+		used        QuotaAmount
+		request     QuotaAmount
+		wantGranted QuotaAmount
+		wantReason  string
+	}{
+		{
+			name:        "unlimited",
+			request:     QuotaAmount{Documents: 100, Tokens: 1_000_000, EmbeddingSpend: 100},
+			wantGranted: QuotaAmount{Documents: 100, Tokens: 1_000_000, EmbeddingSpend: 100},
+		},
+		{
+			name:        "within limits",
+			limits:      QuotaLimits{MaxDocuments: &maxDocuments, MaxTokens: &maxTokens},
+			used:        QuotaAmount{Documents: 5, Tokens: 500},
+			request:     QuotaAmount{Documents: 5, Tokens: 500},
+			wantGranted: QuotaAmount{Documents: 5, Tokens: 500},
+		},
+		{
+			name:        "partial documents",
+			limits:      QuotaLimits{MaxDocuments: &maxDocuments},
+			used:        QuotaAmount{Documents: 7},
+			request:     QuotaAmount{Documents: 5},
+			wantGranted: QuotaAmount{Documents: 3},
+			wantReason:  excludedReasonDocumentLimit,
+		},
+		{
+			name:        "documents already exceeded",
+			limits:      QuotaLimits{MaxDocuments: &maxDocuments},
+			used:        QuotaAmount{Documents: 12},
+			request:     QuotaAmount{Documents: 5},
+			wantGranted: QuotaAmount{},
+			wantReason:  excludedReasonDocumentLimit,
+		},
+		{
+			name:        "tokens are granted as a whole",
+			limits:      QuotaLimits{MaxTokens: &maxTokens},
+			used:        QuotaAmount{Tokens: 900},
+			request:     QuotaAmount{Tokens: 200, EmbeddingSpend: 0.1},
+			wantGranted: QuotaAmount{},
+			wantReason:  excludedReasonTokenLimit,
+		},
+		{
+			name:        "tokens up to the cap",
+			limits:      QuotaLimits{MaxTokens: &maxTokens},
+			used:        QuotaAmount{Tokens: 900},
+			request:     QuotaAmount{Tokens: 100},
+			wantGranted: QuotaAmount{Tokens: 100},
+		},
+		{
+			name:        "embedding spend",
+			limits:      QuotaLimits{MaxTokens: &maxTokens, MaxEmbeddingSpend: &maxEmbeddingSpend},
+			used:        QuotaAmount{Tokens: 100, EmbeddingSpend: 0.45},
+			request:     QuotaAmount{Tokens: 100, EmbeddingSpend: 0.1},
+			wantGranted: QuotaAmount{},
+			wantReason:  excludedReasonEmbeddingSpendLimit,
+		},
+		{
+			name:        "documents without tokens ignore the token cap",
+			limits:      QuotaLimits{MaxTokens: &maxTokens},
+			used:        QuotaAmount{Tokens: 2000},
+			request:     QuotaAmount{Documents: 1},
+			wantGranted: QuotaAmount{Documents: 1},
+		},
+		{
+			name:        "token limit takes precedence over partial documents",
+			limits:      QuotaLimits{MaxDocuments: &maxDocuments, MaxTokens: &maxTokens},
+			used:        QuotaAmount{Documents: 9, Tokens: 1000},
+			request:     QuotaAmount{Documents: 2, Tokens: 10},
+			wantGranted: QuotaAmount{Documents: 1},
+			wantReason:  excludedReasonTokenLimit,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			granted, reason := test.limits.grant(test.used, test.request)
+			if granted != test.wantGranted || reason != test.wantReason {
+				t.Errorf("got %+v, %q, want %+v, %q", granted, reason, test.wantGranted, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestSortDocumentsByPriority(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		docs []IndexedDocument
+		want []string
+	}{
+		{
+			name: "recently edited first",
+			docs: []IndexedDocument{
+				{DocumentType: "page", Id: "a", EditedAt: day(1)},
+				{DocumentType: "page", Id: "b", EditedAt: day(3)},
+				{DocumentType: "page", Id: "c", EditedAt: day(2)},
+			},
+			want: []string{"page/b", "page/c", "page/a"},
+		},
+		{
+			name: "unknown edit times last",
+			docs: []IndexedDocument{
+				{DocumentType: "page", Id: "a"},
+				{DocumentType: "page", Id: "b", EditedAt: day(1)},
+			},
+			want: []string{"page/b", "page/a"},
+		},
+		{
+			name: "ties by type and id",
+			docs: []IndexedDocument{
+				{DocumentType: "page", Id: "b", EditedAt: day(1)},
+				{DocumentType: "database", Id: "z", EditedAt: day(1)},
+				{DocumentType: "page", Id: "a", EditedAt: day(1)},
+				{DocumentType: "page", Id: "c"},
+			},
+			want: []string{"database/z", "page/a", "page/b", "page/c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sortDocumentsByPriority(test.docs)
+
+			keys := make([]string, len(test.docs))
+			for i, doc := range test.docs {
+				keys[i] = doc.DocumentType + "/" + doc.Id
+			}
+			if strings.Join(keys, ",") != strings.Join(test.want, ",") {
+				t.Errorf("got %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
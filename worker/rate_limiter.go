@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Buckets of tokens which weren't used in a while are dropped
+const rateLimitBucketIdleTTL = time.Hour
+
+// Each worker process deletes idle buckets from Postgres at most every rateLimitBucketCleanupInterval
+const rateLimitBucketCleanupInterval = 10 * time.Minute
+
+// RateLimiter paces requests to a provider with a token bucket per workspace or access token. The rate starts out
+// at the documented limit and adapts to the rate limit headers of responses, so requests are slowed down before the
+// provider throttles them. Buckets are kept in a rateLimitStore, which is shared by all worker replicas if it's
+// backed by Postgres.
+type RateLimiter struct {
+	provider string
+	rate     float64
+	burst    float64
+	store    rateLimitStore
+	// fallback is used while the store is unavailable, so indexing isn't blocked by the database
+	fallback *memoryRateLimitStore
+}
+
+// rateLimitStore keeps the token buckets of rate limiters
+type rateLimitStore interface {
+	// reserve takes a token and returns how long to wait until it's available
+	reserve(ctx context.Context, key string, rate, burst float64) (time.Duration, error)
+	// blockedFor returns how long requests are still blocked, as a block may start while waiting for a token
+	blockedFor(ctx context.Context, key string) (time.Duration, error)
+	// block stops requests for d, requests are paced again afterwards
+	block(ctx context.Context, key string, d time.Duration) error
+	// adapt replaces the rate of the bucket for d, until the provider's rate limit window resets
+	adapt(ctx context.Context, key string, rate float64, d time.Duration) error
+}
+
+// newRateLimiter creates a limiter for provider, buckets are kept in memory if store is nil
+func newRateLimiter(provider string, store rateLimitStore, rate float64, burst int) *RateLimiter {
+	fallback := newMemoryRateLimitStore()
+	if store == nil {
+		store = fallback
+	}
+
+	return &RateLimiter{
+		provider: provider,
+		rate:     rate,
+		burst:    float64(burst),
+		store:    store,
+		fallback: fallback,
+	}
+}
+
+// rateLimitKey identifies the bucket of an access token without keeping the token itself around
+func rateLimitKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:16])
+}
+
+func (limiter *RateLimiter) bucketKey(key string) string {
+	return limiter.provider + ":" + key
+}
+
+// Wait blocks until a request may be sent with the workspace or token identified by key
+func (limiter *RateLimiter) Wait(ctx context.Context, key string) error {
+	key = limiter.bucketKey(key)
+
+	delay, err := limiter.store.reserve(ctx, key, limiter.rate, limiter.burst)
+	if err != nil {
+		delay, _ = limiter.fallback.reserve(ctx, key, limiter.rate, limiter.burst)
+	}
+
+	for delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		// Requests may have been blocked by a response received while waiting
+		delay, err = limiter.store.blockedFor(ctx, key)
+		if err != nil {
+			delay, _ = limiter.fallback.blockedFor(ctx, key)
+		}
+	}
+
+	return nil
+}
+
+// Observe adapts the bucket of key to the rate limit headers of a response
+func (limiter *RateLimiter) Observe(ctx context.Context, key string, res *http.Response) {
+	key = limiter.bucketKey(key)
+
+	blockFor, adaptedRate, adaptedFor := parseRateLimitHeaders(res.Header, time.Now(), limiter.rate)
+
+	if blockFor > 0 {
+		err := limiter.store.block(ctx, key, blockFor)
+		if err != nil {
+			_ = limiter.fallback.block(ctx, key, blockFor)
+		}
+	}
+	if adaptedRate > 0 {
+		err := limiter.store.adapt(ctx, key, adaptedRate, adaptedFor)
+		if err != nil {
+			_ = limiter.fallback.adapt(ctx, key, adaptedRate, adaptedFor)
+		}
+	}
+}
+
+// parseRateLimitHeaders returns how long requests must be stopped and the rate at which the remaining requests of the
+// current window can be sent, if it's below defaultRate
+func parseRateLimitHeaders(header http.Header, now time.Time, defaultRate float64) (time.Duration, float64, time.Duration) {
+	var blockFor time.Duration
+	var adaptedRate float64
+	var adaptedFor time.Duration
+
+	if until, ok := parseRetryAfter(header.Get("Retry-After"), now); ok {
+		blockFor = until.Sub(now)
+	}
+
+	adapt := func(remaining float64, reset time.Time) {
+		window := reset.Sub(now)
+		if remaining < 1 {
+			if window > blockFor {
+				blockFor = window
+			}
+			return
+		}
+
+		// Spread the remaining requests over the rest of the window
+		rate := remaining / math.Max(window.Seconds(), 1)
+		if rate < defaultRate && (adaptedRate == 0 || rate < adaptedRate) {
+			adaptedRate = rate
+			adaptedFor = window
+		}
+	}
+
+	// Intercom, Zendesk and the IETF draft used by others
+	// https://developers.intercom.com/docs/references/rest-api/errors/rate-limiting
+	// https://developer.zendesk.com/api-reference/introduction/rate-limits/
+	for _, names := range [][2]string{
+		{"X-RateLimit-Remaining", "X-RateLimit-Reset"},
+		{"X-Rate-Limit-Remaining", "RateLimit-Reset"},
+		{"RateLimit-Remaining", "RateLimit-Reset"},
+	} {
+		remaining, ok := parseRateLimitNumber(header.Get(names[0]))
+		if !ok {
+			continue
+		}
+		reset, ok := parseRateLimitReset(header.Get(names[1]), now)
+		if !ok {
+			continue
+		}
+		adapt(remaining, reset)
+	}
+
+	// Linear limits requests and the complexity of queries, the complexity of the last query estimates how many more
+	// queries fit into the budget
+	// https://developers.linear.app/docs/graphql/working-with-the-graphql-api/rate-limiting
+	if remaining, ok := parseRateLimitNumber(header.Get("X-RateLimit-Requests-Remaining")); ok {
+		if reset, ok := parseRateLimitReset(header.Get("X-RateLimit-Requests-Reset"), now); ok {
+			adapt(remaining, reset)
+		}
+	}
+	if remaining, ok := parseRateLimitNumber(header.Get("X-RateLimit-Complexity-Remaining")); ok {
+		complexity, ok := parseRateLimitNumber(header.Get("X-Complexity"))
+		reset, resetOk := parseRateLimitReset(header.Get("X-RateLimit-Complexity-Reset"), now)
+		if ok && resetOk && complexity > 0 {
+			adapt(remaining/complexity, reset)
+		}
+	}
+
+	return blockFor, adaptedRate, adaptedFor
+}
+
+func parseRateLimitNumber(value string) (float64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, false
+	}
+	return number, true
+}
+
+// parseRateLimitReset parses reset headers, which are sent as seconds until the reset, unix seconds or unix
+// milliseconds depending on the provider
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	number, ok := parseRateLimitNumber(value)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	var reset time.Time
+	switch {
+	case number > 1e12:
+		reset = time.UnixMilli(int64(number))
+	case number > 1e9:
+		reset = time.Unix(int64(number), 0)
+	default:
+		reset = now.Add(time.Duration(number * float64(time.Second)))
+	}
+	if !reset.After(now) {
+		return time.Time{}, false
+	}
+	return reset, true
+}
+
+// parseRetryAfter parses the Retry-After header, which is either seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second))), seconds > 0
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, date.After(now)
+	}
+	return time.Time{}, false
+}
+
+// memoryRateLimitStore keeps buckets in memory, which only coordinates the workers of a single process
+type memoryRateLimitStore struct {
+	// buckets holds one *rateLimitBucket per key
+	buckets sync.Map
+}
+
+type rateLimitBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	// updatedAt is when tokens were last refilled, it's in the future while requests are blocked
+	updatedAt    time.Time
+	blockedUntil time.Time
+	adaptedRate  float64
+	adaptedUntil time.Time
+	usedAt       time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{}
+}
+
+func (store *memoryRateLimitStore) bucket(key string, burst float64) *rateLimitBucket {
+	if bucket, ok := store.buckets.Load(key); ok {
+		return bucket.(*rateLimitBucket)
+	}
+
+	now := time.Now()
+	store.buckets.Range(func(key, value any) bool {
+		bucket := value.(*rateLimitBucket)
+		bucket.mu.Lock()
+		idle := now.Sub(bucket.usedAt) >= rateLimitBucketIdleTTL
+		bucket.mu.Unlock()
+		if idle {
+			store.buckets.Delete(key)
+		}
+		return true
+	})
+
+	bucket, _ := store.buckets.LoadOrStore(key, &rateLimitBucket{tokens: burst, updatedAt: now, usedAt: now})
+	return bucket.(*rateLimitBucket)
+}
+
+func (store *memoryRateLimitStore) reserve(ctx context.Context, key string, rate, burst float64) (time.Duration, error) {
+	bucket := store.bucket(key, burst)
+	now := time.Now()
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	if now.Before(bucket.adaptedUntil) {
+		rate = bucket.adaptedRate
+	}
+
+	if now.After(bucket.updatedAt) {
+		bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*rate)
+		bucket.updatedAt = now
+	}
+	bucket.tokens--
+	bucket.usedAt = now
+
+	delay := bucket.updatedAt.Sub(now)
+	if bucket.tokens < 0 {
+		delay += time.Duration(-bucket.tokens / rate * float64(time.Second))
+	}
+	return delay, nil
+}
+
+func (store *memoryRateLimitStore) blockedFor(ctx context.Context, key string) (time.Duration, error) {
+	value, ok := store.buckets.Load(key)
+	if !ok {
+		return 0, nil
+	}
+	bucket := value.(*rateLimitBucket)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	return time.Until(bucket.blockedUntil), nil
+}
+
+func (store *memoryRateLimitStore) block(ctx context.Context, key string, d time.Duration) error {
+	value, ok := store.buckets.Load(key)
+	if !ok {
+		return nil
+	}
+	bucket := value.(*rateLimitBucket)
+	until := time.Now().Add(d)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	if until.After(bucket.blockedUntil) {
+		bucket.blockedUntil = until
+	}
+	if until.After(bucket.updatedAt) {
+		bucket.updatedAt = until
+	}
+	bucket.tokens = math.Min(bucket.tokens, 0)
+	return nil
+}
+
+func (store *memoryRateLimitStore) adapt(ctx context.Context, key string, rate float64, d time.Duration) error {
+	value, ok := store.buckets.Load(key)
+	if !ok {
+		return nil
+	}
+	bucket := value.(*rateLimitBucket)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.adaptedRate = rate
+	bucket.adaptedUntil = time.Now().Add(d)
+	return nil
+}
+
+// postgresRateLimitStore keeps buckets in the rate_limit_bucket table, so all worker replicas share the budget of a
+// workspace. Just like in memory, buckets are deleted once they're idle for rateLimitBucketIdleTTL.
+type postgresRateLimitStore struct {
+	pool *pgxpool.Pool
+
+	cleanupMutex sync.Mutex
+	cleanedAt    time.Time
+}
+
+func newPostgresRateLimitStore(pool *pgxpool.Pool) *postgresRateLimitStore {
+	return &postgresRateLimitStore{pool: pool}
+}
+
+// deleteIdleBuckets deletes idle buckets if the last cleanup of this process is long enough ago, errors are ignored
+// as the next cleanup catches up
+func (store *postgresRateLimitStore) deleteIdleBuckets(ctx context.Context) {
+	store.cleanupMutex.Lock()
+	due := time.Since(store.cleanedAt) >= rateLimitBucketCleanupInterval
+	if due {
+		store.cleanedAt = time.Now()
+	}
+	store.cleanupMutex.Unlock()
+
+	if due {
+		_, _ = DeleteIdleRateLimitBuckets(ctx, store.pool, rateLimitBucketIdleTTL.Seconds())
+	}
+}
+
+func (store *postgresRateLimitStore) reserve(ctx context.Context, key string, rate, burst float64) (time.Duration, error) {
+	store.deleteIdleBuckets(ctx)
+
+	delay, err := ReserveRateLimitToken(ctx, store.pool, key, rate, burst)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(delay * float64(time.Second)), nil
+}
+
+func (store *postgresRateLimitStore) blockedFor(ctx context.Context, key string) (time.Duration, error) {
+	blockedFor, err := GetRateLimitBlock(ctx, store.pool, key)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(blockedFor * float64(time.Second)), nil
+}
+
+func (store *postgresRateLimitStore) block(ctx context.Context, key string, d time.Duration) error {
+	return BlockRateLimit(ctx, store.pool, key, d.Seconds())
+}
+
+func (store *postgresRateLimitStore) adapt(ctx context.Context, key string, rate float64, d time.Duration) error {
+	return AdaptRateLimit(ctx, store.pool, key, rate, d.Seconds())
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitReset(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Time
+		wantOk bool
+	}{
+		{name: "seconds", value: "30", want: now.Add(30 * time.Second), wantOk: true},
+		{name: "fractional seconds", value: "1.5", want: now.Add(1500 * time.Millisecond), wantOk: true},
+		{name: "epoch seconds", value: "1700000060", want: now.Add(time.Minute), wantOk: true},
+		{name: "epoch milliseconds", value: "1700000060000", want: now.Add(time.Minute), wantOk: true},
+		{name: "epoch in the past", value: "1699999940"},
+		{name: "zero", value: "0"},
+		{name: "empty", value: ""},
+		{name: "negative", value: "-5"},
+		{name: "invalid", value: "soon"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reset, ok := parseRateLimitReset(test.value, now)
+			if ok != test.wantOk || ok && !reset.Equal(test.want) {
+				t.Errorf("got %v, %v, want %v, %v", reset, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name           string
+		header         map[string]string
+		wantBlockFor   time.Duration
+		wantRate       float64
+		wantAdaptedFor time.Duration
+	}{
+		{
+			name: "no headers",
+		},
+		{
+			name:         "Retry-After in seconds",
+			header:       map[string]string{"Retry-After": "20"},
+			wantBlockFor: 20 * time.Second,
+		},
+		{
+			name:         "Retry-After as date",
+			header:       map[string]string{"Retry-After": now.Add(time.Minute).UTC().Format(http.TimeFormat)},
+			wantBlockFor: time.Minute,
+		},
+		{
+			name:   "Retry-After in the past",
+			header: map[string]string{"Retry-After": now.Add(-time.Minute).UTC().Format(http.TimeFormat)},
+		},
+		{
+			name:           "X-RateLimit-Reset in seconds",
+			header:         map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "20"},
+			wantRate:       0.5,
+			wantAdaptedFor: 20 * time.Second,
+		},
+		{
+			name:           "X-RateLimit-Reset as epoch",
+			header:         map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "1700000020"},
+			wantRate:       0.5,
+			wantAdaptedFor: 20 * time.Second,
+		},
+		{
+			name:         "X-RateLimit-Reset as epoch without remaining requests",
+			header:       map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1700000030"},
+			wantBlockFor: 30 * time.Second,
+		},
+		{
+			name:         "Retry-After and a later reset",
+			header:       map[string]string{"Retry-After": "5", "RateLimit-Remaining": "0", "RateLimit-Reset": "15"},
+			wantBlockFor: 15 * time.Second,
+		},
+		{
+			name:   "remaining requests above the default rate",
+			header: map[string]string{"RateLimit-Remaining": "1000", "RateLimit-Reset": "10"},
+		},
+		{
+			name: "lowest rate of all limits",
+			header: map[string]string{
+				"X-RateLimit-Requests-Remaining":   "100",
+				"X-RateLimit-Requests-Reset":       "1700000100000",
+				"X-RateLimit-Complexity-Remaining": "1000",
+				"X-Complexity":                     "100",
+				"X-RateLimit-Complexity-Reset":     "50",
+			},
+			wantRate:       0.2,
+			wantAdaptedFor: 50 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			for name, value := range test.header {
+				header.Set(name, value)
+			}
+
+			blockFor, rate, adaptedFor := parseRateLimitHeaders(header, now, 3)
+			if blockFor != test.wantBlockFor || rate != test.wantRate || adaptedFor != test.wantAdaptedFor {
+				t.Errorf("got %s, %v, %s, want %s, %v, %s", blockFor, rate, adaptedFor, test.wantBlockFor, test.wantRate, test.wantAdaptedFor)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStoreRefill(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryRateLimitStore()
+	const rate, burst = 10.0, 2.0
+
+	// The bucket starts full, so the burst isn't delayed
+	for i := 0; i < 2; i++ {
+		delay, _ := store.reserve(ctx, "key", rate, burst)
+		if delay != 0 {
+			t.Fatalf("request %d was delayed by %s", i, delay)
+		}
+	}
+
+	delay, _ := store.reserve(ctx, "key", rate, burst)
+	if delay <= 50*time.Millisecond || delay > 100*time.Millisecond {
+		t.Errorf("got delay %s, want about 100ms", delay)
+	}
+
+	bucket := store.bucket("key", burst)
+	rewind := func(d time.Duration) {
+		bucket.mu.Lock()
+		bucket.updatedAt = bucket.updatedAt.Add(-d)
+		bucket.mu.Unlock()
+	}
+
+	// Refills are capped at the burst
+	rewind(time.Hour)
+	for i := 0; i < 2; i++ {
+		delay, _ = store.reserve(ctx, "key", rate, burst)
+		if delay != 0 {
+			t.Fatalf("request %d after the refill was delayed by %s", i, delay)
+		}
+	}
+	delay, _ = store.reserve(ctx, "key", rate, burst)
+	if delay == 0 {
+		t.Errorf("bucket was refilled above the burst")
+	}
+
+	// Adapted rates replace the default rate until they expire
+	rewind(time.Hour)
+	_ = store.adapt(ctx, "key", 1, time.Minute)
+	for i := 0; i < 2; i++ {
+		_, _ = store.reserve(ctx, "key", rate, burst)
+	}
+	delay, _ = store.reserve(ctx, "key", rate, burst)
+	if delay <= 500*time.Millisecond || delay > time.Second {
+		t.Errorf("got delay %s at the adapted rate, want about 1s", delay)
+	}
+
+	// Blocks delay requests until they end
+	_ = store.block(ctx, "key", time.Minute)
+	blockedFor, _ := store.blockedFor(ctx, "key")
+	if blockedFor <= 59*time.Second {
+		t.Errorf("blocked for %s, want a minute", blockedFor)
+	}
+	delay, _ = store.reserve(ctx, "key", rate, burst)
+	if delay < time.Minute {
+		t.Errorf("got delay %s while blocked", delay)
+	}
+}
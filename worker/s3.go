@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+type S3DocumentType string
+
+const (
+	S3DocumentTypeObject S3DocumentType = "object"
+)
+
+// Objects larger than this are recorded as skipped
+const s3MaxObjectSize = 25 * 1024 * 1024
+
+type S3ClientImpl struct {
+	logger     logrus.FieldLogger
+	httpClient *http.Client
+	sema       *semaphore.Weighted
+}
+
+// newS3Client creates the object storage client. Custom endpoints (e.g. MinIO) may only point to private networks if
+// AllowPrivateEndpoints is set.
+func newS3Client(config S3Config, logger logrus.FieldLogger) DataSourceApiClient {
+	return &S3ClientImpl{
+		logger: logger,
+		httpClient: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: newPublicOnlyTransport(config.AllowPrivateEndpoints),
+		},
+		sema: semaphore.NewWeighted(int64(config.Concurrency)),
+	}
+}
+
+func (client *S3ClientImpl) newClient(integration S3IntegrationConnection) (*s3.Client, error) {
+	// Never fall back to the worker's own AWS credentials
+	if integration.Config.AccessKeyId == "" || integration.Config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("missing access key")
+	}
+
+	region := integration.Config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	options := s3.Options{
+		Region:       region,
+		Credentials:  aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(integration.Config.AccessKeyId, integration.Config.SecretAccessKey, "")),
+		UsePathStyle: integration.Config.UsePathStyle,
+		HTTPClient:   client.httpClient,
+	}
+	if integration.Config.Endpoint != "" {
+		options.BaseEndpoint = aws.String(integration.Config.Endpoint)
+	}
+
+	return s3.New(options), nil
+}
+
+// splitS3DocumentId splits ids of the form bucket/key
+func splitS3DocumentId(id string) (string, string, error) {
+	bucket, key, found := strings.Cut(id, "/")
+	if !found || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid document id %q", id)
+	}
+	return bucket, key, nil
+}
+
+// s3DocumentTarget splits a document id into bucket and key. Ids of change events aren't trusted, so the object must be
+// inside the data source's bucket and one of its prefixes.
+func s3DocumentTarget(dataSource *PipelineDataSource, id string) (string, string, error) {
+	bucket, key, err := splitS3DocumentId(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	if bucket != dataSource.S3DataSource.Config.Bucket || strings.HasSuffix(key, "/") {
+		return "", "", ErrDocumentOutOfScope
+	}
+
+	prefixes := dataSource.S3DataSource.Config.Prefixes
+	if len(prefixes) == 0 {
+		return bucket, key, nil
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return bucket, key, nil
+		}
+	}
+
+	return "", "", ErrDocumentOutOfScope
+}
+
+func newS3Document(bucket, key, etag string, lastModified *time.Time) IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationS3,
+		DocumentType:       string(S3DocumentTypeObject),
+		Id:                 bucket + "/" + key,
+		Title:              path.Base(key),
+		URL:                fmt.Sprintf("s3://%s/%s", bucket, key),
+		FreshnessIndicator: strings.Trim(etag, `"`),
+		EditedAt:           aws.ToTime(lastModified),
+	}
+}
+
+func (client *S3ClientImpl) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer client.sema.Release(1)
+
+	s3Client, err := client.newClient(integration.S3IntegrationConnection)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := dataSource.S3DataSource.Config.Bucket
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket configured")
+	}
+
+	prefixes := dataSource.S3DataSource.Config.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	indexedDocuments := make(map[string]IndexedDocument)
+
+	for _, prefix := range prefixes {
+		client.logger.Printf("Listing objects in bucket %q with prefix %q\n", bucket, prefix)
+
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		})
+
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("unable to list objects, %w", err)
+			}
+
+			for _, object := range page.Contents {
+				key := aws.ToString(object.Key)
+
+				// Skip folder placeholders
+				if strings.HasSuffix(key, "/") {
+					continue
+				}
+
+				doc := newS3Document(bucket, key, aws.ToString(object.ETag), object.LastModified)
+				if len(doc.Id) > 1024 {
+					client.logger.Printf("Skipping object %q, key too long\n", key)
+					continue
+				}
+
+				indexedDocuments[doc.Id] = doc
+			}
+		}
+	}
+
+	client.logger.Printf("Found %d objects\n", len(indexedDocuments))
+
+	return indexedDocuments, nil
+}
+
+func (client *S3ClientImpl) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+	if documentType != string(S3DocumentTypeObject) {
+		return IndexedDocument{}, fmt.Errorf("unknown document type %q", documentType)
+	}
+
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+	defer client.sema.Release(1)
+
+	bucket, key, err := s3DocumentTarget(dataSource, id)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+
+	s3Client, err := client.newClient(integration.S3IntegrationConnection)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return IndexedDocument{}, fmt.Errorf("unable to get object, %w", err)
+	}
+
+	return newS3Document(bucket, key, aws.ToString(head.ETag), head.LastModified), nil
+}
+
+func (client *S3ClientImpl) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
+	if documentType != string(S3DocumentTypeObject) {
+		return "", nil, fmt.Errorf("unknown document type %q", documentType)
+	}
+
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	defer client.sema.Release(1)
+
+	bucket, key, err := s3DocumentTarget(dataSource, id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Don't download objects we can't extract anyway
+	if _, ok := detectDocumentFormat(key, ""); !ok && path.Ext(key) != "" {
+		return "", nil, &SkippedDocumentError{Reason: SkipReasonUnsupportedFormat}
+	}
+
+	s3Client, err := client.newClient(integration.S3IntegrationConnection)
+	if err != nil {
+		return "", nil, err
+	}
+
+	object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to get object, %w", err)
+	}
+	defer object.Body.Close()
+
+	if object.ContentLength > s3MaxObjectSize {
+		return "", nil, &SkippedDocumentError{Reason: SkipReasonTooLarge}
+	}
+
+	content, err := io.ReadAll(io.LimitReader(object.Body, s3MaxObjectSize+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read object, %w", err)
+	}
+	if len(content) > s3MaxObjectSize {
+		return "", nil, &SkippedDocumentError{Reason: SkipReasonTooLarge}
+	}
+
+	contentType := aws.ToString(object.ContentType)
+
+	textContent, err := extractDocumentText(content, key, contentType)
+	if err != nil {
+		return "", nil, err
+	}
+
+	metadata := map[string]any{
+		"title":  path.Base(key),
+		"bucket": bucket,
+		"key":    key,
+	}
+	if contentType != "" {
+		metadata["content_type"] = contentType
+	}
+	if object.LastModified != nil {
+		metadata["last_modified"] = object.LastModified.UTC().Format(time.RFC3339)
+	}
+
+	return textContent, metadata, nil
+}
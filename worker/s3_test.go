@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+func newTestS3Client(t *testing.T, handler http.Handler) *S3ClientImpl {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return &S3ClientImpl{
+		logger:     logger,
+		httpClient: newTestHTTPClient(t, handler),
+		sema:       semaphore.NewWeighted(1),
+	}
+}
+
+// testS3Bucket serves objects by path style URL, keyed by bucket/key
+type testS3Bucket map[string]string
+
+func (bucket testS3Bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "s3.example.com" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	content, ok := bucket[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("ETag", `"etag"`)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Last-Modified", "Sun, 01 Jan 2023 00:00:00 GMT")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.WriteString(w, content)
+}
+
+func newS3Connection() IntegrationConnection {
+	connection := IntegrationConnection{}
+	connection.Integration = IntegrationS3
+	connection.S3IntegrationConnection.Config.Endpoint = "https://s3.example.com"
+	connection.S3IntegrationConnection.Config.AccessKeyId = "key"
+	connection.S3IntegrationConnection.Config.SecretAccessKey = "secret"
+	connection.S3IntegrationConnection.Config.UsePathStyle = true
+	return connection
+}
+
+func newS3DataSource(prefixes ...string) *PipelineDataSource {
+	dataSource := &PipelineDataSource{}
+	dataSource.IntegrationName = IntegrationS3
+	dataSource.S3DataSource.Config.Bucket = "docs"
+	dataSource.S3DataSource.Config.Prefixes = prefixes
+	return dataSource
+}
+
+func TestS3DocumentScope(t *testing.T) {
+	client := newTestS3Client(t, testS3Bucket{
+		"docs/guides/setup.txt":  "Setup",
+		"docs/private/keys.txt":  "Keys",
+		"other/guides/setup.txt": "Other",
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		prefixes       []string
+		id             string
+		wantOutOfScope bool
+	}{
+		{name: "whole bucket", id: "docs/private/keys.txt"},
+		{name: "inside prefix", prefixes: []string{"guides/"}, id: "docs/guides/setup.txt"},
+		{name: "outside prefix", prefixes: []string{"guides/"}, id: "docs/private/keys.txt", wantOutOfScope: true},
+		{name: "other bucket", id: "other/guides/setup.txt", wantOutOfScope: true},
+		{name: "folder", id: "docs/guides/", wantOutOfScope: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataSource := newS3DataSource(test.prefixes...)
+
+			doc, err := client.GetDocument(ctx, dataSource, string(S3DocumentTypeObject), test.id, newS3Connection())
+			_, _, contentErr := client.GetDocumentContent(ctx, dataSource, string(S3DocumentTypeObject), test.id, newS3Connection())
+			if test.wantOutOfScope {
+				if !errors.Is(err, ErrDocumentOutOfScope) || !errors.Is(contentErr, ErrDocumentOutOfScope) {
+					t.Errorf("got document %+v, errors %v and %v, want out of scope", doc, err, contentErr)
+				}
+				return
+			}
+			if err != nil || contentErr != nil {
+				t.Fatalf("got errors %v and %v", err, contentErr)
+			}
+			if doc.Id != test.id || doc.FreshnessIndicator != "etag" {
+				t.Errorf("got document %+v", doc)
+			}
+		})
+	}
+}
+
+func TestS3DocumentContent(t *testing.T) {
+	client := newTestS3Client(t, testS3Bucket{"docs/guides/setup": "Run make"})
+
+	// Keys without an extension are extracted by their content type
+	content, metadata, err := client.GetDocumentContent(context.Background(), newS3DataSource(), string(S3DocumentTypeObject), "docs/guides/setup", newS3Connection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "Run make" {
+		t.Errorf("got content %q", content)
+	}
+	if metadata["title"] != "setup" || metadata["key"] != "guides/setup" || metadata["content_type"] != "text/plain" || metadata["last_modified"] != "2023-01-01T00:00:00Z" {
+		t.Errorf("got metadata %v", metadata)
+	}
+
+	// Unsupported formats are skipped without downloading them
+	_, _, err = client.GetDocumentContent(context.Background(), newS3DataSource(), string(S3DocumentTypeObject), "docs/guides/setup.exe", newS3Connection())
+	skippedErr := &SkippedDocumentError{}
+	if !errors.As(err, &skippedErr) || skippedErr.Reason != SkipReasonUnsupportedFormat {
+		t.Errorf("got %v, want unsupported format", err)
+	}
+}
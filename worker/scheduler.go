@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+	"math/rand"
+	"time"
+)
+
+// Leases are extended while a run is processed, so runs of crashed workers free their slot after leaseTTL
+const (
+	runLeaseTTL           = 2 * time.Minute
+	runLeaseRenewInterval = 30 * time.Second
+)
+
+// Runs triggered by integration changes may exceed the account's limit by this many runs, so webhook syncs aren't
+// stuck behind the account's own full index
+const accountHighPrioritySlots = 1
+
+// runPriority orders runs by how long users are waiting for them
+type runPriority int
+
+const (
+	// runPriorityHigh are single document syncs triggered by webhooks and polling
+	runPriorityHigh runPriority = iota
+	// runPriorityNormal are runs started by users
+	runPriorityNormal
+	// runPriorityLow are scheduled full indexes
+	runPriorityLow
+)
+
+func (priority runPriority) String() string {
+	switch priority {
+	case runPriorityHigh:
+		return "high"
+	case runPriorityNormal:
+		return "normal"
+	default:
+		return "low"
+	}
+}
+
+func triggerPriority(trigger PipelineRunTrigger) runPriority {
+	switch trigger {
+	case PipelineRunTriggerIntegrationChangeEvent:
+		return runPriorityHigh
+	case PipelineRunTriggerManual:
+		return runPriorityNormal
+	default:
+		return runPriorityLow
+	}
+}
+
+// deferDelay is how long runs over a limit wait before they're received again, high priority runs are retried sooner
+func (priority runPriority) deferDelay() time.Duration {
+	var delay time.Duration
+	switch priority {
+	case runPriorityHigh:
+		delay = 5 * time.Second
+	case runPriorityNormal:
+		delay = 30 * time.Second
+	default:
+		delay = 60 * time.Second
+	}
+	// Spread deferred runs of an account, so they don't all return at once
+	return delay + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// runLeaseStore keeps the leases of runs which are being processed, so the limit of an account holds across all
+// worker replicas
+type runLeaseStore interface {
+	// acquire takes a lease for the run of messageId unless the account already holds limit leases
+	acquire(ctx context.Context, accountId string, messageId string, limit int, ttl time.Duration) (bool, error)
+	extend(ctx context.Context, messageId string, ttl time.Duration) error
+	release(ctx context.Context, messageId string) error
+}
+
+// postgresRunLeaseStore keeps leases in the account_run_lease table
+type postgresRunLeaseStore struct {
+	pool *pgxpool.Pool
+}
+
+func newPostgresRunLeaseStore(pool *pgxpool.Pool) *postgresRunLeaseStore {
+	return &postgresRunLeaseStore{pool: pool}
+}
+
+func (store *postgresRunLeaseStore) acquire(ctx context.Context, accountId string, messageId string, limit int, ttl time.Duration) (bool, error) {
+	tx, err := store.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to begin transaction, %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	acquired, err := AcquireAccountRunLease(ctx, tx, accountId, messageId, limit, ttl)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to commit transaction, %w", err)
+	}
+
+	return acquired, nil
+}
+
+func (store *postgresRunLeaseStore) extend(ctx context.Context, messageId string, ttl time.Duration) error {
+	return ExtendAccountRunLease(ctx, store.pool, messageId, ttl)
+}
+
+func (store *postgresRunLeaseStore) release(ctx context.Context, messageId string) error {
+	return ReleaseAccountRunLease(ctx, store.pool, messageId)
+}
+
+// Scheduler limits how many runs of an account are processed at the same time across all workers and keeps a worker
+// of every process free for high priority runs. Runs over a limit are deferred back to the queue, bulk runs are split
+// into batches so they give up their slot in between.
+type Scheduler struct {
+	logger             logrus.FieldLogger
+	leases             runLeaseStore
+	accountConcurrency int
+	bulkBatchSize      int
+
+	// bulkSlots is taken by runs other than high priority runs
+	bulkSlots *semaphore.Weighted
+}
+
+func newScheduler(logger logrus.FieldLogger, leases runLeaseStore, numWorkers int, accountConcurrency int, bulkBatchSize int) *Scheduler {
+	bulkSlots := numWorkers - 1
+	if bulkSlots < 1 {
+		bulkSlots = 1
+	}
+
+	return &Scheduler{
+		logger:             logger,
+		leases:             leases,
+		accountConcurrency: accountConcurrency,
+		bulkBatchSize:      bulkBatchSize,
+		bulkSlots:          semaphore.NewWeighted(int64(bulkSlots)),
+	}
+}
+
+// Admit takes a slot for the run of a message, returning a DeferredError if the account or the process is at its
+// limit. The returned release func must be called once the run is processed.
+func (scheduler *Scheduler) Admit(ctx context.Context, accountId string, messageId string, priority runPriority) (func(), error) {
+	if priority != runPriorityHigh {
+		if !scheduler.bulkSlots.TryAcquire(1) {
+			return nil, &DeferredError{Delay: priority.deferDelay(), Reason: "all workers for bulk runs are busy"}
+		}
+	}
+	releaseSlot := func() {
+		if priority != runPriorityHigh {
+			scheduler.bulkSlots.Release(1)
+		}
+	}
+
+	limit := scheduler.accountConcurrency
+	if priority == runPriorityHigh {
+		limit += accountHighPrioritySlots
+	}
+
+	acquired, err := scheduler.leases.acquire(ctx, accountId, messageId, limit, runLeaseTTL)
+	if err != nil {
+		releaseSlot()
+		return nil, fmt.Errorf("unable to acquire run lease, %w", err)
+	}
+
+	if !acquired {
+		releaseSlot()
+		return nil, &DeferredError{Delay: priority.deferDelay(), Reason: fmt.Sprintf("account %q is running %d runs", accountId, limit)}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(runLeaseRenewInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := scheduler.leases.extend(context.Background(), messageId, runLeaseTTL)
+				if err != nil {
+					scheduler.logger.Printf("Unable to extend run lease of message %q, %v\n", messageId, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		releaseSlot()
+
+		err := scheduler.leases.release(context.Background(), messageId)
+		if err != nil {
+			scheduler.logger.Printf("Unable to release run lease of message %q, %v\n", messageId, err)
+		}
+	}, nil
+}
+
+// splitBulkRun returns the batch of documents a run ingests now and the rest, which is left to the deferred message.
+// High priority runs are never split, they're small and users are waiting for them.
+func (scheduler *Scheduler) splitBulkRun(priority runPriority, docs []IndexedDocument) ([]IndexedDocument, []IndexedDocument) {
+	if priority == runPriorityHigh || len(docs) <= scheduler.bulkBatchSize {
+		return docs, nil
+	}
+	return docs[:scheduler.bulkBatchSize], docs[scheduler.bulkBatchSize:]
+}
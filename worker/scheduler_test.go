@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testRunLeaseStore keeps leases in memory, acquire fails with err if it's set
+type testRunLeaseStore struct {
+	mutex  sync.Mutex
+	leases map[string]string
+	err    error
+}
+
+func (store *testRunLeaseStore) acquire(ctx context.Context, accountId string, messageId string, limit int, ttl time.Duration) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.err != nil {
+		return false, store.err
+	}
+
+	held := 0
+	for _, account := range store.leases {
+		if account == accountId {
+			held++
+		}
+	}
+	if held >= limit {
+		return false, nil
+	}
+
+	store.leases[messageId] = accountId
+	return true, nil
+}
+
+func (store *testRunLeaseStore) extend(ctx context.Context, messageId string, ttl time.Duration) error {
+	return nil
+}
+
+func (store *testRunLeaseStore) release(ctx context.Context, messageId string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.leases, messageId)
+	return nil
+}
+
+func newTestScheduler(numWorkers int, accountConcurrency int) (*Scheduler, *testRunLeaseStore) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store := &testRunLeaseStore{leases: map[string]string{}}
+	return newScheduler(logger, store, numWorkers, accountConcurrency, 2), store
+}
+
+func TestSchedulerAccountLimit(t *testing.T) {
+	scheduler, store := newTestScheduler(10, 1)
+	ctx := context.Background()
+
+	release, err := scheduler.Admit(ctx, "a", "1", runPriorityLow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deferred *DeferredError
+	_, err = scheduler.Admit(ctx, "a", "2", runPriorityNormal)
+	if !errors.As(err, &deferred) {
+		t.Fatalf("got %v, want the run over the account's limit to be deferred", err)
+	}
+
+	// Other accounts aren't limited by a, and webhook syncs of a may exceed its limit by accountHighPrioritySlots
+	_, err = scheduler.Admit(ctx, "b", "3", runPriorityLow)
+	if err != nil {
+		t.Errorf("got %v for another account", err)
+	}
+	_, err = scheduler.Admit(ctx, "a", "4", runPriorityHigh)
+	if err != nil {
+		t.Errorf("got %v for a high priority run", err)
+	}
+	_, err = scheduler.Admit(ctx, "a", "5", runPriorityHigh)
+	if !errors.As(err, &deferred) {
+		t.Errorf("got %v, want high priority runs over the extra slot to be deferred", err)
+	}
+
+	release()
+	if _, ok := store.leases["1"]; ok {
+		t.Errorf("lease wasn't released")
+	}
+	_, err = scheduler.Admit(ctx, "a", "2", runPriorityNormal)
+	if !errors.As(err, &deferred) {
+		t.Errorf("got %v, want the high priority run to still hold the account's slot", err)
+	}
+}
+
+func TestSchedulerBulkSlots(t *testing.T) {
+	// One of the three workers is kept free for high priority runs
+	scheduler, _ := newTestScheduler(3, 10)
+	ctx := context.Background()
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := scheduler.Admit(ctx, fmt.Sprint(i), fmt.Sprint(i), runPriorityLow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	var deferred *DeferredError
+	_, err := scheduler.Admit(ctx, "c", "c", runPriorityNormal)
+	if !errors.As(err, &deferred) {
+		t.Fatalf("got %v, want the bulk run to be deferred", err)
+	}
+	_, err = scheduler.Admit(ctx, "d", "d", runPriorityHigh)
+	if err != nil {
+		t.Errorf("got %v for a high priority run", err)
+	}
+
+	releases[0]()
+	_, err = scheduler.Admit(ctx, "c", "c", runPriorityNormal)
+	if err != nil {
+		t.Errorf("got %v after a bulk slot was released", err)
+	}
+}
+
+func TestSchedulerLeaseError(t *testing.T) {
+	scheduler, store := newTestScheduler(2, 1)
+	ctx := context.Background()
+
+	store.err = errors.New("connection refused")
+	_, err := scheduler.Admit(ctx, "a", "1", runPriorityLow)
+	var deferred *DeferredError
+	if err == nil || errors.As(err, &deferred) {
+		t.Fatalf("got %v, want the error of the store", err)
+	}
+
+	// The bulk slot is given back, so the failed run doesn't block the worker
+	store.err = nil
+	_, err = scheduler.Admit(ctx, "a", "1", runPriorityLow)
+	if err != nil {
+		t.Errorf("got %v after the store recovered", err)
+	}
+}
+
+func TestDeferDelay(t *testing.T) {
+	tests := []struct {
+		priority runPriority
+		min      time.Duration
+		max      time.Duration
+	}{
+		{priority: runPriorityHigh, min: 5 * time.Second, max: 7500 * time.Millisecond},
+		{priority: runPriorityNormal, min: 30 * time.Second, max: 45 * time.Second},
+		{priority: runPriorityLow, min: time.Minute, max: 90 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.priority.String(), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				delay := test.priority.deferDelay()
+				if delay < test.min || delay >= test.max {
+					t.Fatalf("got delay %s, want between %s and %s", delay, test.min, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestTriggerPriority(t *testing.T) {
+	tests := map[PipelineRunTrigger]runPriority{
+		PipelineRunTriggerIntegrationChangeEvent: runPriorityHigh,
+		PipelineRunTriggerManual:                 runPriorityNormal,
+		PipelineRunTriggerSystem:                 runPriorityLow,
+	}
+
+	for trigger, want := range tests {
+		if priority := triggerPriority(trigger); priority != want {
+			t.Errorf("got priority %s for trigger %q, want %s", priority, trigger, want)
+		}
+	}
+}
+
+func TestSplitBulkRun(t *testing.T) {
+	scheduler, _ := newTestScheduler(2, 1)
+	docs := []IndexedDocument{{Id: "1"}, {Id: "2"}, {Id: "3"}}
+
+	tests := []struct {
+		name      string
+		priority  runPriority
+		docs      []IndexedDocument
+		wantBatch int
+		wantRest  int
+	}{
+		{name: "over the batch size", priority: runPriorityLow, docs: docs, wantBatch: 2, wantRest: 1},
+		{name: "within the batch size", priority: runPriorityNormal, docs: docs[:2], wantBatch: 2},
+		{name: "high priority", priority: runPriorityHigh, docs: docs, wantBatch: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batch, rest := scheduler.splitBulkRun(test.priority, test.docs)
+			if len(batch) != test.wantBatch || len(rest) != test.wantRest {
+				t.Errorf("got batch of %d and rest of %d, want %d and %d", len(batch), len(rest), test.wantBatch, test.wantRest)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secrets in integration connection and pipeline configs are encrypted with envelope encryption: every config gets a
+// random data key which encrypts its secret fields, the data key itself is wrapped by a key management service.
+// Encrypted values replace the plaintext string in the JSON config and carry everything needed to decrypt them:
+//
+//	enc:v1:<base64 of {"k": key id, "w": wrapped data key, "n": nonce, "c": ciphertext}>
+//
+// Plaintext values are passed through when decrypting: the app writes configs in plaintext and workers encrypt them
+// every SECRETS_BACKFILL_INTERVAL, see secrets_migration.go.
+const encryptedSecretPrefix = "enc:v1:"
+
+// Unwrapped data keys are cached, so loading a config doesn't require a request to the key management service
+const dataKeyCacheTTL = 10 * time.Minute
+
+// secretFields are the config fields holding credentials, wherever they're nested
+var secretFields = map[string]bool{
+	"access_token":      true,
+	"refresh_token":     true,
+	"api_token":         true,
+	"api_key":           true,
+	"secret_access_key": true,
+	"client_secret":     true,
+	"password":          true,
+}
+
+// KeyManagementService wraps and unwraps data keys with a key encryption key that never leaves the service
+type KeyManagementService interface {
+	// CurrentKeyId identifies the key new data keys are wrapped with. Secrets wrapped with other keys are re-encrypted
+	// by the migration, which is how keys are rotated.
+	CurrentKeyId() string
+	WrapKey(ctx context.Context, dataKey []byte) (keyId string, wrapped []byte, err error)
+	UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+}
+
+type secretEnvelope struct {
+	KeyId      string `json:"k"`
+	WrappedKey []byte `json:"w"`
+	Nonce      []byte `json:"n"`
+	Ciphertext []byte `json:"c"`
+}
+
+type cachedDataKey struct {
+	key       []byte
+	fetchedAt time.Time
+}
+
+// Secrets encrypts and decrypts the secret fields of configs, kms is nil if encryption isn't configured
+type Secrets struct {
+	kms KeyManagementService
+
+	// dataKeys caches unwrapped data keys by wrapped key
+	dataKeys sync.Map
+}
+
+func newSecrets(kms KeyManagementService) *Secrets {
+	return &Secrets{kms: kms}
+}
+
+// DecryptJSON replaces encrypted values in a JSON document with their plaintext
+func (secrets *Secrets) DecryptJSON(ctx context.Context, data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(encryptedSecretPrefix)) {
+		return data, nil
+	}
+
+	document, err := decodeJSONDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err = walkJSONStrings(document, "", func(field, value string) (string, error) {
+		if !strings.HasPrefix(value, encryptedSecretPrefix) {
+			return value, nil
+		}
+		return secrets.decrypt(ctx, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(document)
+}
+
+// EncryptJSON encrypts plaintext secret fields of a JSON document and re-encrypts fields wrapped with a previous key.
+// Returns whether the document changed.
+func (secrets *Secrets) EncryptJSON(ctx context.Context, data []byte) ([]byte, bool, error) {
+	if secrets.kms == nil {
+		return data, false, nil
+	}
+
+	document, err := decodeJSONDocument(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// All secrets of a document share a data key, which is only created if there's anything to encrypt
+	var dataKey, wrappedKey []byte
+	var keyId string
+	changed := false
+
+	document, err = walkJSONStrings(document, "", func(field, value string) (string, error) {
+		if !secretFields[field] || value == "" {
+			return value, nil
+		}
+
+		if strings.HasPrefix(value, encryptedSecretPrefix) {
+			envelope, err := decodeSecretEnvelope(value)
+			if err != nil {
+				return "", err
+			}
+			if envelope.KeyId == secrets.kms.CurrentKeyId() {
+				return value, nil
+			}
+
+			value, err = secrets.decrypt(ctx, value)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if dataKey == nil {
+			dataKey = make([]byte, 32)
+			_, err := rand.Read(dataKey)
+			if err != nil {
+				return "", err
+			}
+
+			keyId, wrappedKey, err = secrets.kms.WrapKey(ctx, dataKey)
+			if err != nil {
+				return "", fmt.Errorf("unable to wrap data key, %w", err)
+			}
+		}
+
+		changed = true
+		return encryptSecret(dataKey, keyId, wrappedKey, value)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if !changed {
+		return data, false, nil
+	}
+
+	encrypted, err := json.Marshal(document)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return encrypted, true, nil
+}
+
+func (secrets *Secrets) decrypt(ctx context.Context, value string) (string, error) {
+	if secrets.kms == nil {
+		return "", fmt.Errorf("found encrypted secret, but no key management service is configured")
+	}
+
+	envelope, err := decodeSecretEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := secrets.unwrapKey(ctx, envelope)
+	if err != nil {
+		return "", fmt.Errorf("unable to unwrap data key, %w", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt secret, %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+func (secrets *Secrets) unwrapKey(ctx context.Context, envelope *secretEnvelope) ([]byte, error) {
+	cacheKey := envelope.KeyId + "/" + string(envelope.WrappedKey)
+	if cached, ok := secrets.dataKeys.Load(cacheKey); ok && time.Since(cached.(cachedDataKey).fetchedAt) < dataKeyCacheTTL {
+		return cached.(cachedDataKey).key, nil
+	}
+
+	dataKey, err := secrets.kms.UnwrapKey(ctx, envelope.KeyId, envelope.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets.dataKeys.Range(func(key, value any) bool {
+		if time.Since(value.(cachedDataKey).fetchedAt) >= dataKeyCacheTTL {
+			secrets.dataKeys.Delete(key)
+		}
+		return true
+	})
+	secrets.dataKeys.Store(cacheKey, cachedDataKey{key: dataKey, fetchedAt: time.Now()})
+
+	return dataKey, nil
+}
+
+func encryptSecret(dataKey []byte, keyId string, wrappedKey []byte, value string) (string, error) {
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	marshalled, err := json.Marshal(secretEnvelope{
+		KeyId:      keyId,
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, []byte(value), nil),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(marshalled), nil
+}
+
+func decodeSecretEnvelope(value string) (*secretEnvelope, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedSecretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted secret, %w", err)
+	}
+
+	var envelope secretEnvelope
+	err = json.Unmarshal(decoded, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted secret, %w", err)
+	}
+
+	return &envelope, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decodeJSONDocument decodes JSON keeping numbers as they are
+func decodeJSONDocument(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document any
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// walkJSONStrings replaces every string value of a decoded JSON document, field is the key of the closest object
+func walkJSONStrings(value any, field string, replace func(field, value string) (string, error)) (any, error) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, nested := range value {
+			replaced, err := walkJSONStrings(nested, key, replace)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = replaced
+		}
+		return value, nil
+	case []any:
+		for i, nested := range value {
+			replaced, err := walkJSONStrings(nested, field, replace)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = replaced
+		}
+		return value, nil
+	case string:
+		return replace(field, value)
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskms "github.com/aws/aws-sdk-go-v2/service/kms"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// newKeyManagementService creates the key management service selected by SECRETS_KMS, returns nil if secrets aren't
+// encrypted. The settings are checked by Config.validateSecrets.
+func newKeyManagementService(config *Config, awsConfig aws.Config) (KeyManagementService, error) {
+	switch config.Secrets.KMS {
+	case "":
+		return nil, nil
+	case "local":
+		return newLocalKeyManagementService(config.Secrets.LocalKeyFile)
+	case "vault":
+		return newVaultKeyManagementService(config.Vault.Addr, config.Vault.Token, config.Secrets.VaultTransitMount, config.Secrets.VaultTransitKey, config.Secrets.Client), nil
+	case "aws":
+		return newAWSKeyManagementService(awsConfig, config.Secrets.AWSKMSKeyId, config.Secrets.Client), nil
+	default:
+		return nil, fmt.Errorf("unknown key management service %q", config.Secrets.KMS)
+	}
+}
+
+// localKeyManagementService wraps data keys with AES keys read from a file, which is meant for self-hosted setups
+// without a key management service. Keys are rotated by adding a key and making it the current key:
+//
+//	{"current_key": "2024-01", "keys": {"2023-06": "<base64 of 32 bytes>", "2024-01": "<base64 of 32 bytes>"}}
+type localKeyManagementService struct {
+	currentKey string
+	keys       map[string][]byte
+}
+
+func newLocalKeyManagementService(path string) (*localKeyManagementService, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key file, %w", err)
+	}
+
+	var keyFile struct {
+		CurrentKey string            `json:"current_key"`
+		Keys       map[string]string `json:"keys"`
+	}
+	err = json.Unmarshal(content, &keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse key file, %w", err)
+	}
+
+	kms := &localKeyManagementService{
+		currentKey: keyFile.CurrentKey,
+		keys:       make(map[string][]byte, len(keyFile.Keys)),
+	}
+	for id, encoded := range keyFile.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes encoded as base64", id)
+		}
+		kms.keys[id] = key
+	}
+	if _, ok := kms.keys[kms.currentKey]; !ok {
+		return nil, fmt.Errorf("current key %q not found in key file", kms.currentKey)
+	}
+
+	return kms, nil
+}
+
+func (kms *localKeyManagementService) CurrentKeyId() string {
+	return "local:" + kms.currentKey
+}
+
+func (kms *localKeyManagementService) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	gcm, err := newGCM(kms.keys[kms.currentKey])
+	if err != nil {
+		return "", nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kms.CurrentKeyId(), gcm.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (kms *localKeyManagementService) UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	key, ok := kms.keys[strings.TrimPrefix(keyId, "local:")]
+	if !ok || !strings.HasPrefix(keyId, "local:") {
+		return nil, fmt.Errorf("unknown key %q", keyId)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+
+	return gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+}
+
+// vaultKeyManagementService wraps data keys with the transit secrets engine of HashiCorp Vault. Rotating the transit
+// key doesn't require re-encrypting secrets as Vault keeps previous key versions, switching to another transit key
+// does.
+// https://developer.hashicorp.com/vault/api-docs/secret/transit
+type vaultKeyManagementService struct {
+	address   string
+	token     string
+	mount     string
+	key       string
+	transport *ProviderTransport
+}
+
+func newVaultKeyManagementService(address, token, mount, key string, clientConfig HTTPClientConfig) *vaultKeyManagementService {
+	return &vaultKeyManagementService{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		mount:   mount,
+		key:     key,
+		transport: newProviderTransport("vault", &http.Client{
+			Timeout: clientConfig.Timeout,
+		}, nil, clientConfig.MaxAttempts, nil),
+	}
+}
+
+func (kms *vaultKeyManagementService) CurrentKeyId() string {
+	return "vault:" + kms.key
+}
+
+func (kms *vaultKeyManagementService) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := kms.sendRequest(ctx, "encrypt", kms.key, map[string]any{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	}, &resp)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kms.CurrentKeyId(), []byte(resp.Data.Ciphertext), nil
+}
+
+func (kms *vaultKeyManagementService) UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	if !strings.HasPrefix(keyId, "vault:") {
+		return nil, fmt.Errorf("unknown key %q", keyId)
+	}
+
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err := kms.sendRequest(ctx, "decrypt", strings.TrimPrefix(keyId, "vault:"), map[string]any{
+		"ciphertext": string(wrapped),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+func (kms *vaultKeyManagementService) sendRequest(ctx context.Context, operation, key string, body any, result any) error {
+	marshalledBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/%s/%s", kms.address, kms.mount, operation, url.PathEscape(key))
+
+	return kms.transport.DoJSON(ctx, providerRequest{
+		Method: http.MethodPost,
+		URL:    endpoint,
+		Header: http.Header{
+			"X-Vault-Token": {kms.token},
+			"Content-Type":  {"application/json"},
+		},
+		Body: marshalledBody,
+	}, result)
+}
+
+// awsKMSClient is the part of the AWS KMS client used to wrap data keys
+type awsKMSClient interface {
+	Encrypt(ctx context.Context, params *awskms.EncryptInput, optFns ...func(*awskms.Options)) (*awskms.EncryptOutput, error)
+	Decrypt(ctx context.Context, params *awskms.DecryptInput, optFns ...func(*awskms.Options)) (*awskms.DecryptOutput, error)
+}
+
+// awsKeyManagementService wraps data keys with AWS KMS. Automatic key rotation doesn't require re-encrypting secrets,
+// switching to another key does.
+// https://docs.aws.amazon.com/kms/latest/APIReference/Welcome.html
+type awsKeyManagementService struct {
+	keyId  string
+	client awsKMSClient
+}
+
+func newAWSKeyManagementService(awsConfig aws.Config, keyId string, clientConfig HTTPClientConfig) *awsKeyManagementService {
+	return &awsKeyManagementService{
+		keyId: keyId,
+		client: awskms.NewFromConfig(awsConfig, func(options *awskms.Options) {
+			options.HTTPClient = &http.Client{Timeout: clientConfig.Timeout}
+			options.RetryMaxAttempts = clientConfig.MaxAttempts
+		}),
+	}
+}
+
+func (kms *awsKeyManagementService) CurrentKeyId() string {
+	return "aws:" + kms.keyId
+}
+
+func (kms *awsKeyManagementService) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	output, err := kms.client.Encrypt(ctx, &awskms.EncryptInput{
+		KeyId:     aws.String(kms.keyId),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kms.CurrentKeyId(), output.CiphertextBlob, nil
+}
+
+func (kms *awsKeyManagementService) UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	if !strings.HasPrefix(keyId, "aws:") {
+		return nil, fmt.Errorf("unknown key %q", keyId)
+	}
+
+	output, err := kms.client.Decrypt(ctx, &awskms.DecryptInput{
+		KeyId:          aws.String(strings.TrimPrefix(keyId, "aws:")),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Plaintext, nil
+}
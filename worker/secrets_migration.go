@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// runEncryptSecretsCommand implements `worker encrypt-secrets [-dry-run]`, which encrypts plaintext secrets of all
+// integration connections and pipelines and re-encrypts secrets wrapped with a previous key. The app stores configs
+// in plaintext, running workers encrypt them every SECRETS_BACKFILL_INTERVAL and the command should run whenever the
+// key is rotated.
+func runEncryptSecretsCommand(ctx context.Context, logger logrus.FieldLogger, awsConfig aws.Config, args []string) error {
+	flags := flag.NewFlagSet("encrypt-secrets", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report configs which would be encrypted")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// Only the key management service is needed, so the rest of the configuration isn't validated
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+	err = config.validateSecrets()
+	if err != nil {
+		return err
+	}
+	if config.PostgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL_NON_POOLING must be set")
+	}
+
+	kms, err := newKeyManagementService(config, awsConfig)
+	if err != nil {
+		return err
+	}
+	if kms == nil {
+		return fmt.Errorf("SECRETS_KMS must be set")
+	}
+
+	pool, err := pgxpool.New(ctx, config.PostgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database, %w", err)
+	}
+	defer pool.Close()
+
+	return encryptAllSecrets(ctx, logger, pool, newSecrets(kms), *dryRun)
+}
+
+// startSecretsBackfill encrypts the configs which the app stored in plaintext every interval. Every worker runs the
+// backfill, configs are locked while they're encrypted.
+func startSecretsBackfill(ctx context.Context, logger logrus.FieldLogger, pool *pgxpool.Pool, secrets *Secrets, interval time.Duration) {
+	logger.Printf("Starting secrets backfill with interval %s.\n", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Println("Exiting secrets backfill.")
+				return
+			case <-ticker.C:
+				err := encryptAllSecrets(ctx, logger, pool, secrets, false)
+				if err != nil {
+					logger.Printf("Unable to encrypt secrets, %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// encryptAllSecrets encrypts the secrets of all integration connections and pipelines which are stored in plaintext or
+// wrapped with a previous key
+func encryptAllSecrets(ctx context.Context, logger logrus.FieldLogger, pool *pgxpool.Pool, secrets *Secrets, dryRun bool) error {
+	connections, err := GetIntegrationConnectionKeys(ctx, pool)
+	if err != nil {
+		return fmt.Errorf("unable to get integration connections, %w", err)
+	}
+
+	encryptedConnections := 0
+	for _, connection := range connections {
+		changed, err := encryptConfig(ctx, pool, secrets, dryRun,
+			func(client Querier) ([]byte, error) {
+				return LockIntegrationConnectionConfig(ctx, client, connection.Account, connection.Integration)
+			},
+			func(client Querier, config []byte) error {
+				return SetIntegrationConnectionConfig(ctx, client, connection.Account, connection.Integration, config)
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("unable to encrypt %s connection of account %q, %w", connection.Integration, connection.Account, err)
+		}
+		if changed {
+			encryptedConnections++
+		}
+	}
+
+	pipelineIds, err := GetPipelineIds(ctx, pool)
+	if err != nil {
+		return fmt.Errorf("unable to get pipelines, %w", err)
+	}
+
+	encryptedPipelines := 0
+	for _, pipelineId := range pipelineIds {
+		changed, err := encryptConfig(ctx, pool, secrets, dryRun,
+			func(client Querier) ([]byte, error) {
+				return LockPipelineConfig(ctx, client, pipelineId)
+			},
+			func(client Querier, config []byte) error {
+				return SetPipelineConfig(ctx, client, pipelineId, config)
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("unable to encrypt pipeline %q, %w", pipelineId, err)
+		}
+		if changed {
+			encryptedPipelines++
+		}
+	}
+
+	if dryRun || encryptedConnections > 0 || encryptedPipelines > 0 {
+		logger.Printf("Encrypted secrets of %d/%d integration connections and %d/%d pipelines (dry run: %t)\n", encryptedConnections, len(connections), encryptedPipelines, len(pipelineIds), dryRun)
+	}
+
+	return nil
+}
+
+// encryptConfig encrypts a single config in a transaction, the config is locked so concurrent updates by the app
+// aren't overwritten
+func encryptConfig(ctx context.Context, pool *pgxpool.Pool, secrets *Secrets, dryRun bool, lock func(client Querier) ([]byte, error), update func(client Querier, config []byte) error) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	config, err := lock(tx)
+	if err != nil {
+		return false, err
+	}
+
+	encrypted, changed, err := secrets.EncryptJSON(ctx, config)
+	if err != nil {
+		return false, err
+	}
+	if !changed || dryRun {
+		return changed, nil
+	}
+
+	err = update(tx, encrypted)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
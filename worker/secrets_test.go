@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskms "github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// writeTestKeyFile writes a key file for the local key management service with a key per id
+func writeTestKeyFile(t *testing.T, currentKey string, ids ...string) string {
+	t.Helper()
+
+	keys := map[string]string{}
+	for i, id := range ids {
+		key := make([]byte, 32)
+		key[0] = byte(i + 1)
+		keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	content, err := json.Marshal(map[string]any{"current_key": currentKey, "keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	err = os.WriteFile(path, content, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestLocalSecrets(t *testing.T, currentKey string, ids ...string) *Secrets {
+	t.Helper()
+
+	kms, err := newLocalKeyManagementService(writeTestKeyFile(t, currentKey, ids...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSecrets(kms)
+}
+
+func decodeTestConfig(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+
+	var config map[string]any
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestSecretsEnvelopeFormat(t *testing.T) {
+	secrets := newTestLocalSecrets(t, "2024-01", "2024-01")
+	ctx := context.Background()
+
+	encrypted, changed, err := secrets.EncryptJSON(ctx, []byte(`{"access_token":"secret","workspace":"acme","nested":{"api_key":"key"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("config wasn't encrypted")
+	}
+
+	config := decodeTestConfig(t, encrypted)
+	if config["workspace"] != "acme" {
+		t.Errorf("encrypted a field which isn't secret, %v", config["workspace"])
+	}
+
+	value, _ := config["access_token"].(string)
+	if !strings.HasPrefix(value, encryptedSecretPrefix) {
+		t.Fatalf("got access token %q, want prefix %q", value, encryptedSecretPrefix)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedSecretPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope map[string]any
+	err = json.Unmarshal(decoded, &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"k", "w", "n", "c"} {
+		if _, ok := envelope[field]; !ok {
+			t.Errorf("envelope %v is missing %q", envelope, field)
+		}
+	}
+	if envelope["k"] != "local:2024-01" {
+		t.Errorf("got key id %v", envelope["k"])
+	}
+	if strings.Contains(string(decoded), "secret") {
+		t.Errorf("envelope contains the plaintext, %s", decoded)
+	}
+
+	// Secrets of a document share the data key
+	other, err := decodeSecretEnvelope(config["nested"].(map[string]any)["api_key"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base64.StdEncoding.EncodeToString(other.WrappedKey) != envelope["w"] {
+		t.Errorf("secrets of a document were wrapped with different data keys")
+	}
+
+	decrypted, err := secrets.DecryptJSON(ctx, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = decodeTestConfig(t, decrypted)
+	if config["access_token"] != "secret" || config["nested"].(map[string]any)["api_key"] != "key" {
+		t.Errorf("got decrypted config %s", decrypted)
+	}
+
+	// Encrypting again doesn't change the document
+	_, changed, err = secrets.EncryptJSON(ctx, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Errorf("encrypted config was encrypted again")
+	}
+}
+
+func TestSecretsDecryptPlaintext(t *testing.T) {
+	ctx := context.Background()
+	plaintext := []byte(`{"access_token":"secret"}`)
+
+	tests := []struct {
+		name    string
+		secrets *Secrets
+	}{
+		{name: "without key management service", secrets: newSecrets(nil)},
+		{name: "with key management service", secrets: newTestLocalSecrets(t, "a", "a")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decrypted, err := test.secrets.DecryptJSON(ctx, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != string(plaintext) {
+				t.Errorf("got %s, want %s", decrypted, plaintext)
+			}
+		})
+	}
+
+	// Encrypted secrets can't be read without a key management service
+	encrypted, _, err := newTestLocalSecrets(t, "a", "a").EncryptJSON(ctx, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newSecrets(nil).DecryptJSON(ctx, encrypted)
+	if err == nil {
+		t.Errorf("decrypted a secret without key management service")
+	}
+}
+
+func TestSecretsKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	path := writeTestKeyFile(t, "old", "old", "new")
+
+	oldKMS, err := newLocalKeyManagementService(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, _, err := newSecrets(oldKMS).EncryptJSON(ctx, []byte(`{"access_token":"secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate by making the new key the current one, values wrapped with the old key can still be read
+	rotatedKMS, err := newLocalKeyManagementService(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKMS.currentKey = "new"
+	rotated := newSecrets(rotatedKMS)
+
+	decrypted, err := rotated.DecryptJSON(ctx, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodeTestConfig(t, decrypted)["access_token"] != "secret" {
+		t.Errorf("got decrypted config %s", decrypted)
+	}
+
+	reencrypted, changed, err := rotated.EncryptJSON(ctx, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("secret wrapped with the old key wasn't re-encrypted")
+	}
+	envelope, err := decodeSecretEnvelope(decodeTestConfig(t, reencrypted)["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KeyId != "local:new" {
+		t.Errorf("got key id %q, want local:new", envelope.KeyId)
+	}
+
+	// Once the old key is removed, only re-encrypted values can be read
+	delete(rotatedKMS.keys, "old")
+	rotated = newSecrets(rotatedKMS)
+	if _, err := rotated.DecryptJSON(ctx, encrypted); err == nil {
+		t.Errorf("decrypted a secret wrapped with a removed key")
+	}
+	if _, err := rotated.DecryptJSON(ctx, reencrypted); err != nil {
+		t.Errorf("unable to decrypt re-encrypted secret, %v", err)
+	}
+}
+
+// testAWSKMSClient "wraps" data keys by reversing them
+type testAWSKMSClient struct {
+	keyIds []string
+}
+
+func (client *testAWSKMSClient) Encrypt(ctx context.Context, params *awskms.EncryptInput, optFns ...func(*awskms.Options)) (*awskms.EncryptOutput, error) {
+	client.keyIds = append(client.keyIds, aws.ToString(params.KeyId))
+	return &awskms.EncryptOutput{CiphertextBlob: reverseBytes(params.Plaintext)}, nil
+}
+
+func (client *testAWSKMSClient) Decrypt(ctx context.Context, params *awskms.DecryptInput, optFns ...func(*awskms.Options)) (*awskms.DecryptOutput, error) {
+	client.keyIds = append(client.keyIds, aws.ToString(params.KeyId))
+	return &awskms.DecryptOutput{Plaintext: reverseBytes(params.CiphertextBlob)}, nil
+}
+
+func reverseBytes(data []byte) []byte {
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return reversed
+}
+
+func TestAWSKeyManagementService(t *testing.T) {
+	client := &testAWSKMSClient{}
+	secrets := newSecrets(&awsKeyManagementService{keyId: "alias/langsync", client: client})
+	ctx := context.Background()
+
+	encrypted, _, err := secrets.EncryptJSON(ctx, []byte(`{"secret_access_key":"secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := secrets.DecryptJSON(ctx, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodeTestConfig(t, decrypted)["secret_access_key"] != "secret" {
+		t.Errorf("got decrypted config %s", decrypted)
+	}
+	if strings.Join(client.keyIds, ",") != "alias/langsync,alias/langsync" {
+		t.Errorf("got key ids %v", client.keyIds)
+	}
+
+	_, err = secrets.kms.UnwrapKey(ctx, "vault:other", []byte("key"))
+	if err == nil {
+		t.Errorf("unwrapped a key of another key management service")
+	}
+}
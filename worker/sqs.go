@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
@@ -12,14 +15,34 @@ import (
 
 type workerHandlerFunc func(ctx context.Context, logger logrus.FieldLogger, msg types.Message) error
 
+// DeferredError is returned by handlers to process a message later, the message is sent to the queue again with a
+// delay, so deferring doesn't count towards the receive count of the dead letter queue
+type DeferredError struct {
+	Delay  time.Duration
+	Reason string
+}
+
+func (e *DeferredError) Error() string {
+	return fmt.Sprintf("deferred by %s, %s", e.Delay, e.Reason)
+}
+
+// backOffConfig configures the delays between retries of all clients, main sets it from Config.Backoff before any
+// client is created
+var backOffConfig = defaultConfig().Backoff
+
 func newBackOff(ctx context.Context, maxAttempts uint64) backoff.BackOff {
 	expBackoff := backoff.NewExponentialBackOff()
-	// Can change duration here
+	expBackoff.InitialInterval = backOffConfig.InitialInterval
+	expBackoff.MaxInterval = backOffConfig.MaxInterval
+	expBackoff.Multiplier = backOffConfig.Multiplier
+	expBackoff.MaxElapsedTime = backOffConfig.MaxElapsedTime
+	expBackoff.Reset()
 
 	return backoff.WithContext(backoff.WithMaxRetries(expBackoff, maxAttempts), ctx)
 }
 
-func startSQSWorker(ctx context.Context, logger logrus.FieldLogger, sqsClient *sqs.Client, queueUrl string, handler workerHandlerFunc) {
+func startSQSWorker(ctx context.Context, logger logrus.FieldLogger, sqsClient *sqs.Client, queue QueueConfig, handler workerHandlerFunc) {
+	queueUrl := queue.URL
 	logger.Printf("Starting worker for queue %q.\n", queueUrl)
 
 	go func() {
@@ -34,7 +57,9 @@ func startSQSWorker(ctx context.Context, logger logrus.FieldLogger, sqsClient *s
 				QueueUrl:            aws.String(queueUrl),
 				MaxNumberOfMessages: 1, // only process one message at a time to allow for easier multi-worker setup with health checks
 				WaitTimeSeconds:     10,
-				VisibilityTimeout:   20, // make initial timeout a bit longer to allow for health check to run
+				VisibilityTimeout:   int32(queue.VisibilityTimeout.Seconds()),
+				// Attributes are kept when messages are deferred
+				MessageAttributeNames: []string{"All"},
 			})
 			if err != nil {
 				logger.Printf("Unable to receive message from queue %q, %v.", queueUrl, err)
@@ -45,7 +70,14 @@ func startSQSWorker(ctx context.Context, logger logrus.FieldLogger, sqsClient *s
 			for _, msg := range result.Messages {
 				logger.Printf("Processing message %q.\n", *msg.MessageId)
 
-				err := applySQSHealthCheck(ctx, logger, sqsClient, queueUrl, msg, handler)
+				err := applySQSHealthCheck(ctx, logger, sqsClient, queue, msg, handler)
+
+				var deferred *DeferredError
+				if errors.As(err, &deferred) {
+					logger.Printf("Deferring message %q by %s, %s.\n", *msg.MessageId, deferred.Delay, deferred.Reason)
+					err = deferSQSMessage(ctx, sqsClient, queueUrl, msg, deferred.Delay)
+				}
+
 				if err != nil {
 					logger.Printf("Unable to process message %q, %v.\n", *msg.MessageId, err)
 
@@ -80,8 +112,9 @@ func startSQSWorker(ctx context.Context, logger logrus.FieldLogger, sqsClient *s
 	}()
 }
 
-func applySQSHealthCheck(ctx context.Context, logger logrus.FieldLogger, sqsClient *sqs.Client, queueUrl string, msg types.Message, handler workerHandlerFunc) error {
-	healthCheck := time.NewTicker(5 * time.Second)
+func applySQSHealthCheck(ctx context.Context, logger logrus.FieldLogger, sqsClient *sqs.Client, queue QueueConfig, msg types.Message, handler workerHandlerFunc) error {
+	queueUrl := queue.URL
+	healthCheck := time.NewTicker(queue.HeartbeatInterval)
 	done := make(chan bool)
 	defer func() {
 		healthCheck.Stop()
@@ -111,7 +144,7 @@ func applySQSHealthCheck(ctx context.Context, logger logrus.FieldLogger, sqsClie
 				_, err := sqsClient.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
 					QueueUrl:          aws.String(queueUrl),
 					ReceiptHandle:     msg.ReceiptHandle,
-					VisibilityTimeout: 15,
+					VisibilityTimeout: int32(queue.VisibilityExtension.Seconds()),
 				})
 				if err != nil {
 					logger.Printf("Unable to change message visibility, %v.\n", err)
@@ -123,3 +156,67 @@ func applySQSHealthCheck(ctx context.Context, logger logrus.FieldLogger, sqsClie
 
 	return handler(ctx, logger, msg)
 }
+
+// deferSQSMessage sends a copy of msg which becomes visible after delay, the original message must be deleted
+func deferSQSMessage(ctx context.Context, sqsClient *sqs.Client, queueUrl string, msg types.Message, delay time.Duration) error {
+	// SQS doesn't delay messages for more than 15 minutes
+	delaySeconds := int32(delay.Seconds())
+	if delaySeconds > 900 {
+		delaySeconds = 900
+	}
+
+	_, err := sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueUrl),
+		MessageBody:       msg.Body,
+		MessageAttributes: msg.MessageAttributes,
+		DelaySeconds:      delaySeconds,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to send deferred message, %w", err)
+	}
+
+	return nil
+}
+
+// sendIndexMessages sends index messages in batches, the same way the app dispatches pipeline runs
+func sendIndexMessages(ctx context.Context, sqsClient *sqs.Client, queueUrl string, messages []IndexMessage) error {
+	for i := 0; i < len(messages); i += 10 {
+		end := i + 10
+		if end > len(messages) {
+			end = len(messages)
+		}
+		batch := messages[i:end]
+
+		entries := make([]types.SendMessageBatchRequestEntry, 0, len(batch))
+		for _, message := range batch {
+			body, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, types.SendMessageBatchRequestEntry{
+				Id:          aws.String(message.MessageId),
+				MessageBody: aws.String(string(body)),
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					"pipelineId":   {DataType: aws.String("String"), StringValue: aws.String(message.Payload.PipelineId)},
+					"accountId":    {DataType: aws.String("String"), StringValue: aws.String(message.AccountId)},
+					"runId":        {DataType: aws.String("String"), StringValue: aws.String(message.Payload.RunId)},
+					"dataSourceId": {DataType: aws.String("String"), StringValue: aws.String(message.Payload.DataSourceId)},
+				},
+			})
+		}
+
+		res, err := sqsClient.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: aws.String(queueUrl),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to send batch to %q, %w", queueUrl, err)
+		}
+		if len(res.Failed) > 0 {
+			return fmt.Errorf("unable to send %d messages to %q, %s", len(res.Failed), queueUrl, aws.ToString(res.Failed[0].Message))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+type DocumentFormat string
+
+const (
+	DocumentFormatPlainText DocumentFormat = "text"
+	DocumentFormatMarkdown  DocumentFormat = "markdown"
+	DocumentFormatHTML      DocumentFormat = "html"
+	DocumentFormatPDF       DocumentFormat = "pdf"
+	DocumentFormatDOCX      DocumentFormat = "docx"
+)
+
+const (
+	SkipReasonUnsupportedFormat = "unsupported_format"
+	SkipReasonInvalidEncoding   = "invalid_encoding"
+	SkipReasonTooLarge          = "too_large"
+	SkipReasonExtractionFailed  = "extraction_failed"
+)
+
+// detectDocumentFormat uses the file extension and falls back to the content type
+func detectDocumentFormat(filename, contentType string) (DocumentFormat, bool) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".txt", ".text":
+		return DocumentFormatPlainText, true
+	case ".md", ".markdown", ".mdx":
+		return DocumentFormatMarkdown, true
+	case ".html", ".htm", ".xhtml":
+		return DocumentFormatHTML, true
+	case ".pdf":
+		return DocumentFormatPDF, true
+	case ".docx":
+		return DocumentFormatDOCX, true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	switch mediaType {
+	case "text/plain":
+		return DocumentFormatPlainText, true
+	case "text/markdown", "text/x-markdown":
+		return DocumentFormatMarkdown, true
+	case "text/html", "application/xhtml+xml":
+		return DocumentFormatHTML, true
+	case "application/pdf":
+		return DocumentFormatPDF, true
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return DocumentFormatDOCX, true
+	}
+
+	return "", false
+}
+
+// extractDocumentText converts a file to text, returning a SkippedDocumentError for formats we can't handle
+func extractDocumentText(content []byte, filename, contentType string) (string, error) {
+	format, ok := detectDocumentFormat(filename, contentType)
+	if !ok {
+		return "", &SkippedDocumentError{Reason: SkipReasonUnsupportedFormat}
+	}
+
+	switch format {
+	case DocumentFormatPlainText, DocumentFormatMarkdown:
+		if !utf8.Valid(content) {
+			return "", &SkippedDocumentError{Reason: SkipReasonInvalidEncoding}
+		}
+		return string(content), nil
+	case DocumentFormatHTML:
+		doc, err := htmlToMarkdown(string(content), nil)
+		if err != nil {
+			return "", &SkippedDocumentError{Reason: SkipReasonExtractionFailed}
+		}
+		if doc.Title != "" && !strings.HasPrefix(doc.Markdown, "# ") {
+			return "# " + doc.Title + "\n\n" + doc.Markdown, nil
+		}
+		return doc.Markdown, nil
+	case DocumentFormatPDF:
+		text, err := extractPDFText(content)
+		if err != nil {
+			return "", &SkippedDocumentError{Reason: SkipReasonExtractionFailed}
+		}
+		return text, nil
+	case DocumentFormatDOCX:
+		text, err := extractDOCXText(content)
+		if err != nil {
+			return "", &SkippedDocumentError{Reason: SkipReasonExtractionFailed}
+		}
+		return text, nil
+	}
+
+	return "", &SkippedDocumentError{Reason: SkipReasonUnsupportedFormat}
+}
+
+func extractPDFText(content []byte) (text string, err error) {
+	// The PDF parser panics on some malformed documents
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to parse pdf, %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("unable to open pdf, %w", err)
+	}
+
+	pages := make([]string, 0, reader.NumPage())
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		pageText, err := page.GetPlainText(nil)
+		if err != nil {
+			return "", fmt.Errorf("unable to extract text of page %d, %w", i, err)
+		}
+
+		pageText = strings.TrimSpace(pageText)
+		if pageText != "" {
+			pages = append(pages, pageText)
+		}
+	}
+
+	return strings.Join(pages, "\n\n"), nil
+}
+
+// extractDOCXText renders the main document part of a DOCX file, keeping headings, list items and tables
+func extractDOCXText(content []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("unable to open docx, %w", err)
+	}
+
+	var documentFile *zip.File
+	for _, f := range archive.File {
+		if f.Name == "word/document.xml" {
+			documentFile = f
+			break
+		}
+	}
+	if documentFile == nil {
+		return "", fmt.Errorf("docx is missing word/document.xml")
+	}
+
+	rc, err := documentFile.Open()
+	if err != nil {
+		return "", fmt.Errorf("unable to open document.xml, %w", err)
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(io.LimitReader(rc, 50*1024*1024))
+
+	var (
+		paragraphs []string
+		paragraph  strings.Builder
+		prefix     string
+		inText     bool
+		tableDepth int
+		tableRows  [][]string
+		row        []string
+		cell       []string
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to parse document.xml, %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paragraph.Reset()
+				prefix = ""
+			case "pStyle":
+				for _, attr := range t.Attr {
+					if attr.Name.Local != "val" {
+						continue
+					}
+					style := strings.ToLower(attr.Value)
+					if strings.HasPrefix(style, "heading") && len(style) == len("heading")+1 {
+						level := int(style[len(style)-1] - '0')
+						if level >= 1 && level <= 6 {
+							prefix = strings.Repeat("#", level) + " "
+						}
+					} else if style == "title" {
+						prefix = "# "
+					}
+				}
+			case "numPr":
+				if prefix == "" {
+					prefix = "- "
+				}
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			case "tbl":
+				tableDepth++
+			case "tr":
+				row = nil
+			case "tc":
+				cell = nil
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(paragraph.String())
+				if tableDepth > 0 {
+					if text != "" {
+						cell = append(cell, strings.ReplaceAll(text, "\n", " "))
+					}
+					continue
+				}
+				if text != "" {
+					paragraphs = append(paragraphs, prefix+text)
+				}
+			case "tc":
+				row = append(row, strings.ReplaceAll(strings.Join(cell, " "), "|", "\\|"))
+			case "tr":
+				if len(row) > 0 {
+					tableRows = append(tableRows, row)
+				}
+				row = nil
+			case "tbl":
+				tableDepth--
+				if tableDepth == 0 {
+					if table := strings.TrimSpace(markdownTable(tableRows)); table != "" {
+						paragraphs = append(paragraphs, table)
+					}
+					tableRows = nil
+				}
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		}
+	}
+
+	return strings.Join(paragraphs, "\n\n"), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// newTestDOCX returns a DOCX file whose main document part has body as its body
+func newTestDOCX(t *testing.T, body string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	f, err := archive.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body + `</w:body></w:document>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectDocumentFormat(t *testing.T) {
+	tests := []struct {
+		filename    string
+		contentType string
+		want        DocumentFormat
+		wantOk      bool
+	}{
+		{filename: "README.MD", want: DocumentFormatMarkdown, wantOk: true},
+		{filename: "index.htm", contentType: "text/plain", want: DocumentFormatHTML, wantOk: true},
+		{filename: "report", contentType: "application/pdf", want: DocumentFormatPDF, wantOk: true},
+		{filename: "notes", contentType: "text/plain; charset=utf-8", want: DocumentFormatPlainText, wantOk: true},
+		{filename: "image.png", contentType: "image/png"},
+		{filename: "archive"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			format, ok := detectDocumentFormat(test.filename, test.contentType)
+			if format != test.want || ok != test.wantOk {
+				t.Errorf("got %q, %v, want %q, %v", format, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
+
+func TestExtractDocumentText(t *testing.T) {
+	docx := newTestDOCX(t, `
+		<w:p><w:pPr><w:pStyle w:val="Heading2"/></w:pPr><w:r><w:t>Setup</w:t></w:r></w:p>
+		<w:p><w:r><w:t xml:space="preserve">Run </w:t></w:r><w:r><w:t>make</w:t></w:r></w:p>
+		<w:p><w:pPr><w:numPr/></w:pPr><w:r><w:t>Install Go</w:t></w:r></w:p>
+		<w:tbl>
+			<w:tr><w:tc><w:p><w:r><w:t>Key</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Value</w:t></w:r></w:p></w:tc></w:tr>
+			<w:tr><w:tc><w:p><w:r><w:t>a|b</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>1</w:t></w:r></w:p></w:tc></w:tr>
+		</w:tbl>`)
+
+	tests := []struct {
+		name     string
+		content  []byte
+		filename string
+		want     string
+	}{
+		{name: "text", content: []byte("Hello"), filename: "a.txt", want: "Hello"},
+		{name: "html", content: []byte("<html><head><title>Guide</title></head><body><h2>Setup</h2><p>Run <b>make</b></p></body></html>"), filename: "a.html", want: "# Guide\n\n## Setup\n\nRun **make**"},
+		{name: "docx", content: docx, filename: "a.docx", want: "## Setup\n\nRun make\n\n- Install Go\n\n| Key | Value |\n| --- | --- |\n| a\\|b | 1 |"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text, err := extractDocumentText(test.content, test.filename, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != test.want {
+				t.Errorf("got %q, want %q", text, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractDocumentTextSkips(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    []byte
+		filename   string
+		wantReason string
+	}{
+		{name: "unsupported format", content: []byte("data"), filename: "a.bin", wantReason: SkipReasonUnsupportedFormat},
+		{name: "invalid encoding", content: []byte{0xff, 0xfe, 0x00}, filename: "a.txt", wantReason: SkipReasonInvalidEncoding},
+		{name: "malformed pdf", content: []byte("%PDF-1.4 broken"), filename: "a.pdf", wantReason: SkipReasonExtractionFailed},
+		{name: "malformed docx", content: []byte("not a zip"), filename: "a.docx", wantReason: SkipReasonExtractionFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := extractDocumentText(test.content, test.filename, "")
+			skippedErr := &SkippedDocumentError{}
+			if !errors.As(err, &skippedErr) || skippedErr.Reason != test.wantReason {
+				t.Errorf("got %v, want %s", err, test.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,785 @@
+package main
+
+import (
+	"compress/gzip"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/temoto/robotstxt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type WebDocumentType string
+
+const (
+	WebDocumentTypePage WebDocumentType = "page"
+)
+
+const (
+	webUserAgent        = "langsync-bot/1.0 (+https://langsync.gradientsandgrit.com)"
+	webRobotsUserAgent  = "langsync-bot"
+	webMaxBodySize      = 10 * 1024 * 1024
+	webDefaultMaxPages  = 1000
+	webDefaultMaxDepth  = 3
+	webDefaultDelay     = time.Second
+	webRobotsCacheTTL   = time.Hour
+	webMaxSitemapDepth  = 3
+	webMaxDocumentIdLen = 1024
+	webPageCacheTTL     = time.Hour
+	webPageCacheMaxSize = 64 * 1024 * 1024
+)
+
+type WebClientImpl struct {
+	logger    logrus.FieldLogger
+	transport *ProviderTransport
+
+	// hostThrottles holds one *webHostThrottle per host, shared by all workers so that we're polite to every host
+	hostThrottles sync.Map
+
+	// robots holds one *webRobotsEntry per scheme+host
+	robots sync.Map
+
+	// pages holds the pages converted while crawling
+	pages *webPageCache
+}
+
+type webHostThrottle struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the next request to the host may be sent and reserves the slot after it
+func (t *webHostThrottle) wait(ctx context.Context, delay time.Duration) error {
+	t.mu.Lock()
+	now := time.Now()
+	slot := t.next
+	if slot.Before(now) {
+		slot = now
+	}
+	t.next = slot.Add(delay)
+	t.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type webRobotsEntry struct {
+	group     *robotstxt.Group
+	fetchedAt time.Time
+}
+
+// webPageCache keeps the pages converted while crawling, so that changed pages aren't fetched again to retrieve their
+// content. Pages that aren't retrieved, e.g. because they're unchanged, are evicted after webPageCacheTTL or once the
+// cache exceeds webPageCacheMaxSize, oldest first.
+type webPageCache struct {
+	mu    sync.Mutex
+	pages map[string]*list.Element
+	// order holds *webCachedPage, oldest first
+	order *list.List
+	size  int
+}
+
+type webCachedPage struct {
+	id       string
+	doc      HTMLDocument
+	cachedAt time.Time
+}
+
+func (page *webCachedPage) size() int {
+	return len(page.doc.Markdown) + len(page.doc.Title) + len(page.doc.Description)
+}
+
+func newWebPageCache() *webPageCache {
+	return &webPageCache{
+		pages: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (cache *webPageCache) remove(element *list.Element) {
+	page := cache.order.Remove(element).(*webCachedPage)
+	delete(cache.pages, page.id)
+	cache.size -= page.size()
+}
+
+func (cache *webPageCache) put(id string, doc HTMLDocument) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.pages[id]; ok {
+		cache.remove(element)
+	}
+
+	page := &webCachedPage{id: id, doc: doc, cachedAt: time.Now()}
+	cache.pages[id] = cache.order.PushBack(page)
+	cache.size += page.size()
+
+	for front := cache.order.Front(); front != nil; front = cache.order.Front() {
+		if cache.size <= webPageCacheMaxSize && time.Since(front.Value.(*webCachedPage).cachedAt) < webPageCacheTTL {
+			break
+		}
+		cache.remove(front)
+	}
+}
+
+// take removes a page from the cache and returns it, if it was cached less than webPageCacheTTL ago
+func (cache *webPageCache) take(id string) (HTMLDocument, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.pages[id]
+	if !ok {
+		return HTMLDocument{}, false
+	}
+	cache.remove(element)
+
+	page := element.Value.(*webCachedPage)
+	if time.Since(page.cachedAt) >= webPageCacheTTL {
+		return HTMLDocument{}, false
+	}
+	return page.doc, true
+}
+
+func isNonPublicIP(ip net.IP) bool {
+	return ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// isNonPublicAddr reports whether a connection went to a non-public address, unknown addresses count as non-public
+func isNonPublicAddr(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	return isNonPublicIP(tcpAddr.IP)
+}
+
+// newPublicOnlyTransport creates a transport which, unless allowPrivateNetworks is set, refuses to connect to
+// loopback, private and link-local addresses so that user-provided URLs can't be used to reach internal services
+func newPublicOnlyTransport(allowPrivateNetworks bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+	}
+	if !allowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if isNonPublicIP(net.ParseIP(host)) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return transport
+}
+
+func newWebClient(config WebConfig, logger logrus.FieldLogger) DataSourceApiClient {
+	httpClient := &http.Client{
+		Timeout:   config.Timeout,
+		Transport: newPublicOnlyTransport(config.AllowPrivateNetworks),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("stopped after 5 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unsupported redirect to %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+
+	return &WebClientImpl{
+		logger:    logger,
+		transport: newProviderTransport("web", httpClient, nil, config.MaxAttempts, nil),
+		pages:     newWebPageCache(),
+	}
+}
+
+type webResponse struct {
+	url         *url.URL
+	statusCode  int
+	contentType string
+	etag        string
+	modified    string
+	body        []byte
+
+	// private is set if the page was served from a non-public address, see WebConfig.AllowPrivateNetworks
+	private bool
+}
+
+// freshnessIndicator prefers validators sent by the server and falls back to hashing the body
+func (res *webResponse) freshnessIndicator() string {
+	if res.etag != "" {
+		return "etag:" + res.etag
+	}
+	if res.modified != "" {
+		return "last-modified:" + res.modified
+	}
+	hash := sha256.Sum256(res.body)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// acl returns who may access the page. Pages served from private networks are intranet pages, we can't tell who may
+// access them.
+func (res *webResponse) acl() []string {
+	if res.private {
+		return nil
+	}
+	return []string{ACLPublic}
+}
+
+func (res *webResponse) isHTML() bool {
+	mediaType, _, err := mime.ParseMediaType(res.contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func (client *WebClientImpl) throttle(ctx context.Context, host string, delay time.Duration) error {
+	throttle, _ := client.hostThrottles.LoadOrStore(host, &webHostThrottle{})
+	return throttle.(*webHostThrottle).wait(ctx, delay)
+}
+
+// fetch sends a GET request after waiting for the host's politeness delay, retrying on rate limits and server errors.
+// Responses with other statuses are returned, e.g. robots.txt is interpreted by status.
+func (client *WebClientImpl) fetch(ctx context.Context, target *url.URL, delay time.Duration) (*webResponse, error) {
+	// The connection of the last request tells where the page was served from after redirects
+	var remoteAddr net.Addr
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			remoteAddr = info.Conn.RemoteAddr()
+		},
+	}
+
+	res, err := client.transport.Do(httptrace.WithClientTrace(ctx, trace), providerRequest{
+		Method: http.MethodGet,
+		URL:    target.String(),
+		Header: http.Header{
+			"User-Agent": {webUserAgent},
+			"Accept":     {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		},
+		Prepare: func(req *http.Request) error {
+			return client.throttle(ctx, target.Host, delay)
+		},
+		AcceptStatus: func(statusCode int) bool {
+			return statusCode != http.StatusTooManyRequests && statusCode < 500
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var reader io.Reader = res.Body
+	if strings.HasSuffix(res.Request.URL.Path, ".gz") && res.Header.Get("Content-Type") != "text/html" {
+		gzipReader, err := gzip.NewReader(res.Body)
+		if err == nil {
+			defer gzipReader.Close()
+			reader = gzipReader
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, webMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &webResponse{
+		url:         res.Request.URL,
+		statusCode:  res.StatusCode,
+		contentType: res.Header.Get("Content-Type"),
+		etag:        res.Header.Get("ETag"),
+		modified:    res.Header.Get("Last-Modified"),
+		body:        body,
+		private:     isNonPublicAddr(remoteAddr),
+	}, nil
+}
+
+// robotsGroup returns the robots.txt rules applying to our user agent, robots.txt is cached per host. Errors are
+// transient, robots.txt failing with a server error is retried by fetch.
+func (client *WebClientImpl) robotsGroup(ctx context.Context, target *url.URL, delay time.Duration) (*robotstxt.Group, error) {
+	key := target.Scheme + "://" + target.Host
+	if entry, ok := client.robots.Load(key); ok && time.Since(entry.(*webRobotsEntry).fetchedAt) < webRobotsCacheTTL {
+		return entry.(*webRobotsEntry).group, nil
+	}
+
+	robotsURL := &url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/robots.txt"}
+	res, err := client.fetch(ctx, robotsURL, delay)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch robots.txt, %w", err)
+	}
+
+	// 4xx allows everything
+	data, err := robotstxt.FromStatusAndBytes(res.statusCode, res.body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse robots.txt, %w", err)
+	}
+
+	group := data.FindGroup(webRobotsUserAgent)
+	client.robots.Store(key, &webRobotsEntry{group: group, fetchedAt: time.Now()})
+
+	return group, nil
+}
+
+// allowedByRobots checks robots.txt and returns the delay to use for the host
+func (client *WebClientImpl) allowedByRobots(ctx context.Context, target *url.URL, delay time.Duration) (bool, time.Duration, error) {
+	group, err := client.robotsGroup(ctx, target, delay)
+	if err != nil {
+		return false, delay, err
+	}
+
+	if group.CrawlDelay > delay {
+		delay = group.CrawlDelay
+	}
+
+	path := target.EscapedPath()
+	if target.RawQuery != "" {
+		path += "?" + target.RawQuery
+	}
+
+	return group.Test(path), delay, nil
+}
+
+// normalizeWebURL drops fragments and rejects non-http(s) URLs, the result is used as document id
+func normalizeWebURL(u *url.URL) (*url.URL, bool) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, false
+	}
+	normalized := *u
+	normalized.Fragment = ""
+	normalized.RawFragment = ""
+	normalized.Host = strings.ToLower(normalized.Host)
+	if normalized.Path == "" {
+		normalized.Path = "/"
+	}
+	if len(normalized.String()) > webMaxDocumentIdLen {
+		return nil, false
+	}
+	return &normalized, true
+}
+
+type webCrawlScope struct {
+	domains      []string
+	pathPrefixes []string
+}
+
+func (scope webCrawlScope) contains(u *url.URL) bool {
+	host := u.Hostname()
+	domainMatches := false
+	for _, domain := range scope.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			domainMatches = true
+			break
+		}
+	}
+	if !domainMatches {
+		return false
+	}
+
+	if len(scope.pathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range scope.pathPrefixes {
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// loadSitemap returns all page URLs listed in a sitemap, following sitemap indexes
+func (client *WebClientImpl) loadSitemap(ctx context.Context, sitemapURL *url.URL, delay time.Duration, depth int) ([]*url.URL, error) {
+	if depth > webMaxSitemapDepth {
+		return nil, nil
+	}
+
+	res, err := client.fetch(ctx, sitemapURL, delay)
+	if err != nil {
+		return nil, err
+	}
+	if res.statusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d for sitemap %q", res.statusCode, sitemapURL)
+	}
+
+	var sitemap sitemapDocument
+	err = xml.Unmarshal(res.body, &sitemap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse sitemap %q, %w", sitemapURL, err)
+	}
+
+	var pageURLs []*url.URL
+	for _, entry := range sitemap.URLs {
+		u, err := url.Parse(strings.TrimSpace(entry.Loc))
+		if err != nil {
+			continue
+		}
+		pageURLs = append(pageURLs, u)
+	}
+
+	for _, entry := range sitemap.Sitemaps {
+		u, err := url.Parse(strings.TrimSpace(entry.Loc))
+		if err != nil {
+			continue
+		}
+		nested, err := client.loadSitemap(ctx, u, delay, depth+1)
+		if err != nil {
+			client.logger.Printf("Unable to load nested sitemap %q, %v\n", u, err)
+			continue
+		}
+		pageURLs = append(pageURLs, nested...)
+	}
+
+	return pageURLs, nil
+}
+
+type webCrawlItem struct {
+	url    *url.URL
+	depth  int
+	follow bool
+}
+
+// webCrawl is the parsed crawl configuration of a data source
+type webCrawl struct {
+	seedURLs    []*url.URL
+	sitemapURLs []*url.URL
+	maxPages    int
+	maxDepth    int
+	delay       time.Duration
+	scope       webCrawlScope
+}
+
+func parseWebCrawl(dataSource *PipelineDataSource) (*webCrawl, error) {
+	config := dataSource.WebDataSource.Config
+
+	crawl := &webCrawl{
+		maxPages: config.MaxPages,
+		maxDepth: config.MaxDepth,
+		delay:    time.Duration(config.CrawlDelayMs) * time.Millisecond,
+		scope: webCrawlScope{
+			domains:      config.AllowedDomains,
+			pathPrefixes: config.PathPrefixes,
+		},
+	}
+	if crawl.maxPages <= 0 {
+		crawl.maxPages = webDefaultMaxPages
+	}
+	if crawl.maxDepth <= 0 {
+		crawl.maxDepth = webDefaultMaxDepth
+	}
+	if crawl.delay <= 0 {
+		crawl.delay = webDefaultDelay
+	}
+
+	for _, seed := range config.SeedURLs {
+		u, err := url.Parse(seed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed url %q, %w", seed, err)
+		}
+		crawl.seedURLs = append(crawl.seedURLs, u)
+	}
+
+	for _, sitemap := range config.SitemapURLs {
+		u, err := url.Parse(sitemap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sitemap url %q, %w", sitemap, err)
+		}
+		crawl.sitemapURLs = append(crawl.sitemapURLs, u)
+	}
+
+	if len(crawl.seedURLs) == 0 && len(crawl.sitemapURLs) == 0 {
+		return nil, fmt.Errorf("neither seed urls nor sitemaps configured")
+	}
+
+	// Without explicit domains, stay on the hosts we started from
+	if len(crawl.scope.domains) == 0 {
+		for _, u := range crawl.seedURLs {
+			crawl.scope.domains = append(crawl.scope.domains, u.Hostname())
+		}
+		for _, u := range crawl.sitemapURLs {
+			crawl.scope.domains = append(crawl.scope.domains, u.Hostname())
+		}
+	}
+
+	return crawl, nil
+}
+
+func (client *WebClientImpl) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	crawl, err := parseWebCrawl(dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	var queue []webCrawlItem
+	for _, seedURL := range crawl.seedURLs {
+		queue = append(queue, webCrawlItem{url: seedURL, follow: true})
+	}
+
+	for _, sitemapURL := range crawl.sitemapURLs {
+		client.logger.Printf("Loading sitemap %q\n", sitemapURL)
+		pageURLs, err := client.loadSitemap(ctx, sitemapURL, crawl.delay, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load sitemap, %w", err)
+		}
+
+		// Sitemaps are expected to be complete, so we don't follow links on the listed pages
+		for _, pageURL := range pageURLs {
+			queue = append(queue, webCrawlItem{url: pageURL, depth: crawl.maxDepth})
+		}
+	}
+
+	indexedDocuments := make(map[string]IndexedDocument)
+	visited := make(map[string]bool)
+
+	// Pages which couldn't be fetched for now, e.g. due to server errors, may still exist, so the list is incomplete
+	failures := 0
+	var failure error
+	fail := func(target *url.URL, err error) {
+		client.logger.Printf("Unable to fetch %q, skipping, %v\n", target, err)
+		if failures == 0 {
+			failure = fmt.Errorf("unable to fetch %q, %w", target, err)
+		}
+		failures++
+	}
+
+	for len(queue) > 0 && len(indexedDocuments) < crawl.maxPages {
+		item := queue[0]
+		queue = queue[1:]
+
+		target, ok := normalizeWebURL(item.url)
+		if !ok || visited[target.String()] || !crawl.scope.contains(target) {
+			continue
+		}
+		visited[target.String()] = true
+
+		allowed, hostDelay, err := client.allowedByRobots(ctx, target, crawl.delay)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			fail(target, err)
+			continue
+		}
+		if !allowed {
+			continue
+		}
+
+		// Rate limits and server errors are returned as errors once all attempts failed, other statuses mean the page
+		// is gone or not a page
+		res, err := client.fetch(ctx, target, hostDelay)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			fail(target, err)
+			continue
+		}
+		if res.statusCode != http.StatusOK || !res.isHTML() {
+			continue
+		}
+
+		// Redirects may have taken us somewhere else
+		finalURL, ok := normalizeWebURL(res.url)
+		if !ok || !crawl.scope.contains(finalURL) {
+			continue
+		}
+		visited[finalURL.String()] = true
+
+		doc, err := htmlToMarkdown(string(res.body), finalURL)
+		if err != nil {
+			continue
+		}
+		client.pages.put(finalURL.String(), doc)
+
+		indexedDocuments[finalURL.String()] = IndexedDocument{
+			Integration:        IntegrationWeb,
+			DocumentType:       string(WebDocumentTypePage),
+			Id:                 finalURL.String(),
+			Title:              doc.Title,
+			URL:                finalURL.String(),
+			FreshnessIndicator: res.freshnessIndicator(),
+			ACL:                res.acl(),
+		}
+
+		if !item.follow || item.depth >= crawl.maxDepth {
+			continue
+		}
+
+		links, err := htmlLinks(string(res.body), finalURL)
+		if err != nil {
+			continue
+		}
+		for _, link := range links {
+			queue = append(queue, webCrawlItem{url: link, depth: item.depth + 1, follow: true})
+		}
+	}
+
+	client.logger.Printf("Found %d web pages\n", len(indexedDocuments))
+
+	if failures > 0 {
+		return indexedDocuments, &IncompleteListError{Err: fmt.Errorf("%d pages failed, %w", failures, failure)}
+	}
+
+	return indexedDocuments, nil
+}
+
+// pageTarget maps a page id (the normalized URL) to its URL, pages outside the crawl scope of the data source are out
+// of scope
+func pageTarget(dataSource *PipelineDataSource, documentType, id string) (*webCrawl, *url.URL, error) {
+	if documentType != string(WebDocumentTypePage) {
+		return nil, nil, fmt.Errorf("unknown document type %q", documentType)
+	}
+
+	crawl, err := parseWebCrawl(dataSource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid document id %q, %w", id, err)
+	}
+	target, ok := normalizeWebURL(u)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid document id %q", id)
+	}
+	if !crawl.scope.contains(target) {
+		return nil, nil, ErrDocumentOutOfScope
+	}
+
+	return crawl, target, nil
+}
+
+// fetchPage retrieves a single page by its id
+func (client *WebClientImpl) fetchPage(ctx context.Context, dataSource *PipelineDataSource, documentType, id string) (*webResponse, error) {
+	crawl, target, err := pageTarget(dataSource, documentType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, delay, err := client.allowedByRobots(ctx, target, crawl.delay)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%q is disallowed by robots.txt", id)
+	}
+
+	res, err := client.fetch(ctx, target, delay)
+	if err != nil {
+		return nil, err
+	}
+	if res.statusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.statusCode)
+	}
+	if !res.isHTML() {
+		return nil, fmt.Errorf("unsupported content type %q", res.contentType)
+	}
+
+	// Redirects may have taken us somewhere else
+	finalURL, ok := normalizeWebURL(res.url)
+	if !ok || !crawl.scope.contains(finalURL) {
+		return nil, ErrDocumentOutOfScope
+	}
+
+	return res, nil
+}
+
+func (client *WebClientImpl) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+	res, err := client.fetchPage(ctx, dataSource, documentType, id)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+
+	doc, err := htmlToMarkdown(string(res.body), res.url)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+
+	return IndexedDocument{
+		Integration:        IntegrationWeb,
+		DocumentType:       string(WebDocumentTypePage),
+		Id:                 id,
+		Title:              doc.Title,
+		URL:                id,
+		FreshnessIndicator: res.freshnessIndicator(),
+		ACL:                res.acl(),
+	}, nil
+}
+
+func (client *WebClientImpl) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
+	_, _, err := pageTarget(dataSource, documentType, id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Pages listed by the crawl are indexed with the content they had while crawling
+	doc, ok := client.pages.take(id)
+	if !ok {
+		res, err := client.fetchPage(ctx, dataSource, documentType, id)
+		if err != nil {
+			return "", nil, err
+		}
+
+		doc, err = htmlToMarkdown(string(res.body), res.url)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	textContent := doc.Markdown
+	if doc.Title != "" && !strings.HasPrefix(textContent, "# ") {
+		textContent = "# " + doc.Title + "\n\n" + textContent
+	}
+
+	metadata := map[string]any{
+		"title": doc.Title,
+		"url":   id,
+	}
+	if doc.Description != "" {
+		metadata["description"] = doc.Description
+	}
+	if doc.Language != "" {
+		metadata["language"] = doc.Language
+	}
+
+	return textContent, metadata, nil
+}
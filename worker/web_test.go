@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testWebSite serves pages by path and counts the requests of every path
+type testWebSite struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	pages    map[string]string
+	statuses map[string]int
+	requests map[string]int
+}
+
+func newTestWebSite(t *testing.T, pages map[string]string, statuses map[string]int) *testWebSite {
+	site := &testWebSite{pages: pages, statuses: statuses, requests: make(map[string]int)}
+	site.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mutex.Lock()
+		site.requests[r.URL.Path]++
+		site.mutex.Unlock()
+
+		if status, ok := site.statuses[r.URL.Path]; ok {
+			w.WriteHeader(status)
+			return
+		}
+		page, ok := site.pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == "/robots.txt" {
+			w.Header().Set("Content-Type", "text/plain")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		_, _ = io.WriteString(w, page)
+	}))
+	t.Cleanup(site.Close)
+	return site
+}
+
+func (site *testWebSite) requestCount(path string) int {
+	site.mutex.Lock()
+	defer site.mutex.Unlock()
+	return site.requests[path]
+}
+
+func newTestWebClient() *WebClientImpl {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	config := WebConfig{AllowPrivateNetworks: true, HTTPClientConfig: HTTPClientConfig{Timeout: time.Second, MaxAttempts: 1}}
+	return newWebClient(config, logger).(*WebClientImpl)
+}
+
+func newWebDataSource(seedURLs ...string) *PipelineDataSource {
+	dataSource := &PipelineDataSource{}
+	dataSource.IntegrationName = IntegrationWeb
+	dataSource.WebDataSource.Config.SeedURLs = seedURLs
+	dataSource.WebDataSource.Config.CrawlDelayMs = 1
+	return dataSource
+}
+
+func webDocumentIds(site *testWebSite, docs map[string]IndexedDocument) []string {
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, strings.TrimPrefix(id, site.URL))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestWebCrawl(t *testing.T) {
+	site := newTestWebSite(t, map[string]string{
+		"/robots.txt": "User-agent: *\nDisallow: /private\n",
+		"/": `<html><head><title>Home</title></head><body>
+			<a href="/a#intro">A</a> <a href="/private/x">Private</a> <a href="/missing">Missing</a>
+			<a href="https://example.com/">Elsewhere</a> <a href="mailto:hi@example.com">Mail</a>
+		</body></html>`,
+		"/a":         `<html><body><h1>A</h1><a href="b">B</a></body></html>`,
+		"/b":         `<html><body><h1>B</h1><a href="/c">C</a></body></html>`,
+		"/c":         `<html><body><h1>C</h1></body></html>`,
+		"/private/x": `<html><body>secret</body></html>`,
+	}, nil)
+
+	client := newTestWebClient()
+	dataSource := newWebDataSource(site.URL + "/")
+	dataSource.WebDataSource.Config.MaxDepth = 2
+	ctx := context.Background()
+
+	docs, err := client.ListDocuments(ctx, dataSource, IntegrationConnection{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// /c is beyond the maximum depth, /private is disallowed by robots.txt and /missing doesn't exist
+	if ids, want := webDocumentIds(site, docs), []string{"/", "/a", "/b"}; strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Errorf("crawled %v, want %v", ids, want)
+	}
+	if site.requestCount("/private/x") != 0 {
+		t.Errorf("fetched a page disallowed by robots.txt")
+	}
+	if doc := docs[site.URL+"/"]; doc.Title != "Home" || doc.FreshnessIndicator == "" {
+		t.Errorf("got document %+v", doc)
+	}
+
+	// Content of crawled pages is retrieved without fetching them again
+	content, metadata, err := client.GetDocumentContent(ctx, dataSource, "page", site.URL+"/a", IntegrationConnection{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "# A\n\n[B]("+site.URL+"/b)" || metadata["title"] != "A" {
+		t.Errorf("got content %q, metadata %v", content, metadata)
+	}
+	if n := site.requestCount("/a"); n != 1 {
+		t.Errorf("page was fetched %d times", n)
+	}
+
+	// Pages are only taken from the cache once
+	_, _, err = client.GetDocumentContent(ctx, dataSource, "page", site.URL+"/a", IntegrationConnection{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := site.requestCount("/a"); n != 2 {
+		t.Errorf("page was fetched %d times", n)
+	}
+
+	_, _, err = client.GetDocumentContent(ctx, dataSource, "page", "https://example.com/", IntegrationConnection{})
+	if !errors.Is(err, ErrDocumentOutOfScope) {
+		t.Errorf("got error %v for a page of another host", err)
+	}
+}
+
+func TestWebCrawlTransientFailures(t *testing.T) {
+	site := newTestWebSite(t, map[string]string{
+		"/":     `<html><body><a href="/gone">Gone</a> <a href="/flaky">Flaky</a></body></html>`,
+		"/gone": "",
+	}, map[string]int{
+		"/gone":  http.StatusGone,
+		"/flaky": http.StatusBadGateway,
+	})
+
+	docs, err := newTestWebClient().ListDocuments(context.Background(), newWebDataSource(site.URL+"/"), IntegrationConnection{})
+
+	// Pages which are gone are left out, pages failing with server errors make the list incomplete
+	var incomplete *IncompleteListError
+	if !errors.As(err, &incomplete) || !strings.Contains(err.Error(), "/flaky") {
+		t.Fatalf("got error %v, want an incomplete list", err)
+	}
+	if ids := webDocumentIds(site, docs); strings.Join(ids, ",") != "/" {
+		t.Errorf("crawled %v", ids)
+	}
+}
+
+func TestWebRobots(t *testing.T) {
+	tests := []struct {
+		name      string
+		robots    string
+		status    int
+		path      string
+		allowed   bool
+		delay     time.Duration
+		wantError bool
+	}{
+		{name: "allowed", robots: "User-agent: *\nDisallow: /private\n", path: "/docs", allowed: true, delay: time.Millisecond},
+		{name: "disallowed", robots: "User-agent: *\nDisallow: /private\n", path: "/private/a", delay: time.Millisecond},
+		{name: "our user agent", robots: "User-agent: langsync-bot\nDisallow: /\n\nUser-agent: *\nAllow: /\n", path: "/docs", delay: time.Millisecond},
+		{name: "query", robots: "User-agent: *\nDisallow: /search?\n", path: "/search?q=a", delay: time.Millisecond},
+		{name: "crawl delay", robots: "User-agent: *\nCrawl-delay: 2\n", path: "/docs", allowed: true, delay: 2 * time.Second},
+		{name: "missing robots.txt", status: http.StatusNotFound, path: "/docs", allowed: true, delay: time.Millisecond},
+		{name: "server error", status: http.StatusServiceUnavailable, path: "/docs", wantError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statuses := map[string]int{}
+			if test.status != 0 {
+				statuses["/robots.txt"] = test.status
+			}
+			site := newTestWebSite(t, map[string]string{"/robots.txt": test.robots}, statuses)
+
+			target, err := url.Parse(site.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			allowed, delay, err := newTestWebClient().allowedByRobots(context.Background(), target, time.Millisecond)
+			if test.wantError {
+				if err == nil {
+					t.Errorf("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != test.allowed || delay != test.delay {
+				t.Errorf("allowed = %v, delay = %v, want %v, %v", allowed, delay, test.allowed, test.delay)
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	baseURL, _ := url.Parse("https://example.com/docs/guide")
+
+	tests := []struct {
+		name string
+		html string
+		want HTMLDocument
+	}{
+		{
+			name: "metadata",
+			html: `<html lang="en"><head><title> Guide
+				- Example </title><meta name="description" content="How to use it"></head><body><p>Hi</p></body></html>`,
+			want: HTMLDocument{Title: "Guide - Example", Description: "How to use it", Language: "en", Markdown: "Hi"},
+		},
+		{
+			name: "title falls back to h1",
+			html: `<body><h1>Welcome</h1><p>Text</p></body>`,
+			want: HTMLDocument{Title: "Welcome", Markdown: "# Welcome\n\nText"},
+		},
+		{
+			name: "main content only",
+			html: `<body><nav><a href="/">Home</a></nav><header>Header</header><main><h2>Install</h2><p>Run it.</p><script>x()</script></main><footer>Footer</footer></body>`,
+			want: HTMLDocument{Markdown: "## Install\n\nRun it."},
+		},
+		{
+			name: "inline formatting and links",
+			html: `<body><p>Some <strong>bold</strong>, <em>italic</em>, <del>old</del> and <code>code</code>. See <a href="../api">the API</a>, <a href="#top">top</a> and <img src="/logo.png" alt="Logo">.</p></body>`,
+			want: HTMLDocument{Markdown: "Some **bold**, _italic_, ~~old~~ and `code`. See [the API](https://example.com/api), top and ![Logo](https://example.com/logo.png)."},
+		},
+		{
+			name: "lists",
+			html: `<body><ul><li>One</li><li>Two<ol><li>Nested</li></ol></li></ul></body>`,
+			want: HTMLDocument{Markdown: "- One\n- Two\n  1. Nested"},
+		},
+		{
+			name: "code block",
+			html: "<body><pre><code class=\"language-go\">func main() {\n\tfmt.Println(\"hi\")\n}\n</code></pre></body>",
+			want: HTMLDocument{Markdown: "```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```"},
+		},
+		{
+			name: "table",
+			html: `<body><table><thead><tr><th>Name</th><th>Value</th></tr></thead><tbody><tr><td>a|b</td><td>1</td></tr><tr><td>c</td></tr></tbody></table></body>`,
+			want: HTMLDocument{Markdown: "| Name | Value |\n| --- | --- |\n| a\\|b | 1 |\n| c |  |"},
+		},
+		{
+			name: "blockquote",
+			html: `<body><blockquote><p>Quoted</p><p>Twice</p></blockquote></body>`,
+			want: HTMLDocument{Markdown: "> Quoted\n>\n> Twice"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := htmlToMarkdown(test.html, baseURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc != test.want {
+				t.Errorf("got %#v\nwant %#v", doc, test.want)
+			}
+		})
+	}
+}
+
+func TestWebPageCacheEviction(t *testing.T) {
+	cache := newWebPageCache()
+	large := strings.Repeat("a", webPageCacheMaxSize/2+1)
+
+	cache.put("first", HTMLDocument{Markdown: large})
+	cache.put("second", HTMLDocument{Markdown: large})
+
+	if _, ok := cache.take("first"); ok {
+		t.Errorf("oldest page wasn't evicted when the cache exceeded its size")
+	}
+	if doc, ok := cache.take("second"); !ok || doc.Markdown != large {
+		t.Errorf("newest page was evicted")
+	}
+	if cache.size != 0 || cache.order.Len() != 0 {
+		t.Errorf("cache isn't empty, size %d with %d pages", cache.size, cache.order.Len())
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type ZendeskDocumentType string
+
+const (
+	ZendeskDocumentTypeArticle ZendeskDocumentType = "article"
+	ZendeskDocumentTypeTicket  ZendeskDocumentType = "ticket"
+)
+
+var zendeskSubdomainRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+type ZendeskAPIClientImpl struct {
+	logger    logrus.FieldLogger
+	transport *ProviderTransport
+	sema      *semaphore.Weighted
+}
+
+func newZendeskApiClient(config ProviderConfig, rateLimits rateLimitStore, logger logrus.FieldLogger) DataSourceApiClient {
+	httpClient := &http.Client{
+		Timeout: config.Timeout,
+	}
+
+	return &ZendeskAPIClientImpl{
+		// The lowest plan allows 200 requests per minute, higher limits are picked up from response headers
+		transport: newProviderTransport("zendesk", httpClient, newRateLimiter("zendesk", rateLimits, 200.0/60, 10), config.MaxAttempts, nil).withAPIHosts(".zendesk.com"),
+		logger:    logger,
+
+		// https://developer.zendesk.com/api-reference/introduction/rate-limits/
+		sema: semaphore.NewWeighted(int64(config.Concurrency)),
+	}
+}
+
+func zendeskBaseURL(integration ZendeskIntegrationConnection) (string, error) {
+	// The subdomain ends up in the request URL, so make sure it can't point anywhere else
+	if !zendeskSubdomainRegexp.MatchString(integration.Config.Subdomain) {
+		return "", fmt.Errorf("invalid zendesk subdomain %q", integration.Config.Subdomain)
+	}
+	return fmt.Sprintf("https://%s.zendesk.com", integration.Config.Subdomain), nil
+}
+
+// sendRequest sends an authenticated GET request to the Zendesk API, requestURL may be absolute (pagination links) or
+// relative to the account's base URL
+func (client *ZendeskAPIClientImpl) sendRequest(ctx context.Context, integration ZendeskIntegrationConnection, requestURL string, result any) error {
+	baseURL, err := zendeskBaseURL(integration)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(requestURL, "https://") {
+		requestURL = baseURL + requestURL
+	} else if !strings.HasPrefix(requestURL, baseURL+"/") {
+		return fmt.Errorf("unexpected pagination url %q", requestURL)
+	}
+
+	header := http.Header{}
+	if integration.Config.AccessToken != "" {
+		header.Set("Authorization", "Bearer "+integration.Config.AccessToken)
+	} else {
+		// https://developer.zendesk.com/api-reference/introduction/security-and-auth/#api-token
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(integration.Config.Email+"/token:"+integration.Config.ApiToken)))
+	}
+	header.Set("Accept", "application/json")
+
+	return client.transport.DoJSON(ctx, providerRequest{
+		Method: http.MethodGet,
+		URL:    requestURL,
+		Header: header,
+		// Zendesk limits requests per account, whichever credentials are used
+		LimiterKey: integration.Config.Subdomain,
+	}, result)
+}
+
+type ZendeskArticle struct {
+	Id         int64    `json:"id"`
+	Title      string   `json:"title"`
+	Body       string   `json:"body"`
+	HTMLURL    string   `json:"html_url"`
+	Locale     string   `json:"locale"`
+	Draft      bool     `json:"draft"`
+	SectionId  int64    `json:"section_id"`
+	AuthorId   int64    `json:"author_id"`
+	LabelNames []string `json:"label_names"`
+	CreatedAt  string   `json:"created_at"`
+	UpdatedAt  string   `json:"updated_at"`
+}
+
+// inScope returns whether the article is published in locale, or in any locale if locale is empty
+func (article ZendeskArticle) inScope(locale string) bool {
+	return !article.Draft && (locale == "" || strings.EqualFold(article.Locale, locale))
+}
+
+func (article ZendeskArticle) toIndexedDocument() IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationZendesk,
+		DocumentType:       string(ZendeskDocumentTypeArticle),
+		Id:                 fmt.Sprint(article.Id),
+		Title:              article.Title,
+		URL:                article.HTMLURL,
+		FreshnessIndicator: article.UpdatedAt,
+		EditedAt:           parseEditedAt(article.UpdatedAt),
+	}
+}
+
+type ZendeskTicket struct {
+	Id        int64    `json:"id"`
+	Subject   string   `json:"subject"`
+	Status    string   `json:"status"`
+	Priority  string   `json:"priority"`
+	Type      string   `json:"type"`
+	Tags      []string `json:"tags"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+func (ticket ZendeskTicket) isSolved() bool {
+	return ticket.Status == "solved" || ticket.Status == "closed"
+}
+
+func (ticket ZendeskTicket) toIndexedDocument(integration ZendeskIntegrationConnection) IndexedDocument {
+	return IndexedDocument{
+		Integration:        IntegrationZendesk,
+		DocumentType:       string(ZendeskDocumentTypeTicket),
+		Id:                 fmt.Sprint(ticket.Id),
+		Title:              ticket.Subject,
+		URL:                fmt.Sprintf("https://%s.zendesk.com/agent/tickets/%d", integration.Config.Subdomain, ticket.Id),
+		FreshnessIndicator: ticket.UpdatedAt,
+		EditedAt:           parseEditedAt(ticket.UpdatedAt),
+	}
+}
+
+// listArticles lists the published articles of the help center, in a single locale if one is configured. The regular
+// list endpoint is used, as the incremental export is rate limited much more strictly.
+// https://developer.zendesk.com/api-reference/help_center/help-center-api/articles/#list-articles
+func (client *ZendeskAPIClientImpl) listArticles(ctx context.Context, integration ZendeskIntegrationConnection, locale string) (map[string]IndexedDocument, error) {
+	indexedDocuments := make(map[string]IndexedDocument)
+
+	requestURL := "/api/v2/help_center/articles?page[size]=100"
+	if locale != "" {
+		requestURL = fmt.Sprintf("/api/v2/help_center/%s/articles?page[size]=100", url.PathEscape(strings.ToLower(locale)))
+	}
+
+	for {
+		type articlesResp struct {
+			Articles []ZendeskArticle `json:"articles"`
+			Meta     struct {
+				HasMore bool `json:"has_more"`
+			} `json:"meta"`
+			Links struct {
+				Next *string `json:"next"`
+			} `json:"links"`
+		}
+
+		var resp articlesResp
+		err := client.sendRequest(ctx, integration, requestURL, &resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, article := range resp.Articles {
+			if !article.inScope(locale) {
+				continue
+			}
+			doc := article.toIndexedDocument()
+			indexedDocuments[doc.DocumentType+"/"+doc.Id] = doc
+		}
+
+		if !resp.Meta.HasMore || resp.Links.Next == nil {
+			break
+		}
+		requestURL = *resp.Links.Next
+	}
+
+	return indexedDocuments, nil
+}
+
+// listTicketChanges lists the tickets which changed since cursor, or all tickets if cursor is empty, including deleted
+// ones. It returns the cursor to continue from on the next call.
+// https://developer.zendesk.com/api-reference/ticketing/ticket-management/incremental_exports/#incremental-ticket-export-cursor-based
+func (client *ZendeskAPIClientImpl) listTicketChanges(ctx context.Context, integration ZendeskIntegrationConnection, cursor string) ([]ZendeskTicket, string, error) {
+	var tickets []ZendeskTicket
+
+	for {
+		requestURL := "/api/v2/incremental/tickets/cursor.json?per_page=1000&start_time=0"
+		if cursor != "" {
+			requestURL = "/api/v2/incremental/tickets/cursor.json?per_page=1000&cursor=" + url.QueryEscape(cursor)
+		}
+
+		type ticketsResp struct {
+			Tickets     []ZendeskTicket `json:"tickets"`
+			AfterCursor string          `json:"after_cursor"`
+			EndOfStream bool            `json:"end_of_stream"`
+		}
+
+		var resp ticketsResp
+		err := client.sendRequest(ctx, integration, requestURL, &resp)
+		if err != nil {
+			return nil, "", err
+		}
+
+		tickets = append(tickets, resp.Tickets...)
+
+		// The last page returns the cursor to poll for later changes
+		if resp.AfterCursor != "" {
+			cursor = resp.AfterCursor
+		}
+		if resp.EndOfStream || resp.AfterCursor == "" {
+			break
+		}
+	}
+
+	return tickets, cursor, nil
+}
+
+func (client *ZendeskAPIClientImpl) ListDocuments(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection) (map[string]IndexedDocument, error) {
+	documents, _, err := client.ListDocumentsSince(ctx, dataSource, integration, "", nil)
+	return documents, err
+}
+
+// ListDocumentsSince lists all published articles, and the solved tickets by applying the changes of the incremental
+// ticket export to the tickets indexed so far. Articles are listed in full, there are few of them compared to tickets.
+// The cursor is prefixed with the subdomain, so it's ignored once the data source uses another account.
+func (client *ZendeskAPIClientImpl) ListDocumentsSince(ctx context.Context, dataSource *PipelineDataSource, integration IntegrationConnection, cursor string, indexed map[string]string) (map[string]IndexedDocument, string, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer client.sema.Release(1)
+
+	client.logger.Printf("Listing all Zendesk articles\n")
+
+	allDocuments, err := client.listArticles(ctx, integration.ZendeskIntegrationConnection, dataSource.ZendeskDataSource.Config.Locale)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to list articles, %w", err)
+	}
+
+	if !dataSource.ZendeskDataSource.Config.IncludeTickets {
+		client.logger.Printf("Found %d Zendesk documents\n", len(allDocuments))
+		return allDocuments, "", nil
+	}
+
+	subdomain := integration.ZendeskIntegrationConnection.Config.Subdomain
+	ticketCursor, found := strings.CutPrefix(cursor, subdomain+":")
+	if !found {
+		ticketCursor = ""
+	}
+
+	// Indexed tickets didn't change unless the export returns them, without a cursor all tickets are exported
+	if ticketCursor != "" {
+		for key, freshnessIndicator := range indexed {
+			documentType, id, _ := strings.Cut(key, "/")
+			if documentType != string(ZendeskDocumentTypeTicket) {
+				continue
+			}
+			allDocuments[key] = IndexedDocument{
+				Integration:        IntegrationZendesk,
+				DocumentType:       documentType,
+				Id:                 id,
+				URL:                fmt.Sprintf("https://%s.zendesk.com/agent/tickets/%s", subdomain, id),
+				FreshnessIndicator: freshnessIndicator,
+			}
+		}
+	}
+
+	client.logger.Printf("Listing Zendesk ticket changes\n")
+
+	tickets, ticketCursor, err := client.listTicketChanges(ctx, integration.ZendeskIntegrationConnection, ticketCursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to list tickets, %w", err)
+	}
+
+	// Changes are exported in order, so the last change of a ticket wins. Tickets which were reopened or deleted are
+	// left out until they're solved again.
+	for _, ticket := range tickets {
+		doc := ticket.toIndexedDocument(integration.ZendeskIntegrationConnection)
+		if ticket.isSolved() {
+			allDocuments[doc.DocumentType+"/"+doc.Id] = doc
+		} else {
+			delete(allDocuments, doc.DocumentType+"/"+doc.Id)
+		}
+	}
+
+	client.logger.Printf("Found %d Zendesk documents\n", len(allDocuments))
+
+	return allDocuments, subdomain + ":" + ticketCursor, nil
+}
+
+func (client *ZendeskAPIClientImpl) getArticle(ctx context.Context, integration ZendeskIntegrationConnection, id string) (*ZendeskArticle, error) {
+	type articleResp struct {
+		Article ZendeskArticle `json:"article"`
+	}
+
+	var resp articleResp
+	err := client.sendRequest(ctx, integration, fmt.Sprintf("/api/v2/help_center/articles/%s", url.PathEscape(id)), &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp.Article, nil
+}
+
+func (client *ZendeskAPIClientImpl) getTicket(ctx context.Context, integration ZendeskIntegrationConnection, id string) (*ZendeskTicket, error) {
+	type ticketResp struct {
+		Ticket ZendeskTicket `json:"ticket"`
+	}
+
+	var resp ticketResp
+	err := client.sendRequest(ctx, integration, fmt.Sprintf("/api/v2/tickets/%s", url.PathEscape(id)), &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp.Ticket, nil
+}
+
+type ZendeskComment struct {
+	Id        int64  `json:"id"`
+	AuthorId  int64  `json:"author_id"`
+	PlainBody string `json:"plain_body"`
+	Public    bool   `json:"public"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ZendeskUser struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// getPublicComments returns all public comments of a ticket, internal notes are never indexed
+func (client *ZendeskAPIClientImpl) getPublicComments(ctx context.Context, integration ZendeskIntegrationConnection, id string) ([]ZendeskComment, map[int64]ZendeskUser, error) {
+	var comments []ZendeskComment
+	users := make(map[int64]ZendeskUser)
+
+	requestURL := fmt.Sprintf("/api/v2/tickets/%s/comments?include=users&page[size]=100", url.PathEscape(id))
+	for {
+		type commentsResp struct {
+			Comments []ZendeskComment `json:"comments"`
+			Users    []ZendeskUser    `json:"users"`
+			Meta     struct {
+				HasMore bool `json:"has_more"`
+			} `json:"meta"`
+			Links struct {
+				Next *string `json:"next"`
+			} `json:"links"`
+		}
+
+		var resp commentsResp
+		err := client.sendRequest(ctx, integration, requestURL, &resp)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, comment := range resp.Comments {
+			if comment.Public {
+				comments = append(comments, comment)
+			}
+		}
+		for _, user := range resp.Users {
+			users[user.Id] = user
+		}
+
+		if !resp.Meta.HasMore || resp.Links.Next == nil {
+			break
+		}
+		requestURL = *resp.Links.Next
+	}
+
+	return comments, users, nil
+}
+
+func (client *ZendeskAPIClientImpl) GetDocument(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (IndexedDocument, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return IndexedDocument{}, err
+	}
+	defer client.sema.Release(1)
+
+	switch documentType {
+	case string(ZendeskDocumentTypeArticle):
+		article, err := client.getArticle(ctx, integration.ZendeskIntegrationConnection, id)
+		if err != nil {
+			return IndexedDocument{}, err
+		}
+		// Articles which were unpublished are removed, just like ListDocuments leaves them out
+		if !article.inScope(dataSource.ZendeskDataSource.Config.Locale) {
+			return IndexedDocument{}, ErrDocumentOutOfScope
+		}
+		return article.toIndexedDocument(), nil
+	case string(ZendeskDocumentTypeTicket):
+		if !dataSource.ZendeskDataSource.Config.IncludeTickets {
+			return IndexedDocument{}, ErrDocumentOutOfScope
+		}
+
+		ticket, err := client.getTicket(ctx, integration.ZendeskIntegrationConnection, id)
+		if err != nil {
+			return IndexedDocument{}, err
+		}
+		// Tickets which were reopened are removed until they're solved again
+		if !ticket.isSolved() {
+			return IndexedDocument{}, ErrDocumentOutOfScope
+		}
+		return ticket.toIndexedDocument(integration.ZendeskIntegrationConnection), nil
+	default:
+		return IndexedDocument{}, fmt.Errorf("unknown document type %q", documentType)
+	}
+}
+
+func (client *ZendeskAPIClientImpl) GetDocumentContent(ctx context.Context, dataSource *PipelineDataSource, documentType string, id string, integration IntegrationConnection) (string, map[string]any, error) {
+	err := client.sema.Acquire(ctx, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	defer client.sema.Release(1)
+
+	switch documentType {
+	case string(ZendeskDocumentTypeArticle):
+		article, err := client.getArticle(ctx, integration.ZendeskIntegrationConnection, id)
+		if err != nil {
+			return "", nil, err
+		}
+
+		body, err := htmlToMarkdown(article.Body, nil)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "# " + article.Title + "\n\n" + body.Markdown, map[string]any{
+			"title":      article.Title,
+			"locale":     article.Locale,
+			"section_id": fmt.Sprint(article.SectionId),
+			"labels":     article.LabelNames,
+			"created_at": article.CreatedAt,
+			"updated_at": article.UpdatedAt,
+		}, nil
+	case string(ZendeskDocumentTypeTicket):
+		ticket, err := client.getTicket(ctx, integration.ZendeskIntegrationConnection, id)
+		if err != nil {
+			return "", nil, err
+		}
+
+		comments, users, err := client.getPublicComments(ctx, integration.ZendeskIntegrationConnection, id)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var sb strings.Builder
+		sb.WriteString("# " + ticket.Subject + "\n")
+		for _, comment := range comments {
+			author := users[comment.AuthorId].Name
+			if author == "" {
+				author = "Unknown"
+			}
+			sb.WriteString(fmt.Sprintf("\n## %s (%s)\n\n%s\n", author, comment.CreatedAt, strings.TrimSpace(comment.PlainBody)))
+		}
+
+		return sb.String(), map[string]any{
+			"title":      ticket.Subject,
+			"status":     ticket.Status,
+			"priority":   ticket.Priority,
+			"type":       ticket.Type,
+			"tags":       ticket.Tags,
+			"created_at": ticket.CreatedAt,
+			"updated_at": ticket.UpdatedAt,
+		}, nil
+	default:
+		return "", nil, fmt.Errorf("unknown document type %q", documentType)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+// newTestHTTPClient returns a client which sends all requests to handler, whichever host they're addressed to
+func newTestHTTPClient(t *testing.T, handler http.Handler) *http.Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Timeout: time.Second, Transport: &testRewriteTransport{target: target}}
+}
+
+func newTestZendeskClient(t *testing.T, handler http.Handler) *ZendeskAPIClientImpl {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return &ZendeskAPIClientImpl{
+		transport: newProviderTransport("zendesk", newTestHTTPClient(t, handler), nil, 1, nil),
+		logger:    logger,
+		sema:      semaphore.NewWeighted(1),
+	}
+}
+
+// testZendeskAccount serves help center articles and the incremental ticket export, pages of the export are selected
+// by cursor ("" for the start)
+type testZendeskAccount struct {
+	articles []ZendeskArticle
+	exports  map[string]testZendeskExportPage
+	tickets  map[string]ZendeskTicket
+}
+
+type testZendeskExportPage struct {
+	Tickets     []ZendeskTicket `json:"tickets"`
+	AfterCursor string          `json:"after_cursor"`
+	EndOfStream bool            `json:"end_of_stream"`
+}
+
+func (account *testZendeskAccount) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "acme.zendesk.com" || !strings.HasPrefix(r.Header.Get("Authorization"), "Basic ") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var response any
+	switch {
+	case r.URL.Path == "/api/v2/help_center/articles":
+		response = map[string]any{"articles": account.articles, "meta": map[string]any{"has_more": false}}
+	case r.URL.Path == "/api/v2/incremental/tickets/cursor.json":
+		cursor := r.URL.Query().Get("cursor")
+		if cursor == "" && r.URL.Query().Get("start_time") != "0" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		page, ok := account.exports[cursor]
+		if ok {
+			response = page
+		}
+	case strings.HasPrefix(r.URL.Path, "/api/v2/help_center/articles/"):
+		for _, article := range account.articles {
+			if r.URL.Path == "/api/v2/help_center/articles/"+fmt.Sprint(article.Id) {
+				response = map[string]any{"article": article}
+			}
+		}
+	case strings.HasPrefix(r.URL.Path, "/api/v2/tickets/"):
+		ticket, ok := account.tickets[strings.TrimPrefix(r.URL.Path, "/api/v2/tickets/")]
+		if ok {
+			response = map[string]any{"ticket": ticket}
+		}
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func newZendeskConnection() IntegrationConnection {
+	connection := IntegrationConnection{}
+	connection.Integration = IntegrationZendesk
+	connection.ZendeskIntegrationConnection.Config.Subdomain = "acme"
+	connection.ZendeskIntegrationConnection.Config.Email = "agent@example.com"
+	connection.ZendeskIntegrationConnection.Config.ApiToken = "token"
+	return connection
+}
+
+func newZendeskDataSource(includeTickets bool) *PipelineDataSource {
+	dataSource := &PipelineDataSource{}
+	dataSource.IntegrationName = IntegrationZendesk
+	dataSource.ZendeskDataSource.Config.IncludeTickets = includeTickets
+	return dataSource
+}
+
+func documentKeys(docs map[string]IndexedDocument) []string {
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestZendeskAccount() *testZendeskAccount {
+	ticket := func(id int64, status string, updatedAt string) ZendeskTicket {
+		return ZendeskTicket{Id: id, Subject: "Ticket " + fmt.Sprint(id), Status: status, UpdatedAt: updatedAt}
+	}
+
+	return &testZendeskAccount{
+		articles: []ZendeskArticle{
+			{Id: 10, Title: "Published", Locale: "en-us", UpdatedAt: "2023-01-01T00:00:00Z"},
+			{Id: 11, Title: "Draft", Locale: "en-us", Draft: true},
+		},
+		exports: map[string]testZendeskExportPage{
+			"":   {Tickets: []ZendeskTicket{ticket(1, "solved", "2023-01-01T00:00:00Z"), ticket(2, "open", "2023-01-01T00:00:00Z")}, AfterCursor: "c1"},
+			"c1": {Tickets: []ZendeskTicket{ticket(3, "closed", "2023-01-02T00:00:00Z")}, AfterCursor: "c2", EndOfStream: true},
+			// Ticket 1 was reopened, 3 was deleted and 4 was solved since c2
+			"c2": {Tickets: []ZendeskTicket{ticket(1, "open", "2023-02-01T00:00:00Z"), ticket(4, "solved", "2023-02-01T00:00:00Z"), ticket(3, "deleted", "2023-02-02T00:00:00Z")}, AfterCursor: "c3", EndOfStream: true},
+		},
+		tickets: map[string]ZendeskTicket{
+			"1": ticket(1, "open", "2023-02-01T00:00:00Z"),
+			"4": ticket(4, "solved", "2023-02-01T00:00:00Z"),
+		},
+	}
+}
+
+func TestZendeskListDocumentsSince(t *testing.T) {
+	client := newTestZendeskClient(t, newTestZendeskAccount())
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		includeTickets bool
+		cursor         string
+		indexed        map[string]string
+		want           []string
+		wantCursor     string
+	}{
+		{
+			name:       "articles only",
+			want:       []string{"article/10"},
+			wantCursor: "",
+		},
+		{
+			name:           "full export",
+			includeTickets: true,
+			want:           []string{"article/10", "ticket/1", "ticket/3"},
+			wantCursor:     "acme:c2",
+		},
+		{
+			name:           "changes since cursor",
+			includeTickets: true,
+			cursor:         "acme:c2",
+			indexed:        map[string]string{"article/10": "x", "ticket/1": "a", "ticket/3": "b", "ticket/5": "c"},
+			want:           []string{"article/10", "ticket/4", "ticket/5"},
+			wantCursor:     "acme:c3",
+		},
+		{
+			name:           "cursor of another account",
+			includeTickets: true,
+			cursor:         "other:c2",
+			indexed:        map[string]string{"ticket/5": "c"},
+			want:           []string{"article/10", "ticket/1", "ticket/3"},
+			wantCursor:     "acme:c2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docs, cursor, err := client.ListDocumentsSince(ctx, newZendeskDataSource(test.includeTickets), newZendeskConnection(), test.cursor, test.indexed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := documentKeys(docs); strings.Join(keys, ",") != strings.Join(test.want, ",") {
+				t.Errorf("listed %v, want %v", keys, test.want)
+			}
+			if cursor != test.wantCursor {
+				t.Errorf("got cursor %q, want %q", cursor, test.wantCursor)
+			}
+		})
+	}
+
+	// Unchanged tickets keep their stored freshness indicator, so they aren't indexed again
+	docs, _, err := client.ListDocumentsSince(ctx, newZendeskDataSource(true), newZendeskConnection(), "acme:c2", map[string]string{"ticket/5": "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc := docs["ticket/5"]; doc.freshness() != "c" || doc.URL != "https://acme.zendesk.com/agent/tickets/5" {
+		t.Errorf("got unchanged ticket %+v", doc)
+	}
+	if doc := docs["ticket/4"]; doc.Title != "Ticket 4" || doc.FreshnessIndicator != "2023-02-01T00:00:00Z" {
+		t.Errorf("got changed ticket %+v", doc)
+	}
+}
+
+func TestZendeskGetDocumentScope(t *testing.T) {
+	client := newTestZendeskClient(t, newTestZendeskAccount())
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		includeTickets bool
+		documentType   string
+		id             string
+		wantOutOfScope bool
+	}{
+		{name: "published article", documentType: "article", id: "10"},
+		{name: "draft article", documentType: "article", id: "11", wantOutOfScope: true},
+		{name: "solved ticket", includeTickets: true, documentType: "ticket", id: "4"},
+		{name: "reopened ticket", includeTickets: true, documentType: "ticket", id: "1", wantOutOfScope: true},
+		{name: "tickets not included", documentType: "ticket", id: "4", wantOutOfScope: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := client.GetDocument(ctx, newZendeskDataSource(test.includeTickets), test.documentType, test.id, newZendeskConnection())
+			if test.wantOutOfScope {
+				if !errors.Is(err, ErrDocumentOutOfScope) {
+					t.Errorf("got document %+v, error %v, want out of scope", doc, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.DocumentType != test.documentType || doc.Id != test.id {
+				t.Errorf("got document %+v", doc)
+			}
+		})
+	}
+}
+
+func TestZendeskRejectsForeignURLs(t *testing.T) {
+	client := newTestZendeskClient(t, newTestZendeskAccount())
+	connection := newZendeskConnection()
+
+	var result any
+	err := client.sendRequest(context.Background(), connection.ZendeskIntegrationConnection, "https://evil.example.com/api/v2/tickets", &result)
+	if err == nil {
+		t.Errorf("sent a request to another host")
+	}
+
+	connection.ZendeskIntegrationConnection.Config.Subdomain = "evil.com/x"
+	_, err = client.ListDocuments(context.Background(), newZendeskDataSource(false), connection)
+	if err == nil {
+		t.Errorf("listed documents of an invalid subdomain")
+	}
+}